@@ -94,46 +94,68 @@ func initDefaultConfigs(db *gorm.DB) error {
 		{
 			Name:        "summarize_article",
 			Description: "总结文章的主要内容，适合长文段或博客文章提炼摘要。",
-			CreatedAt:   time.Now(),
-			UpdatedAt:   time.Now(),
+			Template:    "请用简洁的语言总结以下文章的主要内容：\n\n{{.content}}",
+			Args: []models.PromptArgModel{
+				{Name: "content", Description: "待总结的文章内容", Required: true},
+			},
+		},
+		{
+			Name:        "summarize_conversation",
+			Description: "把较早的对话合并进已有摘要，长对话中助手据此记住上下文。",
+			Template:    "请把以下对话合并进已有摘要，保留客户的诉求、提供的信息、已做的决定和尚未解决的问题，只输出新的摘要：\n\n{{if .summary}}已有摘要：\n{{.summary}}\n\n{{end}}对话：\n{{.conversation}}",
+			Args: []models.PromptArgModel{
+				{Name: "conversation", Description: "待合并的对话", Required: true},
+				{Name: "summary", Description: "已有摘要", Required: false},
+			},
+		},
+		{
+			Name:        "extract_customer_memory",
+			Description: "会话结束后提取客户信息和会话摘要，客户再次来电时助手据此记住客户。",
+			Template:    "阅读以下客户与助手的对话，只输出一个 JSON 对象：\"summary\" 为供下次与该客户对话参考的简短摘要，\"facts\" 为值得记住的客户信息列表（如姓名、联系方式、订单、偏好），与已知信息合并并去掉过时的内容。\n\n{{if .facts}}已知信息：\n{{.facts}}\n\n{{end}}对话：\n{{.conversation}}",
+			Args: []models.PromptArgModel{
+				{Name: "conversation", Description: "会话的对话内容", Required: true},
+				{Name: "facts", Description: "已知的客户信息，每行一条", Required: false},
+			},
 		},
 		{
 			Name:        "translate_text",
 			Description: "将输入文本翻译为指定语言，适合中英文互译等场景。",
-			CreatedAt:   time.Now(),
-			UpdatedAt:   time.Now(),
+			Template:    "请将以下文本翻译为 {{.target_language}}，只输出译文：\n\n{{.text}}",
+			Args: []models.PromptArgModel{
+				{Name: "text", Description: "要翻译的文本", Required: true},
+				{Name: "target_language", Description: "目标语言（如 en、zh）", Required: true},
+			},
 		},
 		{
 			Name:        "generate_title",
 			Description: "根据文章内容生成简洁有吸引力的标题。",
-			CreatedAt:   time.Now(),
-			UpdatedAt:   time.Now(),
+			Template:    "请为以下文章生成一个简洁有吸引力的标题：\n\n{{.article}}",
+			Args: []models.PromptArgModel{
+				{Name: "article", Description: "文章内容", Required: true},
+			},
 		},
 		{
 			Name:        "email_reply_generator",
 			Description: "根据邮件内容和意图自动生成专业的邮件回复。",
-			CreatedAt:   time.Now(),
-			UpdatedAt:   time.Now(),
+			Template:    "请以{{.tone}}的语气回复以下邮件：\n\n{{.email_body}}",
+			Args: []models.PromptArgModel{
+				{Name: "email_body", Description: "原始邮件内容", Required: true},
+				{Name: "tone", Description: "回复语气（如正式、轻松）", Required: false, Default: "正式"},
+			},
 		},
 	}
-	db.Model(models.PromptModel{}).Create(defaultPrompts)
-
-	defaultArgs := []models.PromptArgModel{
-		// summarize_article
-		{Name: "content", Description: "待总结的文章内容", Required: true, PromptID: 1},
-
-		// translate_text
-		{Name: "text", Description: "要翻译的文本", Required: true, PromptID: 2},
-		{Name: "target_language", Description: "目标语言（如 en、zh）", Required: true, PromptID: 2},
-
-		// generate_title
-		{Name: "article", Description: "文章内容", Required: true, PromptID: 3},
-
-		// email_reply_generator
-		{Name: "email_body", Description: "原始邮件内容", Required: true, PromptID: 4},
-		{Name: "tone", Description: "回复语气（如正式、轻松）", Required: false, PromptID: 4},
+	for _, p := range defaultPrompts {
+		var count int64
+		err := db.Model(&models.PromptModel{}).Where("`name` = ?", p.Name).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			if err := db.Create(&p).Error; err != nil {
+				return err
+			}
+		}
 	}
-	db.Model(models.PromptArgModel{}).Create(defaultArgs)
 	return nil
 }
 
@@ -219,9 +241,20 @@ func main() {
 		&models.UserCredential{},
 		&models.GroupMember{},
 		&models.Assistant{},
+		&models.AssistantTool{},
+		&models.KnowledgeBase{},
+		&models.KnowledgeDocument{},
+		&models.KnowledgeChunk{},
 		&models.ChatSessionLog{},
+		&models.ChatSessionTurn{},
+		&models.Customer{},
+		&models.CustomerSession{},
+		&models.PhoneRoute{},
+		&models.Campaign{},
+		&models.CampaignContact{},
 		&models.PromptModel{},
 		&models.PromptArgModel{},
+		&models.PromptVersionModel{},
 		&notification.InternalNotification{},
 	})
 	if err != nil {
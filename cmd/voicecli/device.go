@@ -0,0 +1,118 @@
+//go:build cgo
+
+package main
+
+import (
+	"VoiceSculptor/internal/voice"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/gen2brain/malgo"
+)
+
+// deviceMedia the default microphone and speaker, a duplex device of malgo
+// at voice.SampleRate, miniaudio converts from the rate of the device
+type deviceMedia struct {
+	ctx    *malgo.AllocatedContext
+	device *malgo.Device
+	frames chan []byte
+	closed chan struct{}
+	once   sync.Once
+
+	captured []byte // PCM not yet filling a frame, only used by the device callback
+
+	mu       sync.Mutex
+	playback []byte // PCM waiting for the speaker
+}
+
+// openDevice opens the default microphone and speaker, the null backend of
+// miniaudio is left out so that a host without sound card fails
+func openDevice() (voice.Media, error) {
+	var backends []malgo.Backend
+	for b := malgo.Backend(malgo.BackendWasapi); b < malgo.BackendNull; b++ {
+		backends = append(backends, b)
+	}
+	ctx, err := malgo.InitContext(backends, malgo.ContextConfig{}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errNoDevice, err)
+	}
+	m := &deviceMedia{ctx: ctx, frames: make(chan []byte, 50), closed: make(chan struct{})}
+	if devices, err := ctx.Devices(malgo.Capture); err != nil || len(devices) == 0 {
+		m.free()
+		return nil, errNoDevice
+	}
+
+	config := malgo.DefaultDeviceConfig(malgo.Duplex)
+	config.Capture.Format = malgo.FormatS16
+	config.Capture.Channels = 1
+	config.Playback.Format = malgo.FormatS16
+	config.Playback.Channels = 1
+	config.SampleRate = voice.SampleRate
+	config.Alsa.NoMMap = 1
+	m.device, err = malgo.InitDevice(ctx.Context, config, malgo.DeviceCallbacks{Data: m.process})
+	if err != nil {
+		m.free()
+		return nil, fmt.Errorf("%w: %v", errNoDevice, err)
+	}
+	if err := m.device.Start(); err != nil {
+		m.device.Uninit()
+		m.free()
+		return nil, err
+	}
+	return m, nil
+}
+
+// process the data callback of the device, it queues the captured frames
+// and plays the PCM written so far, silence when there is none
+func (m *deviceMedia) process(output, input []byte, frameCount uint32) {
+	m.captured = append(m.captured, input...)
+	for len(m.captured) >= voice.FrameSize {
+		frame := make([]byte, voice.FrameSize)
+		copy(frame, m.captured)
+		m.captured = m.captured[voice.FrameSize:]
+		select {
+		case m.frames <- frame:
+		default:
+			// the call is lagging, drop the frame
+		}
+	}
+	m.captured = append([]byte(nil), m.captured...)
+
+	m.mu.Lock()
+	n := copy(output, m.playback)
+	m.playback = m.playback[n:]
+	m.mu.Unlock()
+	clear(output[n:])
+}
+
+func (m *deviceMedia) ReadFrame() ([]byte, error) {
+	select {
+	case <-m.closed:
+		return nil, errors.New("device closed")
+	case frame := <-m.frames:
+		return frame, nil
+	}
+}
+
+// WriteFrame queues the PCM for the speaker, the gateway writes in real time
+func (m *deviceMedia) WriteFrame(pcm []byte) error {
+	m.mu.Lock()
+	m.playback = append(m.playback, pcm...)
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *deviceMedia) Close() error {
+	m.once.Do(func() {
+		close(m.closed)
+		m.device.Uninit()
+		m.free()
+	})
+	return nil
+}
+
+func (m *deviceMedia) free() {
+	_ = m.ctx.Uninit()
+	m.ctx.Free()
+}
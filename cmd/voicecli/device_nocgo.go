@@ -0,0 +1,13 @@
+//go:build !cgo
+
+package main
+
+import (
+	"VoiceSculptor/internal/voice"
+	"fmt"
+)
+
+// openDevice malgo requires cgo, only the WAV files can be used without it
+func openDevice() (voice.Media, error) {
+	return nil, fmt.Errorf("%w: built without cgo", errNoDevice)
+}
@@ -0,0 +1,187 @@
+// Command voicecli talks to an assistant from a terminal: the voice pipeline
+// of the worker runs in process against the default microphone and speaker,
+// or against WAV files in and out when there is no audio device.
+//
+//	voicecli -asr <provider> -asr-secret-id ... -tts tone -assistant 1
+//	voicecli -asr scripted -asr-app-id script.txt -tts wav -tts-app-id reply.wav -in question.wav -out answer.wav
+//
+// The keys typed on a line, 0-9 * # A-D, are sent as dtmf. Use headphones:
+// the assistant heard by the microphone interrupts itself.
+package main
+
+import (
+	"VoiceSculptor/internal/chat"
+	"VoiceSculptor/internal/knowledge"
+	"VoiceSculptor/internal/voice"
+	"VoiceSculptor/pkg/asr"
+	"VoiceSculptor/pkg/config"
+	"VoiceSculptor/pkg/dtmf"
+	"VoiceSculptor/pkg/llm"
+	"VoiceSculptor/pkg/logger"
+	"VoiceSculptor/pkg/prompt"
+	stores "VoiceSculptor/pkg/storage"
+	"VoiceSculptor/pkg/tts"
+	"VoiceSculptor/pkg/util"
+	"VoiceSculptor/pkg/vad"
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const sessionExpirySeconds = 30 * 60
+
+var errNoDevice = errors.New("no audio device")
+
+// terminalLeg the leg of the call with the keys typed in the terminal
+type terminalLeg struct {
+	voice.Media
+	keys chan string
+}
+
+func (l *terminalLeg) Keys() <-chan string {
+	return l.keys
+}
+
+// readKeys sends the keys of the lines of stdin
+func (l *terminalLeg) readKeys() {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		for _, r := range scanner.Text() {
+			if digit := string(r); dtmf.IsKey(digit) {
+				l.keys <- digit
+			}
+		}
+	}
+}
+
+func main() {
+	mode := flag.String("mode", "development", "running environment (development, test, production)")
+	llmProvider := flag.String("llm", llm.ProviderFake, "llm provider when the credential of the assistant owner sets none")
+	llmApiKey := flag.String("llm-key", "", "llm api key")
+	llmApiURL := flag.String("llm-url", "", "llm api url")
+	llmModel := flag.String("llm-model", "", "llm model")
+	asrProvider := flag.String("asr", "", "asr provider when the credential of the assistant owner sets none, the assistant only hears the keys without one")
+	asrAppID := flag.String("asr-app-id", "", "asr app id, the script file of the scripted provider")
+	asrSecretID := flag.String("asr-secret-id", "", "asr secret id")
+	asrSecretKey := flag.String("asr-secret-key", "", "asr secret key")
+	asrLanguage := flag.String("asr-language", "", "asr language")
+	ttsProvider := flag.String("tts", "", "tts provider when the credential of the assistant owner sets none, replies are only printed without one")
+	ttsAppID := flag.String("tts-app-id", "", "tts app id, the wav file of the wav provider")
+	ttsSecretID := flag.String("tts-secret-id", "", "tts secret id")
+	ttsSecretKey := flag.String("tts-secret-key", "", "tts secret key")
+	ttsVoice := flag.String("tts-voice", "", "tts voice")
+	ttsSpeed := flag.Float64("tts-speed", tts.DefaultSpeed, "tts speed")
+	ttsVolume := flag.Float64("tts-volume", tts.DefaultVolume, "tts volume, 0 to 10")
+	assistantID := flag.Uint("assistant", 0, "id of the assistant to talk to, loaded from the configured database")
+	enableVAD := flag.Bool("vad", true, "detect the voice activity, talking interrupts the assistant")
+	inbandDTMF := flag.Bool("inband-dtmf", false, "detect the keys played in the audio")
+	input := flag.String("in", "", "WAV file played as the caller instead of the microphone")
+	output := flag.String("out", "", "WAV file the assistant is written to, with -in")
+	tail := flag.Duration("tail", 10*time.Second, "how long the call goes on after the end of -in")
+	systemPrompt := flag.String("system-prompt", "You are a helpful voice assistant, answer briefly.", "system prompt of the assistant")
+	flag.Parse()
+
+	if *mode != "" {
+		os.Setenv("APP_ENV", *mode)
+	}
+	if err := config.Load(); err != nil {
+		panic("config load failed: " + err.Error())
+	}
+	if err := logger.Init(&config.GlobalConfig.Log, config.GlobalConfig.Mode); err != nil {
+		panic(err)
+	}
+
+	provider, err := llm.New(*llmProvider, llm.Config{APIKey: *llmApiKey, BaseURL: *llmApiURL, Model: *llmModel})
+	if err != nil {
+		log.Fatal("Error creating llm provider:", err)
+	}
+	var newTranscriber func() (voice.Transcriber, error)
+	if *asrProvider != "" {
+		recognizer, err := asr.New(*asrProvider, asr.Config{AppID: *asrAppID, SecretID: *asrSecretID, SecretKey: *asrSecretKey, Language: *asrLanguage})
+		if err != nil {
+			log.Fatal("Error creating asr provider:", err)
+		}
+		newTranscriber = func() (voice.Transcriber, error) {
+			return recognizer.NewStream(context.Background(), asr.Options{SampleRate: voice.SampleRate})
+		}
+	}
+	// the voice of the replies, with any tts provider
+	speech := tts.Options{SampleRate: voice.SampleRate, Voice: *ttsVoice, Speed: float32(*ttsSpeed), Volume: float32(*ttsVolume)}
+	var newSynthesizer func() (voice.Synthesizer, error)
+	if *ttsProvider != "" {
+		synthesizer, err := tts.New(*ttsProvider, tts.Config{AppID: *ttsAppID, SecretID: *ttsSecretID, SecretKey: *ttsSecretKey})
+		if err != nil {
+			log.Fatal("Error creating tts provider:", err)
+		}
+		newSynthesizer = func() (voice.Synthesizer, error) {
+			return tts.WithOptions(synthesizer, speech), nil
+		}
+	}
+
+	opts := voice.CallOptions{Options: chat.Options{SystemPrompt: *systemPrompt, Temperature: 0.7, MaxTokens: 512}, Speech: speech}
+	if *enableVAD {
+		opts.VAD = &vad.Config{}
+	}
+	if *assistantID > 0 {
+		db, err := util.InitDatabase(os.Stdout, config.GlobalConfig.DBDriver, config.GlobalConfig.DSN)
+		if err != nil {
+			log.Fatal("Error opening database:", err)
+		}
+		if err := prompt.InitPromptSystem(db); err != nil {
+			logger.Warn("load prompts failed", zap.Error(err))
+		}
+		assistant, err := voice.LoadAssistant(db, uint(*assistantID))
+		if err != nil {
+			log.Fatal("Error loading assistant:", err)
+		}
+		opts.Knowledge = knowledge.NewService(db, stores.Default(), config.GlobalConfig.EmbeddingProvider, config.GlobalConfig.Embedding).Knowledge
+		opts.ResolveAssistant = voice.AssistantResolver(db, opts)
+		opts = voice.AssistantOptions(opts, assistant, assistant.Instruction)
+		if opts, err = voice.CredentialOptions(db, opts, 0); err != nil {
+			log.Fatal("Error loading the credential of the assistant:", err)
+		}
+	}
+	// the transcript of the call
+	opts.Options.OnTurn = func(turn chat.Turn) {
+		fmt.Printf("%s: %s\n", turn.Role, turn.Content)
+	}
+
+	gateway, err := voice.NewGateway(chat.NewEngine(sessionExpirySeconds), voice.Config{
+		Provider:       provider,
+		NewTranscriber: newTranscriber,
+		NewSynthesizer: newSynthesizer,
+		DetectDTMF:     *inbandDTMF,
+	})
+	if err != nil {
+		log.Fatal("Error creating voice gateway:", err)
+	}
+
+	var media voice.Media
+	if *input != "" {
+		if media, err = openWAV(*input, *output, *tail); err != nil {
+			log.Fatal("Error reading the input WAV:", err)
+		}
+	} else if media, err = openDevice(); err != nil {
+		log.Fatal("Error opening the audio device, talk with WAV files instead (-in, -out):", err)
+	}
+	leg := &terminalLeg{Media: media, keys: make(chan string, 16)}
+	go leg.readKeys()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	fmt.Println("Talking to the assistant, press Ctrl+C to hang up...")
+	if err := gateway.Bridge(ctx, leg, &opts); err != nil {
+		log.Fatal("Error running the call:", err)
+	}
+	if *output != "" && *input != "" {
+		fmt.Println("The assistant is written to", *output)
+	}
+}
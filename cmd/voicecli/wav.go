@@ -0,0 +1,99 @@
+package main
+
+import (
+	"VoiceSculptor/internal/voice"
+	"VoiceSculptor/pkg/codec"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// wavMedia plays a WAV file as the caller in real time, then silence for the
+// tail so that the assistant can answer. The audio of the assistant is kept
+// where it was played and written to the output WAV once the call ends.
+type wavMedia struct {
+	input  []byte
+	tail   int // frames of silence after the input
+	output string
+	start  time.Time
+	next   time.Time // pacing of the frames read
+	closed chan struct{}
+	once   sync.Once
+
+	mu     sync.Mutex
+	played []byte
+}
+
+// openWAV reads the input WAV, the output WAV is not written when empty
+func openWAV(input, output string, tail time.Duration) (*wavMedia, error) {
+	wav, err := codec.LoadWAV(input)
+	if err != nil {
+		return nil, err
+	}
+	return &wavMedia{
+		input:  codec.Resample(wav.Mono(), wav.SampleRate, voice.SampleRate),
+		tail:   int(tail / voice.FrameDuration),
+		output: output,
+		closed: make(chan struct{}),
+	}, nil
+}
+
+func (m *wavMedia) ReadFrame() ([]byte, error) {
+	now := time.Now()
+	if m.next.IsZero() {
+		m.mu.Lock()
+		m.start, m.next = now, now
+		m.mu.Unlock()
+	}
+	select {
+	case <-m.closed:
+		return nil, io.EOF
+	case <-time.After(m.next.Sub(now)):
+	}
+	m.next = m.next.Add(voice.FrameDuration)
+
+	frame := make([]byte, voice.FrameSize)
+	switch {
+	case len(m.input) > 0:
+		n := copy(frame, m.input)
+		m.input = m.input[n:]
+	case m.tail > 0:
+		m.tail--
+	default:
+		return nil, io.EOF
+	}
+	return frame, nil
+}
+
+// WriteFrame keeps the PCM at the time it is played, the gaps are silence
+func (m *wavMedia) WriteFrame(pcm []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.start.IsZero() {
+		at := int(time.Since(m.start)/voice.FrameDuration) * voice.FrameSize
+		if at > len(m.played) {
+			m.played = append(m.played, make([]byte, at-len(m.played))...)
+		}
+	}
+	m.played = append(m.played, pcm...)
+	return nil
+}
+
+func (m *wavMedia) Close() (err error) {
+	m.once.Do(func() {
+		close(m.closed)
+		if m.output == "" {
+			return
+		}
+		var f *os.File
+		if f, err = os.Create(m.output); err != nil {
+			return
+		}
+		defer f.Close()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		err = codec.WriteWAV(f, &codec.WAV{SampleRate: voice.SampleRate, Channels: 1, Data: m.played})
+	})
+	return err
+}
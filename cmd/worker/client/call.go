@@ -0,0 +1,356 @@
+package main
+
+import (
+	"VoiceSculptor/internal/voice"
+	"VoiceSculptor/pkg/codec"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+// quietPeriod the assistant is done talking once no audio arrived for so long,
+// the gateway only sends audio while it speaks
+const quietPeriod = 600 * time.Millisecond
+
+var errTimeout = errors.New("timeout")
+
+// callConfig the script of a call
+type callConfig struct {
+	URL       string
+	APIKey    string
+	APISecret string
+	Codec     codec.Codec
+	Input     []byte // the caller, PCM at voice.SampleRate
+	// WaitGreeting how long the caller waits for the greeting to end before talking
+	WaitGreeting time.Duration
+	Timeout      time.Duration
+}
+
+// callResult the measures of a call
+type callResult struct {
+	SessionID string
+	// TimeToFirstAudio from the offer to the first audio of the assistant
+	TimeToFirstAudio time.Duration
+	// RoundTrip from the end of the caller audio to the first audio of the reply
+	RoundTrip  time.Duration
+	Transcript string // the final transcript of the caller
+	Reply      string // the text of the reply
+	Recording  []byte // the audio of the assistant at voice.SampleRate, gaps are silence
+	Err        error
+}
+
+// call a scripted caller of the worker
+type call struct {
+	config  callConfig
+	ws      *websocket.Conn
+	wsMu    sync.Mutex
+	pc      *webrtc.PeerConnection
+	track   *webrtc.TrackLocalStaticSample
+	encoder codec.Encoder
+
+	connected   chan struct{}
+	connectOnce sync.Once
+	firstByte   chan struct{} // closed with the first audio of the assistant
+	closeOnce   sync.Once
+	speaking    atomic.Bool  // the caller streams the input
+	lastAudio   atomic.Int64 // unix nanoseconds of the last audio packet
+	talked      atomic.Int64 // unix nanoseconds of the end of the caller audio, 0 while talking
+
+	mu        sync.Mutex
+	result    callResult
+	start     time.Time     // the offer, the origin of the recording
+	roundTrip chan struct{} // closed with the first audio of the reply
+}
+
+// runCall places a call and plays the script, the result holds the error of a failed call
+func runCall(ctx context.Context, config callConfig) callResult {
+	ctx, cancel := context.WithTimeout(ctx, config.Timeout)
+	defer cancel()
+	c := &call{
+		config:    config,
+		connected: make(chan struct{}),
+		firstByte: make(chan struct{}),
+		roundTrip: make(chan struct{}),
+	}
+	err := c.run(ctx)
+	c.close()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.result.Err = err
+	return c.result
+}
+
+func (c *call) run(ctx context.Context) error {
+	if err := c.dial(ctx); err != nil {
+		return err
+	}
+
+	// the assistant greets the caller once connected
+	if err := wait(ctx, c.connected, "connection"); err != nil {
+		return err
+	}
+	if err := wait(ctx, c.firstByte, "greeting"); err != nil {
+		return err
+	}
+	greeting, cancel := context.WithTimeout(ctx, c.config.WaitGreeting)
+	c.waitQuiet(greeting)
+	cancel()
+	if ctx.Err() != nil {
+		return fmt.Errorf("greeting: %w", errTimeout)
+	}
+
+	// the caller talks, then listens to the whole reply
+	if err := c.talk(ctx); err != nil {
+		return err
+	}
+	if err := wait(ctx, c.roundTrip, "reply"); err != nil {
+		return err
+	}
+	c.waitQuiet(ctx)
+	if ctx.Err() != nil {
+		return fmt.Errorf("reply: %w", errTimeout)
+	}
+	c.send(voice.Message{Type: voice.MessageHangup})
+	return nil
+}
+
+// dial negotiates the peer connection over the WebSocket of the worker
+func (c *call) dial(ctx context.Context) error {
+	var err error
+	header := http.Header{"X-API-KEY": {c.config.APIKey}, "X-API-SECRET": {c.config.APISecret}}
+	c.ws, _, err = websocket.DefaultDialer.DialContext(ctx, c.config.URL, header)
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	mediaEngine := &webrtc.MediaEngine{}
+	params := webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: c.config.Codec.MimeType(), ClockRate: c.config.Codec.ClockRate, Channels: 1},
+		PayloadType:        webrtc.PayloadType(c.config.Codec.PayloadType),
+	}
+	if err := mediaEngine.RegisterCodec(params, webrtc.RTPCodecTypeAudio); err != nil {
+		return err
+	}
+	c.pc, err = webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine)).NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return err
+	}
+	if c.encoder, err = codec.NewEncoder(c.config.Codec.Name); err != nil {
+		return err
+	}
+	c.track, err = webrtc.NewTrackLocalStaticSample(params.RTPCodecCapability, "audio", "client")
+	if err != nil {
+		return err
+	}
+	if _, err := c.pc.AddTrack(c.track); err != nil {
+		return err
+	}
+	c.pc.OnTrack(func(remote *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		go c.listen(remote)
+	})
+	c.pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state != webrtc.PeerConnectionStateConnected {
+			return
+		}
+		c.connectOnce.Do(func() {
+			close(c.connected)
+			go c.stream(ctx)
+		})
+	})
+
+	offer, err := c.pc.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	gathered := webrtc.GatheringCompletePromise(c.pc)
+	if err := c.pc.SetLocalDescription(offer); err != nil {
+		return err
+	}
+	select {
+	case <-gathered:
+	case <-ctx.Done():
+		return fmt.Errorf("gathering candidates: %w", errTimeout)
+	}
+	c.mu.Lock()
+	c.start = time.Now()
+	c.mu.Unlock()
+	c.send(voice.Message{Type: voice.MessageOffer, SDP: c.pc.LocalDescription().SDP})
+	go c.readSignals()
+	return nil
+}
+
+// readSignals handles the signalling messages of the worker until it closes the WebSocket
+func (c *call) readSignals() {
+	for {
+		var msg voice.Message
+		if err := c.ws.ReadJSON(&msg); err != nil {
+			return
+		}
+		switch msg.Type {
+		case voice.MessageAnswer:
+			c.mu.Lock()
+			c.result.SessionID = msg.SessionID
+			c.mu.Unlock()
+			_ = c.pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: msg.SDP})
+		case voice.MessageCandidate:
+			if msg.Candidate != nil {
+				_ = c.pc.AddICECandidate(*msg.Candidate)
+			}
+		case voice.MessageTranscript:
+			if msg.Final {
+				c.mu.Lock()
+				c.result.Transcript = msg.Text
+				c.mu.Unlock()
+			}
+		case voice.MessageDone:
+			if c.talked.Load() != 0 {
+				c.mu.Lock()
+				c.result.Reply = msg.Text
+				c.mu.Unlock()
+			}
+		}
+	}
+}
+
+// listen records the audio of the assistant
+func (c *call) listen(remote *webrtc.TrackRemote) {
+	decoder, err := codec.NewDecoder(c.config.Codec.Name)
+	if err != nil {
+		return
+	}
+	var first sync.Once
+	for {
+		packet, _, err := remote.ReadRTP()
+		if err != nil {
+			return
+		}
+		now := time.Now()
+		pcm, err := decoder.Decode(packet.Payload)
+		if err != nil {
+			continue
+		}
+		pcm = codec.Resample(pcm, c.config.Codec.SampleRate, voice.SampleRate)
+		// the reply starts with the first audio after the caller, the end of
+		// the greeting was waited for
+		if talked := c.talked.Load(); talked != 0 && c.lastAudio.Load() < talked {
+			c.mu.Lock()
+			c.result.RoundTrip = now.Sub(time.Unix(0, talked))
+			c.mu.Unlock()
+			close(c.roundTrip)
+		}
+		c.lastAudio.Store(now.UnixNano())
+
+		c.mu.Lock()
+		first.Do(func() {
+			c.result.TimeToFirstAudio = now.Sub(c.start)
+			close(c.firstByte)
+		})
+		at := int(now.Sub(c.start)/voice.FrameDuration) * voice.FrameSize
+		if at > len(c.result.Recording)+voice.FrameSize {
+			c.result.Recording = append(c.result.Recording, make([]byte, at-len(c.result.Recording))...)
+		}
+		c.result.Recording = append(c.result.Recording, pcm...)
+		c.mu.Unlock()
+	}
+}
+
+// stream sends silence until the caller talks, then the input, then silence
+// again so that the worker detects the end of the utterance
+func (c *call) stream(ctx context.Context) {
+	silence := make([]byte, voice.FrameSize)
+	ticker := time.NewTicker(voice.FrameDuration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		frame := silence
+		c.mu.Lock()
+		if c.speaking.Load() && len(c.config.Input) > 0 {
+			n := min(voice.FrameSize, len(c.config.Input))
+			frame = append(c.config.Input[:n:n], silence[n:]...)
+			c.config.Input = c.config.Input[n:]
+			if len(c.config.Input) == 0 {
+				c.talked.Store(time.Now().UnixNano())
+			}
+		}
+		c.mu.Unlock()
+		payload, err := c.encoder.Encode(codec.Resample(frame, voice.SampleRate, c.config.Codec.SampleRate))
+		if err != nil {
+			return
+		}
+		if err := c.track.WriteSample(media.Sample{Data: payload, Duration: voice.FrameDuration}); err != nil {
+			return
+		}
+	}
+}
+
+// talk streams the input and blocks until it is sent
+func (c *call) talk(ctx context.Context) error {
+	c.mu.Lock()
+	empty := len(c.config.Input) == 0
+	c.mu.Unlock()
+	if empty {
+		return errors.New("empty input")
+	}
+	c.speaking.Store(true)
+	for c.talked.Load() == 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("talk: %w", errTimeout)
+		case <-time.After(voice.FrameDuration):
+		}
+	}
+	return nil
+}
+
+// waitQuiet blocks until no audio arrived for the quiet period
+func (c *call) waitQuiet(ctx context.Context) {
+	for {
+		idle := time.Since(time.Unix(0, c.lastAudio.Load()))
+		if idle >= quietPeriod {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(quietPeriod - idle):
+		}
+	}
+}
+
+func (c *call) send(msg voice.Message) {
+	c.wsMu.Lock()
+	defer c.wsMu.Unlock()
+	_ = c.ws.WriteJSON(msg)
+}
+
+func (c *call) close() {
+	c.closeOnce.Do(func() {
+		if c.pc != nil {
+			_ = c.pc.Close()
+		}
+		if c.ws != nil {
+			_ = c.ws.Close()
+		}
+	})
+}
+
+// wait blocks until the channel is closed
+func wait(ctx context.Context, ch <-chan struct{}, what string) error {
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%s: %w", what, errTimeout)
+	}
+}
@@ -1,22 +1,115 @@
+// Command client load-tests the worker: each call signals WebRTC over the
+// WebSocket of the worker, waits for the greeting, streams a WAV file as the
+// caller and records the reply. The time to the first audio of the greeting
+// and the round trip from the end of the caller to the reply are reported.
+//
+//	client -url ws://localhost:8080/ws -api-key KEY -api-secret SECRET -wav question.wav -calls 20 -out recordings
 package main
 
 import (
-	"github.com/gorilla/websocket"
+	"VoiceSculptor/internal/voice"
+	"VoiceSculptor/pkg/codec"
+	"context"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"time"
 )
 
 func main() {
-	dial, _, err := websocket.DefaultDialer.Dial("ws://localhost:8080/ws", nil)
+	url := flag.String("url", "ws://localhost:8080/ws", "WebSocket url of the worker, ?assistantId= selects the assistant")
+	apiKey := flag.String("api-key", "", "api key of the credential the calls are answered with, required")
+	apiSecret := flag.String("api-secret", "", "api secret of the credential, required")
+	wavFile := flag.String("wav", "", "WAV file streamed as the caller, required")
+	calls := flag.Int("calls", 1, "number of concurrent calls")
+	rampUp := flag.Duration("ramp-up", 100*time.Millisecond, "delay between the start of two calls")
+	codecName := flag.String("codec", codec.PCMU, "audio codec offered (G722, PCMU, PCMA)")
+	waitGreeting := flag.Duration("wait-greeting", 10*time.Second, "how long the caller waits for the greeting to end before talking")
+	timeout := flag.Duration("timeout", time.Minute, "how long a call lasts at most")
+	out := flag.String("out", "", "directory the replies are recorded to as <session id>.wav, not recorded when empty")
+	flag.Parse()
+
+	if *wavFile == "" || *apiKey == "" || *apiSecret == "" || *calls < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	wav, err := codec.LoadWAV(*wavFile)
 	if err != nil {
-		log.Println("Failed to connect to WebSocket server:", err)
+		log.Fatal("Error reading the caller WAV:", err)
+	}
+	c, ok := codec.Lookup(*codecName)
+	if !ok {
+		log.Fatal("Unsupported codec:", *codecName)
+	}
+	if *out != "" {
+		if err := os.MkdirAll(*out, 0o755); err != nil {
+			log.Fatal("Error creating the recording directory:", err)
+		}
+	}
+	config := callConfig{
+		URL:          *url,
+		APIKey:       *apiKey,
+		APISecret:    *apiSecret,
+		Codec:        c,
+		Input:        codec.Resample(wav.Mono(), wav.SampleRate, voice.SampleRate),
+		WaitGreeting: *waitGreeting,
+		Timeout:      *timeout,
 	}
-	dial.WriteMessage(websocket.TextMessage, []byte("Hello, WebSocket!"))
-	for {
-		_, p, err := dial.ReadMessage()
-		if err != nil {
 
-			log.Println("Failed to read message:", err)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	results := make([]callResult, *calls)
+	var wg sync.WaitGroup
+	started := time.Now()
+	for i := range results {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(*rampUp):
+			}
 		}
-		log.Println("Received: ", string(p))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = runCall(ctx, config)
+			r := &results[i]
+			if r.Err != nil {
+				log.Printf("call %d failed: %v", i+1, r.Err)
+				return
+			}
+			log.Printf("call %d %s: first audio %v, round trip %v, heard %q, replied %q",
+				i+1, r.SessionID, r.TimeToFirstAudio.Round(time.Millisecond), r.RoundTrip.Round(time.Millisecond), r.Transcript, r.Reply)
+			if *out != "" {
+				if err := saveRecording(filepath.Join(*out, recordingName(r, i)), r.Recording); err != nil {
+					log.Printf("call %d: save recording failed: %v", i+1, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	fmt.Print(newReport(results, time.Since(started)))
+	if failed := countFailed(results); failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func recordingName(r *callResult, i int) string {
+	if r.SessionID == "" {
+		return fmt.Sprintf("call-%d.wav", i+1)
+	}
+	return r.SessionID + ".wav"
+}
+
+func saveRecording(path string, pcm []byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
 	}
+	defer f.Close()
+	return codec.WriteWAV(f, &codec.WAV{SampleRate: voice.SampleRate, Channels: 1, Data: pcm})
 }
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// report the latencies of the answered calls
+type report struct {
+	calls, failed int
+	elapsed       time.Duration
+	firstAudio    []time.Duration
+	roundTrip     []time.Duration
+}
+
+func newReport(results []callResult, elapsed time.Duration) *report {
+	r := &report{calls: len(results), elapsed: elapsed}
+	for _, result := range results {
+		if result.Err != nil {
+			r.failed++
+			continue
+		}
+		r.firstAudio = append(r.firstAudio, result.TimeToFirstAudio)
+		r.roundTrip = append(r.roundTrip, result.RoundTrip)
+	}
+	slices.Sort(r.firstAudio)
+	slices.Sort(r.roundTrip)
+	return r
+}
+
+func countFailed(results []callResult) int {
+	n := 0
+	for _, r := range results {
+		if r.Err != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "calls: %d, failed: %d, elapsed: %v\n", r.calls, r.failed, r.elapsed.Round(time.Millisecond))
+	fmt.Fprintf(&b, "%-20s %8s %8s %8s %8s %8s\n", "", "min", "avg", "p50", "p95", "max")
+	b.WriteString(latencyRow("time to first audio", r.firstAudio))
+	b.WriteString(latencyRow("round trip", r.roundTrip))
+	return b.String()
+}
+
+// latencyRow formats the statistics of the sorted durations
+func latencyRow(name string, sorted []time.Duration) string {
+	if len(sorted) == 0 {
+		return fmt.Sprintf("%-20s %8s\n", name, "-")
+	}
+	var sum time.Duration
+	for _, d := range sorted {
+		sum += d
+	}
+	ms := func(d time.Duration) string {
+		return fmt.Sprintf("%dms", d.Milliseconds())
+	}
+	return fmt.Sprintf("%-20s %8s %8s %8s %8s %8s\n", name,
+		ms(sorted[0]), ms(sum/time.Duration(len(sorted))), ms(percentile(sorted, 50)), ms(percentile(sorted, 95)), ms(sorted[len(sorted)-1]))
+}
+
+// percentile returns the nearest rank percentile of the sorted durations
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := (len(sorted)*p+99)/100 - 1
+	return sorted[max(i, 0)]
+}
@@ -1,108 +1,485 @@
 package main
 
 import (
+	"VoiceSculptor/internal/chat"
+	"VoiceSculptor/internal/customer"
+	"VoiceSculptor/internal/escalation"
+	"VoiceSculptor/internal/knowledge"
+	"VoiceSculptor/internal/models"
+	"VoiceSculptor/internal/task"
+	"VoiceSculptor/internal/voice"
+	"VoiceSculptor/pkg/asr"
+	"VoiceSculptor/pkg/config"
+	constants "VoiceSculptor/pkg/constant"
+	"VoiceSculptor/pkg/llm"
+	"VoiceSculptor/pkg/logger"
+	"VoiceSculptor/pkg/pbx"
+	"VoiceSculptor/pkg/prompt"
+	stores "VoiceSculptor/pkg/storage"
+	"VoiceSculptor/pkg/tts"
+	"VoiceSculptor/pkg/util"
+	"VoiceSculptor/pkg/vad"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
-	"github.com/pion/webrtc/v3"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
-// 定义一个 upgrader，将 HTTP 请求升级为 WebSocket 请求
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
-}
+// 定义一个 upgrader，将 HTTP 请求升级为 WebSocket 请求, 浏览器只能从 -origins 中的来源连接
+var upgrader = websocket.Upgrader{}
+
+const sessionExpirySeconds = 30 * 60
+
+// credentialField the credential of the api key of the request
+const credentialField = "credential"
+
+// ringTimeout how long a campaign call rings before it counts as no answer
+const ringTimeout = 30 * time.Second
+
+var errRouteClosed = errors.New("phone route closed")
 
 // GoPBX Server
 func main() {
-	r := gin.Default()
+	mode := flag.String("mode", "test", "running environment (development, test, production)")
+	port := flag.String("addr", ":8080", "WebSocket serve address")
+	llmProvider := flag.String("llm", "", "llm provider of the calls whose credential sets none, required, fake answers without a model")
+	llmApiKey := flag.String("llm-key", "", "llm api key")
+	llmApiURL := flag.String("llm-url", "", "llm api url")
+	llmModel := flag.String("llm-model", "", "llm model")
+	asrProvider := flag.String("asr", "", "asr provider of the calls whose credential sets none, the caller can only type without one")
+	asrAppID := flag.String("asr-app-id", "", "asr app id, the script file of the scripted provider")
+	asrSecretID := flag.String("asr-secret-id", "", "asr secret id")
+	asrSecretKey := flag.String("asr-secret-key", "", "asr secret key")
+	asrLanguage := flag.String("asr-language", "", "asr language")
+	ttsProvider := flag.String("tts", "", "tts provider of the calls whose credential sets none, replies are only sent as text without one")
+	ttsAppID := flag.String("tts-app-id", "", "tts app id, the wav file of the wav provider")
+	ttsSecretID := flag.String("tts-secret-id", "", "tts secret id")
+	ttsSecretKey := flag.String("tts-secret-key", "", "tts secret key")
+	ttsVoice := flag.String("tts-voice", "", "tts voice")
+	ttsSpeed := flag.Float64("tts-speed", tts.DefaultSpeed, "tts speed")
+	ttsVolume := flag.Float64("tts-volume", tts.DefaultVolume, "tts volume, 0 to 10")
+	codecs := flag.String("codecs", "", "comma separated audio codecs by preference (G722, PCMU, PCMA), all supported codecs when empty")
+	assistantID := flag.Uint("assistant", 0, "id of the assistant answering the calls, loaded from the configured database")
+	enableVAD := flag.Bool("vad", true, "detect the voice activity of the caller, the caller can interrupt the assistant")
+	inbandDTMF := flag.Bool("inband-dtmf", true, "detect the keys pressed in the caller audio, for the callers which do not send telephone events")
+	enablePBX := flag.Bool("pbx", false, "answer the incoming calls of RustPBX, see RUST_PBX_URL and RUST_PBX_WEBSOCKET_URL, the calls are routed by the phone routes of the configured database")
+	enableCampaigns := flag.Bool("campaigns", false, "call the contacts of the running campaigns of the configured database through RustPBX")
+	record := flag.Bool("record", false, "record the calls to the default store, linked from the transcript")
+	origins := flag.String("origins", "", "comma separated origins the browsers may open the WebSockets from (https://example.com), the origin of the worker only when empty")
+	systemPrompt := flag.String("system-prompt", "You are a helpful voice assistant, answer briefly.", "system prompt of the assistant")
+	flag.Parse()
 
-	r.GET("/ws", handleConnection)
-	port := ":8080"
-	fmt.Printf("WebSocket server running at %s...\n", port)
-	err := r.Run(port)
+	if *mode != "" {
+		os.Setenv("APP_ENV", *mode)
+	}
+	if err := config.Load(); err != nil {
+		panic("config load failed: " + err.Error())
+	}
+	if err := logger.Init(&config.GlobalConfig.Log, config.GlobalConfig.Mode); err != nil {
+		panic(err)
+	}
+	upgrader.CheckOrigin = checkOrigin(splitList(*origins))
+
+	if *llmProvider == "" {
+		log.Fatalf("Error creating llm provider: -llm is required, one of %s", strings.Join(llm.Providers(), ", "))
+	}
+	provider, err := llm.New(*llmProvider, llm.Config{APIKey: *llmApiKey, BaseURL: *llmApiURL, Model: *llmModel})
+	if err != nil {
+		log.Fatal("Error creating llm provider:", err)
+	}
+	var newTranscriber func() (voice.Transcriber, error)
+	if *asrProvider != "" {
+		recognizer, err := asr.New(*asrProvider, asr.Config{AppID: *asrAppID, SecretID: *asrSecretID, SecretKey: *asrSecretKey, Language: *asrLanguage})
+		if err != nil {
+			log.Fatal("Error creating asr provider:", err)
+		}
+		newTranscriber = func() (voice.Transcriber, error) {
+			return recognizer.NewStream(context.Background(), asr.Options{SampleRate: voice.SampleRate})
+		}
+	}
+	// the voice of the replies, with any tts provider
+	speech := tts.Options{SampleRate: voice.SampleRate, Voice: *ttsVoice, Speed: float32(*ttsSpeed), Volume: float32(*ttsVolume)}
+	var newSynthesizer func() (voice.Synthesizer, error)
+	if *ttsProvider != "" {
+		synthesizer, err := tts.New(*ttsProvider, tts.Config{AppID: *ttsAppID, SecretID: *ttsSecretID, SecretKey: *ttsSecretKey})
+		if err != nil {
+			log.Fatal("Error creating tts provider:", err)
+		}
+		newSynthesizer = func() (voice.Synthesizer, error) {
+			return tts.WithOptions(synthesizer, speech), nil
+		}
+	}
+	options := chat.Options{
+		SystemPrompt: *systemPrompt,
+		Temperature:  0.7,
+		MaxTokens:    512,
+	}
+	var vadOption *vad.Config
+	if *enableVAD {
+		vadOption = &vad.Config{}
+	}
+	defaults := voice.CallOptions{Options: options, VAD: vadOption, Speech: speech}
+	// the users calling over /ws, the assistants, the phone routes and the transcripts
+	db, err := openDatabase()
+	if err != nil {
+		log.Fatal("Error opening database:", err)
+	}
+	engine := chat.NewEngine(sessionExpirySeconds)
+	defaults.Knowledge = knowledge.NewService(db, stores.Default(), config.GlobalConfig.EmbeddingProvider, config.GlobalConfig.Embedding).Knowledge
+	// the calls escalated to the staff, see /escalation
+	desk := escalation.NewDesk(db, engine, escalation.ChannelCall)
+	defaults.Escalation = desk.Escalation
+	// the assistants the key menus hand off to
+	defaults.ResolveAssistant = voice.AssistantResolver(db, defaults)
+	if *assistantID > 0 {
+		assistant, err := voice.LoadAssistant(db, uint(*assistantID))
+		if err != nil {
+			log.Fatal("Error loading assistant:", err)
+		}
+		defaults = voice.AssistantOptions(defaults, assistant, assistant.Instruction)
+	}
+	var recordings stores.Store
+	if *record {
+		recordings = stores.Default()
+	}
+	gateway, err := voice.NewGateway(engine, voice.Config{
+		ICE:              config.GlobalConfig.ICE,
+		Codecs:           splitList(*codecs),
+		Provider:         provider,
+		NewTranscriber:   newTranscriber,
+		NewSynthesizer:   newSynthesizer,
+		Options:          defaults.Options,
+		VAD:              defaults.VAD,
+		IVR:              defaults.IVR,
+		ResolveAssistant: defaults.ResolveAssistant,
+		DetectDTMF:       *inbandDTMF,
+		DB:               db,
+		Recordings:       recordings,
+	})
+	if err != nil {
+		log.Fatal("Error creating voice gateway:", err)
+	}
+
+	if *enablePBX || *enableCampaigns {
+		client, err := pbx.Dial(context.Background(), pbx.Config{
+			URL:          config.GlobalConfig.RustPbxUrl,
+			WebSocketURL: config.GlobalConfig.RustPbxWebSocketURL,
+			SampleRate:   voice.SampleRate,
+		})
+		if err != nil {
+			log.Fatal("Error connecting to the pbx:", err)
+		}
+		go func() {
+			if err := client.Run(context.Background(), func(ctx context.Context, call *pbx.Call) {
+				// the incoming calls are rejected unless -pbx is set
+				if *enablePBX {
+					handleCall(ctx, call, gateway, db, defaults)
+				}
+			}); err != nil {
+				log.Fatal("Error running the pbx client:", err)
+			}
+		}()
+		if *enableCampaigns {
+			dialer := &campaignDialer{client: client, gateway: gateway, db: db, defaults: defaults}
+			go func() {
+				if err := task.NewCampaignScheduler(db, dialer).Run(context.Background()); err != nil {
+					log.Fatal("Error running the campaigns:", err)
+				}
+			}()
+		}
+	}
+
+	r := gin.Default()
+	r.GET("/ws", apiKeyRequired(db), func(c *gin.Context) {
+		handleConnection(c, gateway, db, defaults)
+	})
+	// 工作人员接管转人工的通话, 回复由 TTS 播放给对方
+	staff := r.Group("/escalation", apiKeyRequired(db), staffRequired)
+	staff.GET("/ws", func(c *gin.Context) {
+		if conn, err := upgrader.Upgrade(c.Writer, c.Request, nil); err == nil {
+			_ = desk.ServeRequests(c.Request.Context(), conn, c.MustGet(constants.UserField).(*models.User))
+		}
+	})
+	staff.GET("/:sessionId/ws", func(c *gin.Context) {
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			return
+		}
+		if err := desk.Join(c.Request.Context(), conn, c.Param("sessionId"), c.MustGet(constants.UserField).(*models.User)); err != nil {
+			logger.Warn("join escalated call failed", zap.String("sessionId", c.Param("sessionId")), zap.Error(err))
+		}
+	})
+	fmt.Printf("WebSocket server running at %s...\n", *port)
+	err = r.Run(*port)
 	if err != nil {
 		log.Fatal("Error starting server:", err)
 	}
 }
 
-type Server struct {
-	conn     *websocket.Conn
-	peerConn *webrtc.PeerConnection
+// checkOrigin accepts the WebSockets of the clients which are not browsers,
+// those of the browsers only from the origin of the worker or an allowed one
+func checkOrigin(allowed []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		for _, o := range allowed {
+			if strings.EqualFold(origin, o) {
+				return true
+			}
+		}
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+}
+
+// apiKeyRequired authenticates the users by the api key and secret of one of
+// their credentials, the credential is kept for the call
+func apiKeyRequired(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apiKey, apiSecret := c.GetHeader("X-API-KEY"), c.GetHeader("X-API-SECRET")
+		if apiKey == "" {
+			apiKey, apiSecret = c.Query("apiKey"), c.Query("apiSecret")
+		}
+		if apiKey == "" || apiSecret == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "api key required"})
+			return
+		}
+		c.Set(constants.DbField, db)
+		user, err := models.GetUserByAPIKey(c, apiKey, apiSecret)
+		if err != nil || user == nil || user.ID == 0 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid api key"})
+			return
+		}
+		credential, err := models.GetUserCredentialByAPIKey(db, user.ID, apiKey)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid api key"})
+			return
+		}
+		c.Set(constants.UserField, user)
+		c.Set(credentialField, credential)
+		c.Next()
+	}
+}
+
+// staffRequired accepts the staff users and the superusers
+func staffRequired(c *gin.Context) {
+	user := c.MustGet(constants.UserField).(*models.User)
+	if !user.IsStaff && !user.IsSuperUser {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "staff only"})
+		return
+	}
+	c.Next()
 }
 
-func handleConnection(c *gin.Context) {
+// handleConnection answers the call of a WebSocket for the user of the api
+// key with the providers of its credential: by the assistant of the
+// assistantId query, else the default assistant of the worker, which the user
+// must be able to see, else the assistant of the flags
+func handleConnection(c *gin.Context, gateway *voice.Gateway, db *gorm.DB, defaults voice.CallOptions) {
+	user := c.MustGet(constants.UserField).(*models.User)
+	credential := c.MustGet(credentialField).(*models.UserCredential)
+	opts, err := userCall(db, defaults, user, credential, c.Query("assistantId"))
+	if err != nil {
+		logger.Warn("load call assistant failed", zap.Uint("userId", user.ID), zap.Error(err))
+		status := http.StatusServiceUnavailable
+		var e *util.Error
+		if errors.As(err, &e) {
+			status = e.Code
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
 	// 升级 HTTP 请求为 WebSocket 连接
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Println("Error upgrading connection:", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to upgrade connection"})
 		return
 	}
-	defer conn.Close()
+	// 一个 WebSocket 连接对应一路通话, 挂断后返回
+	if err := gateway.Serve(c.Request.Context(), conn, &opts); err != nil {
+		logger.Warn("voice call failed", zap.Error(err))
+	}
+}
 
-	connection, err := webrtc.NewPeerConnection(webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{
-			{
-				URLs: []string{"stun:stun.l.google.com:19302"},
-			},
-		},
-	})
+// userCall returns the options of a call of the user, see handleConnection
+func userCall(db *gorm.DB, defaults voice.CallOptions, user *models.User, credential *models.UserCredential, assistantID string) (voice.CallOptions, error) {
+	opts := defaults
+	id := defaults.Options.AssistantID
+	if assistantID != "" {
+		parsed, err := strconv.ParseUint(assistantID, 10, 64)
+		if err != nil {
+			return opts, &util.Error{Code: http.StatusBadRequest, Message: "invalid assistant id"}
+		}
+		id = uint(parsed)
+	}
+	if id != 0 {
+		if _, err := models.GetAssistant(db, user, id); err != nil {
+			return opts, err
+		}
+		assistant, err := voice.LoadAssistant(db, id)
+		if err != nil {
+			return opts, err
+		}
+		opts = voice.AssistantOptions(defaults, assistant, assistant.Instruction)
+	}
+	opts.Options.UserID = user.ID
+	return voice.CredentialOptions(db, opts, credential.ID)
+}
 
-	defer connection.Close()
+// handleCall answers a call of the PBX with the assistant of its route until
+// either side hangs up, the calls of numbers without a route are answered by
+// the default assistant of the worker
+func handleCall(ctx context.Context, call *pbx.Call, gateway *voice.Gateway, db *gorm.DB, defaults voice.CallOptions) {
+	logger.Info("pbx call incoming", zap.String("callId", call.ID), zap.String("caller", call.Caller), zap.String("callee", call.Callee))
+	opts, fallback, err := routeCall(db, call, defaults)
+	if err != nil {
+		logger.Info("pbx call not answered by an assistant", zap.String("callId", call.ID), zap.String("fallback", fallback), zap.Error(err))
+		if fallback == "" {
+			return
+		}
+		if err := call.Transfer(fallback); err != nil {
+			logger.Warn("transfer pbx call failed", zap.String("callId", call.ID), zap.Error(err))
+			return
+		}
+		select {
+		case <-call.Done():
+		case <-ctx.Done():
+		}
+		return
+	}
+	if err := call.Answer(ctx); err != nil {
+		logger.Warn("answer pbx call failed", zap.String("callId", call.ID), zap.Error(err))
+		return
+	}
+	if opts != nil && db != nil {
+		// the assistant remembers the caller across the calls
+		opts.Options.Customer = customer.NewService(db).Customer(opts.Options.UserID, opts.Options.AssistantID, call.Caller)
+	}
+	if err := gateway.Bridge(ctx, call, opts); err != nil {
+		logger.Warn("pbx call failed", zap.String("callId", call.ID), zap.Error(err))
+	}
+}
 
-	//远程音视频轨道（track）被接收到时触发
-	connection.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
-		log.Println("Received track", track.ID(), track.StreamID())
-	})
+// campaignDialer calls the contacts of the campaigns through the PBX, an
+// answered call is bridged to the assistant of the campaign until it ends
+type campaignDialer struct {
+	client   *pbx.Client
+	gateway  *voice.Gateway
+	db       *gorm.DB
+	defaults voice.CallOptions
+}
 
-	sample, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{
-		MimeType:  webrtc.MimeTypePCMU,
-		Channels:  1,
-		ClockRate: 8000,
-	}, "audio", "pion")
+func (d *campaignDialer) Dial(ctx context.Context, campaign *models.Campaign, contact *models.CampaignContact) (task.CallResult, error) {
+	assistant, err := voice.LoadAssistant(d.db, campaign.AssistantID)
 	if err != nil {
-		log.Println("Error creating track:", err)
-		return
+		return task.CallResult{}, err
+	}
+	greeting, err := campaign.RenderGreeting(contact)
+	if err != nil {
+		return task.CallResult{}, err
+	}
+	if greeting == "" {
+		greeting = assistant.Instruction
+	}
+	opts, err := voice.CredentialOptions(d.db, voice.AssistantOptions(d.defaults, assistant, greeting), campaign.CredentialID)
+	if err != nil {
+		return task.CallResult{}, err
 	}
 
-	//向当前连接中添加一个媒体轨道（如音频或视频）
-	connection.AddTrack(sample)
+	ringCtx, cancel := context.WithTimeout(ctx, ringTimeout)
+	call, err := d.client.Invite(ringCtx, campaign.CallerNumber, contact.Number)
+	cancel()
+	switch {
+	case errors.Is(err, pbx.ErrBusy):
+		return task.CallResult{Outcome: models.CallOutcomeBusy}, err
+	case errors.Is(err, pbx.ErrNoAnswer):
+		return task.CallResult{Outcome: models.CallOutcomeNoAnswer}, err
+	case err != nil:
+		return task.CallResult{}, err
+	}
+	logger.Info("campaign call answered", zap.String("callId", call.ID), zap.Uint("campaignId", campaign.ID), zap.String("callee", contact.Number))
 
-	offer, err := connection.CreateOffer(nil)
+	opts.Options.Customer = customer.NewService(d.db).Customer(assistant.UserID, assistant.ID, contact.Number)
+	result := task.CallResult{Outcome: models.CallOutcomeAnswered}
+	opts.OnStart = func(sessionID string) {
+		result.SessionID = sessionID
+	}
+	err = d.gateway.Bridge(ctx, call, &opts)
+	return result, err
+}
+
+// routeCall returns the options of the assistant of the route of the number
+// called, those of the default assistant without a route. A closed route or
+// an unavailable assistant returns an error with the fallback number of the route.
+func routeCall(db *gorm.DB, call *pbx.Call, defaults voice.CallOptions) (*voice.CallOptions, string, error) {
+	decision, err := models.ResolvePhoneRoute(db, call.Callee, call.Caller, time.Now())
+	if errors.Is(err, models.ErrPhoneRouteNotFound) {
+		opts, err := defaultCall(db, defaults)
+		return opts, "", err
+	}
 	if err != nil {
-		log.Println("Error creating offer:", err)
+		return nil, "", err
+	}
+	if decision.Closed {
+		return nil, decision.Fallback, errRouteClosed
 	}
-	err = connection.SetLocalDescription(offer)
+	assistant, err := voice.LoadAssistant(db, decision.AssistantID)
 	if err != nil {
-		log.Println("Error setting local description:", err)
+		return nil, decision.Fallback, err
 	}
+	opts, err := voice.CredentialOptions(db, voice.AssistantOptions(defaults, assistant, decision.Greeting), 0)
+	if err != nil {
+		return nil, decision.Fallback, err
+	}
+	return &opts, "", nil
+}
 
-	connection.OnICEConnectionStateChange(func(connectionState webrtc.ICEConnectionState) {
-		log.Println("ICE connection state changed:", connectionState)
-		if connectionState == webrtc.ICEConnectionStateConnected {
-			log.Println("Connection established")
-		}
-	})
-	<-webrtc.GatheringCompletePromise(connection)
-	// 循环接收消息并回复
-	for {
-		// 读取消息
-		messageType, p, err := conn.ReadMessage()
-		if err != nil {
-			log.Println("Error reading message:", err)
-			break
-		}
+// defaultCall returns the options of the default assistant of the worker
+// answered with the providers of the credential of its owner, nil for the
+// assistant of the flags
+func defaultCall(db *gorm.DB, defaults voice.CallOptions) (*voice.CallOptions, error) {
+	if defaults.Options.AssistantID == 0 {
+		return nil, nil
+	}
+	opts, err := voice.CredentialOptions(db, defaults, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &opts, nil
+}
 
-		// 打印收到的消息
-		fmt.Printf("Received message: %s\n", p)
-		err = conn.WriteMessage(messageType, p)
-		if err != nil {
-			log.Println("Error writing message:", err)
-			break
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
 		}
 	}
+	return items
+}
+
+// openDatabase opens the configured database, it keeps the assistants, the
+// phone routes and the transcripts of the calls
+func openDatabase() (*gorm.DB, error) {
+	db, err := util.InitDatabase(os.Stdout, config.GlobalConfig.DBDriver, config.GlobalConfig.DSN)
+	if err != nil {
+		return nil, err
+	}
+	if err := prompt.InitPromptSystem(db); err != nil {
+		logger.Warn("load prompts failed", zap.Error(err))
+	}
+	return db, nil
 }
@@ -0,0 +1,110 @@
+package chat
+
+import (
+	"VoiceSculptor/pkg/llm"
+	"VoiceSculptor/pkg/prompt"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// CustomerPrompt the prompt rendering the request to extract the memory
+	// of the customer, with the arguments facts and conversation,
+	// customerFallback is used without it
+	CustomerPrompt = "extract_customer_memory"
+
+	// rememberTimeout how long the extraction of a closed conversation may take
+	rememberTimeout = time.Minute
+)
+
+const customerFallback = "Read the conversation between a customer and an assistant below. Reply with a JSON object only: \"summary\", a short summary of the conversation for the next conversation with this customer, and \"facts\", the list of the facts about the customer worth remembering, such as the name, the contact details, the orders or the preferences, merged with the facts already known and without the outdated ones.\n\nFacts already known:\n%s\n\nConversation:\n%s"
+
+// Customer the memory of the customer across the conversations
+type Customer interface {
+	// Recall returns what is remembered of the customer, empty for a new customer
+	Recall() string
+	// Remember keeps the summary of the closed conversation and the facts
+	// known about the customer, facts is nil if they could not be extracted
+	Remember(ctx context.Context, sessionID, summary string, facts []string) error
+	// Facts returns the facts known about the customer
+	Facts() []string
+}
+
+// customerMemory what the model extracted from a conversation
+type customerMemory struct {
+	Summary string   `json:"summary"`
+	Facts   []string `json:"facts"`
+}
+
+// remember extracts the memory of the customer from the closed
+// conversation, nothing is remembered without a user message
+func (c *Conversation) remember() {
+	c.mu.Lock()
+	history := append([]llm.Message(nil), c.history...)
+	summary := c.summary
+	c.mu.Unlock()
+
+	var transcript strings.Builder
+	if summary != "" {
+		fmt.Fprintf(&transcript, "(earlier) %s\n", summary)
+	}
+	spoke := false
+	for _, msg := range history {
+		if msg.Content == "" || msg.Role == llm.RoleTool {
+			continue
+		}
+		spoke = spoke || msg.Role == llm.RoleUser
+		fmt.Fprintf(&transcript, "%s: %s\n", msg.Role, msg.Content)
+	}
+	if !spoke {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), rememberTimeout)
+	defer cancel()
+	customer := c.Options.Customer
+	facts := strings.Join(customer.Facts(), "\n")
+	request, err := prompt.Render(CustomerPrompt, map[string]any{"facts": facts, "conversation": transcript.String()})
+	if err != nil {
+		request = fmt.Sprintf(customerFallback, facts, transcript.String())
+	}
+	resp, err := c.provider.ChatStream(ctx, &llm.ChatRequest{
+		Messages:    []llm.Message{{Role: llm.RoleUser, Content: request}},
+		Temperature: summaryTemperature,
+		MaxTokens:   summaryMaxTokens,
+	}, func(llm.Chunk) error { return nil })
+	if resp != nil && c.Options.OnUsage != nil {
+		c.Options.OnUsage(resp.Usage)
+	}
+	if err != nil || resp == nil {
+		_ = customer.Remember(ctx, c.ID, "", nil)
+		return
+	}
+	memory := parseCustomerMemory(resp.Content)
+	_ = customer.Remember(ctx, c.ID, memory.Summary, memory.Facts)
+}
+
+// parseCustomerMemory decodes the JSON object of the reply, a reply which is
+// not JSON is taken as the summary
+func parseCustomerMemory(reply string) customerMemory {
+	reply = strings.TrimSpace(reply)
+	var memory customerMemory
+	start, end := strings.Index(reply, "{"), strings.LastIndex(reply, "}")
+	if start >= 0 && end > start && json.Unmarshal([]byte(reply[start:end+1]), &memory) == nil {
+		memory.Summary = strings.TrimSpace(memory.Summary)
+		if memory.Facts != nil {
+			facts := make([]string, 0, len(memory.Facts))
+			for _, fact := range memory.Facts {
+				if fact = strings.TrimSpace(fact); fact != "" {
+					facts = append(facts, fact)
+				}
+			}
+			memory.Facts = facts
+		}
+		return memory
+	}
+	return customerMemory{Summary: reply}
+}
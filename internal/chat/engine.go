@@ -0,0 +1,596 @@
+package chat
+
+import (
+	"VoiceSculptor/pkg/llm"
+	"VoiceSculptor/pkg/session"
+	"VoiceSculptor/pkg/util"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	EventToken     = "token"
+	EventDone      = "done"
+	EventError     = "error"
+	EventHeartbeat = "heartbeat"
+	EventTool      = "tool"
+	EventCitations = "citations"
+	// EventEscalation the escalation to a human agent changed, see EscalationData
+	EventEscalation = "escalation"
+)
+
+const (
+	sessionConversationKey = "conversation"
+	eventBufferSize        = 256
+	// maxToolRounds the rounds of tool calls of a reply, the reply ends with
+	// the text generated so far once reached
+	maxToolRounds = 5
+)
+
+var ErrConversationNotFound = &util.Error{Code: http.StatusNotFound, Message: "chat session not found"}
+var ErrConversationClosed = &util.Error{Code: http.StatusGone, Message: "chat session closed"}
+
+// Event a server-sent event of the conversation
+type Event struct {
+	Type string `json:"type"`
+	Data any    `json:"data,omitempty"`
+}
+
+type TokenData struct {
+	Content string `json:"content"`
+}
+
+type DoneData struct {
+	Seq         int       `json:"seq,omitempty"` // the assistant turn, 0 if nothing was generated
+	Content     string    `json:"content"`
+	Interrupted bool      `json:"interrupted,omitempty"`
+	LatencyMs   int64     `json:"latencyMs"`
+	Usage       llm.Usage `json:"usage"`
+}
+
+type ErrorData struct {
+	Message string `json:"message"`
+}
+
+// ToolData a tool called by the assistant while generating the reply
+type ToolData struct {
+	Seq       int    `json:"seq"`
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+	Result    string `json:"result,omitempty"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latencyMs"`
+}
+
+// Tools the functions the assistant can call
+type Tools interface {
+	// Tools returns the tools offered to the model
+	Tools() []llm.Tool
+	// Call executes a call requested by the model and returns its result
+	Call(ctx context.Context, call llm.ToolCall) (string, error)
+}
+
+// Passage an excerpt of the knowledge base the reply is grounded on
+type Passage struct {
+	Index      int     `json:"index"` // cited as [index] in the reply
+	DocumentID uint    `json:"documentId"`
+	Document   string  `json:"document"`
+	ChunkID    uint    `json:"chunkId"`
+	Seq        int     `json:"seq"` // the position of the excerpt in the document
+	Score      float64 `json:"score"`
+	Content    string  `json:"content"`
+}
+
+// CitationsData the passages retrieved for the reply, sent before its tokens
+type CitationsData struct {
+	Citations []Passage `json:"citations"`
+}
+
+// Knowledge retrieves the passages the replies are grounded on
+type Knowledge interface {
+	// Retrieve returns the passages relevant to the query, best first
+	Retrieve(ctx context.Context, query string) ([]Passage, error)
+}
+
+// ToolCall the invocation of a tool turn, its result is the content of the turn
+type ToolCall struct {
+	ID        string
+	Name      string
+	Arguments string
+	Error     string
+}
+
+// Turn a completed message of the conversation
+type Turn struct {
+	Seq         int
+	AssistantID uint // the assistant of the conversation when the turn completed
+	Role        string
+	Content     string
+	LatencyMs   int64
+	Usage       llm.Usage
+	Interrupted bool
+	Tool        *ToolCall // set for the tool turns
+	AgentID     uint      // the human agent who wrote the turn, 0 for the assistant
+}
+
+// Options the settings of a conversation
+type Options struct {
+	UserID       uint
+	AssistantID  uint
+	CredentialID uint
+	SystemPrompt string
+	Temperature  float32
+	MaxTokens    int
+	Tools        Tools       // nil without tools
+	Knowledge    Knowledge   // nil without knowledge base
+	Memory       *Memory     // nil sends the whole history
+	Customer     Customer    // nil for an anonymous customer
+	Escalation   *Escalation // nil without escalation to a human agent
+
+	// OnUsage is called with the tokens consumed by every generation
+	OnUsage func(usage llm.Usage)
+	// OnTurn is called in order with every completed turn
+	OnTurn func(turn Turn)
+	// OnClose is called once when the conversation is closed
+	OnClose func()
+}
+
+// Conversation a running chat between a client and an assistant
+type Conversation struct {
+	ID      string
+	Options Options
+
+	provider llm.Provider
+	ctx      context.Context
+	close    context.CancelFunc
+	events   chan Event
+
+	// turnMu serializes the turns, a new turn interrupts the running one
+	turnMu  sync.Mutex
+	mu      sync.Mutex
+	history []llm.Message
+	seq     int
+	// the running summary of history[:summarized], see Memory
+	summary    string
+	summarized int
+	// compactMu serializes the summarizations, compacting tracks them
+	compactMu  sync.Mutex
+	compacting sync.WaitGroup
+	escalation EscalationData
+	watchers   map[chan Turn]struct{}
+	cancel     context.CancelFunc
+	running    chan struct{}
+	closeOnce  sync.Once
+}
+
+// Events returns the events of the conversation
+func (c *Conversation) Events() <-chan Event {
+	return c.events
+}
+
+// Done is closed when the conversation is closed
+func (c *Conversation) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
+
+// History returns a copy of the messages exchanged so far
+func (c *Conversation) History() []llm.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]llm.Message(nil), c.history...)
+}
+
+// Send appends a user message and generates the reply,
+// the reply being generated is interrupted. No reply is generated while
+// a human agent took the conversation over.
+func (c *Conversation) Send(text string) error {
+	c.turnMu.Lock()
+	defer c.turnMu.Unlock()
+	if c.ctx.Err() != nil {
+		return ErrConversationClosed
+	}
+	c.Cancel()
+
+	c.mu.Lock()
+	c.history = append(c.history, llm.Message{Role: llm.RoleUser, Content: text})
+	c.mu.Unlock()
+	c.record(Turn{Role: llm.RoleUser, Content: text})
+	if c.takenOver() {
+		return nil
+	}
+	c.escalateOnKeyword(text)
+	c.reply()
+	return nil
+}
+
+// Greet lets the assistant open the conversation
+func (c *Conversation) Greet() error {
+	c.turnMu.Lock()
+	defer c.turnMu.Unlock()
+	if c.ctx.Err() != nil {
+		return ErrConversationClosed
+	}
+	if c.takenOver() {
+		return nil
+	}
+	c.Cancel()
+	c.reply()
+	return nil
+}
+
+// Append appends a message which was not generated nor sent by the client
+// to the history, such as the prompt of a key menu or a key pressed, without
+// generating a reply, returns the seq of its turn
+func (c *Conversation) Append(role, text string) int {
+	c.mu.Lock()
+	c.history = append(c.history, llm.Message{Role: role, Content: text})
+	c.mu.Unlock()
+	return c.record(Turn{Role: role, Content: text})
+}
+
+// Handoff hands the conversation over to the assistant of opts, the history
+// is kept and the generation in progress is stopped. Only the assistant
+// settings of opts are used: AssistantID, SystemPrompt, Temperature, MaxTokens, Tools, Knowledge, Memory and Escalation.
+func (c *Conversation) Handoff(opts Options) {
+	c.turnMu.Lock()
+	defer c.turnMu.Unlock()
+	c.Cancel()
+	c.mu.Lock()
+	c.Options.AssistantID = opts.AssistantID
+	c.Options.SystemPrompt = opts.SystemPrompt
+	c.Options.Temperature = opts.Temperature
+	c.Options.MaxTokens = opts.MaxTokens
+	c.Options.Tools = opts.Tools
+	c.Options.Knowledge = opts.Knowledge
+	c.Options.Memory = opts.Memory
+	c.Options.Escalation = opts.Escalation
+	c.mu.Unlock()
+}
+
+// Cancel stops the generation in progress and waits for it to exit
+func (c *Conversation) Cancel() {
+	c.mu.Lock()
+	cancel, running := c.cancel, c.running
+	c.cancel, c.running = nil, nil
+	c.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-running
+}
+
+// Close stops the generation and releases the conversation, the memory of
+// the customer is extracted in the background
+func (c *Conversation) Close() {
+	c.Cancel()
+	c.closeOnce.Do(func() {
+		c.close()
+		if c.Options.OnClose != nil {
+			c.Options.OnClose()
+		}
+		if c.Options.Customer != nil {
+			go func() {
+				// the summarization in progress stops with the conversation
+				c.compacting.Wait()
+				c.remember()
+			}()
+		}
+	})
+}
+
+// record numbers the turn and hands it to OnTurn and the watchers, returns
+// the seq of the turn
+func (c *Conversation) record(turn Turn) int {
+	c.mu.Lock()
+	c.seq++
+	turn.Seq = c.seq
+	turn.AssistantID = c.Options.AssistantID
+	for watcher := range c.watchers {
+		select {
+		case watcher <- turn:
+		default:
+		}
+	}
+	c.mu.Unlock()
+	if c.Options.OnTurn != nil {
+		c.Options.OnTurn(turn)
+	}
+	return turn.Seq
+}
+
+// messages returns the prompt of the next reply, with the memory only the
+// recent history fitting in the context window is sent after the summary
+func (c *Conversation) messages() []llm.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	system, history := c.systemPrompt(), c.history
+	if c.Options.Memory != nil {
+		history = history[c.summarized:]
+		history = history[window(history, len(history), c.historyBudget(system)):]
+	}
+	messages := make([]llm.Message, 0, len(history)+1)
+	if system != "" {
+		messages = append(messages, llm.Message{Role: llm.RoleSystem, Content: system})
+	}
+	return append(messages, history...)
+}
+
+func (c *Conversation) reply() {
+	ctx, cancel := context.WithCancel(c.ctx)
+	running := make(chan struct{})
+	messages := c.messages()
+
+	c.mu.Lock()
+	c.cancel, c.running = cancel, running
+	c.mu.Unlock()
+
+	go func() {
+		defer close(running)
+		defer cancel()
+		c.generate(ctx, messages)
+	}()
+}
+
+// generate streams the reply, the tools called by the model are executed
+// and their results handed back to it until it answers
+func (c *Conversation) generate(ctx context.Context, messages []llm.Message) {
+	var reply strings.Builder
+	var usage llm.Usage
+	var resp *llm.ChatResponse
+	var err error
+	start := time.Now()
+	if c.Options.Knowledge != nil {
+		messages = c.ground(ctx, messages)
+	}
+	for round := 0; ; round++ {
+		reply.Reset()
+		req := &llm.ChatRequest{
+			Messages:    messages,
+			Temperature: c.Options.Temperature,
+			MaxTokens:   c.Options.MaxTokens,
+		}
+		if c.Options.Tools != nil {
+			req.Tools = c.Options.Tools.Tools()
+		}
+		if c.Options.Escalation != nil && c.Options.Escalation.Tool {
+			req.Tools = append(req.Tools[:len(req.Tools):len(req.Tools)], escalationTool)
+		}
+		resp, err = c.provider.ChatStream(ctx, req, func(chunk llm.Chunk) error {
+			if chunk.Content == "" {
+				return nil
+			}
+			reply.WriteString(chunk.Content)
+			return c.emit(ctx, Event{Type: EventToken, Data: TokenData{Content: chunk.Content}})
+		})
+		if resp != nil {
+			usage.PromptTokens += resp.Usage.PromptTokens
+			usage.CompletionTokens += resp.Usage.CompletionTokens
+			usage.TotalTokens += resp.Usage.TotalTokens
+			if c.Options.OnUsage != nil {
+				c.Options.OnUsage(resp.Usage)
+			}
+		}
+		if err != nil || ctx.Err() != nil || resp == nil || len(resp.ToolCalls) == 0 || len(req.Tools) == 0 {
+			break
+		}
+		if round == maxToolRounds {
+			break
+		}
+		var ok bool
+		if messages, ok = c.callTools(ctx, messages, reply.String(), resp.ToolCalls, time.Since(start).Milliseconds()); !ok {
+			reply.Reset()
+			break
+		}
+	}
+
+	interrupted := ctx.Err() != nil
+	latency := time.Since(start).Milliseconds()
+	seq := 0
+	if reply.Len() > 0 {
+		c.mu.Lock()
+		c.history = append(c.history, llm.Message{Role: llm.RoleAssistant, Content: reply.String()})
+		c.mu.Unlock()
+		seq = c.record(Turn{
+			Role:        llm.RoleAssistant,
+			Content:     reply.String(),
+			LatencyMs:   latency,
+			Usage:       usage,
+			Interrupted: interrupted,
+		})
+	}
+	if err != nil && !interrupted {
+		c.finish(ctx, Event{Type: EventError, Data: ErrorData{Message: err.Error()}})
+		return
+	}
+	c.finish(ctx, Event{Type: EventDone, Data: DoneData{
+		Seq:         seq,
+		Content:     reply.String(),
+		Interrupted: interrupted,
+		LatencyMs:   latency,
+		Usage:       usage,
+	}})
+	if c.Options.Memory != nil && !interrupted {
+		// in the background, neither cancelled by the next turn nor waited for
+		c.compacting.Add(1)
+		go func() {
+			defer c.compacting.Done()
+			ctx, cancel := context.WithTimeout(c.ctx, summaryTimeout)
+			defer cancel()
+			c.compactMu.Lock()
+			defer c.compactMu.Unlock()
+			c.compact(ctx)
+		}()
+	}
+}
+
+// ground retrieves the passages relevant to the last user message and adds
+// them to the system prompt, the clients receive them as citations. The
+// reply goes on without them if the retrieval fails.
+func (c *Conversation) ground(ctx context.Context, messages []llm.Message) []llm.Message {
+	if len(messages) == 0 || messages[len(messages)-1].Role != llm.RoleUser {
+		return messages
+	}
+	passages, err := c.Options.Knowledge.Retrieve(ctx, messages[len(messages)-1].Content)
+	if err != nil || len(passages) == 0 {
+		return messages
+	}
+	var prompt strings.Builder
+	prompt.WriteString("Answer from the following excerpts of the knowledge base when they are relevant, cite them as [n]. Say so when they do not contain the answer.")
+	for _, p := range passages {
+		fmt.Fprintf(&prompt, "\n\n[%d] %s\n%s", p.Index, p.Document, p.Content)
+	}
+	if messages[0].Role == llm.RoleSystem {
+		messages[0].Content += "\n\n" + prompt.String()
+	} else {
+		messages = append([]llm.Message{{Role: llm.RoleSystem, Content: prompt.String()}}, messages...)
+	}
+	_ = c.emit(ctx, Event{Type: EventCitations, Data: CitationsData{Citations: passages}})
+	return messages
+}
+
+// callTools executes the calls requested by the model, returns the messages
+// with the calls and their results, ok is false if the generation was
+// cancelled meanwhile: the calls are then left out of the history. The text
+// generated along with the calls is recorded as an assistant turn.
+func (c *Conversation) callTools(ctx context.Context, messages []llm.Message, content string, calls []llm.ToolCall, latency int64) ([]llm.Message, bool) {
+	results := make([]llm.Message, 0, len(calls)+1)
+	results = append(results, llm.Message{Role: llm.RoleAssistant, Content: content, ToolCalls: calls})
+	turns := make([]Turn, 0, len(calls))
+	for _, call := range calls {
+		start := time.Now()
+		var result string
+		var err error
+		switch {
+		case call.Name == EscalationTool && c.Options.Escalation != nil && c.Options.Escalation.Tool:
+			result = c.callEscalationTool(call)
+		case c.Options.Tools == nil:
+			err = fmt.Errorf("unknown tool: %s", call.Name)
+		default:
+			result, err = c.Options.Tools.Call(ctx, call)
+		}
+		if ctx.Err() != nil {
+			return nil, false
+		}
+		invocation := &ToolCall{ID: call.ID, Name: call.Name, Arguments: call.Arguments}
+		if err != nil {
+			invocation.Error = err.Error()
+			data, _ := json.Marshal(map[string]string{"error": err.Error()})
+			result = string(data)
+		}
+		results = append(results, llm.Message{Role: llm.RoleTool, Content: result, ToolCallID: call.ID})
+		turns = append(turns, Turn{Role: llm.RoleTool, Content: result, LatencyMs: time.Since(start).Milliseconds(), Tool: invocation})
+	}
+
+	c.mu.Lock()
+	c.history = append(c.history, results...)
+	c.mu.Unlock()
+	if content != "" {
+		c.record(Turn{Role: llm.RoleAssistant, Content: content, LatencyMs: latency})
+	}
+	for _, turn := range turns {
+		seq := c.record(turn)
+		data := ToolData{Seq: seq, ID: turn.Tool.ID, Name: turn.Tool.Name, Arguments: turn.Tool.Arguments, Error: turn.Tool.Error, LatencyMs: turn.LatencyMs}
+		if turn.Tool.Error == "" {
+			data.Result = turn.Content
+		}
+		if c.emit(ctx, Event{Type: EventTool, Data: data}) != nil {
+			return nil, false
+		}
+	}
+	return append(messages, results...), true
+}
+
+// emit delivers the event unless the generation is cancelled
+func (c *Conversation) emit(ctx context.Context, ev Event) error {
+	select {
+	case c.events <- ev:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// finish delivers the last event of a generation, it is dropped if
+// the generation is cancelled and nobody is reading the events
+func (c *Conversation) finish(ctx context.Context, ev Event) {
+	select {
+	case c.events <- ev:
+	case <-ctx.Done():
+		select {
+		case c.events <- ev:
+		default:
+		}
+	}
+}
+
+// Engine manages the conversations on top of the session manager
+type Engine struct {
+	sessions *session.SessionManager
+}
+
+// NewEngine creates an engine, conversations idle for expirySeconds are closed
+func NewEngine(expirySeconds int) *Engine {
+	sessions := session.NewSessionManager(expirySeconds)
+	sessions.SetExpireHandler(func(s *session.Session) {
+		if conv := conversationOf(s); conv != nil {
+			conv.Close()
+		}
+	})
+	return &Engine{sessions: sessions}
+}
+
+// Start creates a conversation using the provider
+func (e *Engine) Start(provider llm.Provider, opts Options) *Conversation {
+	s := e.sessions.CreateSession()
+	ctx, cancel := context.WithCancel(context.Background())
+	conv := &Conversation{
+		ID:       s.ID,
+		Options:  opts,
+		provider: provider,
+		ctx:      ctx,
+		close:    cancel,
+		events:   make(chan Event, eventBufferSize),
+	}
+	s.SetData(sessionConversationKey, conv)
+	return conv
+}
+
+// Get returns the conversation and refreshes its activity
+func (e *Engine) Get(id string) (*Conversation, error) {
+	s, ok := e.sessions.GetSession(id)
+	if !ok {
+		return nil, ErrConversationNotFound
+	}
+	conv := conversationOf(s)
+	if conv == nil {
+		return nil, ErrConversationNotFound
+	}
+	return conv, nil
+}
+
+// Stop closes the conversation and removes its session
+func (e *Engine) Stop(id string) error {
+	conv, err := e.Get(id)
+	if err != nil {
+		return err
+	}
+	e.sessions.TerminateSession(id)
+	conv.Close()
+	return nil
+}
+
+func conversationOf(s *session.Session) *Conversation {
+	v, ok := s.GetData(sessionConversationKey)
+	if !ok {
+		return nil
+	}
+	conv, _ := v.(*Conversation)
+	return conv
+}
@@ -0,0 +1,651 @@
+package chat
+
+import (
+	"VoiceSculptor/pkg/llm"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubProvider streams the tokens, then blocks until cancelled if hang is set
+type stubProvider struct {
+	tokens []string
+	hang   bool
+}
+
+func (p *stubProvider) ChatStream(ctx context.Context, req *llm.ChatRequest, onChunk llm.StreamHandler) (*llm.ChatResponse, error) {
+	for _, token := range p.tokens {
+		if err := onChunk(llm.Chunk{Content: token}); err != nil {
+			return nil, err
+		}
+	}
+	if p.hang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return &llm.ChatResponse{FinishReason: "stop"}, nil
+}
+
+func nextEvent(t *testing.T, conv *Conversation) Event {
+	select {
+	case ev := <-conv.Events():
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+	}
+	return Event{}
+}
+
+func TestConversation_Send(t *testing.T) {
+	engine := NewEngine(60)
+	conv := engine.Start(&stubProvider{tokens: []string{"Hello", ", ", "world"}}, Options{UserID: 1, SystemPrompt: "be nice"})
+
+	require.NoError(t, conv.Send("hi"))
+
+	for _, token := range []string{"Hello", ", ", "world"} {
+		ev := nextEvent(t, conv)
+		assert.Equal(t, EventToken, ev.Type)
+		assert.Equal(t, token, ev.Data.(TokenData).Content)
+	}
+	ev := nextEvent(t, conv)
+	assert.Equal(t, EventDone, ev.Type)
+	assert.Equal(t, "Hello, world", ev.Data.(DoneData).Content)
+	assert.False(t, ev.Data.(DoneData).Interrupted)
+
+	conv.Cancel()
+	assert.Equal(t, []llm.Message{
+		{Role: llm.RoleUser, Content: "hi"},
+		{Role: llm.RoleAssistant, Content: "Hello, world"},
+	}, conv.History())
+}
+
+func TestConversation_CancelInterruptsGeneration(t *testing.T) {
+	engine := NewEngine(60)
+	conv := engine.Start(&stubProvider{tokens: []string{"partial"}, hang: true}, Options{UserID: 1})
+
+	require.NoError(t, conv.Send("hi"))
+	assert.Equal(t, EventToken, nextEvent(t, conv).Type)
+
+	conv.Cancel()
+	ev := nextEvent(t, conv)
+	assert.Equal(t, EventDone, ev.Type)
+	assert.True(t, ev.Data.(DoneData).Interrupted)
+	assert.Equal(t, "partial", ev.Data.(DoneData).Content)
+}
+
+func TestEngine_Stop(t *testing.T) {
+	engine := NewEngine(60)
+	conv := engine.Start(&stubProvider{hang: true}, Options{UserID: 1})
+	require.NoError(t, conv.Greet())
+
+	got, err := engine.Get(conv.ID)
+	require.NoError(t, err)
+	assert.Equal(t, conv, got)
+
+	require.NoError(t, engine.Stop(conv.ID))
+	select {
+	case <-conv.Done():
+	case <-time.After(time.Second):
+		t.Fatal("conversation not closed")
+	}
+
+	_, err = engine.Get(conv.ID)
+	assert.ErrorIs(t, err, ErrConversationNotFound)
+	assert.ErrorIs(t, conv.Send("hi"), ErrConversationClosed)
+}
+
+func TestConversation_RecordsTurns(t *testing.T) {
+	var turns []Turn
+	closed := 0
+	engine := NewEngine(60)
+	conv := engine.Start(&stubProvider{tokens: []string{"Hello"}}, Options{
+		UserID:  1,
+		OnTurn:  func(turn Turn) { turns = append(turns, turn) },
+		OnClose: func() { closed++ },
+	})
+
+	require.NoError(t, conv.Send("hi"))
+	assert.Equal(t, EventToken, nextEvent(t, conv).Type)
+	done := nextEvent(t, conv)
+	assert.Equal(t, EventDone, done.Type)
+	// the done event refers to the assistant turn
+	assert.Equal(t, 2, done.Data.(DoneData).Seq)
+	require.NoError(t, engine.Stop(conv.ID))
+	conv.Close()
+
+	require.Len(t, turns, 2)
+	assert.Equal(t, Turn{Seq: 1, Role: llm.RoleUser, Content: "hi"}, turns[0])
+	assert.Equal(t, 2, turns[1].Seq)
+	assert.Equal(t, llm.RoleAssistant, turns[1].Role)
+	assert.Equal(t, "Hello", turns[1].Content)
+	assert.Equal(t, 1, closed)
+}
+
+// recordingProvider keeps the requests it received
+type recordingProvider struct {
+	requests []*llm.ChatRequest
+}
+
+func (p *recordingProvider) ChatStream(ctx context.Context, req *llm.ChatRequest, onChunk llm.StreamHandler) (*llm.ChatResponse, error) {
+	p.requests = append(p.requests, req)
+	if err := onChunk(llm.Chunk{Content: "ok"}); err != nil {
+		return nil, err
+	}
+	return &llm.ChatResponse{FinishReason: "stop"}, nil
+}
+
+func TestConversation_AppendAndHandoff(t *testing.T) {
+	var turns []Turn
+	provider := &recordingProvider{}
+	engine := NewEngine(60)
+	conv := engine.Start(provider, Options{
+		AssistantID:  1,
+		SystemPrompt: "menu",
+		OnTurn:       func(turn Turn) { turns = append(turns, turn) },
+	})
+
+	assert.Equal(t, 1, conv.Append(llm.RoleAssistant, "Press 1 for sales"))
+	assert.Equal(t, 2, conv.Append(llm.RoleUser, "DTMF 1"))
+	conv.Handoff(Options{AssistantID: 2, SystemPrompt: "sales", Temperature: 0.3, MaxTokens: 64, UserID: 9})
+	require.NoError(t, conv.Greet())
+	assert.Equal(t, EventToken, nextEvent(t, conv).Type)
+	assert.Equal(t, EventDone, nextEvent(t, conv).Type)
+	conv.Cancel()
+
+	require.Len(t, provider.requests, 1)
+	req := provider.requests[0]
+	assert.Equal(t, []llm.Message{
+		{Role: llm.RoleSystem, Content: "sales"},
+		{Role: llm.RoleAssistant, Content: "Press 1 for sales"},
+		{Role: llm.RoleUser, Content: "DTMF 1"},
+	}, req.Messages)
+	assert.Equal(t, float32(0.3), req.Temperature)
+	assert.Equal(t, 64, req.MaxTokens)
+	// only the assistant settings are handed over
+	assert.Zero(t, conv.Options.UserID)
+
+	require.Len(t, turns, 3)
+	assert.EqualValues(t, 1, turns[0].AssistantID)
+	assert.EqualValues(t, 2, turns[2].AssistantID)
+}
+
+// stubTools answers the calls of the weather tool, fails the others
+type stubTools struct {
+	calls []llm.ToolCall
+}
+
+func (s *stubTools) Tools() []llm.Tool {
+	return []llm.Tool{{Name: "weather"}, {Name: "broken"}}
+}
+
+func (s *stubTools) Call(ctx context.Context, call llm.ToolCall) (string, error) {
+	s.calls = append(s.calls, call)
+	if call.Name != "weather" {
+		return "", errors.New("unavailable")
+	}
+	return "sunny", nil
+}
+
+func TestConversation_CallsTools(t *testing.T) {
+	var turns []Turn
+	tools := &stubTools{}
+	engine := NewEngine(60)
+	conv := engine.Start(&llm.FakeProvider{}, Options{
+		Tools:  tools,
+		OnTurn: func(turn Turn) { turns = append(turns, turn) },
+	})
+
+	require.NoError(t, conv.Send("weather in Paris"))
+	ev := nextEvent(t, conv)
+	require.Equal(t, EventTool, ev.Type)
+	data := ev.Data.(ToolData)
+	assert.Equal(t, 2, data.Seq)
+	assert.Equal(t, "weather", data.Name)
+	assert.Equal(t, "sunny", data.Result)
+	assert.JSONEq(t, `{"query":"weather in Paris"}`, data.Arguments)
+	var reply strings.Builder
+	for ev = nextEvent(t, conv); ev.Type == EventToken; ev = nextEvent(t, conv) {
+		reply.WriteString(ev.Data.(TokenData).Content)
+	}
+	require.Equal(t, EventDone, ev.Type)
+	assert.Equal(t, "weather: sunny", reply.String())
+	assert.Equal(t, 3, ev.Data.(DoneData).Seq)
+
+	require.NoError(t, conv.Send("is broken"))
+	ev = nextEvent(t, conv)
+	require.Equal(t, EventTool, ev.Type)
+	assert.Equal(t, "unavailable", ev.Data.(ToolData).Error)
+	for ev = nextEvent(t, conv); ev.Type == EventToken; ev = nextEvent(t, conv) {
+	}
+	require.Equal(t, EventDone, ev.Type)
+	assert.Equal(t, `broken: {"error":"unavailable"}`, ev.Data.(DoneData).Content)
+	conv.Cancel()
+
+	require.Len(t, tools.calls, 2)
+	history := conv.History()
+	require.Len(t, history, 8)
+	assert.Equal(t, []llm.ToolCall{tools.calls[0]}, history[1].ToolCalls)
+	assert.Equal(t, llm.Message{Role: llm.RoleTool, Content: "sunny", ToolCallID: tools.calls[0].ID}, history[2])
+
+	require.Len(t, turns, 6)
+	assert.Equal(t, llm.RoleTool, turns[1].Role)
+	assert.Equal(t, &ToolCall{ID: tools.calls[0].ID, Name: "weather", Arguments: tools.calls[0].Arguments}, turns[1].Tool)
+	assert.Equal(t, "unavailable", turns[4].Tool.Error)
+	assert.Equal(t, llm.RoleAssistant, turns[5].Role)
+}
+
+// loopingProvider always calls the tool
+type loopingProvider struct {
+	requests int
+}
+
+func (p *loopingProvider) ChatStream(ctx context.Context, req *llm.ChatRequest, onChunk llm.StreamHandler) (*llm.ChatResponse, error) {
+	p.requests++
+	return &llm.ChatResponse{ToolCalls: []llm.ToolCall{{ID: "call", Name: "weather"}}, FinishReason: llm.FinishReasonToolCalls}, nil
+}
+
+func TestConversation_LimitsToolRounds(t *testing.T) {
+	provider := &loopingProvider{}
+	engine := NewEngine(60)
+	conv := engine.Start(provider, Options{Tools: &stubTools{}})
+
+	require.NoError(t, conv.Send("weather"))
+	for i := 0; i < maxToolRounds; i++ {
+		assert.Equal(t, EventTool, nextEvent(t, conv).Type)
+	}
+	ev := nextEvent(t, conv)
+	assert.Equal(t, EventDone, ev.Type)
+	assert.Zero(t, ev.Data.(DoneData).Seq)
+	conv.Cancel()
+	assert.Equal(t, maxToolRounds+1, provider.requests)
+}
+
+// stubKnowledge returns a passage for every query
+type stubKnowledge struct {
+	queries []string
+}
+
+func (k *stubKnowledge) Retrieve(ctx context.Context, query string) ([]Passage, error) {
+	k.queries = append(k.queries, query)
+	return []Passage{{Index: 1, DocumentID: 7, Document: "manual.pdf", ChunkID: 3, Content: "Hold the reset button."}}, nil
+}
+
+func TestConversation_GroundsReplies(t *testing.T) {
+	knowledge := &stubKnowledge{}
+	provider := &recordingProvider{}
+	engine := NewEngine(60)
+	conv := engine.Start(provider, Options{SystemPrompt: "be nice", Knowledge: knowledge})
+
+	require.NoError(t, conv.Greet())
+	assert.Equal(t, EventToken, nextEvent(t, conv).Type)
+	assert.Equal(t, EventDone, nextEvent(t, conv).Type)
+	// nothing to retrieve without a user message
+	assert.Empty(t, knowledge.queries)
+
+	require.NoError(t, conv.Send("how to reset?"))
+	ev := nextEvent(t, conv)
+	require.Equal(t, EventCitations, ev.Type)
+	assert.Equal(t, "manual.pdf", ev.Data.(CitationsData).Citations[0].Document)
+	assert.Equal(t, EventToken, nextEvent(t, conv).Type)
+	assert.Equal(t, EventDone, nextEvent(t, conv).Type)
+	conv.Cancel()
+
+	assert.Equal(t, []string{"how to reset?"}, knowledge.queries)
+	require.Len(t, provider.requests, 2)
+	system := provider.requests[1].Messages[0]
+	assert.Equal(t, llm.RoleSystem, system.Role)
+	assert.True(t, strings.HasPrefix(system.Content, "be nice\n\n"))
+	assert.Contains(t, system.Content, "[1] manual.pdf\nHold the reset button.")
+	// the passages are not kept in the history
+	assert.Equal(t, "be nice", conv.Options.SystemPrompt)
+	assert.Len(t, conv.History(), 3)
+}
+
+// summaryProvider answers the summarization requests with "summary <n>"
+type summaryProvider struct {
+	recordingProvider
+	summaries int
+}
+
+func (p *summaryProvider) ChatStream(ctx context.Context, req *llm.ChatRequest, onChunk llm.StreamHandler) (*llm.ChatResponse, error) {
+	if strings.HasPrefix(req.Messages[0].Content, "Merge the conversation") {
+		p.summaries++
+		return &llm.ChatResponse{Content: fmt.Sprintf("summary %d", p.summaries), FinishReason: "stop"}, nil
+	}
+	return p.recordingProvider.ChatStream(ctx, req, onChunk)
+}
+
+func TestConversation_SummarizesOlderTurns(t *testing.T) {
+	provider := &summaryProvider{}
+	engine := NewEngine(60)
+	conv := engine.Start(provider, Options{SystemPrompt: "be nice", Memory: &Memory{Turns: 2}})
+
+	send := func(text string) {
+		require.NoError(t, conv.Send(text))
+		assert.Equal(t, EventToken, nextEvent(t, conv).Type)
+		assert.Equal(t, EventDone, nextEvent(t, conv).Type)
+		conv.Cancel()
+		// waits for the summary
+		conv.compacting.Wait()
+	}
+	send("one")
+	assert.Empty(t, conv.Summary())
+	send("two")
+	assert.Equal(t, "summary 1", conv.Summary())
+	send("three")
+	assert.Equal(t, "summary 2", conv.Summary())
+
+	require.Len(t, provider.requests, 3)
+	messages := provider.requests[2].Messages
+	assert.Equal(t, llm.Message{Role: llm.RoleSystem, Content: "be nice\n\nSummary of the earlier conversation:\nsummary 1"}, messages[0])
+	assert.Equal(t, []llm.Message{
+		{Role: llm.RoleUser, Content: "two"},
+		{Role: llm.RoleAssistant, Content: "ok"},
+		{Role: llm.RoleUser, Content: "three"},
+	}, messages[1:])
+	// the whole conversation is kept in the history
+	assert.Len(t, conv.History(), 6)
+}
+
+// slowSummaryProvider summarizes like a model taking its time: the
+// summaries wait for release, they fail if their context is cancelled meanwhile
+type slowSummaryProvider struct {
+	summaryProvider
+	started chan struct{}
+	release chan struct{}
+}
+
+func newSlowSummaryProvider() *slowSummaryProvider {
+	return &slowSummaryProvider{started: make(chan struct{}, 8), release: make(chan struct{})}
+}
+
+func (p *slowSummaryProvider) ChatStream(ctx context.Context, req *llm.ChatRequest, onChunk llm.StreamHandler) (*llm.ChatResponse, error) {
+	if strings.HasPrefix(req.Messages[0].Content, "Merge the conversation") {
+		p.started <- struct{}{}
+		select {
+		case <-p.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return p.summaryProvider.ChatStream(ctx, req, onChunk)
+}
+
+func waitSummaryStarted(t *testing.T, p *slowSummaryProvider) {
+	select {
+	case <-p.started:
+	case <-time.After(time.Second):
+		t.Fatal("the summary was not started")
+	}
+}
+
+func TestConversation_CancelDoesNotWaitForSummary(t *testing.T) {
+	provider := newSlowSummaryProvider()
+	engine := NewEngine(60)
+	conv := engine.Start(provider, Options{SystemPrompt: "be nice", Memory: &Memory{Turns: 1}})
+
+	require.NoError(t, conv.Send("one"))
+	assert.Equal(t, EventToken, nextEvent(t, conv).Type)
+	assert.Equal(t, EventDone, nextEvent(t, conv).Type)
+	waitSummaryStarted(t, provider)
+
+	cancelled := make(chan struct{})
+	go func() {
+		conv.Cancel()
+		close(cancelled)
+	}()
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("Cancel waits for the summary")
+	}
+
+	close(provider.release)
+	conv.compacting.Wait()
+	assert.Equal(t, "summary 1", conv.Summary())
+}
+
+func TestConversation_SummarizesBackToBackMessages(t *testing.T) {
+	provider := newSlowSummaryProvider()
+	engine := NewEngine(60)
+	conv := engine.Start(provider, Options{SystemPrompt: "be nice", Memory: &Memory{Turns: 2}})
+
+	require.NoError(t, conv.Send("one"))
+	assert.Equal(t, EventToken, nextEvent(t, conv).Type)
+	assert.Equal(t, EventDone, nextEvent(t, conv).Type)
+	require.NoError(t, conv.Send("two"))
+	assert.Equal(t, EventToken, nextEvent(t, conv).Type)
+	assert.Equal(t, EventDone, nextEvent(t, conv).Type)
+	waitSummaryStarted(t, provider)
+	// sent while "one" is being summarized
+	require.NoError(t, conv.Send("three"))
+	assert.Equal(t, EventToken, nextEvent(t, conv).Type)
+	assert.Equal(t, EventDone, nextEvent(t, conv).Type)
+
+	close(provider.release)
+	conv.Cancel()
+	conv.compacting.Wait()
+	// the summary of "one" was kept and "two" was merged into it
+	assert.Equal(t, "summary 2", conv.Summary())
+	require.Len(t, provider.requests, 3)
+}
+
+func TestConversation_TrimsHistoryToContextWindow(t *testing.T) {
+	provider := &recordingProvider{}
+	engine := NewEngine(60)
+	// the summaries fail, the window is enforced by trimming alone
+	conv := engine.Start(provider, Options{MaxTokens: 50, Memory: &Memory{Turns: 10, ContextTokens: 100}})
+
+	long := strings.Repeat("word ", 40)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, conv.Send(long))
+		assert.Equal(t, EventToken, nextEvent(t, conv).Type)
+		assert.Equal(t, EventDone, nextEvent(t, conv).Type)
+		conv.Cancel()
+		conv.compacting.Wait()
+	}
+	// the replies and the failed summaries alternate
+	require.Len(t, provider.requests, 6)
+	last := provider.requests[4]
+	// the last user message is sent even beyond the budget
+	assert.Equal(t, []llm.Message{{Role: llm.RoleUser, Content: long}}, last.Messages)
+}
+
+func TestWindow(t *testing.T) {
+	history := []llm.Message{
+		{Role: llm.RoleUser, Content: "weather?"},
+		{Role: llm.RoleAssistant, ToolCalls: []llm.ToolCall{{ID: "call", Name: "weather"}}},
+		{Role: llm.RoleTool, Content: "sunny", ToolCallID: "call"},
+		{Role: llm.RoleAssistant, Content: "It is sunny"},
+	}
+	assert.Equal(t, 0, window(history, 10, 1000))
+	assert.Equal(t, 3, window(history, 1, 1000))
+	// a tool result does not start the window
+	assert.Equal(t, 3, window(history, 2, 1000))
+	assert.Equal(t, 1, window(history, 3, 1000))
+	assert.Equal(t, 3, window(history, 10, 0))
+}
+
+// stubCustomer remembers in memory
+type stubCustomer struct {
+	remembered chan customerMemory
+}
+
+func (c *stubCustomer) Recall() string  { return "- Name is Alice" }
+func (c *stubCustomer) Facts() []string { return []string{"Name is Alice"} }
+func (c *stubCustomer) Remember(ctx context.Context, sessionID, summary string, facts []string) error {
+	c.remembered <- customerMemory{Summary: summary, Facts: facts}
+	return nil
+}
+
+// memoryProvider answers the extraction requests with a JSON memory
+type memoryProvider struct {
+	recordingProvider
+}
+
+func (p *memoryProvider) ChatStream(ctx context.Context, req *llm.ChatRequest, onChunk llm.StreamHandler) (*llm.ChatResponse, error) {
+	if strings.HasPrefix(req.Messages[0].Content, "Read the conversation") {
+		p.requests = append(p.requests, req)
+		return &llm.ChatResponse{Content: "```json\n{\"summary\": \"Asked about order 42\", \"facts\": [\"Name is Alice\", \" \", \"Ordered #42\"]}\n```"}, nil
+	}
+	return p.recordingProvider.ChatStream(ctx, req, onChunk)
+}
+
+func TestConversation_RemembersCustomer(t *testing.T) {
+	customer := &stubCustomer{remembered: make(chan customerMemory, 1)}
+	provider := &memoryProvider{}
+	engine := NewEngine(60)
+	conv := engine.Start(provider, Options{SystemPrompt: "be nice", Customer: customer})
+
+	require.NoError(t, conv.Send("where is order 42?"))
+	assert.Equal(t, EventToken, nextEvent(t, conv).Type)
+	assert.Equal(t, EventDone, nextEvent(t, conv).Type)
+	require.NoError(t, engine.Stop(conv.ID))
+
+	select {
+	case memory := <-customer.remembered:
+		assert.Equal(t, customerMemory{Summary: "Asked about order 42", Facts: []string{"Name is Alice", "Ordered #42"}}, memory)
+	case <-time.After(time.Second):
+		t.Fatal("the customer was not remembered")
+	}
+	require.Len(t, provider.requests, 2)
+	assert.Equal(t, "be nice\n\nWhat you remember of the customer from the previous conversations:\n- Name is Alice", provider.requests[0].Messages[0].Content)
+	extraction := provider.requests[1].Messages[0].Content
+	assert.Contains(t, extraction, "Facts already known:\nName is Alice\n")
+	assert.Contains(t, extraction, "user: where is order 42?\nassistant: ok\n")
+}
+
+func TestConversation_ForgetsSilentCustomer(t *testing.T) {
+	customer := &stubCustomer{remembered: make(chan customerMemory, 1)}
+	engine := NewEngine(60)
+	conv := engine.Start(&recordingProvider{}, Options{Customer: customer})
+	require.NoError(t, conv.Greet())
+	assert.Equal(t, EventToken, nextEvent(t, conv).Type)
+	assert.Equal(t, EventDone, nextEvent(t, conv).Type)
+	require.NoError(t, engine.Stop(conv.ID))
+
+	select {
+	case <-customer.remembered:
+		t.Fatal("nothing said by the customer to remember")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestParseCustomerMemory(t *testing.T) {
+	assert.Equal(t, customerMemory{Summary: "just text"}, parseCustomerMemory(" just text "))
+	assert.Equal(t, customerMemory{Summary: "s"}, parseCustomerMemory(`{"summary": " s "}`))
+	assert.Equal(t, customerMemory{Facts: []string{}}, parseCustomerMemory(`{"facts": []}`))
+}
+
+func TestConversation_EscalatesOnKeyword(t *testing.T) {
+	var changes []EscalationData
+	provider := &recordingProvider{}
+	engine := NewEngine(60)
+	conv := engine.Start(provider, Options{
+		SystemPrompt: "be nice",
+		Escalation: &Escalation{
+			Keywords: []string{" ", "Human"},
+			OnChange: func(c *Conversation, data EscalationData) { changes = append(changes, data) },
+		},
+	})
+
+	require.NoError(t, conv.Send("hi"))
+	assert.Equal(t, EventToken, nextEvent(t, conv).Type)
+	assert.Equal(t, EventDone, nextEvent(t, conv).Type)
+	assert.Empty(t, changes)
+
+	require.NoError(t, conv.Send("I want a HUMAN"))
+	ev := nextEvent(t, conv)
+	require.Equal(t, EventEscalation, ev.Type)
+	assert.Equal(t, EscalationData{Status: EscalationWaiting, Reason: "keyword: Human"}, ev.Data)
+	// the assistant answers until an agent takes over
+	assert.Equal(t, EventToken, nextEvent(t, conv).Type)
+	assert.Equal(t, EventDone, nextEvent(t, conv).Type)
+	conv.Cancel()
+
+	assert.Equal(t, []EscalationData{{Status: EscalationWaiting, Reason: "keyword: Human"}}, changes)
+	assert.Equal(t, EscalationWaiting, conv.Escalation().Status)
+	assert.False(t, conv.Escalate("again"))
+	require.Len(t, provider.requests, 2)
+	assert.NotContains(t, provider.requests[0].Messages[0].Content, "human agent")
+	assert.Contains(t, provider.requests[1].Messages[0].Content, "A human agent was asked to join")
+}
+
+func TestConversation_EscalationTool(t *testing.T) {
+	engine := NewEngine(60)
+	conv := engine.Start(&llm.FakeProvider{}, Options{Escalation: &Escalation{Tool: true}})
+
+	require.NoError(t, conv.Send("transfer_to_human please"))
+	ev := nextEvent(t, conv)
+	require.Equal(t, EventEscalation, ev.Type)
+	assert.Equal(t, EscalationWaiting, ev.Data.(EscalationData).Status)
+	ev = nextEvent(t, conv)
+	require.Equal(t, EventTool, ev.Type)
+	assert.Equal(t, EscalationTool, ev.Data.(ToolData).Name)
+	assert.JSONEq(t, `{"status":"a human agent was notified and will join the conversation shortly"}`, ev.Data.(ToolData).Result)
+	for ev = nextEvent(t, conv); ev.Type == EventToken; ev = nextEvent(t, conv) {
+	}
+	assert.Equal(t, EventDone, ev.Type)
+	conv.Cancel()
+}
+
+func TestConversation_TakeOver(t *testing.T) {
+	var turns []Turn
+	provider := &recordingProvider{}
+	engine := NewEngine(60)
+	conv := engine.Start(provider, Options{OnTurn: func(turn Turn) { turns = append(turns, turn) }})
+	watched, stop := conv.Watch()
+	defer stop()
+
+	agent := Agent{ID: 7, Name: "Bob"}
+	// only a conversation escalated to a human agent is taken over
+	assert.ErrorIs(t, conv.TakeOver(agent), ErrNotEscalated)
+	require.True(t, conv.Escalate("upset"))
+	assert.Equal(t, EscalationData{Status: EscalationWaiting, Reason: "upset"}, nextEvent(t, conv).Data)
+	require.NoError(t, conv.TakeOver(agent))
+	assert.Equal(t, EscalationData{Status: EscalationActive, Reason: "upset", Agent: &agent}, nextEvent(t, conv).Data)
+	assert.ErrorIs(t, conv.TakeOver(Agent{ID: 8}), ErrEscalationTaken)
+	assert.NoError(t, conv.TakeOver(agent))
+
+	// the assistant is silent, the agent answers
+	require.NoError(t, conv.Send("hi"))
+	require.NoError(t, conv.Greet())
+	assert.ErrorIs(t, conv.Reply(8, "no"), ErrNotTakenOver)
+	require.NoError(t, conv.Reply(7, "Hello, Bob here"))
+	assert.Equal(t, TokenData{Content: "Hello, Bob here"}, nextEvent(t, conv).Data)
+	assert.Equal(t, DoneData{Seq: 2, Content: "Hello, Bob here"}, nextEvent(t, conv).Data)
+	assert.Empty(t, provider.requests)
+
+	for _, want := range []Turn{{Seq: 1, Role: llm.RoleUser, Content: "hi"}, {Seq: 2, Role: llm.RoleAssistant, Content: "Hello, Bob here", AgentID: 7}} {
+		select {
+		case turn := <-watched:
+			assert.Equal(t, want, turn)
+		case <-time.After(time.Second):
+			t.Fatal("no turn watched")
+		}
+	}
+	assert.Equal(t, []Turn{{Seq: 1, Role: llm.RoleUser, Content: "hi"}, {Seq: 2, Role: llm.RoleAssistant, Content: "Hello, Bob here", AgentID: 7}}, turns)
+
+	assert.ErrorIs(t, conv.Release(8), ErrNotTakenOver)
+	require.NoError(t, conv.Release(7))
+	assert.Equal(t, EscalationData{}, nextEvent(t, conv).Data)
+	require.NoError(t, conv.Send("thanks"))
+	assert.Equal(t, EventToken, nextEvent(t, conv).Type)
+	assert.Equal(t, EventDone, nextEvent(t, conv).Type)
+	conv.Cancel()
+	require.Len(t, provider.requests, 1)
+	assert.Equal(t, []llm.Message{
+		{Role: llm.RoleUser, Content: "hi"},
+		{Role: llm.RoleAssistant, Content: "Hello, Bob here"},
+		{Role: llm.RoleUser, Content: "thanks"},
+	}, provider.requests[0].Messages)
+
+	conv.Close()
+	assert.ErrorIs(t, conv.TakeOver(agent), ErrConversationClosed)
+}
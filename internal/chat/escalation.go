@@ -0,0 +1,208 @@
+package chat
+
+import (
+	"VoiceSculptor/pkg/llm"
+	"VoiceSculptor/pkg/util"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// EscalationTool the tool offered to the model to ask for a human agent
+const EscalationTool = "transfer_to_human"
+
+// the status of the escalation of a conversation to a human agent
+const (
+	EscalationNone    = ""
+	EscalationWaiting = "waiting" // a human agent is requested, the assistant still answers
+	EscalationActive  = "active"  // a human agent took over, the assistant is silent
+)
+
+var ErrEscalationTaken = &util.Error{Code: http.StatusConflict, Message: "another agent took over the chat session"}
+var ErrNotTakenOver = &util.Error{Code: http.StatusConflict, Message: "chat session not taken over by the agent"}
+var ErrNotEscalated = &util.Error{Code: http.StatusConflict, Message: "no human agent was requested for the chat session"}
+
+var escalationTool = llm.Tool{
+	Name:        EscalationTool,
+	Description: "Transfer the conversation to a human agent when the customer asks for a human, is upset, or needs something you cannot do. Tell the customer a human agent will join shortly.",
+	Parameters:  json.RawMessage(`{"type":"object","properties":{"reason":{"type":"string","description":"Why the customer needs a human agent"}},"required":["reason"]}`),
+}
+
+// Escalation the settings of the escalation of a conversation to a human agent
+type Escalation struct {
+	Keywords []string // a user message containing one of them escalates, case insensitive
+	Tool     bool     // offers EscalationTool to the model
+	// OnChange is called with every change of the escalation, out of the turns
+	OnChange func(conv *Conversation, data EscalationData)
+}
+
+// Agent the human agent taking a conversation over
+type Agent struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+// EscalationData the escalation of the conversation, sent when it changes
+type EscalationData struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+	Agent  *Agent `json:"agent,omitempty"` // the agent of an active escalation
+}
+
+// Escalation returns the escalation of the conversation to a human agent
+func (c *Conversation) Escalation() EscalationData {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.escalation
+}
+
+// Escalate requests a human agent, the assistant answers until one takes the
+// conversation over. It reports false if a human agent was already requested.
+func (c *Conversation) Escalate(reason string) bool {
+	c.mu.Lock()
+	if c.escalation.Status != EscalationNone {
+		c.mu.Unlock()
+		return false
+	}
+	c.escalation = EscalationData{Status: EscalationWaiting, Reason: reason}
+	data := c.escalation
+	c.mu.Unlock()
+	c.escalated(data)
+	return true
+}
+
+// TakeOver hands the conversation escalated to a human agent to the agent,
+// the generation in progress is stopped and the assistant stays silent until
+// Release
+func (c *Conversation) TakeOver(agent Agent) error {
+	c.turnMu.Lock()
+	defer c.turnMu.Unlock()
+	if c.ctx.Err() != nil {
+		return ErrConversationClosed
+	}
+	c.mu.Lock()
+	current := c.escalation
+	c.mu.Unlock()
+	if current.Status == EscalationNone {
+		return ErrNotEscalated
+	}
+	if current.Status == EscalationActive {
+		if current.Agent.ID != agent.ID {
+			return ErrEscalationTaken
+		}
+		return nil
+	}
+	c.Cancel()
+
+	c.mu.Lock()
+	c.escalation.Status, c.escalation.Agent = EscalationActive, &agent
+	data := c.escalation
+	c.mu.Unlock()
+	c.escalated(data)
+	return nil
+}
+
+// Release hands the conversation taken over by the agent back to the assistant
+func (c *Conversation) Release(agentID uint) error {
+	c.turnMu.Lock()
+	defer c.turnMu.Unlock()
+	c.mu.Lock()
+	if c.escalation.Status != EscalationActive || c.escalation.Agent.ID != agentID {
+		c.mu.Unlock()
+		return ErrNotTakenOver
+	}
+	c.escalation = EscalationData{}
+	c.mu.Unlock()
+	c.escalated(EscalationData{})
+	return nil
+}
+
+// Reply sends the reply of the agent who took the conversation over, the
+// client receives it as the tokens and the done event of a reply
+func (c *Conversation) Reply(agentID uint, text string) error {
+	c.turnMu.Lock()
+	defer c.turnMu.Unlock()
+	if c.ctx.Err() != nil {
+		return ErrConversationClosed
+	}
+	c.mu.Lock()
+	if c.escalation.Status != EscalationActive || c.escalation.Agent.ID != agentID {
+		c.mu.Unlock()
+		return ErrNotTakenOver
+	}
+	c.history = append(c.history, llm.Message{Role: llm.RoleAssistant, Content: text})
+	c.mu.Unlock()
+	seq := c.record(Turn{Role: llm.RoleAssistant, Content: text, AgentID: agentID})
+	c.notify(Event{Type: EventToken, Data: TokenData{Content: text}})
+	c.notify(Event{Type: EventDone, Data: DoneData{Seq: seq, Content: text}})
+	return nil
+}
+
+// Watch returns the turns recorded from now on, until stop is called. The
+// turns a watcher does not keep up with are dropped.
+func (c *Conversation) Watch() (turns <-chan Turn, stop func()) {
+	ch := make(chan Turn, eventBufferSize)
+	c.mu.Lock()
+	if c.watchers == nil {
+		c.watchers = make(map[chan Turn]struct{})
+	}
+	c.watchers[ch] = struct{}{}
+	c.mu.Unlock()
+	return ch, func() {
+		c.mu.Lock()
+		delete(c.watchers, ch)
+		c.mu.Unlock()
+	}
+}
+
+// takenOver reports whether a human agent took the conversation over
+func (c *Conversation) takenOver() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.escalation.Status == EscalationActive
+}
+
+// escalateOnKeyword requests a human agent if the user message contains a keyword
+func (c *Conversation) escalateOnKeyword(text string) {
+	if c.Options.Escalation == nil {
+		return
+	}
+	text = strings.ToLower(text)
+	for _, keyword := range c.Options.Escalation.Keywords {
+		if keyword = strings.TrimSpace(keyword); keyword != "" && strings.Contains(text, strings.ToLower(keyword)) {
+			c.Escalate("keyword: " + keyword)
+			return
+		}
+	}
+}
+
+// callEscalationTool requests a human agent on behalf of the model
+func (c *Conversation) callEscalationTool(call llm.ToolCall) string {
+	var args struct {
+		Reason string `json:"reason"`
+	}
+	_ = json.Unmarshal([]byte(call.Arguments), &args)
+	status := "a human agent was notified and will join the conversation shortly"
+	if !c.Escalate(strings.TrimSpace(args.Reason)) {
+		status = "a human agent was already requested"
+	}
+	data, _ := json.Marshal(map[string]string{"status": status})
+	return string(data)
+}
+
+// escalated tells the client and OnChange about the escalation
+func (c *Conversation) escalated(data EscalationData) {
+	c.notify(Event{Type: EventEscalation, Data: data})
+	if c.Options.Escalation != nil && c.Options.Escalation.OnChange != nil {
+		c.Options.Escalation.OnChange(c, data)
+	}
+}
+
+// notify delivers an event which does not belong to a generation, it is
+// dropped if the client does not keep up
+func (c *Conversation) notify(ev Event) {
+	select {
+	case c.events <- ev:
+	default:
+	}
+}
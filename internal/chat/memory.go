@@ -0,0 +1,164 @@
+package chat
+
+import (
+	"VoiceSculptor/pkg/llm"
+	"VoiceSculptor/pkg/prompt"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultMemoryTurns   = 12
+	DefaultContextTokens = 8192
+
+	// SummaryPrompt the prompt rendering the summarization request, with the
+	// arguments summary and conversation, summaryFallback is used without it
+	SummaryPrompt = "summarize_conversation"
+
+	// summaryMaxTokens the tokens of the running summary
+	summaryMaxTokens   = 512
+	summaryTemperature = 0.2
+	// summaryTimeout how long the summarization after a reply may take
+	summaryTimeout = 30 * time.Second
+)
+
+const summaryFallback = "Merge the conversation below into the summary so far, keep the requests of the customer, the facts given, the decisions made and what is still open. Only write the new summary.\n\nSummary so far:\n%s\n\nConversation:\n%s"
+
+// Memory the settings of the conversation memory: the last Turns messages
+// are sent verbatim, the older ones are rolled into a running summary written
+// by the model, and the prompt is trimmed to leave MaxTokens for the reply
+// within ContextTokens
+type Memory struct {
+	Turns         int // messages kept verbatim, 0 uses DefaultMemoryTurns
+	ContextTokens int // context window of the model, 0 uses DefaultContextTokens
+}
+
+func (m *Memory) turns() int {
+	if m.Turns <= 0 {
+		return DefaultMemoryTurns
+	}
+	return m.Turns
+}
+
+func (m *Memory) contextTokens() int {
+	if m.ContextTokens <= 0 {
+		return DefaultContextTokens
+	}
+	return m.ContextTokens
+}
+
+// Summary returns the running summary of the messages older than the memory
+// window, empty until the conversation outgrows it
+func (c *Conversation) Summary() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.summary
+}
+
+// systemPrompt returns the system prompt with what is remembered of the
+// customer, the running summary and the pending escalation, c.mu held
+func (c *Conversation) systemPrompt() string {
+	parts := make([]string, 0, 4)
+	if c.Options.SystemPrompt != "" {
+		parts = append(parts, c.Options.SystemPrompt)
+	}
+	if c.Options.Customer != nil {
+		if recall := c.Options.Customer.Recall(); recall != "" {
+			parts = append(parts, "What you remember of the customer from the previous conversations:\n"+recall)
+		}
+	}
+	if c.summary != "" {
+		parts = append(parts, "Summary of the earlier conversation:\n"+c.summary)
+	}
+	if c.escalation.Status == EscalationWaiting {
+		parts = append(parts, "A human agent was asked to join the conversation. Keep helping the customer meanwhile, tell them the agent will join shortly if they ask.")
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// historyBudget returns the tokens left to the history once the system
+// prompt and the reply are accounted for, c.mu held
+func (c *Conversation) historyBudget(system string) int {
+	budget := c.Options.Memory.contextTokens() - c.Options.MaxTokens
+	if system != "" {
+		budget -= llm.EstimateMessageTokens(llm.Message{Role: llm.RoleSystem, Content: system})
+	}
+	return budget
+}
+
+// window returns the start of the most recent messages fitting in the
+// budget, at most turns of them. The last message is always kept and a tool
+// result is never separated from its call.
+func window(history []llm.Message, turns, budget int) int {
+	start := len(history)
+	for start > 0 && len(history)-start < turns {
+		tokens := llm.EstimateMessageTokens(history[start-1])
+		if start < len(history) && tokens > budget {
+			break
+		}
+		budget -= tokens
+		start--
+	}
+	for start < len(history)-1 && history[start].Role == llm.RoleTool {
+		start++
+	}
+	return start
+}
+
+// compact rolls the messages which left the memory window into the running
+// summary, the summary is left as is if the summarization fails or the
+// conversation is closed meanwhile
+func (c *Conversation) compact(ctx context.Context) {
+	c.mu.Lock()
+	from := c.summarized
+	turns := c.Options.Memory.turns()
+	// room for the summary growing
+	budget := c.historyBudget(c.systemPrompt()) - summaryMaxTokens
+	to := c.summarized + window(c.history[c.summarized:], turns, budget)
+	older := append([]llm.Message(nil), c.history[from:to]...)
+	summary := c.summary
+	c.mu.Unlock()
+	if len(older) == 0 {
+		return
+	}
+
+	text, err := c.summarize(ctx, summary, older)
+	if err != nil || text == "" || ctx.Err() != nil {
+		return
+	}
+	c.mu.Lock()
+	if c.summarized == from {
+		c.summary, c.summarized = text, to
+	}
+	c.mu.Unlock()
+}
+
+// summarize asks the model to merge the messages into the summary
+func (c *Conversation) summarize(ctx context.Context, summary string, messages []llm.Message) (string, error) {
+	var transcript strings.Builder
+	for _, msg := range messages {
+		if msg.Content == "" {
+			continue
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n", msg.Role, msg.Content)
+	}
+	request, err := prompt.Render(SummaryPrompt, map[string]any{"summary": summary, "conversation": transcript.String()})
+	if err != nil {
+		request = fmt.Sprintf(summaryFallback, summary, transcript.String())
+	}
+
+	resp, err := c.provider.ChatStream(ctx, &llm.ChatRequest{
+		Messages:    []llm.Message{{Role: llm.RoleUser, Content: request}},
+		Temperature: summaryTemperature,
+		MaxTokens:   summaryMaxTokens,
+	}, func(llm.Chunk) error { return nil })
+	if resp == nil {
+		return "", err
+	}
+	if c.Options.OnUsage != nil {
+		c.Options.OnUsage(resp.Usage)
+	}
+	return strings.TrimSpace(resp.Content), err
+}
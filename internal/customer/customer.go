@@ -0,0 +1,95 @@
+// Package customer remembers the customers across their conversations: the
+// facts learnt about them and the summaries of their previous sessions.
+package customer
+
+import (
+	"VoiceSculptor/internal/chat"
+	"VoiceSculptor/internal/models"
+	"VoiceSculptor/pkg/logger"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Service the customer profiles stored in the database
+type Service struct {
+	db *gorm.DB
+}
+
+func NewService(db *gorm.DB) *Service {
+	return &Service{db: db}
+}
+
+// Customer returns the memory of the customer with the external ID for a
+// conversation of the user with the assistant, nil without an external ID
+func (s *Service) Customer(userID, assistantID uint, externalID string) chat.Customer {
+	externalID = models.NormalizeExternalID(externalID)
+	if externalID == "" {
+		return nil
+	}
+	m := &memory{service: s, userID: userID, assistantID: assistantID, externalID: externalID}
+	customer, err := models.FindCustomer(s.db, userID, externalID)
+	if err != nil {
+		logger.Warn("load customer failed", zap.Uint("userId", userID), zap.Error(err))
+	}
+	if customer != nil {
+		m.facts = customer.Facts
+		m.recall = Recall(customer)
+	}
+	return m
+}
+
+// Recall returns the facts and the latest session summaries of the customer
+// as added to the system prompt, empty if nothing is known
+func Recall(customer *models.Customer) string {
+	var b strings.Builder
+	for _, fact := range customer.Facts {
+		fmt.Fprintf(&b, "- %s\n", fact)
+	}
+	sessions := customer.Sessions
+	if len(sessions) > models.CustomerRecalledSessions {
+		sessions = sessions[:models.CustomerRecalledSessions]
+	}
+	// the sessions are newest first, the oldest is told first
+	for i := len(sessions) - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "- Conversation of %s: %s\n", sessions[i].CreatedAt.Format("2006-01-02"), sessions[i].Summary)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// memory implements chat.Customer
+type memory struct {
+	service     *Service
+	userID      uint
+	assistantID uint
+	externalID  string
+	facts       []string
+	recall      string
+	once        sync.Once
+}
+
+// Recall implements chat.Customer.
+func (m *memory) Recall() string {
+	return m.recall
+}
+
+// Facts implements chat.Customer.
+func (m *memory) Facts() []string {
+	return m.facts
+}
+
+// Remember implements chat.Customer.
+func (m *memory) Remember(ctx context.Context, sessionID, summary string, facts []string) (err error) {
+	m.once.Do(func() {
+		session := &models.CustomerSession{SessionID: sessionID, AssistantID: m.assistantID, Summary: summary}
+		err = models.RememberCustomer(m.service.db.WithContext(ctx), m.userID, m.externalID, session, facts)
+		if err != nil {
+			logger.Warn("remember customer failed", zap.String("sessionId", sessionID), zap.Error(err))
+		}
+	})
+	return err
+}
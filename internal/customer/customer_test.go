@@ -0,0 +1,83 @@
+package customer
+
+import (
+	"VoiceSculptor/internal/models"
+	"VoiceSculptor/pkg/logger"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestMain(m *testing.M) {
+	dir, _ := os.MkdirTemp("", "customer")
+	_ = logger.Init(&logger.LogConfig{Level: "error", Filename: filepath.Join(dir, "customer.log")}, "test")
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func setupService(t *testing.T) (*Service, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "customer.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.Customer{}, &models.CustomerSession{}))
+	return NewService(db), db
+}
+
+func TestService_RemembersAcrossSessions(t *testing.T) {
+	service, db := setupService(t)
+	ctx := context.Background()
+
+	assert.Nil(t, service.Customer(1, 2, " "))
+
+	first := service.Customer(1, 2, "Alice@Example.com")
+	require.NotNil(t, first)
+	assert.Empty(t, first.Recall())
+	require.NoError(t, first.Remember(ctx, "s1", "Asked about order 42", []string{"Name is Alice", "Ordered #42"}))
+	// a conversation is remembered once
+	require.NoError(t, first.Remember(ctx, "s1", "again", nil))
+
+	second := service.Customer(1, 3, "alice@example.com")
+	assert.Equal(t, []string{"Name is Alice", "Ordered #42"}, second.Facts())
+	assert.Contains(t, second.Recall(), "- Name is Alice\n- Ordered #42\n- Conversation of ")
+	assert.Contains(t, second.Recall(), ": Asked about order 42")
+	// the facts are kept when they could not be extracted
+	require.NoError(t, second.Remember(ctx, "s2", "", nil))
+
+	// the customers are kept per user
+	assert.Empty(t, service.Customer(9, 2, "alice@example.com").Recall())
+
+	customers, err := models.ListCustomers(db, 1, "ALICE@example.com")
+	require.NoError(t, err)
+	require.Len(t, customers, 1)
+	customer, err := models.GetCustomer(db, 1, customers[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", customer.ExternalID)
+	assert.Equal(t, 2, customer.SessionCount)
+	assert.Equal(t, []string{"Name is Alice", "Ordered #42"}, customer.Facts)
+	require.Len(t, customer.Sessions, 1)
+	assert.Equal(t, models.CustomerSession{ID: customer.Sessions[0].ID, CreatedAt: customer.Sessions[0].CreatedAt, CustomerID: customer.ID, SessionID: "s1", AssistantID: 2, Summary: "Asked about order 42"}, customer.Sessions[0])
+
+	_, err = models.GetCustomer(db, 9, customer.ID)
+	assert.ErrorIs(t, err, models.ErrCustomerNotFound)
+
+	require.NoError(t, models.EraseCustomer(db, customer))
+	var sessions int64
+	db.Model(&models.CustomerSession{}).Count(&sessions)
+	assert.Zero(t, sessions)
+	assert.Empty(t, service.Customer(1, 2, "alice@example.com").Recall())
+}
+
+func TestRecall(t *testing.T) {
+	customer := &models.Customer{Sessions: make([]models.CustomerSession, models.CustomerRecalledSessions+1)}
+	for i := range customer.Sessions {
+		customer.Sessions[i].Summary = string(rune('d' - i))
+	}
+	// newest first in, oldest first out, the oldest beyond the limit dropped
+	assert.Equal(t, "- Conversation of 0001-01-01: b\n- Conversation of 0001-01-01: c\n- Conversation of 0001-01-01: d", Recall(customer))
+}
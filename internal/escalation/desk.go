@@ -0,0 +1,407 @@
+// Package escalation hands the conversations over to the staff: the
+// conversations escalated to a human agent are announced to the staff users,
+// a staff member joins one over a WebSocket to take it over from the assistant.
+package escalation
+
+import (
+	"VoiceSculptor/internal/chat"
+	"VoiceSculptor/internal/models"
+	"VoiceSculptor/pkg/llm"
+	"VoiceSculptor/pkg/logger"
+	"VoiceSculptor/pkg/notification"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// the channels of the escalated conversations
+const (
+	ChannelChat = "chat"
+	ChannelCall = "call"
+)
+
+// the messages of the staff WebSockets
+const (
+	MessageRequests = "requests" // the escalated conversations, sent once connected
+	MessageRequest  = "request"  // an escalated conversation changed
+	MessageContext  = "context"  // the conversation taken over, sent once joined
+	MessageTurn     = "turn"     // a turn of the conversation taken over
+	MessageReply    = "reply"    // sent by the agent: the reply to the customer
+	MessageRelease  = "release"  // sent by the agent: hands the conversation back to the assistant
+	MessageClosed   = "closed"   // the conversation taken over was closed
+	MessageError    = "error"
+)
+
+// watcherBufferSize the changes a staff WebSocket may lag behind
+const watcherBufferSize = 64
+
+// Request a conversation escalated to a human agent
+type Request struct {
+	SessionID   string      `json:"sessionId"`
+	Channel     string      `json:"channel"`
+	UserID      uint        `json:"userId"`
+	AssistantID uint        `json:"assistantId"`
+	GroupID     uint        `json:"groupId,omitempty"` // the group of the assistant
+	Status      string      `json:"status"`            // waiting, active, or empty once handed back to the assistant or closed
+	Reason      string      `json:"reason,omitempty"`
+	Agent       *chat.Agent `json:"agent,omitempty"`
+	CreatedAt   time.Time   `json:"createdAt"`
+}
+
+// TurnData a turn of the conversation taken over
+type TurnData struct {
+	Seq     int    `json:"seq"`
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	AgentID uint   `json:"agentId,omitempty"`
+	Tool    string `json:"tool,omitempty"` // the tool called, for the tool turns
+}
+
+// Message a message of the staff WebSockets
+type Message struct {
+	Type     string        `json:"type"`
+	Content  string        `json:"content,omitempty"` // the reply of the agent
+	Request  *Request      `json:"request,omitempty"`
+	Requests []Request     `json:"requests,omitempty"`
+	History  []llm.Message `json:"history,omitempty"`
+	Summary  string        `json:"summary,omitempty"`
+	Facts    []string      `json:"facts,omitempty"` // what is remembered of the customer
+	Turn     *TurnData     `json:"turn,omitempty"`
+	Message  string        `json:"message,omitempty"` // the error
+}
+
+// Desk the escalated conversations of an engine
+type Desk struct {
+	db            *gorm.DB
+	engine        *chat.Engine
+	channel       string
+	notifications *notification.InternalNotificationService
+
+	mu       sync.Mutex
+	requests map[string]*Request
+	watchers map[chan Request]struct{}
+}
+
+func NewDesk(db *gorm.DB, engine *chat.Engine, channel string) *Desk {
+	return &Desk{
+		db:            db,
+		engine:        engine,
+		channel:       channel,
+		notifications: notification.NewInternalNotificationService(db),
+		requests:      make(map[string]*Request),
+		watchers:      make(map[chan Request]struct{}),
+	}
+}
+
+// Escalation returns the escalation settings of the conversations of the
+// assistant, nil if the assistant does not escalate to the staff
+func (d *Desk) Escalation(assistant *models.Assistant) *chat.Escalation {
+	keywords := assistant.EscalationKeywordList()
+	if !assistant.Escalation && len(keywords) == 0 {
+		return nil
+	}
+	return &chat.Escalation{Keywords: keywords, Tool: assistant.Escalation, OnChange: d.changed}
+}
+
+// AgentOf returns the agent of the staff user
+func AgentOf(user *models.User) chat.Agent {
+	name := user.DisplayName
+	if name == "" {
+		name = strings.TrimSpace(user.FirstName + " " + user.LastName)
+	}
+	if name == "" {
+		name = user.Email
+	}
+	return chat.Agent{ID: user.ID, Name: name}
+}
+
+// Requests returns the escalated conversations the staff member handles, oldest first
+func (d *Desk) Requests(user *models.User) []Request {
+	staff := d.staff(user)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	requests := make([]Request, 0, len(d.requests))
+	for _, r := range d.requests {
+		if staff.handles(*r) {
+			requests = append(requests, *r)
+		}
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].CreatedAt.Before(requests[j].CreatedAt) })
+	return requests
+}
+
+// staffMember a staff member and the groups whose conversations they handle
+type staffMember struct {
+	user     *models.User
+	groupIDs map[uint]bool
+}
+
+// staff returns the staff member of the user
+func (d *Desk) staff(user *models.User) staffMember {
+	member := staffMember{user: user, groupIDs: make(map[uint]bool)}
+	ids, err := models.GetUserGroupIDs(d.db, user.ID)
+	if err != nil {
+		logger.Warn("list staff groups failed", zap.Uint("userId", user.ID), zap.Error(err))
+	}
+	for _, id := range ids {
+		member.groupIDs[id] = true
+	}
+	return member
+}
+
+// handles reports whether the staff member handles the request: the
+// superusers handle all of them, the others those of their own assistants and
+// of the assistants of their groups
+func (s staffMember) handles(r Request) bool {
+	return s.user.IsSuperUser || r.UserID == s.user.ID || (r.GroupID != 0 && s.groupIDs[r.GroupID])
+}
+
+// Watch returns the changes of the escalated conversations until stop is called
+func (d *Desk) Watch() (changes <-chan Request, stop func()) {
+	ch := make(chan Request, watcherBufferSize)
+	d.mu.Lock()
+	d.watchers[ch] = struct{}{}
+	d.mu.Unlock()
+	return ch, func() {
+		d.mu.Lock()
+		delete(d.watchers, ch)
+		d.mu.Unlock()
+	}
+}
+
+// changed implements chat.Escalation.OnChange, the staff users are notified
+// of the new requests
+func (d *Desk) changed(conv *chat.Conversation, data chat.EscalationData) {
+	d.mu.Lock()
+	r, ok := d.requests[conv.ID]
+	if !ok {
+		if data.Status == chat.EscalationNone {
+			d.mu.Unlock()
+			return
+		}
+		r = &Request{
+			SessionID:   conv.ID,
+			Channel:     d.channel,
+			UserID:      conv.Options.UserID,
+			AssistantID: conv.Options.AssistantID,
+			GroupID:     d.assistantGroup(conv.Options.AssistantID),
+			CreatedAt:   time.Now(),
+		}
+		d.requests[conv.ID] = r
+		go d.forget(conv)
+	}
+	r.Status, r.Agent = data.Status, data.Agent
+	if data.Reason != "" {
+		r.Reason = data.Reason
+	}
+	request := *r
+	if data.Status == chat.EscalationNone {
+		delete(d.requests, conv.ID)
+	}
+	d.broadcast(request)
+	d.mu.Unlock()
+
+	if !ok && data.Status == chat.EscalationWaiting {
+		d.notify(request)
+	}
+}
+
+// assistantGroup returns the group the assistant is shared to, 0 for none
+func (d *Desk) assistantGroup(assistantID uint) uint {
+	var groupIDs []uint
+	if err := d.db.Model(&models.Assistant{}).Where("id = ?", assistantID).Pluck("group_id", &groupIDs).Error; err != nil {
+		logger.Warn("load assistant group failed", zap.Uint("assistantId", assistantID), zap.Error(err))
+	}
+	if len(groupIDs) == 0 {
+		return 0
+	}
+	return groupIDs[0]
+}
+
+// forget drops the request once the conversation is closed
+func (d *Desk) forget(conv *chat.Conversation) {
+	<-conv.Done()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r, ok := d.requests[conv.ID]
+	if !ok {
+		return
+	}
+	delete(d.requests, conv.ID)
+	request := *r
+	request.Status, request.Agent = chat.EscalationNone, nil
+	d.broadcast(request)
+}
+
+// broadcast sends the change to the watchers, d.mu held
+func (d *Desk) broadcast(r Request) {
+	for watcher := range d.watchers {
+		select {
+		case watcher <- r:
+		default:
+		}
+	}
+}
+
+// notify leaves a notification to the staff users who handle the request
+func (d *Desk) notify(r Request) {
+	staff, err := models.ListStaffUserIDs(d.db)
+	if err != nil {
+		logger.Warn("list staff users failed", zap.String("sessionId", r.SessionID), zap.Error(err))
+		return
+	}
+	title := fmt.Sprintf("A %s needs a human agent", r.Channel)
+	content := fmt.Sprintf("Session %s of assistant %d asks for a human agent", r.SessionID, r.AssistantID)
+	if r.Reason != "" {
+		content += ": " + r.Reason
+	}
+	for _, userID := range staff {
+		if userID != r.UserID && (r.GroupID == 0 || models.GetGroupRole(d.db, userID, r.GroupID) == "") {
+			continue
+		}
+		if err := d.notifications.Send(userID, title, content); err != nil {
+			logger.Warn("notify staff user failed", zap.Uint("userId", userID), zap.Error(err))
+		}
+	}
+}
+
+// ServeRequests streams the escalated conversations the staff member handles
+// until the WebSocket is closed
+func (d *Desk) ServeRequests(ctx context.Context, ws *websocket.Conn, user *models.User) error {
+	defer ws.Close()
+	staff := d.staff(user)
+	changes, stop := d.Watch()
+	defer stop()
+	if err := ws.WriteJSON(Message{Type: MessageRequests, Requests: d.Requests(user)}); err != nil {
+		return err
+	}
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case r := <-changes:
+			if !staff.handles(r) {
+				continue
+			}
+			if err := ws.WriteJSON(Message{Type: MessageRequest, Request: &r}); err != nil {
+				return err
+			}
+		case <-closed:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Join lets the staff member take the escalated conversation over through the
+// WebSocket: the conversation so far is sent, then its turns, and the replies
+// of the agent are sent to the customer. The conversation is handed back to
+// the assistant once the agent releases it or the WebSocket is closed.
+func (d *Desk) Join(ctx context.Context, ws *websocket.Conn, sessionID string, user *models.User) error {
+	defer ws.Close()
+	agent := AgentOf(user)
+	conv, err := d.engine.Get(sessionID)
+	if r, ok := d.request(sessionID); ok && !d.staff(user).handles(r) {
+		// the conversations of the other tenants are not disclosed
+		err = chat.ErrConversationNotFound
+	}
+	if err == nil {
+		err = conv.TakeOver(agent)
+	}
+	if err != nil {
+		_ = ws.WriteJSON(Message{Type: MessageError, Message: err.Error()})
+		return err
+	}
+	defer conv.Release(agent.ID)
+	turns, stop := conv.Watch()
+	defer stop()
+
+	joined := Message{Type: MessageContext, History: conv.History(), Summary: conv.Summary()}
+	if r, ok := d.request(sessionID); ok {
+		joined.Request = &r
+	}
+	if conv.Options.Customer != nil {
+		joined.Facts = conv.Options.Customer.Facts()
+	}
+	if err := ws.WriteJSON(joined); err != nil {
+		return err
+	}
+
+	// the messages of the agent, the errors are written by the loop below
+	failures := make(chan error, 1)
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			var msg Message
+			if err := ws.ReadJSON(&msg); err != nil {
+				return
+			}
+			var err error
+			switch msg.Type {
+			case MessageReply:
+				if strings.TrimSpace(msg.Content) != "" {
+					err = conv.Reply(agent.ID, msg.Content)
+				}
+			case MessageRelease:
+				return
+			default:
+				err = fmt.Errorf("unknown message type: %s", msg.Type)
+			}
+			if err != nil {
+				select {
+				case failures <- err:
+				default:
+				}
+			}
+		}
+	}()
+
+	for {
+		select {
+		case turn := <-turns:
+			data := &TurnData{Seq: turn.Seq, Role: turn.Role, Content: turn.Content, AgentID: turn.AgentID}
+			if turn.Tool != nil {
+				data.Tool = turn.Tool.Name
+			}
+			if err := ws.WriteJSON(Message{Type: MessageTurn, Turn: data}); err != nil {
+				return err
+			}
+		case err := <-failures:
+			if err := ws.WriteJSON(Message{Type: MessageError, Message: err.Error()}); err != nil {
+				return err
+			}
+		case <-conv.Done():
+			return ws.WriteJSON(Message{Type: MessageClosed})
+		case <-closed:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (d *Desk) request(sessionID string) (Request, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r, ok := d.requests[sessionID]
+	if !ok {
+		return Request{}, false
+	}
+	return *r, true
+}
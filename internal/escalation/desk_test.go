@@ -0,0 +1,189 @@
+package escalation
+
+import (
+	"VoiceSculptor/internal/chat"
+	"VoiceSculptor/internal/models"
+	"VoiceSculptor/pkg/llm"
+	"VoiceSculptor/pkg/logger"
+	"VoiceSculptor/pkg/notification"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestMain(m *testing.M) {
+	dir, _ := os.MkdirTemp("", "escalation")
+	_ = logger.Init(&logger.LogConfig{Level: "error", Filename: filepath.Join(dir, "escalation.log")}, "test")
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func setupDesk(t *testing.T) (*Desk, *chat.Engine, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "escalation.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.Group{}, &models.GroupMember{}, &models.Assistant{}, &notification.InternalNotification{}))
+	require.NoError(t, db.Create(&[]models.User{
+		{Email: "staff@example.com", DisplayName: "Staff", IsStaff: true, Enabled: true},
+		{Email: "user@example.com", Enabled: true},
+		{Email: "former@example.com", IsStaff: true},
+		{Email: "other@example.com", IsStaff: true, Enabled: true},
+		{Email: "root@example.com", IsSuperUser: true, Enabled: true},
+	}).Error)
+	// the assistant 4 of the user 2 is shared to the group of the staff user 1
+	require.NoError(t, db.Create(&models.Group{ID: 7, Name: "support"}).Error)
+	require.NoError(t, db.Create(&models.GroupMember{UserID: 1, GroupID: 7, Role: models.GroupRoleMember}).Error)
+	require.NoError(t, db.Create(&models.Assistant{ID: 4, UserID: 2, GroupID: 7, Name: "shop"}).Error)
+	engine := chat.NewEngine(60)
+	return NewDesk(db, engine, ChannelChat), engine, db
+}
+
+func getUser(t *testing.T, db *gorm.DB, id string) *models.User {
+	var user models.User
+	require.NoError(t, db.Take(&user, id).Error)
+	return &user
+}
+
+func nextMessage(t *testing.T, ws *websocket.Conn) Message {
+	require.NoError(t, ws.SetReadDeadline(time.Now().Add(time.Second)))
+	var msg Message
+	require.NoError(t, ws.ReadJSON(&msg))
+	return msg
+}
+
+func TestDesk_Escalation(t *testing.T) {
+	desk, _, _ := setupDesk(t)
+	assert.Nil(t, desk.Escalation(&models.Assistant{}))
+	escalation := desk.Escalation(&models.Assistant{EscalationKeywords: "agent， refund,"})
+	require.NotNil(t, escalation)
+	assert.Equal(t, []string{"agent", "refund"}, escalation.Keywords)
+	assert.False(t, escalation.Tool)
+	assert.True(t, desk.Escalation(&models.Assistant{Escalation: true}).Tool)
+}
+
+func TestDesk_JoinTakesOver(t *testing.T) {
+	desk, engine, db := setupDesk(t)
+	changes, stop := desk.Watch()
+	defer stop()
+	conv := engine.Start(&llm.FakeProvider{}, chat.Options{
+		UserID:      2,
+		AssistantID: 4,
+		Escalation:  desk.Escalation(&models.Assistant{EscalationKeywords: "agent"}),
+	})
+
+	require.NoError(t, conv.Send("an agent please"))
+	for ev := range conv.Events() {
+		if ev.Type == chat.EventDone {
+			break
+		}
+	}
+	requests := desk.Requests(getUser(t, db, "1"))
+	require.Len(t, requests, 1)
+	assert.Equal(t, Request{SessionID: conv.ID, Channel: ChannelChat, UserID: 2, AssistantID: 4, GroupID: 7, Status: chat.EscalationWaiting, Reason: "keyword: agent", CreatedAt: requests[0].CreatedAt}, requests[0])
+	assert.Equal(t, requests[0], <-changes)
+	// the staff of the other tenants do not see it, the superusers do
+	assert.Empty(t, desk.Requests(getUser(t, db, "4")))
+	assert.Len(t, desk.Requests(getUser(t, db, "5")), 1)
+	// only the enabled staff users handling the request are notified
+	var notifications []notification.InternalNotification
+	require.NoError(t, db.Find(&notifications).Error)
+	require.Len(t, notifications, 1)
+	assert.EqualValues(t, 1, notifications[0].UserID)
+	assert.Contains(t, notifications[0].Content, conv.ID)
+
+	joined := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		require.NoError(t, err)
+		joined <- desk.Join(context.Background(), ws, r.URL.Query().Get("sessionId"), getUser(t, db, r.URL.Query().Get("userId")))
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	for _, query := range []string{"?userId=1&sessionId=missing", "?userId=4&sessionId=" + conv.ID} {
+		ws, _, err := websocket.DefaultDialer.Dial(url+query, nil)
+		require.NoError(t, err)
+		assert.Equal(t, Message{Type: MessageError, Message: chat.ErrConversationNotFound.Error()}, nextMessage(t, ws))
+		ws.Close()
+		<-joined
+	}
+
+	ws, _, err := websocket.DefaultDialer.Dial(url+"?userId=1&sessionId="+conv.ID, nil)
+	require.NoError(t, err)
+	defer ws.Close()
+	msg := nextMessage(t, ws)
+	require.Equal(t, MessageContext, msg.Type)
+	require.NotNil(t, msg.Request)
+	assert.Equal(t, chat.EscalationActive, msg.Request.Status)
+	assert.Equal(t, "Staff", msg.Request.Agent.Name)
+	assert.Equal(t, "an agent please", msg.History[0].Content)
+	assert.Equal(t, chat.EscalationActive, (<-changes).Status)
+
+	require.NoError(t, conv.Send("are you human?"))
+	assert.Equal(t, &TurnData{Seq: 3, Role: llm.RoleUser, Content: "are you human?"}, nextMessage(t, ws).Turn)
+	require.NoError(t, ws.WriteJSON(Message{Type: MessageReply, Content: "Yes, how can I help?"}))
+	assert.Equal(t, &TurnData{Seq: 4, Role: llm.RoleAssistant, Content: "Yes, how can I help?", AgentID: 1}, nextMessage(t, ws).Turn)
+	require.NoError(t, ws.WriteJSON(Message{Type: "unknown"}))
+	assert.Equal(t, MessageError, nextMessage(t, ws).Type)
+
+	require.NoError(t, ws.WriteJSON(Message{Type: MessageRelease}))
+	select {
+	case err := <-joined:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("join not ended")
+	}
+	assert.Equal(t, chat.EscalationNone, conv.Escalation().Status)
+	assert.Empty(t, desk.Requests(getUser(t, db, "1")))
+	assert.Equal(t, chat.EscalationNone, (<-changes).Status)
+}
+
+func TestDesk_ServeRequests(t *testing.T) {
+	desk, engine, db := setupDesk(t)
+	// an assistant of the staff user 1
+	conv := engine.Start(&llm.FakeProvider{}, chat.Options{UserID: 1, Escalation: desk.Escalation(&models.Assistant{Escalation: true})})
+	conv.Escalate("upset")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		require.NoError(t, err)
+		_ = desk.ServeRequests(context.Background(), ws, getUser(t, db, r.URL.Query().Get("userId")))
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	other, _, err := websocket.DefaultDialer.Dial(url+"?userId=4", nil)
+	require.NoError(t, err)
+	defer other.Close()
+	ws, _, err := websocket.DefaultDialer.Dial(url+"?userId=1", nil)
+	require.NoError(t, err)
+	defer ws.Close()
+
+	msg := nextMessage(t, ws)
+	assert.Equal(t, MessageRequests, msg.Type)
+	require.Len(t, msg.Requests, 1)
+	assert.Equal(t, "upset", msg.Requests[0].Reason)
+	// the staff of the other tenants are not told about it
+	assert.Equal(t, Message{Type: MessageRequests}, nextMessage(t, other))
+
+	// closing the conversation drops its request
+	require.NoError(t, engine.Stop(conv.ID))
+	msg = nextMessage(t, ws)
+	assert.Equal(t, MessageRequest, msg.Type)
+	assert.Equal(t, conv.ID, msg.Request.SessionID)
+	assert.Equal(t, chat.EscalationNone, msg.Request.Status)
+	assert.Empty(t, desk.Requests(getUser(t, db, "1")))
+	require.NoError(t, other.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+	_, _, err = other.ReadMessage()
+	assert.Error(t, err)
+}
@@ -0,0 +1,384 @@
+package handlers
+
+import (
+	voiceSculptor "VoiceSculptor"
+	"VoiceSculptor/internal/models"
+	"VoiceSculptor/pkg/config"
+	"VoiceSculptor/pkg/ivr"
+	"VoiceSculptor/pkg/response"
+	"VoiceSculptor/pkg/util"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	textTemplate "text/template"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type CreateAssistantRequest struct {
+	Name         string   `json:"name" binding:"required"`
+	Description  string   `json:"description"`
+	GroupID      uint     `json:"groupId"`
+	SystemPrompt string   `json:"systemPrompt"`
+	Instruction  string   `json:"instruction"`
+	PersonaTag   string   `json:"personaTag"`
+	MaxTokens    *int     `json:"maxTokens"`
+	Temperature  *float32 `json:"temperature"`
+
+	PromptID      uint           `json:"promptId"`
+	PromptVersion int            `json:"promptVersion" comment:"0 follows the latest version"`
+	PromptArgs    map[string]any `json:"promptArgs"`
+
+	VadThreshold    float64 `json:"vadThreshold" comment:"Speech level in dBFS between -90 and 0, 0 uses the default"`
+	VadMinSpeechMs  int     `json:"vadMinSpeechMs" comment:"Speech needed to interrupt the assistant, 0 uses the default"`
+	VadMinSilenceMs int     `json:"vadMinSilenceMs" comment:"Silence ending an utterance, 0 uses the default"`
+
+	IvrMenu *ivr.Menu `json:"ivrMenu" comment:"Key menu played before the assistant takes the call, e.g. press 1 for sales"`
+
+	KnowledgeBaseID uint `json:"knowledgeBaseId" comment:"Knowledge base the replies are grounded on, 0 for none"`
+	KnowledgeTopK   int  `json:"knowledgeTopK" comment:"Chunks retrieved per user message between 1 and 20, 0 uses the default 3"`
+
+	MemoryTurns   int `json:"memoryTurns" comment:"Recent messages sent verbatim, the older ones are summarized, 0 uses the default 12"`
+	ContextTokens int `json:"contextTokens" comment:"Context window of the model, the prompt is trimmed to leave maxTokens for the reply, 0 uses the default 8192"`
+
+	Escalation         bool   `json:"escalation" comment:"The assistant can call transfer_to_human to ask for a human agent"`
+	EscalationKeywords string `json:"escalationKeywords" comment:"Comma separated, a user message containing one of them asks for a human agent"`
+}
+
+type UpdateAssistantRequest struct {
+	Name         *string  `json:"name"`
+	Description  *string  `json:"description"`
+	SystemPrompt *string  `json:"systemPrompt"`
+	Instruction  *string  `json:"instruction"`
+	PersonaTag   *string  `json:"personaTag"`
+	MaxTokens    *int     `json:"maxTokens"`
+	Temperature  *float32 `json:"temperature"`
+
+	PromptID      *uint          `json:"promptId"`
+	PromptVersion *int           `json:"promptVersion"`
+	PromptArgs    map[string]any `json:"promptArgs"`
+
+	VadThreshold    *float64 `json:"vadThreshold"`
+	VadMinSpeechMs  *int     `json:"vadMinSpeechMs"`
+	VadMinSilenceMs *int     `json:"vadMinSilenceMs"`
+
+	IvrMenu *ivr.Menu `json:"ivrMenu" comment:"An empty object removes the menu"`
+
+	KnowledgeBaseID *uint `json:"knowledgeBaseId" comment:"0 detaches the knowledge base"`
+	KnowledgeTopK   *int  `json:"knowledgeTopK"`
+
+	MemoryTurns   *int `json:"memoryTurns"`
+	ContextTokens *int `json:"contextTokens"`
+
+	Escalation         *bool   `json:"escalation"`
+	EscalationKeywords *string `json:"escalationKeywords"`
+}
+
+var assistantLoaderTemplate = textTemplate.Must(textTemplate.New("loader.js").Parse(voiceSculptor.AssistantJsModule))
+
+// CreateAssistant create an assistant owned by the current user
+func (h *Handlers) CreateAssistant(c *gin.Context) {
+	var req CreateAssistantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	user := models.CurrentUser(c)
+
+	if req.GroupID != 0 && models.GetGroupRole(h.db, user.ID, req.GroupID) == "" {
+		voiceSculptor.AbortWithJSONError(c, http.StatusForbidden, models.ErrNotGroupMember)
+		return
+	}
+
+	jsSourceID, err := util.GenerateSecureToken(12)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	assistant := models.Assistant{
+		UserID:       user.ID,
+		GroupID:      req.GroupID,
+		Name:         req.Name,
+		Description:  req.Description,
+		SystemPrompt: req.SystemPrompt,
+		Instruction:  req.Instruction,
+		PersonaTag:   req.PersonaTag,
+		MaxTokens:    models.AssistantDefaultMaxTokens,
+		Temperature:  models.AssistantDefaultTemperature,
+		JsSourceID:   jsSourceID,
+
+		PromptID:      req.PromptID,
+		PromptVersion: req.PromptVersion,
+
+		VadThreshold:    req.VadThreshold,
+		VadMinSpeechMs:  req.VadMinSpeechMs,
+		VadMinSilenceMs: req.VadMinSilenceMs,
+
+		KnowledgeBaseID: req.KnowledgeBaseID,
+		KnowledgeTopK:   req.KnowledgeTopK,
+
+		MemoryTurns:   req.MemoryTurns,
+		ContextTokens: req.ContextTokens,
+
+		Escalation:         req.Escalation,
+		EscalationKeywords: req.EscalationKeywords,
+	}
+	if req.PromptArgs != nil {
+		promptArgs, _ := json.Marshal(req.PromptArgs)
+		assistant.PromptArgs = string(promptArgs)
+	}
+	assistant.IvrMenu = marshalMenu(req.IvrMenu)
+	if req.MaxTokens != nil {
+		assistant.MaxTokens = *req.MaxTokens
+	}
+	if req.Temperature != nil {
+		assistant.Temperature = *req.Temperature
+	}
+	if err := assistant.Validate(); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := h.checkMenuAssistants(user, req.IvrMenu); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := h.checkKnowledgeBase(user, assistant.KnowledgeBaseID); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	if _, err := assistant.RenderSystemPrompt(h.db); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.db.Create(&assistant).Error; err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "create assistant success", assistant)
+}
+
+// ListAssistants list the assistants visible to the current user
+func (h *Handlers) ListAssistants(c *gin.Context) {
+	assistants, err := models.ListAssistants(h.db, models.CurrentUser(c))
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "success", assistants)
+}
+
+// GetAssistant get an assistant visible to the current user
+func (h *Handlers) GetAssistant(c *gin.Context) {
+	assistant, ok := h.loadAssistant(c)
+	if !ok {
+		return
+	}
+	response.Success(c, "success", assistant)
+}
+
+// UpdateAssistant update an assistant, only the owner or group admin can do this
+func (h *Handlers) UpdateAssistant(c *gin.Context) {
+	var req UpdateAssistantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	assistant, ok := h.loadAssistant(c)
+	if !ok {
+		return
+	}
+	if !models.CanModifyAssistant(h.db, models.CurrentUser(c), assistant) {
+		voiceSculptor.AbortWithJSONError(c, http.StatusForbidden, models.ErrAssistantForbidden)
+		return
+	}
+
+	if req.Name != nil {
+		assistant.Name = *req.Name
+	}
+	if req.Description != nil {
+		assistant.Description = *req.Description
+	}
+	if req.SystemPrompt != nil {
+		assistant.SystemPrompt = *req.SystemPrompt
+	}
+	if req.Instruction != nil {
+		assistant.Instruction = *req.Instruction
+	}
+	if req.PersonaTag != nil {
+		assistant.PersonaTag = *req.PersonaTag
+	}
+	if req.MaxTokens != nil {
+		assistant.MaxTokens = *req.MaxTokens
+	}
+	if req.Temperature != nil {
+		assistant.Temperature = *req.Temperature
+	}
+	if req.PromptID != nil {
+		assistant.PromptID = *req.PromptID
+	}
+	if req.PromptVersion != nil {
+		assistant.PromptVersion = *req.PromptVersion
+	}
+	if req.PromptArgs != nil {
+		promptArgs, _ := json.Marshal(req.PromptArgs)
+		assistant.PromptArgs = string(promptArgs)
+	}
+	if req.VadThreshold != nil {
+		assistant.VadThreshold = *req.VadThreshold
+	}
+	if req.VadMinSpeechMs != nil {
+		assistant.VadMinSpeechMs = *req.VadMinSpeechMs
+	}
+	if req.VadMinSilenceMs != nil {
+		assistant.VadMinSilenceMs = *req.VadMinSilenceMs
+	}
+	if req.IvrMenu != nil {
+		assistant.IvrMenu = marshalMenu(req.IvrMenu)
+	}
+	if req.KnowledgeBaseID != nil {
+		assistant.KnowledgeBaseID = *req.KnowledgeBaseID
+	}
+	if req.KnowledgeTopK != nil {
+		assistant.KnowledgeTopK = *req.KnowledgeTopK
+	}
+	if req.MemoryTurns != nil {
+		assistant.MemoryTurns = *req.MemoryTurns
+	}
+	if req.ContextTokens != nil {
+		assistant.ContextTokens = *req.ContextTokens
+	}
+	if req.Escalation != nil {
+		assistant.Escalation = *req.Escalation
+	}
+	if req.EscalationKeywords != nil {
+		assistant.EscalationKeywords = *req.EscalationKeywords
+	}
+	if err := assistant.Validate(); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := h.checkMenuAssistants(models.CurrentUser(c), req.IvrMenu); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	if req.KnowledgeBaseID != nil {
+		if err := h.checkKnowledgeBase(models.CurrentUser(c), assistant.KnowledgeBaseID); err != nil {
+			voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if _, err := assistant.RenderSystemPrompt(h.db); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.db.Save(assistant).Error; err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "update assistant success", assistant)
+}
+
+// DeleteAssistant delete an assistant, only the owner or group admin can do this
+func (h *Handlers) DeleteAssistant(c *gin.Context) {
+	assistant, ok := h.loadAssistant(c)
+	if !ok {
+		return
+	}
+	if !models.CanModifyAssistant(h.db, models.CurrentUser(c), assistant) {
+		voiceSculptor.AbortWithJSONError(c, http.StatusForbidden, models.ErrAssistantForbidden)
+		return
+	}
+	if err := h.db.Select("Tools").Delete(assistant).Error; err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "delete assistant success", nil)
+}
+
+// ServeVoiceSculptorLoaderJS render the embeddable client script of an assistant
+func (h *Handlers) ServeVoiceSculptorLoaderJS(c *gin.Context) {
+	assistant, err := models.GetAssistantByJsSourceID(h.db, c.Param("id"))
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusNotFound, err)
+		return
+	}
+
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+
+	var buf bytes.Buffer
+	err = assistantLoaderTemplate.Execute(&buf, map[string]any{
+		"BaseURL": fmt.Sprintf("%s://%s%s", scheme, c.Request.Host, config.GlobalConfig.APIPrefix),
+		"Name":    template.JSEscapeString(template.HTMLEscapeString(assistant.Name)),
+	})
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.Data(http.StatusOK, "application/javascript; charset=utf-8", buf.Bytes())
+}
+
+// marshalMenu returns the menu in JSON, empty for nil or an empty menu
+func marshalMenu(menu *ivr.Menu) string {
+	if menu == nil || (menu.Prompt == "" && len(menu.Options) == 0) {
+		return ""
+	}
+	data, _ := json.Marshal(menu)
+	return string(data)
+}
+
+// checkMenuAssistants checks the assistants a key menu hands off to are visible to the user
+func (h *Handlers) checkMenuAssistants(user *models.User, menu *ivr.Menu) error {
+	if menu == nil {
+		return nil
+	}
+	for _, id := range menu.AssistantIDs() {
+		if _, err := models.GetAssistant(h.db, user, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkKnowledgeBase checks the knowledge base grounding an assistant is visible to the user
+func (h *Handlers) checkKnowledgeBase(user *models.User, id uint) error {
+	if id == 0 {
+		return nil
+	}
+	_, err := models.GetKnowledgeBase(h.db, user, id)
+	return err
+}
+
+// loadAssistant load the assistant of the path id, abort the request if it is not visible
+func (h *Handlers) loadAssistant(c *gin.Context) (*models.Assistant, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, errors.New("invalid assistant id"))
+		return nil, false
+	}
+	assistant, err := models.GetAssistant(h.db, models.CurrentUser(c), uint(id))
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, lookupStatus(err, models.ErrAssistantNotFound), err)
+		return nil, false
+	}
+	return assistant, true
+}
+
+// lookupStatus returns the status of a failed lookup: 404 when the record does
+// not exist or is not visible to the user, 500 for the database errors
+func lookupStatus(err, notFound error) int {
+	if errors.Is(err, notFound) || errors.Is(err, gorm.ErrRecordNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
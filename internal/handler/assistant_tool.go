@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	voiceSculptor "VoiceSculptor"
+	"VoiceSculptor/internal/models"
+	"VoiceSculptor/pkg/response"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CreateAssistantToolRequest struct {
+	Name        string         `json:"name" binding:"required" comment:"Function name called by the model, letters, digits, _ or -"`
+	Description string         `json:"description" comment:"Tells the model when to call the tool"`
+	Parameters  map[string]any `json:"parameters" comment:"JSON schema of the arguments, e.g. {\"type\":\"object\",\"properties\":{\"city\":{\"type\":\"string\"}}}"`
+	URL         string         `json:"url" binding:"required" comment:"Webhook receiving POST {tool, callId, arguments}, its response body is the result"`
+	TimeoutMs   int            `json:"timeoutMs" comment:"0 uses the default 10000, at most 60000"`
+	AuthHeader  string         `json:"authHeader" comment:"Header sent to the webhook, e.g. Authorization"`
+	AuthValue   string         `json:"authValue" comment:"Value of the header, never returned"`
+	Disabled    bool           `json:"disabled"`
+}
+
+type UpdateAssistantToolRequest struct {
+	Name        *string        `json:"name"`
+	Description *string        `json:"description"`
+	Parameters  map[string]any `json:"parameters"`
+	URL         *string        `json:"url"`
+	TimeoutMs   *int           `json:"timeoutMs"`
+	AuthHeader  *string        `json:"authHeader"`
+	AuthValue   *string        `json:"authValue"`
+	Disabled    *bool          `json:"disabled"`
+}
+
+// CreateAssistantTool add a tool to an assistant, only the owner or group admin can do this
+func (h *Handlers) CreateAssistantTool(c *gin.Context) {
+	var req CreateAssistantToolRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	assistant, ok := h.loadModifiableAssistant(c)
+	if !ok {
+		return
+	}
+
+	tool := models.AssistantTool{
+		AssistantID: assistant.ID,
+		Name:        req.Name,
+		Description: req.Description,
+		URL:         req.URL,
+		TimeoutMs:   req.TimeoutMs,
+		AuthHeader:  req.AuthHeader,
+		AuthValue:   req.AuthValue,
+		Disabled:    req.Disabled,
+	}
+	if req.Parameters != nil {
+		parameters, _ := json.Marshal(req.Parameters)
+		tool.Parameters = string(parameters)
+	}
+	if err := tool.Validate(); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := models.SaveAssistantTool(h.db, &tool); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "create tool success", tool)
+}
+
+// ListAssistantTools list the tools of an assistant visible to the current user
+func (h *Handlers) ListAssistantTools(c *gin.Context) {
+	assistant, ok := h.loadAssistant(c)
+	if !ok {
+		return
+	}
+	tools, err := models.ListAssistantTools(h.db, assistant.ID)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "success", tools)
+}
+
+// UpdateAssistantTool update a tool of an assistant, only the owner or group admin can do this
+func (h *Handlers) UpdateAssistantTool(c *gin.Context) {
+	var req UpdateAssistantToolRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	tool, ok := h.loadAssistantTool(c)
+	if !ok {
+		return
+	}
+
+	if req.Name != nil {
+		tool.Name = *req.Name
+	}
+	if req.Description != nil {
+		tool.Description = *req.Description
+	}
+	if req.Parameters != nil {
+		parameters, _ := json.Marshal(req.Parameters)
+		tool.Parameters = string(parameters)
+	}
+	if req.URL != nil {
+		tool.URL = *req.URL
+	}
+	if req.TimeoutMs != nil {
+		tool.TimeoutMs = *req.TimeoutMs
+	}
+	if req.AuthHeader != nil {
+		tool.AuthHeader = *req.AuthHeader
+	}
+	if req.AuthValue != nil {
+		tool.AuthValue = *req.AuthValue
+	}
+	if req.Disabled != nil {
+		tool.Disabled = *req.Disabled
+	}
+	if err := tool.Validate(); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := models.SaveAssistantTool(h.db, tool); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "update tool success", tool)
+}
+
+// DeleteAssistantTool delete a tool of an assistant, only the owner or group admin can do this
+func (h *Handlers) DeleteAssistantTool(c *gin.Context) {
+	tool, ok := h.loadAssistantTool(c)
+	if !ok {
+		return
+	}
+	if err := h.db.Delete(tool).Error; err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "delete tool success", nil)
+}
+
+// loadModifiableAssistant load the assistant of the path id, abort the request
+// if the current user cannot modify it
+func (h *Handlers) loadModifiableAssistant(c *gin.Context) (*models.Assistant, bool) {
+	assistant, ok := h.loadAssistant(c)
+	if !ok {
+		return nil, false
+	}
+	if !models.CanModifyAssistant(h.db, models.CurrentUser(c), assistant) {
+		voiceSculptor.AbortWithJSONError(c, http.StatusForbidden, models.ErrAssistantForbidden)
+		return nil, false
+	}
+	return assistant, true
+}
+
+// loadAssistantTool load the tool of the path toolId, abort the request if the
+// current user cannot modify its assistant
+func (h *Handlers) loadAssistantTool(c *gin.Context) (*models.AssistantTool, bool) {
+	assistant, ok := h.loadModifiableAssistant(c)
+	if !ok {
+		return nil, false
+	}
+	id, err := strconv.ParseUint(c.Param("toolId"), 10, 64)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, errors.New("invalid tool id"))
+		return nil, false
+	}
+	tool, err := models.GetAssistantTool(h.db, assistant.ID, uint(id))
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return tool, true
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	voiceSculptor "VoiceSculptor"
+	"VoiceSculptor/internal/models"
+	"VoiceSculptor/pkg/response"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CreateCampaignRequest struct {
+	Name              string `json:"name" binding:"required"`
+	AssistantID       uint   `json:"assistantId" binding:"required"`
+	CredentialID      uint   `json:"credentialId" binding:"required" comment:"The outbound calls of its campaigns are limited by its callConcurrency"`
+	CallerNumber      string `json:"callerNumber"`
+	Greeting          string `json:"greeting" comment:"Template of the opening instruction, the CSV columns are fields, e.g. {{.name}}"`
+	CallingHours      string `json:"callingHours" comment:"e.g. mon-fri 09:00-18:00; sat 10:00-14:00 in the timezone of the contact, empty calls anytime"`
+	Timezone          string `json:"timezone" comment:"Timezone of the contacts without one, UTC when empty"`
+	MaxAttempts       int    `json:"maxAttempts" comment:"Calls per contact, 0 uses the default 3"`
+	RetryDelaySeconds int    `json:"retryDelaySeconds" comment:"Delay before retrying no answer or busy, doubled every attempt, 0 uses the default 300"`
+}
+
+// CreateCampaign create a draft outbound campaign of the current user
+func (h *Handlers) CreateCampaign(c *gin.Context) {
+	var req CreateCampaignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	user := models.CurrentUser(c)
+	if _, err := models.GetAssistant(h.db, user, req.AssistantID); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	var credential models.UserCredential
+	if err := h.db.Where("id = ? AND user_id = ?", req.CredentialID, user.ID).Take(&credential).Error; err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, models.ErrCredentialNotFound)
+		return
+	}
+
+	campaign := models.Campaign{
+		UserID:            user.ID,
+		Name:              req.Name,
+		AssistantID:       req.AssistantID,
+		CredentialID:      req.CredentialID,
+		CallerNumber:      req.CallerNumber,
+		Greeting:          req.Greeting,
+		CallingHours:      req.CallingHours,
+		Timezone:          req.Timezone,
+		MaxAttempts:       req.MaxAttempts,
+		RetryDelaySeconds: req.RetryDelaySeconds,
+		Status:            models.CampaignStatusDraft,
+	}
+	if err := campaign.Validate(); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := h.db.Create(&campaign).Error; err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "create campaign success", campaign)
+}
+
+// ListCampaigns list the campaigns of the current user
+func (h *Handlers) ListCampaigns(c *gin.Context) {
+	campaigns, err := models.ListCampaigns(h.db, models.CurrentUser(c).ID)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "success", campaigns)
+}
+
+// GetCampaign get a campaign of the current user with the number of contacts by status
+func (h *Handlers) GetCampaign(c *gin.Context) {
+	campaign, ok := h.loadCampaign(c)
+	if !ok {
+		return
+	}
+	counts, err := models.CountCampaignContacts(h.db, campaign.ID)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "success", gin.H{
+		"campaign": campaign,
+		"contacts": counts,
+	})
+}
+
+// UploadCampaignContacts add the contacts of the CSV file to a campaign which is not running
+func (h *Handlers) UploadCampaignContacts(c *gin.Context) {
+	campaign, ok := h.loadCampaign(c)
+	if !ok {
+		return
+	}
+	if campaign.Status == models.CampaignStatusRunning {
+		voiceSculptor.AbortWithJSONError(c, http.StatusConflict, models.ErrCampaignRunning)
+		return
+	}
+	header, err := c.FormFile("file")
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, errors.New("file is required"))
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	defer file.Close()
+
+	contacts, err := models.ParseCampaignContacts(file)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := models.AddCampaignContacts(h.db, campaign.ID, contacts); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "upload contacts success", gin.H{"count": len(contacts)})
+}
+
+// ListCampaignContacts list the contacts of a campaign with the outcomes of their calls
+func (h *Handlers) ListCampaignContacts(c *gin.Context) {
+	campaign, ok := h.loadCampaign(c)
+	if !ok {
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "10"))
+	contacts, total, err := models.ListCampaignContacts(h.db, campaign.ID, c.Query("status"), page, size)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "success", gin.H{
+		"list":  contacts,
+		"total": total,
+		"page":  page,
+		"size":  size,
+	})
+}
+
+// StartCampaign start or resume calling the contacts of a campaign
+func (h *Handlers) StartCampaign(c *gin.Context) {
+	campaign, ok := h.loadCampaign(c)
+	if !ok {
+		return
+	}
+	var pending int64
+	err := h.db.Model(&models.CampaignContact{}).
+		Where("campaign_id = ? AND status = ?", campaign.ID, models.ContactStatusPending).
+		Count(&pending).Error
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if pending == 0 {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, models.ErrCampaignNoContacts)
+		return
+	}
+	if err := models.SetCampaignStatus(h.db, campaign, models.CampaignStatusRunning); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "start campaign success", campaign)
+}
+
+// PauseCampaign stop placing new calls of a campaign, the calls in progress go on
+func (h *Handlers) PauseCampaign(c *gin.Context) {
+	campaign, ok := h.loadCampaign(c)
+	if !ok {
+		return
+	}
+	if err := models.SetCampaignStatus(h.db, campaign, models.CampaignStatusPaused); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "pause campaign success", campaign)
+}
+
+// loadCampaign load the campaign of the path id, abort the request if it is not the current user's
+func (h *Handlers) loadCampaign(c *gin.Context) (*models.Campaign, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, errors.New("invalid campaign id"))
+		return nil, false
+	}
+	campaign, err := models.GetCampaign(h.db, models.CurrentUser(c).ID, uint(id))
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return campaign, true
+}
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	voiceSculptor "VoiceSculptor"
+	"VoiceSculptor/internal/chat"
+	"VoiceSculptor/internal/models"
+	"VoiceSculptor/pkg/llm"
+	"VoiceSculptor/pkg/logger"
+	"VoiceSculptor/pkg/response"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	chatSessionExpirySeconds = 30 * 60
+	chatHeartbeatInterval    = 15 * time.Second
+)
+
+type ChatStartRequest struct {
+	AssistantID  uint   `json:"assistantId" binding:"required"`
+	Message      string `json:"message" comment:"First user message, the assistant greets first if empty"`
+	SystemPrompt string `json:"systemPrompt" comment:"Used only if the assistant has no system prompt"`
+	CustomerID   string `json:"customerId" comment:"External ID of the customer, e.g. a phone number, an email or the user ID of the host app. The assistant remembers the customer across the sessions"`
+}
+
+type ChatMessageRequest struct {
+	SessionID string `json:"sessionId" binding:"required"`
+	Message   string `json:"message" binding:"required"`
+}
+
+// Chat start a conversation with an assistant, the reply is read from ChatStream
+func (h *Handlers) Chat(c *gin.Context) {
+	var req ChatStartRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	user := models.CurrentUser(c)
+
+	assistant, err := models.GetAssistant(h.db, user, req.AssistantID)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, lookupStatus(err, models.ErrAssistantNotFound), err)
+		return
+	}
+	credential, err := h.currentCredential(c, user)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, lookupStatus(err, models.ErrCredentialNotFound), err)
+		return
+	}
+	if err := credential.CheckQuota(); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusPaymentRequired, err)
+		return
+	}
+	provider, err := credential.NewLLMProvider()
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	systemPrompt, err := assistant.RenderSystemPrompt(h.db)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	if len(req.CustomerID) > models.CustomerMaxExternalIDLength {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, models.ErrCustomerInvalidExternalID)
+		return
+	}
+	if assistant.Tools, err = models.ListAssistantTools(h.db, assistant.ID); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	sessionLog := &models.ChatSessionLog{
+		UserID:       user.ID,
+		AssistantID:  assistant.ID,
+		CredentialID: credential.ID,
+	}
+	opts := chat.Options{
+		UserID:       user.ID,
+		AssistantID:  assistant.ID,
+		CredentialID: credential.ID,
+		SystemPrompt: systemPrompt,
+		Temperature:  assistant.Temperature,
+		MaxTokens:    assistant.MaxTokens,
+		Tools:        assistant.Toolset(),
+		Knowledge:    h.knowledge.Knowledge(assistant),
+		Memory:       &chat.Memory{Turns: assistant.MemoryTurns, ContextTokens: assistant.ContextTokens},
+		Customer:     h.customers.Customer(user.ID, assistant.ID, req.CustomerID),
+		Escalation:   h.escalations.Escalation(assistant),
+		OnUsage: func(usage llm.Usage) {
+			if err := models.AddCredentialUsage(h.db, credential.ID, usage.TotalTokens); err != nil {
+				logger.Warn("add credential usage failed", zap.Uint("credentialId", credential.ID), zap.Error(err))
+			}
+		},
+		OnTurn: func(turn chat.Turn) {
+			record := &models.ChatSessionTurn{
+				SessionLogID:     sessionLog.ID,
+				Seq:              turn.Seq,
+				Role:             turn.Role,
+				Content:          turn.Content,
+				LatencyMs:        turn.LatencyMs,
+				PromptTokens:     turn.Usage.PromptTokens,
+				CompletionTokens: turn.Usage.CompletionTokens,
+				TotalTokens:      turn.Usage.TotalTokens,
+				Interrupted:      turn.Interrupted,
+				AssistantID:      assistant.ID,
+				CredentialID:     credential.ID,
+				AgentID:          turn.AgentID,
+			}
+			if turn.Tool != nil {
+				record.ToolCallID, record.ToolName = turn.Tool.ID, turn.Tool.Name
+				record.ToolArguments, record.ToolError = turn.Tool.Arguments, turn.Tool.Error
+			}
+			if err := models.AppendChatSessionTurn(h.db, record); err != nil {
+				logger.Warn("append chat session turn failed", zap.String("sessionId", sessionLog.SessionID), zap.Error(err))
+			}
+		},
+		OnClose: func() {
+			if err := models.EndChatSessionLog(h.db, sessionLog.ID); err != nil {
+				logger.Warn("end chat session log failed", zap.String("sessionId", sessionLog.SessionID), zap.Error(err))
+			}
+		},
+	}
+	if opts.SystemPrompt == "" {
+		opts.SystemPrompt = req.SystemPrompt
+	}
+	if assistant.Instruction != "" {
+		opts.SystemPrompt += "\n\n" + assistant.Instruction
+	}
+
+	conv := h.chat.Start(provider, opts)
+	sessionLog.SessionID = conv.ID
+	if err := models.CreateChatSessionLog(h.db, sessionLog); err != nil {
+		_ = h.chat.Stop(conv.ID)
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if req.Message != "" {
+		err = conv.Send(req.Message)
+	} else {
+		err = conv.Greet()
+	}
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "success", gin.H{"sessionId": conv.ID})
+}
+
+// SendChatMessage send a user message to a running conversation
+func (h *Handlers) SendChatMessage(c *gin.Context) {
+	var req ChatMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	conv, ok := h.loadConversation(c, req.SessionID)
+	if !ok {
+		return
+	}
+	if err := conv.Send(req.Message); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "success", gin.H{"sessionId": conv.ID})
+}
+
+// StopChat stop the generation and close the conversation
+func (h *Handlers) StopChat(c *gin.Context) {
+	sessionID := c.Query("sessionId")
+	if sessionID == "" {
+		var req struct {
+			SessionID string `json:"sessionId"`
+		}
+		_ = c.ShouldBindJSON(&req)
+		sessionID = req.SessionID
+	}
+	conv, ok := h.loadConversation(c, sessionID)
+	if !ok {
+		return
+	}
+	if err := h.chat.Stop(conv.ID); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "success", gin.H{"message": "chat stopped"})
+}
+
+// ChatStream stream the events of a conversation as server-sent events,
+// the generation in progress is cancelled when the client disconnects
+func (h *Handlers) ChatStream(c *gin.Context) {
+	conv, ok := h.loadConversation(c, c.Query("sessionId"))
+	if !ok {
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(chatHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case ev := <-conv.Events():
+			c.SSEvent(ev.Type, ev.Data)
+		case <-heartbeat.C:
+			// keep the session alive while the client is listening
+			_, _ = h.chat.Get(conv.ID)
+			c.SSEvent(chat.EventHeartbeat, gin.H{"time": time.Now().Unix()})
+		case <-conv.Done():
+			return
+		case <-c.Request.Context().Done():
+			conv.Cancel()
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+// currentCredential returns the credential used by the request, the one matching
+// the api key if the request is signed by api key, otherwise the latest one
+func (h *Handlers) currentCredential(c *gin.Context, user *models.User) (*models.UserCredential, error) {
+	apiKey := c.GetHeader("X-API-KEY")
+	if apiKey == "" {
+		apiKey = c.Query("apiKey")
+	}
+	if apiKey != "" {
+		return models.GetUserCredentialByAPIKey(h.db, user.ID, apiKey)
+	}
+	return models.GetLatestUserCredential(h.db, user.ID)
+}
+
+// loadConversation load the conversation of the current user, abort the request if not found
+func (h *Handlers) loadConversation(c *gin.Context, sessionID string) (*chat.Conversation, bool) {
+	if sessionID == "" {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, errors.New("sessionId is required"))
+		return nil, false
+	}
+	conv, err := h.chat.Get(sessionID)
+	if err == nil && conv.Options.UserID != models.CurrentUser(c).ID {
+		err = chat.ErrConversationNotFound
+	}
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusNotFound, err)
+		return nil, false
+	}
+	return conv, true
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	voiceSculptor "VoiceSculptor"
+	"VoiceSculptor/internal/models"
+	"VoiceSculptor/pkg/response"
+	stores "VoiceSculptor/pkg/storage"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getChatSessionLog list the conversation transcripts of the current user
+func (h *Handlers) getChatSessionLog(c *gin.Context) {
+	filter := models.ChatSessionLogFilter{UserID: models.CurrentUser(c).ID}
+	filter.Page, _ = strconv.Atoi(c.DefaultQuery("page", "1"))
+	filter.Size, _ = strconv.Atoi(c.DefaultQuery("size", "10"))
+	if assistantID := c.Query("assistantId"); assistantID != "" {
+		id, err := strconv.ParseUint(assistantID, 10, 64)
+		if err != nil {
+			voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, fmt.Errorf("invalid assistantId: %s", assistantID))
+			return
+		}
+		filter.AssistantID = uint(id)
+	}
+
+	var err error
+	if filter.Start, err = parseQueryTime(c, "start_time"); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	if filter.End, err = parseQueryTime(c, "end_time"); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	logs, total, err := models.ListChatSessionLogs(h.db, filter)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "success", gin.H{
+		"list":  logs,
+		"total": total,
+		"page":  filter.Page,
+		"size":  filter.Size,
+	})
+}
+
+// getChatSessionLogDetail get a transcript of the current user with its turns
+func (h *Handlers) getChatSessionLogDetail(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	log, err := models.GetChatSessionLog(h.db, models.CurrentUser(c).ID, uint(id))
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "success", log)
+}
+
+// getChatSessionLogRecording replay the recording of a voice conversation of the
+// current user, the whole call or the clip of the turn seq
+func (h *Handlers) getChatSessionLogRecording(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	seq, err := strconv.Atoi(c.DefaultQuery("seq", "0"))
+	if err != nil || seq < 0 {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, fmt.Errorf("invalid seq: %s", c.Query("seq")))
+		return
+	}
+	log, err := models.GetChatSessionLog(h.db, models.CurrentUser(c).ID, uint(id))
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if log.RecordingURL == "" {
+		voiceSculptor.AbortWithJSONError(c, http.StatusNotFound, errors.New("recording not found"))
+		return
+	}
+	r, size, err := stores.Default().Read(models.ChatRecordingKey(log.SessionID, seq))
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusNotFound, errors.New("recording not found"))
+		return
+	}
+	defer r.Close()
+	c.DataFromReader(http.StatusOK, size, "audio/wav", r, nil)
+}
+
+// parseQueryTime parse a RFC3339 time of the query, zero if absent
+func parseQueryTime(c *gin.Context, key string) (time.Time, error) {
+	value := c.Query(key)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s: %s", key, value)
+	}
+	return t, nil
+}
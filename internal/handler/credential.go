@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	voiceSculptor "VoiceSculptor"
+	"VoiceSculptor/internal/models"
+	"VoiceSculptor/pkg/asr"
+	"VoiceSculptor/pkg/llm"
+	"VoiceSculptor/pkg/response"
+	"VoiceSculptor/pkg/tts"
+	"VoiceSculptor/pkg/util"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CreateCredentialRequest struct {
+	Name        string `json:"name" binding:"required"`
+	LLMProvider string `json:"llmProvider" binding:"required"`
+	LLMApiKey   string `json:"llmApiKey"`
+	LLMApiURL   string `json:"llmApiUrl"`
+	LLMModel    string `json:"llmModel"`
+
+	AsrProvider  string `json:"asrProvider"`
+	AsrAppID     string `json:"asrAppId"`
+	AsrSecretID  string `json:"asrSecretId"`
+	AsrSecretKey string `json:"asrSecretKey"`
+	AsrLanguage  string `json:"language"`
+
+	TtsProvider  string `json:"ttsProvider"`
+	TTSAppID     string `json:"ttsAppId"`
+	TTSSecretID  string `json:"ttsSecretId"`
+	TTSSecretKey string `json:"ttsSecretKey"`
+
+	CallConcurrency int `json:"callConcurrency" binding:"gte=0"`
+}
+
+// handleCreateCredential create an api credential, the api secret is only returned once
+func (h *Handlers) handleCreateCredential(c *gin.Context) {
+	var req CreateCredentialRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	if !llm.IsRegistered(req.LLMProvider) {
+		err := fmt.Errorf("unknown llm provider: %s, available: %s", req.LLMProvider, strings.Join(llm.Providers(), ", "))
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	if req.AsrProvider != "" && !asr.IsRegistered(req.AsrProvider) {
+		err := fmt.Errorf("unknown asr provider: %s, available: %s", req.AsrProvider, strings.Join(asr.Providers(), ", "))
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	if req.TtsProvider != "" && !tts.IsRegistered(req.TtsProvider) {
+		err := fmt.Errorf("unknown tts provider: %s, available: %s", req.TtsProvider, strings.Join(tts.Providers(), ", "))
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	apiKey, err := util.GenerateSecureToken(24)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	apiSecret, err := util.GenerateSecureToken(32)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	credential := models.UserCredential{
+		UserID:       models.CurrentUser(c).ID,
+		Name:         req.Name,
+		APIKey:       apiKey,
+		APISecret:    apiSecret,
+		LLMProvider:  req.LLMProvider,
+		LLMApiKey:    req.LLMApiKey,
+		LLMApiURL:    req.LLMApiURL,
+		LLMModel:     req.LLMModel,
+		AsrProvider:  req.AsrProvider,
+		AsrAppID:     req.AsrAppID,
+		AsrSecretID:  req.AsrSecretID,
+		AsrSecretKey: req.AsrSecretKey,
+		AsrLanguage:  req.AsrLanguage,
+		TtsProvider:  req.TtsProvider,
+		TTSAppID:     req.TTSAppID,
+		TTSSecretID:  req.TTSSecretID,
+		TTSSecretKey: req.TTSSecretKey,
+
+		CallConcurrency: req.CallConcurrency,
+	}
+	if err := h.db.Create(&credential).Error; err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "create credential success", credential)
+}
+
+// handleGetCredential list the credentials of the current user, secrets are masked
+func (h *Handlers) handleGetCredential(c *gin.Context) {
+	credentials, err := models.GetUserCredentials(h.db, models.CurrentUser(c).ID)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	for i := range credentials {
+		credentials[i].APISecret = ""
+		credentials[i].LLMApiKey = maskSecret(credentials[i].LLMApiKey)
+		credentials[i].AsrSecretKey = maskSecret(credentials[i].AsrSecretKey)
+		credentials[i].TTSSecretKey = maskSecret(credentials[i].TTSSecretKey)
+	}
+	response.Success(c, "success", credentials)
+}
+
+// maskSecret keeps only the last 4 characters of the secret
+func maskSecret(secret string) string {
+	if len(secret) <= 4 {
+		return strings.Repeat("*", len(secret))
+	}
+	return strings.Repeat("*", len(secret)-4) + secret[len(secret)-4:]
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	voiceSculptor "VoiceSculptor"
+	"VoiceSculptor/internal/models"
+	"VoiceSculptor/pkg/response"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListCustomers list the customers remembered by the assistants of the current
+// user, filtered by the externalId query
+func (h *Handlers) ListCustomers(c *gin.Context) {
+	customers, err := models.ListCustomers(h.db, models.CurrentUser(c).ID, c.Query("externalId"))
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "success", customers)
+}
+
+// GetCustomer get the memory of a customer: the facts and the summaries of its sessions
+func (h *Handlers) GetCustomer(c *gin.Context) {
+	customer, ok := h.loadCustomer(c)
+	if !ok {
+		return
+	}
+	response.Success(c, "success", customer)
+}
+
+// EraseCustomer forget a customer with everything remembered of it
+func (h *Handlers) EraseCustomer(c *gin.Context) {
+	customer, ok := h.loadCustomer(c)
+	if !ok {
+		return
+	}
+	if err := models.EraseCustomer(h.db, customer); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "erase customer success", nil)
+}
+
+// loadCustomer load the customer of the path id, abort the request if it is
+// not a customer of the current user
+func (h *Handlers) loadCustomer(c *gin.Context) (*models.Customer, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, errors.New("invalid customer id"))
+		return nil, false
+	}
+	customer, err := models.GetCustomer(h.db, models.CurrentUser(c).ID, uint(id))
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return customer, true
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"VoiceSculptor/internal/apidocs"
+	"VoiceSculptor/internal/escalation"
 	"VoiceSculptor/internal/models"
 	"net/http"
 )
@@ -98,6 +99,354 @@ func (h *Handlers) GetDocs() []apidocs.UriDoc {
 				},
 			},
 		},
+		{
+			Group:        "Assistant",
+			Path:         "/api/assistant/add",
+			Method:       http.MethodPost,
+			AuthRequired: true,
+			Desc:         "Create an assistant owned by the current user, set `groupId` to share it with a group",
+			Request:      apidocs.GetDocDefine(CreateAssistantRequest{}),
+			Response:     apidocs.GetDocDefine(models.Assistant{}),
+		},
+		{
+			Group:        "Assistant",
+			Path:         "/api/assistant",
+			Method:       http.MethodGet,
+			AuthRequired: true,
+			Desc:         "List the assistants owned by the current user or shared with the user's groups",
+		},
+		{
+			Group:        "Assistant",
+			Path:         "/api/assistant/:id",
+			Method:       http.MethodPut,
+			AuthRequired: true,
+			Desc:         "Update an assistant, only the owner or the group admin can do this",
+			Request:      apidocs.GetDocDefine(UpdateAssistantRequest{}),
+			Response:     apidocs.GetDocDefine(models.Assistant{}),
+		},
+		{
+			Group:        "Assistant",
+			Path:         "/api/assistant/:id",
+			Method:       http.MethodDelete,
+			AuthRequired: true,
+			Desc:         "Delete an assistant, only the owner or the group admin can do this",
+		},
+		{
+			Group:        "Assistant",
+			Path:         "/api/assistant/:id/tools",
+			Method:       http.MethodPost,
+			AuthRequired: true,
+			Desc:         "Add a tool the assistant can call: when the model calls it, its arguments are POSTed to the webhook as `{tool, callId, arguments}` and the response body is handed back to the model. Only the owner or the group admin can do this",
+			Request:      apidocs.GetDocDefine(CreateAssistantToolRequest{}),
+			Response:     apidocs.GetDocDefine(models.AssistantTool{}),
+		},
+		{
+			Group:        "Assistant",
+			Path:         "/api/assistant/:id/tools",
+			Method:       http.MethodGet,
+			AuthRequired: true,
+			Desc:         "List the tools of the assistant",
+		},
+		{
+			Group:        "Assistant",
+			Path:         "/api/assistant/:id/tools/:toolId",
+			Method:       http.MethodPut,
+			AuthRequired: true,
+			Desc:         "Update a tool of the assistant, only the owner or the group admin can do this",
+			Request:      apidocs.GetDocDefine(UpdateAssistantToolRequest{}),
+			Response:     apidocs.GetDocDefine(models.AssistantTool{}),
+		},
+		{
+			Group:        "Assistant",
+			Path:         "/api/assistant/:id/tools/:toolId",
+			Method:       http.MethodDelete,
+			AuthRequired: true,
+			Desc:         "Delete a tool of the assistant, only the owner or the group admin can do this",
+		},
+		{
+			Group:        "Chat",
+			Path:         "/api/chat/start",
+			Method:       http.MethodPost,
+			AuthRequired: true,
+			Desc:         "Start a conversation with an assistant, returns the `sessionId` used by `/api/chat/stream`",
+			Request:      apidocs.GetDocDefine(ChatStartRequest{}),
+			Response: &apidocs.DocField{
+				Type: "object",
+				Fields: []apidocs.DocField{
+					{Name: "sessionId", Type: apidocs.TYPE_STRING},
+				},
+			},
+		},
+		{
+			Group:        "Chat",
+			Path:         "/api/chat/message",
+			Method:       http.MethodPost,
+			AuthRequired: true,
+			Desc:         "Send a user message to the conversation, the reply being generated is interrupted",
+			Request:      apidocs.GetDocDefine(ChatMessageRequest{}),
+		},
+		{
+			Group:        "Chat",
+			Path:         "/api/chat/stream?sessionId={SESSION_ID}",
+			Method:       http.MethodGet,
+			AuthRequired: true,
+			Desc:         "Server-sent events of the conversation: `citations` with the knowledge base passages a reply is grounded on, `token`, `tool` for every tool called, `done`, `error`, `escalation` when a human agent is requested, takes over (`status` is `waiting` or `active`) or hands back to the assistant, and `heartbeat`. The replies of a human agent come as `token` and `done`. The generation is cancelled when the client disconnects",
+		},
+		{
+			Group:        "Chat",
+			Path:         "/api/chat/stop?sessionId={SESSION_ID}",
+			Method:       http.MethodPost,
+			AuthRequired: true,
+			Desc:         "Cancel the generation and close the conversation",
+		},
+		{
+			Group:        "Chat",
+			Path:         "/api/chat/chat-session-log?page=1&size=10&start_time={RFC3339}&end_time={RFC3339}&assistantId={ASSISTANT_ID}",
+			Method:       http.MethodGet,
+			AuthRequired: true,
+			Desc:         "List the conversation transcripts of the current user, newest first",
+			Response: &apidocs.DocField{
+				Type: "object",
+				Fields: []apidocs.DocField{
+					{Name: "list", Type: apidocs.TYPE_OBJECT, Desc: "Array of ChatSessionLog"},
+					{Name: "total", Type: apidocs.TYPE_INT},
+					{Name: "page", Type: apidocs.TYPE_INT},
+					{Name: "size", Type: apidocs.TYPE_INT},
+				},
+			},
+		},
+		{
+			Group:        "Chat",
+			Path:         "/api/chat/chat-session-log/:id",
+			Method:       http.MethodGet,
+			AuthRequired: true,
+			Desc:         "Get a conversation transcript with its turns in order",
+			Response:     apidocs.GetDocDefine(models.ChatSessionLog{}),
+		},
+		{
+			Group:        "Chat",
+			Path:         "/api/chat/chat-session-log/:id/recording?seq={SEQ}",
+			Method:       http.MethodGet,
+			AuthRequired: true,
+			Desc:         "Replay the recording of a voice conversation as audio/wav, the stereo call (caller left, assistant right) without seq, the clip of the turn otherwise",
+		},
+		{
+			Group:        "Prompt",
+			Path:         "/api/prompt",
+			Method:       http.MethodGet,
+			AuthRequired: true,
+			Desc:         "List the prompts with their arguments",
+		},
+		{
+			Group:        "Prompt",
+			Path:         "/api/prompt/:name/render",
+			Method:       http.MethodPost,
+			AuthRequired: true,
+			Desc:         "Render the named prompt with the arguments, the required arguments must be supplied",
+			Request:      apidocs.GetDocDefine(RenderPromptRequest{}),
+			Response: &apidocs.DocField{
+				Type: "object",
+				Fields: []apidocs.DocField{
+					{Name: "name", Type: apidocs.TYPE_STRING},
+					{Name: "prompt", Type: apidocs.TYPE_STRING},
+				},
+			},
+		},
+		{
+			Group:        "Prompt",
+			Path:         "/api/prompt/:name/versions",
+			Method:       http.MethodGet,
+			AuthRequired: true,
+			Desc:         "List the versions of the prompt, newest first. Staff only",
+		},
+		{
+			Group:        "Prompt",
+			Path:         "/api/prompt/:name/diff?from={VERSION}&to={VERSION}",
+			Method:       http.MethodGet,
+			AuthRequired: true,
+			Desc:         "Line diff of the templates of two versions, `to` defaults to the latest. Staff only",
+		},
+		{
+			Group:        "Prompt",
+			Path:         "/api/prompt/:name/rollback",
+			Method:       http.MethodPost,
+			AuthRequired: true,
+			Desc:         "Restore the template of a previous version, recorded as a new version. Staff only",
+			Request:      apidocs.GetDocDefine(RollbackPromptRequest{}),
+		},
+		{
+			Group:        "Campaign",
+			Path:         "/api/campaign",
+			Method:       http.MethodPost,
+			AuthRequired: true,
+			Desc:         "Create a draft outbound campaign calling its contacts with the assistant",
+			Request:      apidocs.GetDocDefine(CreateCampaignRequest{}),
+			Response:     apidocs.GetDocDefine(models.Campaign{}),
+		},
+		{
+			Group:        "Campaign",
+			Path:         "/api/campaign",
+			Method:       http.MethodGet,
+			AuthRequired: true,
+			Desc:         "List the campaigns of the current user, newest first",
+		},
+		{
+			Group:        "Campaign",
+			Path:         "/api/campaign/:id",
+			Method:       http.MethodGet,
+			AuthRequired: true,
+			Desc:         "Get a campaign with the number of its contacts by status",
+		},
+		{
+			Group:        "Campaign",
+			Path:         "/api/campaign/:id/contacts",
+			Method:       http.MethodPost,
+			AuthRequired: true,
+			Desc:         "Upload the contacts as the multipart CSV `file` with a header line. The `number` (or `phone`) column is required, `name` and `timezone` are optional, the other columns are fields of the greeting. Not while running",
+		},
+		{
+			Group:        "Campaign",
+			Path:         "/api/campaign/:id/contacts?status={STATUS}&page={PAGE}&size={SIZE}",
+			Method:       http.MethodGet,
+			AuthRequired: true,
+			Desc:         "List the contacts with the outcome, attempts and `sessionId` of their calls, the status is pending, calling, completed or failed",
+		},
+		{
+			Group:        "Campaign",
+			Path:         "/api/campaign/:id/start",
+			Method:       http.MethodPost,
+			AuthRequired: true,
+			Desc:         "Start or resume calling the pending contacts within the calling hours",
+		},
+		{
+			Group:        "Campaign",
+			Path:         "/api/campaign/:id/pause",
+			Method:       http.MethodPost,
+			AuthRequired: true,
+			Desc:         "Stop placing new calls, the calls in progress go on",
+		},
+		{
+			Group:        "Knowledge",
+			Path:         "/api/knowledge",
+			Method:       http.MethodPost,
+			AuthRequired: true,
+			Desc:         "Create a knowledge base, the documents are embedded with the provider configured by `EMBEDDING_PROVIDER`, a local hashing embedding by default",
+			Request:      apidocs.GetDocDefine(CreateKnowledgeBaseRequest{}),
+			Response:     apidocs.GetDocDefine(models.KnowledgeBase{}),
+		},
+		{
+			Group:        "Knowledge",
+			Path:         "/api/knowledge",
+			Method:       http.MethodGet,
+			AuthRequired: true,
+			Desc:         "List the knowledge bases visible to the current user",
+		},
+		{
+			Group:        "Knowledge",
+			Path:         "/api/knowledge/:id",
+			Method:       http.MethodGet,
+			AuthRequired: true,
+			Desc:         "Get a knowledge base",
+			Response:     apidocs.GetDocDefine(models.KnowledgeBase{}),
+		},
+		{
+			Group:        "Knowledge",
+			Path:         "/api/knowledge/:id",
+			Method:       http.MethodPut,
+			AuthRequired: true,
+			Desc:         "Update a knowledge base, only the owner or the group admin can do this",
+			Request:      apidocs.GetDocDefine(UpdateKnowledgeBaseRequest{}),
+			Response:     apidocs.GetDocDefine(models.KnowledgeBase{}),
+		},
+		{
+			Group:        "Knowledge",
+			Path:         "/api/knowledge/:id",
+			Method:       http.MethodDelete,
+			AuthRequired: true,
+			Desc:         "Delete a knowledge base with its documents, the assistants using it are no longer grounded",
+		},
+		{
+			Group:        "Knowledge",
+			Path:         "/api/knowledge/:id/documents",
+			Method:       http.MethodPost,
+			AuthRequired: true,
+			Desc:         "Upload a `.pdf`, `.md` or `.txt` document as the multipart field `file`, at most 20 MB. The document is returned `processing` and becomes `ready` once chunked and embedded, or `failed` with an `error`",
+			Response:     apidocs.GetDocDefine(models.KnowledgeDocument{}),
+		},
+		{
+			Group:        "Knowledge",
+			Path:         "/api/knowledge/:id/documents",
+			Method:       http.MethodGet,
+			AuthRequired: true,
+			Desc:         "List the documents of the knowledge base with their status",
+		},
+		{
+			Group:        "Knowledge",
+			Path:         "/api/knowledge/:id/documents/:docId",
+			Method:       http.MethodDelete,
+			AuthRequired: true,
+			Desc:         "Delete a document with its chunks, only the owner or the group admin can do this",
+		},
+		{
+			Group:        "Knowledge",
+			Path:         "/api/knowledge/:id/search?q={QUERY}&topK=3",
+			Method:       http.MethodGet,
+			AuthRequired: true,
+			Desc:         "The chunks closest to the query by score, as an assistant grounded on the knowledge base would retrieve them",
+			Response:     apidocs.GetDocDefine(KnowledgeSearchResult{}),
+		},
+		{
+			Group:        "Customer",
+			Path:         "/api/customer?externalId={EXTERNAL_ID}",
+			Method:       http.MethodGet,
+			AuthRequired: true,
+			Desc:         "List the customers remembered by the assistants of the current user, the `customerId` given to `/api/chat/start` or the caller number of a call",
+		},
+		{
+			Group:        "Customer",
+			Path:         "/api/customer/:id",
+			Method:       http.MethodGet,
+			AuthRequired: true,
+			Desc:         "Get what is remembered of a customer: the facts and the summaries of its sessions, newest first",
+			Response:     apidocs.GetDocDefine(models.Customer{}),
+		},
+		{
+			Group:        "Customer",
+			Path:         "/api/customer/:id",
+			Method:       http.MethodDelete,
+			AuthRequired: true,
+			Desc:         "Erase a customer with everything remembered of it",
+		},
+		{
+			Group:        "Escalation",
+			Path:         "/api/escalation",
+			Method:       http.MethodGet,
+			AuthRequired: true,
+			Desc:         "Staff only. List the chat sessions waiting for a human agent or taken over by one, oldest first",
+			Response:     apidocs.GetDocDefine(escalation.Request{}),
+		},
+		{
+			Group:        "Escalation",
+			Path:         "/api/escalation/ws",
+			Method:       http.MethodGet,
+			AuthRequired: true,
+			Desc:         "Staff only, WebSocket. Receives `requests` with the escalated chat sessions once connected, then `request` every time one is escalated, taken over, handed back or closed (`status` empty). The staff users are also left an internal notification for every escalation",
+		},
+		{
+			Group:        "Escalation",
+			Path:         "/api/escalation/:sessionId/ws",
+			Method:       http.MethodGet,
+			AuthRequired: true,
+			Desc:         "Staff only, WebSocket. Takes the chat session over, the assistant stays silent. Receives `context` with the `history`, the `summary` and the customer `facts`, then a `turn` for every message. Send `{\"type\":\"reply\",\"content\":\"...\"}` to answer the customer and `{\"type\":\"release\"}` or close the socket to hand the session back to the assistant. The voice worker serves the same endpoints for calls under `/escalation`, authenticated by the api key of a staff user, the replies are spoken by the TTS",
+		},
+		{
+			Group:        "Voice",
+			Path:         "/api/voice/ice-servers",
+			Method:       http.MethodGet,
+			AuthRequired: true,
+			Desc:         "The STUN and TURN servers to create the RTCPeerConnection of a voice call with. With a TURN REST secret the credentials are issued to the current user and expire after `ttl` seconds, fetch them again before starting a call",
+			Response:     apidocs.GetDocDefine(ICEServersResponse{}),
+		},
 		{
 			Group:        "System Module",
 			Path:         "/api/system/health",
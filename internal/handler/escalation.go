@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	voiceSculptor "VoiceSculptor"
+	"VoiceSculptor/internal/models"
+	"VoiceSculptor/pkg/logger"
+	"VoiceSculptor/pkg/response"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// the staff WebSockets are only accepted from the same origin
+var escalationUpgrader = websocket.Upgrader{}
+
+// ListEscalations list the chat sessions waiting for or taken over by a human agent
+func (h *Handlers) ListEscalations(c *gin.Context) {
+	response.Success(c, "success", h.escalations.Requests(models.CurrentUser(c)))
+}
+
+// WatchEscalations stream the escalated chat sessions to a staff member over a WebSocket
+func (h *Handlers) WatchEscalations(c *gin.Context) {
+	ws, err := escalationUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	if err := h.escalations.ServeRequests(c.Request.Context(), ws, models.CurrentUser(c)); err != nil {
+		logger.Warn("watch escalations failed", zap.Error(err))
+	}
+}
+
+// JoinEscalation let a staff member take a chat session over from the
+// assistant over a WebSocket, the assistant answers again once released
+func (h *Handlers) JoinEscalation(c *gin.Context) {
+	ws, err := escalationUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	if err := h.escalations.Join(c.Request.Context(), ws, c.Param("sessionId"), models.CurrentUser(c)); err != nil {
+		logger.Warn("join escalation failed", zap.String("sessionId", c.Param("sessionId")), zap.Error(err))
+	}
+}
+
+// staffRequired abort the requests of the users who are neither staff nor superusers
+func staffRequired(c *gin.Context) {
+	user := models.CurrentUser(c)
+	if user == nil || (!user.IsStaff && !user.IsSuperUser) {
+		voiceSculptor.AbortWithJSONError(c, http.StatusForbidden, errors.New("staff only"))
+		return
+	}
+	c.Next()
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	voiceSculptor "VoiceSculptor"
+	"VoiceSculptor/internal/knowledge"
+	"VoiceSculptor/internal/models"
+	"VoiceSculptor/pkg/response"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CreateKnowledgeBaseRequest struct {
+	Name         string `json:"name" binding:"required"`
+	Description  string `json:"description"`
+	GroupID      uint   `json:"groupId"`
+	ChunkSize    int    `json:"chunkSize" comment:"Characters per chunk between 100 and 8000, 0 uses the default 800"`
+	ChunkOverlap *int   `json:"chunkOverlap" comment:"Characters repeated between two chunks, at most half of chunkSize, the default is 100"`
+}
+
+type UpdateKnowledgeBaseRequest struct {
+	Name         *string `json:"name"`
+	Description  *string `json:"description"`
+	ChunkSize    *int    `json:"chunkSize" comment:"Applies to the documents uploaded afterwards"`
+	ChunkOverlap *int    `json:"chunkOverlap" comment:"Applies to the documents uploaded afterwards"`
+}
+
+// KnowledgeSearchResult a chunk found by a search
+type KnowledgeSearchResult struct {
+	DocumentID uint    `json:"documentId"`
+	Document   string  `json:"document"`
+	ChunkID    uint    `json:"chunkId"`
+	Seq        int     `json:"seq"`
+	Score      float64 `json:"score"`
+	Content    string  `json:"content"`
+}
+
+// CreateKnowledgeBase create a knowledge base owned by the current user
+func (h *Handlers) CreateKnowledgeBase(c *gin.Context) {
+	var req CreateKnowledgeBaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	user := models.CurrentUser(c)
+	if req.GroupID != 0 && models.GetGroupRole(h.db, user.ID, req.GroupID) == "" {
+		voiceSculptor.AbortWithJSONError(c, http.StatusForbidden, models.ErrNotGroupMember)
+		return
+	}
+
+	kb := h.knowledge.NewKnowledgeBase(user.ID, req.Name)
+	kb.GroupID = req.GroupID
+	kb.Description = req.Description
+	if req.ChunkSize != 0 {
+		kb.ChunkSize = req.ChunkSize
+	}
+	if req.ChunkOverlap != nil {
+		kb.ChunkOverlap = *req.ChunkOverlap
+	}
+	if err := kb.Validate(); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := h.db.Create(&kb).Error; err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "create knowledge base success", kb)
+}
+
+// ListKnowledgeBases list the knowledge bases visible to the current user
+func (h *Handlers) ListKnowledgeBases(c *gin.Context) {
+	bases, err := models.ListKnowledgeBases(h.db, models.CurrentUser(c))
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "success", bases)
+}
+
+// GetKnowledgeBase get a knowledge base visible to the current user
+func (h *Handlers) GetKnowledgeBase(c *gin.Context) {
+	kb, ok := h.loadKnowledgeBase(c, false)
+	if !ok {
+		return
+	}
+	response.Success(c, "success", kb)
+}
+
+// UpdateKnowledgeBase update a knowledge base, only the owner or group admin can do this
+func (h *Handlers) UpdateKnowledgeBase(c *gin.Context) {
+	var req UpdateKnowledgeBaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	kb, ok := h.loadKnowledgeBase(c, true)
+	if !ok {
+		return
+	}
+	if req.Name != nil {
+		kb.Name = *req.Name
+	}
+	if req.Description != nil {
+		kb.Description = *req.Description
+	}
+	if req.ChunkSize != nil {
+		kb.ChunkSize = *req.ChunkSize
+	}
+	if req.ChunkOverlap != nil {
+		kb.ChunkOverlap = *req.ChunkOverlap
+	}
+	if err := kb.Validate(); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := h.db.Save(kb).Error; err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "update knowledge base success", kb)
+}
+
+// DeleteKnowledgeBase delete a knowledge base with its documents, only the owner or group admin can do this
+func (h *Handlers) DeleteKnowledgeBase(c *gin.Context) {
+	kb, ok := h.loadKnowledgeBase(c, true)
+	if !ok {
+		return
+	}
+	if err := h.knowledge.DeleteKnowledgeBase(kb); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "delete knowledge base success", nil)
+}
+
+// UploadKnowledgeDocument upload a PDF, Markdown or text document, it is
+// chunked and embedded in the background
+func (h *Handlers) UploadKnowledgeDocument(c *gin.Context) {
+	kb, ok := h.loadKnowledgeBase(c, true)
+	if !ok {
+		return
+	}
+	header, err := c.FormFile("file")
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, errors.New("file is required"))
+		return
+	}
+	if header.Size > knowledge.MaxDocumentSize {
+		voiceSculptor.AbortWithJSONError(c, http.StatusRequestEntityTooLarge, fmt.Errorf("the document is larger than %d MB", knowledge.MaxDocumentSize>>20))
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	doc, err := h.knowledge.Upload(kb, header.Filename, data)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	response.Success(c, "upload document success", doc)
+}
+
+// ListKnowledgeDocuments list the documents of a knowledge base with their ingestion status
+func (h *Handlers) ListKnowledgeDocuments(c *gin.Context) {
+	kb, ok := h.loadKnowledgeBase(c, false)
+	if !ok {
+		return
+	}
+	docs, err := models.ListKnowledgeDocuments(h.db, kb.ID)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "success", docs)
+}
+
+// DeleteKnowledgeDocument delete a document with its chunks, only the owner or group admin can do this
+func (h *Handlers) DeleteKnowledgeDocument(c *gin.Context) {
+	kb, ok := h.loadKnowledgeBase(c, true)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("docId"), 10, 64)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, errors.New("invalid document id"))
+		return
+	}
+	doc, err := models.GetKnowledgeDocument(h.db, kb.ID, uint(id))
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, lookupStatus(err, models.ErrKnowledgeDocumentNotFound), err)
+		return
+	}
+	if err := h.knowledge.DeleteDocument(doc); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "delete document success", nil)
+}
+
+// SearchKnowledgeBase return the chunks an assistant would retrieve for the query
+func (h *Handlers) SearchKnowledgeBase(c *gin.Context) {
+	kb, ok := h.loadKnowledgeBase(c, false)
+	if !ok {
+		return
+	}
+	query := c.Query("q")
+	if query == "" {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, errors.New("q is required"))
+		return
+	}
+	topK, _ := strconv.Atoi(c.DefaultQuery("topK", strconv.Itoa(models.KnowledgeDefaultTopK)))
+	if topK < 1 || topK > models.KnowledgeMaxTopK {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, models.ErrKnowledgeInvalidTopK)
+		return
+	}
+	matches, err := h.knowledge.Search(c.Request.Context(), kb, query, topK)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	results := make([]KnowledgeSearchResult, 0, len(matches))
+	for _, m := range matches {
+		results = append(results, KnowledgeSearchResult{
+			DocumentID: m.Chunk.DocumentID,
+			Document:   m.Document,
+			ChunkID:    m.Chunk.ID,
+			Seq:        m.Chunk.Seq,
+			Score:      m.Score,
+			Content:    m.Chunk.Content,
+		})
+	}
+	response.Success(c, "success", results)
+}
+
+// loadKnowledgeBase load the knowledge base of the path id, abort the request
+// if it is not visible, or cannot be modified when modify is set
+func (h *Handlers) loadKnowledgeBase(c *gin.Context, modify bool) (*models.KnowledgeBase, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, errors.New("invalid knowledge base id"))
+		return nil, false
+	}
+	user := models.CurrentUser(c)
+	kb, err := models.GetKnowledgeBase(h.db, user, uint(id))
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, lookupStatus(err, models.ErrKnowledgeBaseNotFound), err)
+		return nil, false
+	}
+	if modify && !models.CanModifyKnowledgeBase(h.db, user, kb) {
+		voiceSculptor.AbortWithJSONError(c, http.StatusForbidden, models.ErrKnowledgeBaseForbidden)
+		return nil, false
+	}
+	return kb, true
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	voiceSculptor "VoiceSculptor"
+	"VoiceSculptor/internal/models"
+	"VoiceSculptor/pkg/prompt"
+	"VoiceSculptor/pkg/response"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RenderPromptRequest struct {
+	Args map[string]any `json:"args"`
+}
+
+// ListPrompts list the prompts with their arguments
+func (h *Handlers) ListPrompts(c *gin.Context) {
+	var prompts []models.PromptModel
+	if err := h.db.Preload("Args").Order("name").Find(&prompts).Error; err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "success", prompts)
+}
+
+// RenderPrompt render the named prompt with the supplied arguments
+func (h *Handlers) RenderPrompt(c *gin.Context) {
+	var req RenderPromptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	text, err := prompt.Render(c.Param("name"), req.Args)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "success", gin.H{"name": c.Param("name"), "prompt": text})
+}
+
+type RollbackPromptRequest struct {
+	Version int `json:"version" binding:"required"`
+}
+
+// ListPromptVersions list the versions of the named prompt, newest first
+func (h *Handlers) ListPromptVersions(c *gin.Context) {
+	m, err := prompt.GetPromptByName(h.db, c.Param("name"))
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	versions, err := prompt.ListVersions(h.db, m.ID)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "success", versions)
+}
+
+// DiffPromptVersions compare two versions of the named prompt, to defaults to the latest
+func (h *Handlers) DiffPromptVersions(c *gin.Context) {
+	m, err := prompt.GetPromptByName(h.db, c.Param("name"))
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	from, err := strconv.Atoi(c.Query("from"))
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, errors.New("from version is required"))
+		return
+	}
+	to := m.Version
+	if toStr := c.Query("to"); toStr != "" {
+		if to, err = strconv.Atoi(toStr); err != nil {
+			voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	fromVersion, err := prompt.GetVersion(h.db, m.ID, from)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	toVersion, err := prompt.GetVersion(h.db, m.ID, to)
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	lines := prompt.Diff(fromVersion.Template, toVersion.Template)
+	argLines := prompt.Diff(fromVersion.ArgsText(), toVersion.ArgsText())
+	response.Success(c, "success", gin.H{
+		"from":     from,
+		"to":       to,
+		"lines":    lines,
+		"diff":     prompt.FormatDiff(lines),
+		"argLines": argLines,
+		"argDiff":  prompt.FormatDiff(argLines),
+	})
+}
+
+// RollbackPrompt restore the template and the arguments of a previous version as a new version
+func (h *Handlers) RollbackPrompt(c *gin.Context) {
+	var req RollbackPromptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	m, err := prompt.GetPromptByName(h.db, c.Param("name"))
+	if err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if err := prompt.Rollback(h.db, m, req.Version); err != nil {
+		voiceSculptor.AbortWithJSONError(c, http.StatusInternalServerError, err)
+		return
+	}
+	response.Success(c, "rollback prompt success", gin.H{"name": m.Name, "version": m.Version})
+}
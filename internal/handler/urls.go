@@ -3,21 +3,38 @@ package handlers
 import (
 	voiceSculptor "VoiceSculptor"
 	"VoiceSculptor/internal/apidocs"
+	"VoiceSculptor/internal/chat"
+	"VoiceSculptor/internal/customer"
+	"VoiceSculptor/internal/escalation"
+	"VoiceSculptor/internal/knowledge"
 	"VoiceSculptor/internal/models"
 	"VoiceSculptor/pkg/config"
 	"VoiceSculptor/pkg/middleware"
 	"VoiceSculptor/pkg/notification"
+	"VoiceSculptor/pkg/prompt"
+	stores "VoiceSculptor/pkg/storage"
+	"VoiceSculptor/pkg/util"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"strconv"
 )
 
 type Handlers struct {
-	db *gorm.DB
+	db          *gorm.DB
+	chat        *chat.Engine
+	knowledge   *knowledge.Service
+	customers   *customer.Service
+	escalations *escalation.Desk
 }
 
 func NewHandlers(db *gorm.DB) *Handlers {
+	engine := chat.NewEngine(chatSessionExpirySeconds)
 	return &Handlers{
-		db: db,
+		db:          db,
+		chat:        engine,
+		knowledge:   knowledge.NewService(db, stores.Default(), config.GlobalConfig.EmbeddingProvider, config.GlobalConfig.Embedding),
+		customers:   customer.NewService(db),
+		escalations: escalation.NewDesk(db, engine, escalation.ChannelChat),
 	}
 }
 
@@ -33,9 +50,15 @@ func (h *Handlers) Register(engine *gin.Engine) {
 	h.registerAuthRoutes(r)
 	h.registerAssistantRoutes(r)
 	h.registerChatRoutes(r)
+	h.registerPromptRoutes(r)
 	h.registerNotificationRoutes(r)
 	h.registerCredentialsRoutes(r)
 	h.registerGroupRoutes(r)
+	h.registerCampaignRoutes(r)
+	h.registerVoiceRoutes(r)
+	h.registerKnowledgeRoutes(r)
+	h.registerCustomerRoutes(r)
+	h.registerEscalationRoutes(r)
 
 	objs := h.GetObjs()
 	voiceSculptor.RegisterObjects(r, objs)
@@ -99,6 +122,14 @@ func (h *Handlers) registerAssistantRoutes(r *gin.RouterGroup) {
 
 		assistant.DELETE("/:id", models.AuthRequired, h.DeleteAssistant)
 
+		assistant.POST("/:id/tools", models.AuthRequired, h.CreateAssistantTool)
+
+		assistant.GET("/:id/tools", models.AuthRequired, h.ListAssistantTools)
+
+		assistant.PUT("/:id/tools/:toolId", models.AuthRequired, h.UpdateAssistantTool)
+
+		assistant.DELETE("/:id/tools/:toolId", models.AuthRequired, h.DeleteAssistantTool)
+
 		assistant.GET("/voiceSculptor/client/:id/loader.js", h.ServeVoiceSculptorLoaderJS)
 	}
 }
@@ -109,6 +140,8 @@ func (h *Handlers) registerChatRoutes(r *gin.RouterGroup) {
 	{
 		chat.POST("start", h.Chat)
 
+		chat.POST("message", h.SendChatMessage)
+
 		chat.POST("stop", h.StopChat)
 
 		chat.GET("stream", h.ChatStream)
@@ -116,6 +149,24 @@ func (h *Handlers) registerChatRoutes(r *gin.RouterGroup) {
 		chat.GET("chat-session-log", h.getChatSessionLog)
 
 		chat.GET("chat-session-log/:id", h.getChatSessionLogDetail)
+
+		chat.GET("chat-session-log/:id/recording", h.getChatSessionLogRecording)
+	}
+}
+
+func (h *Handlers) registerPromptRoutes(r *gin.RouterGroup) {
+	prompts := r.Group("prompt")
+	prompts.Use(models.AuthApiRequired)
+	{
+		prompts.GET("", h.ListPrompts)
+
+		prompts.POST("/:name/render", h.RenderPrompt)
+
+		prompts.GET("/:name/versions", models.WithAdminAuth(), h.ListPromptVersions)
+
+		prompts.GET("/:name/diff", models.WithAdminAuth(), h.DiffPromptVersions)
+
+		prompts.POST("/:name/rollback", models.WithAdminAuth(), h.RollbackPrompt)
 	}
 }
 
@@ -174,6 +225,82 @@ func (h *Handlers) registerGroupRoutes(r *gin.RouterGroup) {
 	}
 }
 
+func (h *Handlers) registerCampaignRoutes(r *gin.RouterGroup) {
+	campaign := r.Group("campaign")
+	campaign.Use(models.AuthRequired)
+	{
+		campaign.POST("", h.CreateCampaign)
+
+		campaign.GET("", h.ListCampaigns)
+
+		campaign.GET("/:id", h.GetCampaign)
+
+		campaign.POST("/:id/contacts", h.UploadCampaignContacts)
+
+		campaign.GET("/:id/contacts", h.ListCampaignContacts)
+
+		campaign.POST("/:id/start", h.StartCampaign)
+
+		campaign.POST("/:id/pause", h.PauseCampaign)
+	}
+}
+
+func (h *Handlers) registerKnowledgeRoutes(r *gin.RouterGroup) {
+	kb := r.Group("knowledge")
+	kb.Use(models.AuthRequired)
+	{
+		kb.POST("", h.CreateKnowledgeBase)
+
+		kb.GET("", h.ListKnowledgeBases)
+
+		kb.GET("/:id", h.GetKnowledgeBase)
+
+		kb.PUT("/:id", h.UpdateKnowledgeBase)
+
+		kb.DELETE("/:id", h.DeleteKnowledgeBase)
+
+		kb.POST("/:id/documents", h.UploadKnowledgeDocument)
+
+		kb.GET("/:id/documents", h.ListKnowledgeDocuments)
+
+		kb.DELETE("/:id/documents/:docId", h.DeleteKnowledgeDocument)
+
+		kb.GET("/:id/search", h.SearchKnowledgeBase)
+	}
+}
+
+func (h *Handlers) registerCustomerRoutes(r *gin.RouterGroup) {
+	customers := r.Group("customer")
+	customers.Use(models.AuthApiRequired)
+	{
+		customers.GET("", h.ListCustomers)
+
+		customers.GET("/:id", h.GetCustomer)
+
+		customers.DELETE("/:id", h.EraseCustomer)
+	}
+}
+
+func (h *Handlers) registerEscalationRoutes(r *gin.RouterGroup) {
+	escalations := r.Group("escalation")
+	escalations.Use(models.AuthApiRequired, staffRequired)
+	{
+		escalations.GET("", h.ListEscalations)
+
+		escalations.GET("/ws", h.WatchEscalations)
+
+		escalations.GET("/:sessionId/ws", h.JoinEscalation)
+	}
+}
+
+func (h *Handlers) registerVoiceRoutes(r *gin.RouterGroup) {
+	voice := r.Group("voice")
+	voice.Use(models.AuthApiRequired)
+	{
+		voice.GET("/ice-servers", h.GetICEServers)
+	}
+}
+
 func (h *Handlers) GetObjs() []voiceSculptor.WebObject {
 	return []voiceSculptor.WebObject{
 		{
@@ -206,28 +333,177 @@ func (h *Handlers) RegisterAdmin(router *gin.RouterGroup) {
 	iconInternalNotification, _ := voiceSculptor.EmbedStaticAssets.ReadFile("static/img/icon_internal_notification.svg")
 	iconPrompt, _ := voiceSculptor.EmbedStaticAssets.ReadFile("static/img/icon_prompt_model.svg")
 	iconPromptArg, _ := voiceSculptor.EmbedStaticAssets.ReadFile("static/img/icon_prompt_args.svg")
+	iconPhoneRoute, _ := voiceSculptor.EmbedStaticAssets.ReadFile("static/img/icon_phone_route.svg")
+	iconCampaign, _ := voiceSculptor.EmbedStaticAssets.ReadFile("static/img/icon_campaign.svg")
+	iconKnowledge, _ := voiceSculptor.EmbedStaticAssets.ReadFile("static/img/icon_knowledge.svg")
+	iconCustomer, _ := voiceSculptor.EmbedStaticAssets.ReadFile("static/img/icon_user.svg")
 	admins := []models.AdminObject{
 		{
 			Model:       &models.Assistant{},
 			Group:       "Business",
 			Name:        "Assistant",
 			Desc:        "This is a definition of AI assistant, including the use of prompts and so on.",
-			Shows:       []string{"ID", "Name", "SystemPrompt", "Instruction", "PersonaTag", "MaxTokens", "Temperature", "JsSourceID", "CreatedAt"},
-			Editables:   []string{"ID", "Name", "SystemPrompt", "Instruction", "PersonaTag", "MaxTokens", "Temperature", "JsSourceID", "CreatedAt"},
+			Shows:       []string{"ID", "Name", "UserID", "GroupID", "SystemPrompt", "Instruction", "PersonaTag", "MaxTokens", "Temperature", "JsSourceID", "CreatedAt"},
+			Editables:   []string{"ID", "Name", "UserID", "GroupID", "SystemPrompt", "Instruction", "PersonaTag", "MaxTokens", "Temperature", "JsSourceID", "VadThreshold", "VadMinSpeechMs", "VadMinSilenceMs", "IvrMenu", "KnowledgeBaseID", "KnowledgeTopK", "MemoryTurns", "ContextTokens", "Escalation", "EscalationKeywords", "CreatedAt"},
 			Orderables:  []string{"UpdatedAt"},
 			Searchables: []string{"Name"},
 			Requireds:   []string{"Name"},
 			Icon:        &models.AdminIcon{SVG: string(iconAssistant)},
+			BeforeCreate: func(db *gorm.DB, c *gin.Context, obj any) error {
+				assistant := obj.(*models.Assistant)
+				if assistant.JsSourceID == "" {
+					jsSourceID, err := util.GenerateSecureToken(12)
+					if err != nil {
+						return err
+					}
+					assistant.JsSourceID = jsSourceID
+				}
+				return assistant.Validate()
+			},
+			BeforeUpdate: func(db *gorm.DB, c *gin.Context, obj any, vals map[string]any) error {
+				return obj.(*models.Assistant).Validate()
+			},
+		},
+		{
+			Model:       &models.AssistantTool{},
+			Group:       "Business",
+			Name:        "AssistantTool",
+			Desc:        "This is a function an assistant can call, the arguments chosen by the model are POSTed to its webhook and the response is handed back to the model.",
+			Shows:       []string{"ID", "AssistantID", "Name", "URL", "TimeoutMs", "Disabled", "UpdatedAt"},
+			Editables:   []string{"ID", "AssistantID", "Name", "Description", "Parameters", "URL", "TimeoutMs", "AuthHeader", "AuthValue", "Disabled"},
+			Orderables:  []string{"UpdatedAt"},
+			Searchables: []string{"AssistantID", "Name"},
+			Requireds:   []string{"AssistantID", "Name", "URL"},
+			Icon:        &models.AdminIcon{SVG: string(iconAssistant)},
+			BeforeCreate: func(db *gorm.DB, c *gin.Context, obj any) error {
+				return obj.(*models.AssistantTool).Validate()
+			},
+			BeforeUpdate: func(db *gorm.DB, c *gin.Context, obj any, vals map[string]any) error {
+				return obj.(*models.AssistantTool).Validate()
+			},
+		},
+		{
+			Model:       &models.KnowledgeBase{},
+			Group:       "Business",
+			Name:        "KnowledgeBase",
+			Desc:        "This is a collection of documents an assistant grounds its replies on, the chunks closest to the user message are added to the system prompt.",
+			Shows:       []string{"ID", "Name", "UserID", "GroupID", "EmbeddingProvider", "EmbeddingModel", "ChunkSize", "UpdatedAt"},
+			Editables:   []string{"ID", "Name", "Description", "UserID", "GroupID", "ChunkSize", "ChunkOverlap"},
+			Orderables:  []string{"UpdatedAt"},
+			Searchables: []string{"Name", "UserID"},
+			Requireds:   []string{"Name", "UserID"},
+			Icon:        &models.AdminIcon{SVG: string(iconKnowledge)},
+			BeforeCreate: func(db *gorm.DB, c *gin.Context, obj any) error {
+				kb := obj.(*models.KnowledgeBase)
+				if kb.EmbeddingProvider == "" {
+					defaults := h.knowledge.NewKnowledgeBase(kb.UserID, kb.Name)
+					kb.EmbeddingProvider, kb.EmbeddingModel, kb.Dimensions = defaults.EmbeddingProvider, defaults.EmbeddingModel, defaults.Dimensions
+				}
+				return kb.Validate()
+			},
+			BeforeUpdate: func(db *gorm.DB, c *gin.Context, obj any, vals map[string]any) error {
+				return obj.(*models.KnowledgeBase).Validate()
+			},
+		},
+		{
+			Model:       &models.KnowledgeDocument{},
+			Group:       "Business",
+			Name:        "KnowledgeDocument",
+			Desc:        "This is a document uploaded to a knowledge base, with the status of its chunking and embedding.",
+			Shows:       []string{"ID", "KnowledgeBaseID", "Name", "Type", "Size", "Status", "ChunkCount", "UpdatedAt"},
+			Editables:   []string{"ID", "Name"},
+			Filterables: []string{"Status"},
+			Orderables:  []string{"UpdatedAt"},
+			Searchables: []string{"KnowledgeBaseID", "Name"},
+			Icon:        &models.AdminIcon{SVG: string(iconKnowledge)},
+		},
+		{
+			Model:       &models.Customer{},
+			Group:       "Business",
+			Name:        "Customer",
+			Desc:        "This is a customer remembered across its conversations by its phone number, email or user ID.",
+			Shows:       []string{"ID", "UserID", "ExternalID", "SessionCount", "LastSeenAt"},
+			Editables:   []string{"ID", "Facts"},
+			Orderables:  []string{"LastSeenAt"},
+			Searchables: []string{"UserID", "ExternalID"},
+			Icon:        &models.AdminIcon{SVG: string(iconCustomer)},
+		},
+		{
+			Model:       &models.CustomerSession{},
+			Group:       "Business",
+			Name:        "CustomerSession",
+			Desc:        "This is the summary of a conversation of a customer, recalled by its next conversations.",
+			Shows:       []string{"ID", "CustomerID", "SessionID", "AssistantID", "Summary", "CreatedAt"},
+			Editables:   []string{"ID", "Summary"},
+			Orderables:  []string{"CreatedAt"},
+			Searchables: []string{"CustomerID", "SessionID"},
+			Icon:        &models.AdminIcon{SVG: string(iconCustomer)},
+		},
+		{
+			Model:       &models.PhoneRoute{},
+			Group:       "Business",
+			Name:        "PhoneRoute",
+			Desc:        "This is a route of an inbound phone number (DID) to an assistant, with business hours, a fallback number and caller overrides.",
+			Shows:       []string{"ID", "Number", "Name", "AssistantID", "BusinessHours", "Timezone", "Fallback", "Disabled", "UpdatedAt"},
+			Editables:   []string{"ID", "Number", "Name", "UserID", "AssistantID", "Greeting", "BusinessHours", "Timezone", "Fallback", "CallerOverrides", "Disabled"},
+			Orderables:  []string{"UpdatedAt"},
+			Searchables: []string{"Number", "Name", "AssistantID"},
+			Requireds:   []string{"Number", "AssistantID"},
+			Icon:        &models.AdminIcon{SVG: string(iconPhoneRoute)},
+			BeforeCreate: func(db *gorm.DB, c *gin.Context, obj any) error {
+				route := obj.(*models.PhoneRoute)
+				if route.UserID == 0 {
+					route.UserID = models.CurrentUser(c).ID
+				}
+				return route.Validate()
+			},
+			BeforeUpdate: func(db *gorm.DB, c *gin.Context, obj any, vals map[string]any) error {
+				return obj.(*models.PhoneRoute).Validate()
+			},
+		},
+		{
+			Model:       &models.Campaign{},
+			Group:       "Business",
+			Name:        "Campaign",
+			Desc:        "This is an outbound calling campaign, an assistant calls the uploaded contacts within the calling hours and retries no answer or busy.",
+			Shows:       []string{"ID", "Name", "AssistantID", "CredentialID", "Status", "CallingHours", "Timezone", "MaxAttempts", "StartedAt", "CompletedAt"},
+			Editables:   []string{"ID", "Name", "UserID", "AssistantID", "CredentialID", "CallerNumber", "Greeting", "CallingHours", "Timezone", "MaxAttempts", "RetryDelaySeconds", "Status"},
+			Orderables:  []string{"CreatedAt"},
+			Searchables: []string{"Name", "Status", "AssistantID"},
+			Requireds:   []string{"Name", "AssistantID", "CredentialID"},
+			Icon:        &models.AdminIcon{SVG: string(iconCampaign)},
+			BeforeCreate: func(db *gorm.DB, c *gin.Context, obj any) error {
+				campaign := obj.(*models.Campaign)
+				if campaign.UserID == 0 {
+					campaign.UserID = models.CurrentUser(c).ID
+				}
+				return campaign.Validate()
+			},
+			BeforeUpdate: func(db *gorm.DB, c *gin.Context, obj any, vals map[string]any) error {
+				return obj.(*models.Campaign).Validate()
+			},
+		},
+		{
+			Model:       &models.CampaignContact{},
+			Group:       "Business",
+			Name:        "CampaignContact",
+			Desc:        "This is a contact of a campaign with the outcome of its calls.",
+			Shows:       []string{"ID", "CampaignID", "Number", "Name", "Status", "Outcome", "Attempts", "NextAttemptAt", "LastAttemptAt", "SessionID", "Error"},
+			Editables:   []string{"ID", "CampaignID", "Number", "Name", "Timezone", "Variables", "Status", "NextAttemptAt"},
+			Orderables:  []string{"LastAttemptAt", "NextAttemptAt"},
+			Searchables: []string{"CampaignID", "Number", "Status", "Outcome"},
+			Requireds:   []string{"CampaignID", "Number"},
+			Icon:        &models.AdminIcon{SVG: string(iconCampaign)},
 		},
 		{
 			Model:       &models.ChatSessionLog{},
 			Group:       "Business",
 			Name:        "ChatSessionLog",
 			Desc:        "This is a conversation log, which records the AI conversation log.",
-			Shows:       []string{"ID", "SessionID", "Content", "CreatedAt", "UserID"},
+			Shows:       []string{"ID", "SessionID", "Content", "TurnCount", "TotalTokens", "AssistantID", "RecordingURL", "CreatedAt", "EndedAt", "UserID"},
 			Editables:   []string{"ID", "SessionID", "Content", "CreatedAt", "UserID"},
 			Orderables:  []string{"CreatedAt"},
-			Searchables: []string{"UserID"},
+			Searchables: []string{"UserID", "SessionID", "AssistantID"},
 			Requireds:   []string{"UserID"},
 			Icon:        &models.AdminIcon{SVG: string(iconChatLog)},
 		},
@@ -236,8 +512,8 @@ func (h *Handlers) RegisterAdmin(router *gin.RouterGroup) {
 			Group:       "Business",
 			Name:        "UserCredential",
 			Desc:        "This is a user credential used to define which user resources.",
-			Shows:       []string{"ID", "Name", "LLMProvider", "LLMApiKey", "LLMApiURL", "Quota", "Used"},
-			Editables:   []string{"ID", "Name", "LLMProvider", "LLMApiKey", "LLMApiURL", "Quota", "Used"},
+			Shows:       []string{"ID", "Name", "LLMProvider", "LLMApiKey", "LLMApiURL", "LLMModel", "Quota", "Used", "CallConcurrency"},
+			Editables:   []string{"ID", "Name", "LLMProvider", "LLMApiKey", "LLMApiURL", "LLMModel", "Quota", "Used", "CallConcurrency"},
 			Orderables:  []string{"UpdatedAt"},
 			Searchables: []string{"LLMProvider"},
 			Requireds:   []string{"LLMProvider"},
@@ -259,19 +535,69 @@ func (h *Handlers) RegisterAdmin(router *gin.RouterGroup) {
 			Group:       "Business",
 			Name:        "PromptModel",
 			Desc:        "This is a PromptModel, can quick build prompt",
-			Shows:       []string{"ID", "Name", "Description", "CreatedAt", "UpdatedAt"},
-			Editables:   []string{"ID", "Name", "Description", "CreatedAt", "UpdatedAt"},
+			Shows:       []string{"ID", "Name", "Description", "Version", "CreatedAt", "UpdatedAt"},
+			Editables:   []string{"ID", "Name", "Description", "Template", "CreatedAt", "UpdatedAt"},
 			Orderables:  []string{"CreatedAt"},
 			Searchables: []string{"Name"},
+			Requireds:   []string{"Name", "Template"},
 			Icon:        &models.AdminIcon{SVG: string(iconPrompt)},
+			BeforeCreate: func(db *gorm.DB, c *gin.Context, obj any) error {
+				p := obj.(*models.PromptModel)
+				_, err := prompt.ParseTemplate(p.Name, p.Template)
+				return err
+			},
+			BeforeUpdate: func(db *gorm.DB, c *gin.Context, obj any, vals map[string]any) error {
+				p := obj.(*models.PromptModel)
+				_, err := prompt.ParseTemplate(p.Name, p.Template)
+				return err
+			},
+			Actions: []models.AdminAction{
+				{
+					Path:  "rollback",
+					Name:  "Rollback",
+					Label: "Restore the template and the arguments of a previous version, the previous one if no version is given",
+					Handler: func(db *gorm.DB, c *gin.Context, obj any) (bool, any, error) {
+						p := obj.(*models.PromptModel)
+						version := p.Version - 1
+						if v := c.Query("version"); v != "" {
+							var err error
+							if version, err = strconv.Atoi(v); err != nil {
+								return false, nil, err
+							}
+						}
+						err := prompt.Rollback(db, p, version)
+						return false, p.Version, err
+					},
+				},
+			},
+		},
+		{
+			Model:       &models.PromptVersionModel{},
+			Group:       "Business",
+			Name:        "PromptVersionModel",
+			Desc:        "This is an immutable version of a PromptModel template",
+			Shows:       []string{"ID", "PromptID", "Version", "Description", "CreatedAt"},
+			Editables:   []string{},
+			Orderables:  []string{"CreatedAt", "Version"},
+			Searchables: []string{"PromptID"},
+			Icon:        &models.AdminIcon{SVG: string(iconPrompt)},
+			BeforeCreate: func(db *gorm.DB, c *gin.Context, obj any) error {
+				return prompt.ErrPromptVersionImmutable
+			},
+			BeforeUpdate: func(db *gorm.DB, c *gin.Context, obj any, vals map[string]any) error {
+				return prompt.ErrPromptVersionImmutable
+			},
+			BeforeDelete: func(db *gorm.DB, c *gin.Context, obj any) error {
+				return prompt.ErrPromptVersionImmutable
+			},
 		},
 		{
 			Model:       &models.PromptArgModel{},
 			Group:       "Business",
 			Name:        "PromptArgModel",
 			Desc:        "This is a PromptModel Args to fill model",
-			Shows:       []string{"ID", "Name", "Description", "Required", "PromptID"},
-			Editables:   []string{"ID", "Name", "Description", "Required", "PromptID"},
+			Shows:       []string{"ID", "Name", "Description", "Required", "Default", "PromptID"},
+			Editables:   []string{"ID", "Name", "Description", "Required", "Default", "PromptID"},
 			Orderables:  []string{"ID"},
 			Searchables: []string{"Name"},
 			Icon:        &models.AdminIcon{SVG: string(iconPromptArg)},
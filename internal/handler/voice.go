@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"VoiceSculptor/internal/models"
+	"VoiceSculptor/pkg/config"
+	"VoiceSculptor/pkg/ice"
+	"VoiceSculptor/pkg/response"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ICEServersResponse the ICE servers of the voice calls, as the iceServers of RTCPeerConnection
+type ICEServersResponse struct {
+	ICEServers []ice.Server `json:"iceServers"`
+	// TTL how many seconds the TURN credentials are valid, 0 for static credentials
+	TTL int64 `json:"ttl"`
+}
+
+// GetICEServers returns the ICE servers a client connects the voice worker with,
+// the time-limited TURN credentials are issued to the current user
+func (h *Handlers) GetICEServers(c *gin.Context) {
+	user := models.CurrentUser(c)
+	iceConfig := config.GlobalConfig.ICE
+	servers := iceConfig.Servers(strconv.FormatUint(uint64(user.ID), 10), time.Now())
+	if servers == nil {
+		servers = []ice.Server{}
+	}
+	response.Success(c, "success", ICEServersResponse{ICEServers: servers, TTL: int64(iceConfig.TTL() / time.Second)})
+}
@@ -0,0 +1,265 @@
+// Package knowledge ingests the documents of the knowledge bases and
+// retrieves the passages grounding the replies of the assistants.
+package knowledge
+
+import (
+	"VoiceSculptor/internal/chat"
+	"VoiceSculptor/internal/models"
+	"VoiceSculptor/pkg/document"
+	"VoiceSculptor/pkg/embedding"
+	"VoiceSculptor/pkg/logger"
+	stores "VoiceSculptor/pkg/storage"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxDocumentSize the largest document uploaded
+	MaxDocumentSize = 20 << 20
+	// embedBatch the chunks embedded by a request
+	embedBatch = 64
+	// ingestTimeout how long the ingestion of a document may take
+	ingestTimeout = 10 * time.Minute
+)
+
+// Service the knowledge bases stored in the database, their documents in the store
+type Service struct {
+	db        *gorm.DB
+	store     stores.Store
+	provider  string
+	embedding embedding.Config
+	wg        sync.WaitGroup
+}
+
+// NewService creates a service, the new knowledge bases are embedded by the
+// provider. The connection settings of cfg are used for all the knowledge
+// bases, the model and the dimensions are those of each knowledge base.
+func NewService(db *gorm.DB, store stores.Store, provider string, cfg embedding.Config) *Service {
+	if provider == "" {
+		provider = embedding.ProviderHash
+	}
+	return &Service{db: db, store: store, provider: provider, embedding: cfg}
+}
+
+// NewKnowledgeBase returns a knowledge base embedded by the provider of the
+// service with the default chunking
+func (s *Service) NewKnowledgeBase(userID uint, name string) models.KnowledgeBase {
+	return models.KnowledgeBase{
+		UserID:            userID,
+		Name:              name,
+		EmbeddingProvider: s.provider,
+		EmbeddingModel:    s.embedding.Model,
+		Dimensions:        s.embedding.Dimensions,
+		ChunkSize:         document.DefaultChunkSize,
+		ChunkOverlap:      document.DefaultChunkOverlap,
+	}
+}
+
+// Embedder returns the embedding provider of the knowledge base
+func (s *Service) Embedder(kb *models.KnowledgeBase) (embedding.Provider, error) {
+	cfg := s.embedding
+	cfg.Model, cfg.Dimensions = kb.EmbeddingModel, kb.Dimensions
+	return embedding.New(kb.EmbeddingProvider, cfg)
+}
+
+// Upload stores the document and ingests it in the background, the document
+// is returned processing
+func (s *Service) Upload(kb *models.KnowledgeBase, name string, data []byte) (*models.KnowledgeDocument, error) {
+	typ, err := document.TypeOf(name)
+	if err != nil {
+		return nil, err
+	}
+	doc := &models.KnowledgeDocument{
+		KnowledgeBaseID: kb.ID,
+		Name:            filepath.Base(name),
+		Type:            typ,
+		Size:            int64(len(data)),
+		Status:          models.KnowledgeDocumentProcessing,
+	}
+	if err := s.db.Create(doc).Error; err != nil {
+		return nil, err
+	}
+	doc.StorageKey = fmt.Sprintf("knowledge/%d/%d/%s", kb.ID, doc.ID, doc.Name)
+	if err := s.store.Write(doc.StorageKey, bytes.NewReader(data)); err != nil {
+		_ = models.DeleteKnowledgeDocument(s.db, doc.ID)
+		return nil, err
+	}
+	if err := s.db.Model(doc).Update("storage_key", doc.StorageKey).Error; err != nil {
+		return nil, err
+	}
+
+	// the document returned is not updated by the ingestion
+	base, ingested := *kb, *doc
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), ingestTimeout)
+		defer cancel()
+		if err := s.Ingest(ctx, &base, &ingested); err != nil {
+			logger.Warn("ingest document failed", zap.Uint("documentId", doc.ID), zap.String("name", doc.Name), zap.Error(err))
+		}
+	}()
+	return doc, nil
+}
+
+// Wait waits for the documents being ingested
+func (s *Service) Wait() {
+	s.wg.Wait()
+}
+
+// Ingest extracts the text of the stored document, chunks and embeds it,
+// the document is marked failed on error
+func (s *Service) Ingest(ctx context.Context, kb *models.KnowledgeBase, doc *models.KnowledgeDocument) error {
+	chunks, err := s.chunks(ctx, kb, doc)
+	if err != nil {
+		if failErr := models.FailKnowledgeDocument(s.db, doc, err); failErr != nil {
+			return failErr
+		}
+		return err
+	}
+	return models.SaveKnowledgeChunks(s.db, doc, chunks)
+}
+
+func (s *Service) chunks(ctx context.Context, kb *models.KnowledgeBase, doc *models.KnowledgeDocument) ([]models.KnowledgeChunk, error) {
+	r, _, err := s.store.Read(doc.StorageKey)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(r, MaxDocumentSize+1))
+	r.Close()
+	if err != nil {
+		return nil, err
+	}
+	text, err := document.Extract(doc.Type, data)
+	if err != nil {
+		return nil, err
+	}
+	embedder, err := s.Embedder(kb)
+	if err != nil {
+		return nil, err
+	}
+
+	contents := document.Chunk(text, kb.ChunkSize, kb.ChunkOverlap)
+	chunks := make([]models.KnowledgeChunk, 0, len(contents))
+	for start := 0; start < len(contents); start += embedBatch {
+		batch := contents[start:min(start+embedBatch, len(contents))]
+		vectors, err := embedder.Embed(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		for i, content := range batch {
+			chunks = append(chunks, models.KnowledgeChunk{
+				KnowledgeBaseID: kb.ID,
+				DocumentID:      doc.ID,
+				Seq:             start + i + 1,
+				Content:         content,
+				Embedding:       models.EncodeVector(vectors[i]),
+			})
+		}
+	}
+	return chunks, nil
+}
+
+// Search returns the topK chunks of the knowledge base closest to the query
+func (s *Service) Search(ctx context.Context, kb *models.KnowledgeBase, query string, topK int) ([]models.KnowledgeMatch, error) {
+	if topK <= 0 {
+		topK = models.KnowledgeDefaultTopK
+	}
+	embedder, err := s.Embedder(kb)
+	if err != nil {
+		return nil, err
+	}
+	vectors, err := embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	return models.SearchKnowledgeChunks(s.db, kb.ID, vectors[0], topK)
+}
+
+// DeleteDocument deletes the document, its chunks and its file
+func (s *Service) DeleteDocument(doc *models.KnowledgeDocument) error {
+	if err := models.DeleteKnowledgeDocument(s.db, doc.ID); err != nil {
+		return err
+	}
+	s.deleteFile(doc)
+	return nil
+}
+
+// DeleteKnowledgeBase deletes the knowledge base, its documents and their files
+func (s *Service) DeleteKnowledgeBase(kb *models.KnowledgeBase) error {
+	docs, err := models.ListKnowledgeDocuments(s.db, kb.ID)
+	if err != nil {
+		return err
+	}
+	if err := models.DeleteKnowledgeBase(s.db, kb.ID); err != nil {
+		return err
+	}
+	for i := range docs {
+		s.deleteFile(&docs[i])
+	}
+	return nil
+}
+
+func (s *Service) deleteFile(doc *models.KnowledgeDocument) {
+	if doc.StorageKey == "" {
+		return
+	}
+	if err := s.store.Delete(doc.StorageKey); err != nil {
+		logger.Warn("delete document file failed", zap.String("key", doc.StorageKey), zap.Error(err))
+	}
+}
+
+// Knowledge returns the knowledge grounding the replies of the assistant,
+// nil without knowledge base
+func (s *Service) Knowledge(assistant *models.Assistant) chat.Knowledge {
+	if assistant.KnowledgeBaseID == 0 {
+		return nil
+	}
+	return &retriever{service: s, kbID: assistant.KnowledgeBaseID, topK: assistant.KnowledgeTopK}
+}
+
+type retriever struct {
+	service *Service
+	kbID    uint
+	topK    int
+}
+
+// Retrieve implements chat.Knowledge.
+func (r *retriever) Retrieve(ctx context.Context, query string) ([]chat.Passage, error) {
+	matches, err := r.search(ctx, query)
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Warn("retrieve knowledge failed", zap.Uint("knowledgeBaseId", r.kbID), zap.Error(err))
+		}
+		return nil, err
+	}
+	passages := make([]chat.Passage, 0, len(matches))
+	for i, m := range matches {
+		passages = append(passages, chat.Passage{
+			Index:      i + 1,
+			DocumentID: m.Chunk.DocumentID,
+			Document:   m.Document,
+			ChunkID:    m.Chunk.ID,
+			Seq:        m.Chunk.Seq,
+			Score:      m.Score,
+			Content:    m.Chunk.Content,
+		})
+	}
+	return passages, nil
+}
+
+func (r *retriever) search(ctx context.Context, query string) ([]models.KnowledgeMatch, error) {
+	var kb models.KnowledgeBase
+	if err := r.service.db.Take(&kb, r.kbID).Error; err != nil {
+		return nil, err
+	}
+	return r.service.Search(ctx, &kb, query, r.topK)
+}
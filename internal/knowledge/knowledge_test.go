@@ -0,0 +1,102 @@
+package knowledge
+
+import (
+	"VoiceSculptor/internal/models"
+	"VoiceSculptor/pkg/document"
+	"VoiceSculptor/pkg/embedding"
+	"VoiceSculptor/pkg/logger"
+	stores "VoiceSculptor/pkg/storage"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const manual = `# Router manual
+
+To restart the router, unplug the power cable and plug it back after ten seconds.
+
+To reset the router to its factory settings, hold the reset button on the back for thirty seconds.
+
+The warranty covers repairs for two years after the purchase, keep the receipt.`
+
+func TestMain(m *testing.M) {
+	dir, _ := os.MkdirTemp("", "knowledge")
+	_ = logger.Init(&logger.LogConfig{Level: "error", Filename: filepath.Join(dir, "knowledge.log")}, "test")
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func setupService(t *testing.T) (*Service, *models.KnowledgeBase, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "knowledge.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.KnowledgeBase{}, &models.KnowledgeDocument{}, &models.KnowledgeChunk{}))
+	store := &stores.LocalStore{Root: t.TempDir(), NewDirPerm: 0755}
+	service := NewService(db, store, embedding.ProviderHash, embedding.Config{})
+
+	kb := service.NewKnowledgeBase(1, "manuals")
+	kb.ChunkSize, kb.ChunkOverlap = 120, 0
+	require.NoError(t, kb.Validate())
+	require.NoError(t, db.Create(&kb).Error)
+	return service, &kb, db
+}
+
+func TestService_UploadAndSearch(t *testing.T) {
+	service, kb, db := setupService(t)
+
+	doc, err := service.Upload(kb, "router.md", []byte(manual))
+	require.NoError(t, err)
+	assert.Equal(t, models.KnowledgeDocumentProcessing, doc.Status)
+	service.Wait()
+
+	doc, err = models.GetKnowledgeDocument(db, kb.ID, doc.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.KnowledgeDocumentReady, doc.Status)
+	assert.Equal(t, 3, doc.ChunkCount)
+
+	matches, err := service.Search(context.Background(), kb, "how do I reset my router to factory settings", 2)
+	require.NoError(t, err)
+	require.Len(t, matches, 2)
+	assert.Contains(t, matches[0].Chunk.Content, "hold the reset button")
+	assert.Equal(t, "router.md", matches[0].Document)
+	assert.Greater(t, matches[0].Score, matches[1].Score)
+
+	knowledge := service.Knowledge(&models.Assistant{KnowledgeBaseID: kb.ID, KnowledgeTopK: 1})
+	passages, err := knowledge.Retrieve(context.Background(), "is the warranty two years")
+	require.NoError(t, err)
+	require.Len(t, passages, 1)
+	assert.Equal(t, 1, passages[0].Index)
+	assert.Equal(t, doc.ID, passages[0].DocumentID)
+	assert.Contains(t, passages[0].Content, "warranty")
+	assert.Nil(t, service.Knowledge(&models.Assistant{}))
+
+	require.NoError(t, service.DeleteDocument(doc))
+	matches, err = service.Search(context.Background(), kb, "reset", 2)
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+	exists, err := service.store.Exists(doc.StorageKey)
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestService_IngestFails(t *testing.T) {
+	service, kb, db := setupService(t)
+
+	_, err := service.Upload(kb, "slides.pptx", []byte("x"))
+	assert.ErrorIs(t, err, document.ErrUnsupportedType)
+
+	doc, err := service.Upload(kb, "empty.txt", []byte(strings.Repeat(" \n", 10)))
+	require.NoError(t, err)
+	service.Wait()
+	doc, err = models.GetKnowledgeDocument(db, kb.ID, doc.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.KnowledgeDocumentFailed, doc.Status)
+	assert.Equal(t, document.ErrNoText.Error(), doc.Error)
+}
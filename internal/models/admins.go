@@ -172,7 +172,7 @@ func GetHibiscusAdminObjects() []AdminObject {
 			Filterables: []string{"CreatedAt", "UpdatedAt", "Username", "IsStaff", "IsSuperUser", "Enabled", "Activated "},
 			Orderables:  []string{"CreatedAt", "UpdatedAt", "Enabled", "Activated"},
 			Searchables: []string{"Username", "Email", "FirstName", "ListName"},
-			Orders:      []voiceSculptor.Order{{"UpdatedAt", voiceSculptor.OrderOpDesc}},
+			Orders:      []voiceSculptor.Order{{Name: "UpdatedAt", Op: voiceSculptor.OrderOpDesc}},
 			Icon:        &AdminIcon{SVG: string(iconUser)},
 			AccessCheck: superAccessCheck,
 			BeforeCreate: func(db *gorm.DB, c *gin.Context, obj any) error {
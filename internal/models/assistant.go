@@ -0,0 +1,263 @@
+package models
+
+import (
+	"VoiceSculptor/pkg/ivr"
+	"VoiceSculptor/pkg/prompt"
+	"VoiceSculptor/pkg/util"
+	"VoiceSculptor/pkg/vad"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	AssistantMinTemperature = 0.0
+	AssistantMaxTemperature = 2.0
+	AssistantMinMaxTokens   = 1
+	AssistantMaxMaxTokens   = 32768
+
+	AssistantDefaultTemperature = 0.7
+	AssistantDefaultMaxTokens   = 512
+
+	AssistantMinVadThreshold = -90.0
+	AssistantMaxVadMs        = 10000
+
+	AssistantMaxMemoryTurns   = 200
+	AssistantMaxContextTokens = 1 << 20
+
+	AssistantMaxEscalationKeywords = 512
+)
+
+var ErrAssistantNotFound = &util.Error{Code: http.StatusNotFound, Message: "assistant not found"}
+var ErrAssistantForbidden = &util.Error{Code: http.StatusForbidden, Message: "no permission to modify this assistant"}
+var ErrAssistantInvalidTemperature = &util.Error{Code: http.StatusBadRequest, Message: "temperature must be between 0 and 2"}
+var ErrAssistantInvalidMaxTokens = &util.Error{Code: http.StatusBadRequest, Message: "maxTokens must be between 1 and 32768"}
+var ErrAssistantInvalidPromptVersion = &util.Error{Code: http.StatusBadRequest, Message: "promptVersion must not be negative"}
+var ErrAssistantInvalidPromptArgs = &util.Error{Code: http.StatusBadRequest, Message: "promptArgs must be a JSON object"}
+var ErrAssistantInvalidVAD = &util.Error{Code: http.StatusBadRequest, Message: "vadThreshold must be between -90 and 0 dBFS, vadMinSpeechMs and vadMinSilenceMs between 0 and 10000"}
+var ErrAssistantInvalidMemory = &util.Error{Code: http.StatusBadRequest, Message: "memoryTurns must be between 0 and 200, contextTokens 0 or larger than maxTokens and at most 1048576"}
+var ErrAssistantInvalidEscalationKeywords = &util.Error{Code: http.StatusBadRequest, Message: "escalationKeywords must be at most 512 bytes"}
+var ErrNotGroupMember = &util.Error{Code: http.StatusForbidden, Message: "not a member of the group"}
+
+// Assistant AI 助手定义, 归属于创建者, 也可以共享给某个用户组
+type Assistant struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt    time.Time `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt    time.Time `json:"updatedAt" gorm:"autoUpdateTime"`
+	UserID       uint      `json:"userId" gorm:"index"`                   // 创建者
+	GroupID      uint      `json:"groupId,omitempty" gorm:"index"`        // 所属用户组, 0 表示私有
+	Name         string    `json:"name" gorm:"size:128"`                  // 助手名称
+	Description  string    `json:"description,omitempty"`                 // 描述
+	SystemPrompt string    `json:"systemPrompt"`                          // 系统提示词
+	Instruction  string    `json:"instruction"`                           // 开场指令
+	PersonaTag   string    `json:"personaTag" gorm:"size:64"`             // 人设标签
+	MaxTokens    int       `json:"maxTokens"`                             // 最大生成 token 数
+	Temperature  float32   `json:"temperature"`                           // 采样温度
+	JsSourceID   string    `json:"jsSourceId" gorm:"size:64;uniqueIndex"` // 前端嵌入脚本标识
+
+	// 使用提示词模板生成系统提示词, 设置后 SystemPrompt 不再使用
+	PromptID      uint   `json:"promptId,omitempty" gorm:"index"`
+	PromptVersion int    `json:"promptVersion,omitempty"`               // 固定的模板版本, 0 表示跟随最新版本
+	PromptArgs    string `json:"promptArgs,omitempty" gorm:"type:text"` // 模板参数, JSON 对象
+
+	// 语音通话的 VAD 阈值, 0 表示使用默认值
+	VadThreshold    float64 `json:"vadThreshold,omitempty"`    // 判定为说话的音量, dBFS
+	VadMinSpeechMs  int     `json:"vadMinSpeechMs,omitempty"`  // 持续说话多久才打断助手
+	VadMinSilenceMs int     `json:"vadMinSilenceMs,omitempty"` // 静音多久判定一句话结束
+
+	// 电话按键菜单, JSON, 见 ivr.Menu. 设置后通话先播放菜单, 按键后再交给助手、转接或挂断
+	IvrMenu string `json:"ivrMenu,omitempty" gorm:"type:text"`
+
+	// 检索知识库回答, 每次回复前按用户消息检索 KnowledgeTopK 个切块加入系统提示词
+	KnowledgeBaseID uint `json:"knowledgeBaseId,omitempty" gorm:"index"`
+	KnowledgeTopK   int  `json:"knowledgeTopK,omitempty"` // 0 表示默认 3 个
+
+	// 会话记忆, 最近 MemoryTurns 条消息原样发送, 更早的由模型合并成摘要,
+	// 提示词按估算的 token 数裁剪, 在 ContextTokens 中为回复留出 MaxTokens
+	MemoryTurns   int `json:"memoryTurns,omitempty"`   // 0 表示默认 12 条
+	ContextTokens int `json:"contextTokens,omitempty"` // 模型的上下文窗口, 0 表示默认 8192
+
+	// 转人工, 会话请求人工客服后通知工作人员, 工作人员接管期间助手不再回复
+	Escalation         bool   `json:"escalation,omitempty"`                         // 助手可以调用 transfer_to_human 转人工
+	EscalationKeywords string `json:"escalationKeywords,omitempty" gorm:"size:512"` // 用户消息包含任一关键词时转人工, 逗号分隔
+
+	Tools []AssistantTool `json:"tools,omitempty" gorm:"foreignKey:AssistantID;constraint:OnDelete:CASCADE"` // 可调用的函数
+}
+
+// Validate check the generation parameters of the assistant
+func (a *Assistant) Validate() error {
+	if a.Name == "" {
+		return &util.Error{Code: http.StatusBadRequest, Message: "name is required"}
+	}
+	if a.Temperature < AssistantMinTemperature || a.Temperature > AssistantMaxTemperature {
+		return ErrAssistantInvalidTemperature
+	}
+	if a.MaxTokens < AssistantMinMaxTokens || a.MaxTokens > AssistantMaxMaxTokens {
+		return ErrAssistantInvalidMaxTokens
+	}
+	if a.PromptVersion < 0 {
+		return ErrAssistantInvalidPromptVersion
+	}
+	if _, err := a.GetPromptArgs(); err != nil {
+		return ErrAssistantInvalidPromptArgs
+	}
+	if a.VadThreshold < AssistantMinVadThreshold || a.VadThreshold > 0 ||
+		a.VadMinSpeechMs < 0 || a.VadMinSpeechMs > AssistantMaxVadMs ||
+		a.VadMinSilenceMs < 0 || a.VadMinSilenceMs > AssistantMaxVadMs {
+		return ErrAssistantInvalidVAD
+	}
+	if a.MemoryTurns < 0 || a.MemoryTurns > AssistantMaxMemoryTurns ||
+		a.ContextTokens < 0 || a.ContextTokens > AssistantMaxContextTokens ||
+		(a.ContextTokens > 0 && a.ContextTokens <= a.MaxTokens) {
+		return ErrAssistantInvalidMemory
+	}
+	if len(a.EscalationKeywords) > AssistantMaxEscalationKeywords {
+		return ErrAssistantInvalidEscalationKeywords
+	}
+	if a.KnowledgeTopK < 0 || a.KnowledgeTopK > KnowledgeMaxTopK {
+		return ErrKnowledgeInvalidTopK
+	}
+	if _, err := a.Menu(); err != nil {
+		return &util.Error{Code: http.StatusBadRequest, Message: "ivrMenu: " + err.Error()}
+	}
+	return nil
+}
+
+// Menu returns the key menu of the calls, nil without one
+func (a *Assistant) Menu() (*ivr.Menu, error) {
+	return ivr.Parse(a.IvrMenu)
+}
+
+// EscalationKeywordList returns the keywords escalating the conversations to a human agent
+func (a *Assistant) EscalationKeywordList() []string {
+	var keywords []string
+	for _, keyword := range strings.FieldsFunc(a.EscalationKeywords, func(r rune) bool { return r == ',' || r == '，' }) {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			keywords = append(keywords, keyword)
+		}
+	}
+	return keywords
+}
+
+// VADConfig returns the voice activity detection thresholds of the assistant
+func (a *Assistant) VADConfig() vad.Config {
+	return vad.Config{
+		Threshold:  a.VadThreshold,
+		MinSpeech:  time.Duration(a.VadMinSpeechMs) * time.Millisecond,
+		MinSilence: time.Duration(a.VadMinSilenceMs) * time.Millisecond,
+	}
+}
+
+// GetPromptArgs decodes the arguments of the prompt template
+func (a *Assistant) GetPromptArgs() (map[string]any, error) {
+	args := map[string]any{}
+	if a.PromptArgs == "" {
+		return args, nil
+	}
+	err := json.Unmarshal([]byte(a.PromptArgs), &args)
+	return args, err
+}
+
+// RenderSystemPrompt returns the system prompt, rendered from the pinned
+// version of the prompt template if the assistant uses one
+func (a *Assistant) RenderSystemPrompt(db *gorm.DB) (string, error) {
+	if a.PromptID == 0 {
+		return a.SystemPrompt, nil
+	}
+	var p PromptModel
+	if err := db.Select("id", "name").Take(&p, a.PromptID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", prompt.ErrPromptNotFound
+		}
+		return "", err
+	}
+	args, err := a.GetPromptArgs()
+	if err != nil {
+		return "", ErrAssistantInvalidPromptArgs
+	}
+	return prompt.RenderVersion(p.Name, a.PromptVersion, args)
+}
+
+// GetUserGroupIDs returns the ids of the groups which the user belongs to
+func GetUserGroupIDs(db *gorm.DB, userID uint) ([]uint, error) {
+	var ids []uint
+	err := db.Model(&GroupMember{}).Where("user_id = ?", userID).Pluck("group_id", &ids).Error
+	return ids, err
+}
+
+// GetGroupRole returns the role of the user in the group, empty if not a member
+func GetGroupRole(db *gorm.DB, userID, groupID uint) string {
+	var member GroupMember
+	if err := db.Where("user_id = ? AND group_id = ?", userID, groupID).Take(&member).Error; err != nil {
+		return ""
+	}
+	return member.Role
+}
+
+// ScopeAssistants limits the query to the assistants visible to the user:
+// owned by the user or shared to one of the user's groups
+func ScopeAssistants(db *gorm.DB, user *User) (*gorm.DB, error) {
+	groupIDs, err := GetUserGroupIDs(db, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	tx := db.Model(&Assistant{})
+	if len(groupIDs) == 0 {
+		return tx.Where("user_id = ?", user.ID), nil
+	}
+	return tx.Where("user_id = ? OR (group_id <> 0 AND group_id IN ?)", user.ID, groupIDs), nil
+}
+
+// ListAssistants returns all assistants visible to the user
+func ListAssistants(db *gorm.DB, user *User) ([]Assistant, error) {
+	tx, err := ScopeAssistants(db, user)
+	if err != nil {
+		return nil, err
+	}
+	var assistants []Assistant
+	err = tx.Order("updated_at DESC").Find(&assistants).Error
+	return assistants, err
+}
+
+// GetAssistant returns the assistant if it is visible to the user
+func GetAssistant(db *gorm.DB, user *User, id uint) (*Assistant, error) {
+	tx, err := ScopeAssistants(db, user)
+	if err != nil {
+		return nil, err
+	}
+	var assistant Assistant
+	if err := tx.Where("id = ?", id).Take(&assistant).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAssistantNotFound
+		}
+		return nil, err
+	}
+	return &assistant, nil
+}
+
+// GetAssistantByJsSourceID returns the assistant by the public script id
+func GetAssistantByJsSourceID(db *gorm.DB, jsSourceID string) (*Assistant, error) {
+	var assistant Assistant
+	if err := db.Where("js_source_id = ?", jsSourceID).Take(&assistant).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAssistantNotFound
+		}
+		return nil, err
+	}
+	return &assistant, nil
+}
+
+// CanModifyAssistant only the owner or the admin of the owning group can modify the assistant
+func CanModifyAssistant(db *gorm.DB, user *User, assistant *Assistant) bool {
+	if assistant.UserID == user.ID {
+		return true
+	}
+	if assistant.GroupID == 0 {
+		return false
+	}
+	return GetGroupRole(db, user.ID, assistant.GroupID) == GroupRoleAdmin
+}
@@ -0,0 +1,154 @@
+package models
+
+import (
+	"VoiceSculptor/internal/chat"
+	"VoiceSculptor/pkg/tool"
+	"VoiceSculptor/pkg/util"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	AssistantToolDefaultTimeoutMs = 10000
+	AssistantToolMaxTimeoutMs     = 60000
+)
+
+var ErrAssistantToolNotFound = &util.Error{Code: http.StatusNotFound, Message: "tool not found"}
+var ErrAssistantToolInvalidName = &util.Error{Code: http.StatusBadRequest, Message: "name must be 1 to 64 letters, digits, _ or -"}
+var ErrAssistantToolReservedName = &util.Error{Code: http.StatusBadRequest, Message: "name is reserved for a built-in tool"}
+var ErrAssistantToolDuplicateName = &util.Error{Code: http.StatusConflict, Message: "the assistant already has a tool with this name"}
+var ErrAssistantToolInvalidParameters = &util.Error{Code: http.StatusBadRequest, Message: "parameters must be a JSON schema object"}
+var ErrAssistantToolInvalidURL = &util.Error{Code: http.StatusBadRequest, Message: "url must be an http or https url of a public host"}
+var ErrAssistantToolInvalidTimeout = &util.Error{Code: http.StatusBadRequest, Message: "timeoutMs must be between 0 and 60000"}
+var ErrAssistantToolInvalidAuthHeader = &util.Error{Code: http.StatusBadRequest, Message: "authHeader must be a header name"}
+
+var assistantToolNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// reservedToolNames the tools offered to the model by the engine itself
+var reservedToolNames = []string{chat.EscalationTool}
+
+// AssistantTool 助手可以调用的函数, 模型请求调用时把参数 POST 到 URL, 响应作为结果交还给模型
+type AssistantTool struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt   time.Time `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt   time.Time `json:"updatedAt" gorm:"autoUpdateTime"`
+	AssistantID uint      `json:"assistantId" gorm:"uniqueIndex:idx_assistant_tool_name"`
+	Name        string    `json:"name" gorm:"size:64;uniqueIndex:idx_assistant_tool_name"` // 函数名, 模型按名称调用
+	Description string    `json:"description,omitempty"`                                   // 告诉模型何时调用
+	Parameters  string    `json:"parameters,omitempty" gorm:"type:text"`                   // 参数的 JSON schema
+	URL         string    `json:"url" gorm:"size:512"`                                     // webhook 地址
+	TimeoutMs   int       `json:"timeoutMs,omitempty"`                                     // 0 表示默认 10 秒
+	AuthHeader  string    `json:"authHeader,omitempty" gorm:"size:128"`                    // 调用时附带的请求头, 如 Authorization
+	AuthValue   string    `json:"-" gorm:"size:1024"`                                      // 请求头的值, 不返回给客户端
+	Disabled    bool      `json:"disabled,omitempty"`                                      // 停用后不再提供给模型
+}
+
+// Validate checks the name, the schema and the webhook of the tool
+func (t *AssistantTool) Validate() error {
+	if !assistantToolNamePattern.MatchString(t.Name) {
+		return ErrAssistantToolInvalidName
+	}
+	for _, name := range reservedToolNames {
+		if strings.EqualFold(t.Name, name) {
+			return ErrAssistantToolReservedName
+		}
+	}
+	if t.Parameters != "" {
+		var schema map[string]any
+		if err := json.Unmarshal([]byte(t.Parameters), &schema); err != nil {
+			return ErrAssistantToolInvalidParameters
+		}
+	}
+	u, err := url.Parse(t.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrAssistantToolInvalidURL
+	}
+	// the names are resolved again when the tool is called, see tool.NewClient
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrAssistantToolInvalidURL
+	}
+	if ip, err := netip.ParseAddr(host); err == nil && !tool.IsPublicAddr(ip) {
+		return ErrAssistantToolInvalidURL
+	}
+	if t.TimeoutMs < 0 || t.TimeoutMs > AssistantToolMaxTimeoutMs {
+		return ErrAssistantToolInvalidTimeout
+	}
+	if strings.ContainsAny(t.AuthHeader, " :\r\n") {
+		return ErrAssistantToolInvalidAuthHeader
+	}
+	return nil
+}
+
+// Webhook returns the tool to call
+func (t *AssistantTool) Webhook() tool.Webhook {
+	timeoutMs := t.TimeoutMs
+	if timeoutMs == 0 {
+		timeoutMs = AssistantToolDefaultTimeoutMs
+	}
+	webhook := tool.Webhook{
+		Name:        t.Name,
+		Description: t.Description,
+		URL:         t.URL,
+		Timeout:     time.Duration(timeoutMs) * time.Millisecond,
+		AuthHeader:  t.AuthHeader,
+		AuthValue:   t.AuthValue,
+	}
+	if t.Parameters != "" {
+		webhook.Parameters = json.RawMessage(t.Parameters)
+	}
+	return webhook
+}
+
+// Toolset returns the enabled tools of the assistant, loaded by ListAssistantTools
+func (a *Assistant) Toolset() *tool.Registry {
+	var webhooks []tool.Webhook
+	for _, t := range a.Tools {
+		if !t.Disabled {
+			webhooks = append(webhooks, t.Webhook())
+		}
+	}
+	return tool.NewRegistry(webhooks...)
+}
+
+// ListAssistantTools returns the tools of the assistant by name
+func ListAssistantTools(db *gorm.DB, assistantID uint) ([]AssistantTool, error) {
+	var tools []AssistantTool
+	err := db.Where("assistant_id = ?", assistantID).Order("name ASC").Find(&tools).Error
+	return tools, err
+}
+
+// GetAssistantTool returns the tool of the assistant
+func GetAssistantTool(db *gorm.DB, assistantID, id uint) (*AssistantTool, error) {
+	var t AssistantTool
+	if err := db.Where("id = ? AND assistant_id = ?", id, assistantID).Take(&t).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAssistantToolNotFound
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+// SaveAssistantTool creates or updates the tool, the names are unique per assistant
+func SaveAssistantTool(db *gorm.DB, t *AssistantTool) error {
+	var count int64
+	err := db.Model(&AssistantTool{}).
+		Where("assistant_id = ? AND name = ? AND id <> ?", t.AssistantID, t.Name, t.ID).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrAssistantToolDuplicateName
+	}
+	return db.Save(t).Error
+}
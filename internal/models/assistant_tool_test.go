@@ -0,0 +1,33 @@
+package models
+
+import (
+	"VoiceSculptor/internal/chat"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssistantTool_Validate(t *testing.T) {
+	valid := AssistantTool{Name: "lookup_order", Parameters: `{"type":"object"}`, URL: "https://example.com/orders", AuthHeader: "Authorization"}
+	assert.NoError(t, valid.Validate())
+
+	for name, tc := range map[string]struct {
+		edit func(*AssistantTool)
+		err  error
+	}{
+		"invalid name":         {func(a *AssistantTool) { a.Name = "lookup order" }, ErrAssistantToolInvalidName},
+		"escalation tool":      {func(a *AssistantTool) { a.Name = chat.EscalationTool }, ErrAssistantToolReservedName},
+		"escalation tool case": {func(a *AssistantTool) { a.Name = "Transfer_To_Human" }, ErrAssistantToolReservedName},
+		"parameters":           {func(a *AssistantTool) { a.Parameters = "[1]" }, ErrAssistantToolInvalidParameters},
+		"scheme":               {func(a *AssistantTool) { a.URL = "ftp://example.com" }, ErrAssistantToolInvalidURL},
+		"localhost":            {func(a *AssistantTool) { a.URL = "http://localhost:8080/" }, ErrAssistantToolInvalidURL},
+		"private address":      {func(a *AssistantTool) { a.URL = "http://10.0.0.1/" }, ErrAssistantToolInvalidURL},
+		"metadata address":     {func(a *AssistantTool) { a.URL = "http://169.254.169.254/" }, ErrAssistantToolInvalidURL},
+		"timeout":              {func(a *AssistantTool) { a.TimeoutMs = AssistantToolMaxTimeoutMs + 1 }, ErrAssistantToolInvalidTimeout},
+		"auth header":          {func(a *AssistantTool) { a.AuthHeader = "X-Key: 1" }, ErrAssistantToolInvalidAuthHeader},
+	} {
+		tool := valid
+		tc.edit(&tool)
+		assert.ErrorIs(t, tool.Validate(), tc.err, name)
+	}
+}
@@ -59,15 +59,21 @@ type User struct {
 }
 
 type UserCredential struct {
-	ID        uint   `gorm:"primaryKey"`
-	UserID    uint   `gorm:"index;"`               // 关联到用户
-	Name      string `json:"name"`                 // 应用名称 or 用途备注
-	APIKey    string `gorm:"uniqueIndex;not null"` // 用于认证
-	APISecret string `gorm:"not null"`             // 用于签名校验
+	ID        uint   `json:"id" gorm:"primaryKey"`
+	UserID    uint   `json:"userId" gorm:"index;"`                // 关联到用户
+	Name      string `json:"name"`                                // 应用名称 or 用途备注
+	APIKey    string `json:"apiKey" gorm:"uniqueIndex;not null"`  // 用于认证
+	APISecret string `json:"apiSecret,omitempty" gorm:"not null"` // 用于签名校验
 
 	LLMProvider string `json:"llmProvider"`
 	LLMApiKey   string `json:"llmApiKey"`
 	LLMApiURL   string `json:"llmApiUrl"`
+	LLMModel    string `json:"llmModel"`
+
+	Quota int64 `json:"quota"` // token 额度, 0 表示不限制
+	Used  int64 `json:"used"`  // 已使用的 token 数
+
+	CallConcurrency int `json:"callConcurrency"` // 外呼任务同时进行的通话数, 0 表示 1
 
 	AsrProvider  string `json:"asrProvider"`
 	AsrAppID     string `json:"asrAppId"`
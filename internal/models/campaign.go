@@ -0,0 +1,361 @@
+package models
+
+import (
+	"VoiceSculptor/pkg/schedule"
+	"VoiceSculptor/pkg/util"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	CampaignStatusDraft     = "draft"
+	CampaignStatusRunning   = "running"
+	CampaignStatusPaused    = "paused"
+	CampaignStatusCompleted = "completed"
+
+	ContactStatusPending   = "pending"
+	ContactStatusCalling   = "calling"
+	ContactStatusCompleted = "completed"
+	ContactStatusFailed    = "failed"
+
+	// outcomes of a call to a contact, no answer and busy are retried
+	CallOutcomeAnswered = "answered"
+	CallOutcomeNoAnswer = "no_answer"
+	CallOutcomeBusy     = "busy"
+	CallOutcomeFailed   = "failed"
+
+	CampaignDefaultMaxAttempts = 3
+	CampaignMaxMaxAttempts     = 10
+	CampaignDefaultRetryDelay  = 5 * time.Minute
+	CampaignMaxContacts        = 10000 // per upload
+)
+
+var ErrCampaignNotFound = &util.Error{Code: http.StatusNotFound, Message: "campaign not found"}
+var ErrCampaignInvalidAttempts = &util.Error{Code: http.StatusBadRequest, Message: "maxAttempts must be between 0 and 10, retryDelaySeconds must not be negative"}
+var ErrCampaignInvalidHours = &util.Error{Code: http.StatusBadRequest, Message: "callingHours must look like mon-fri 09:00-18:00; sat 10:00-14:00 with a valid timezone"}
+var ErrCampaignInvalidGreeting = &util.Error{Code: http.StatusBadRequest, Message: "greeting is not a valid template"}
+var ErrCampaignRunning = &util.Error{Code: http.StatusConflict, Message: "campaign is running, pause it first"}
+var ErrCampaignNoContacts = &util.Error{Code: http.StatusBadRequest, Message: "campaign has no contacts to call"}
+
+// Campaign 外呼任务, 由助手按 CSV 上传的联系人名单逐个外呼
+type Campaign struct {
+	ID           uint   `json:"id" gorm:"primaryKey"`
+	UserID       uint   `json:"userId" gorm:"index"`       // 创建者
+	Name         string `json:"name" gorm:"size:128"`      // 名称
+	AssistantID  uint   `json:"assistantId" gorm:"index"`  // 接通后对话的助手
+	CredentialID uint   `json:"credentialId" gorm:"index"` // 外呼使用的凭证, 同时外呼的通话数受凭证限制
+	CallerNumber string `json:"callerNumber" gorm:"size:32"`
+	// 开场指令模板, 可以引用联系人的 CSV 列, 如 提醒 {{.name}} 明天 {{.time}} 的预约
+	Greeting     string `json:"greeting,omitempty"`
+	CallingHours string `json:"callingHours,omitempty" gorm:"size:256"` // 外呼时段, 按联系人的时区, 空表示全天
+	Timezone     string `json:"timezone,omitempty" gorm:"size:64"`      // 联系人未指定时区时使用, 默认 UTC
+
+	MaxAttempts       int `json:"maxAttempts"`       // 每个联系人最多呼叫次数, 0 表示默认 3 次
+	RetryDelaySeconds int `json:"retryDelaySeconds"` // 未接通或忙线后首次重试的间隔, 之后每次翻倍, 0 表示默认 5 分钟
+
+	Status      string     `json:"status" gorm:"size:16;index"`
+	StartedAt   *time.Time `json:"startedAt,omitempty"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt   time.Time  `json:"updatedAt" gorm:"autoUpdateTime"`
+}
+
+// CampaignContact 外呼名单中的联系人, 记录每次呼叫的结果
+type CampaignContact struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	CampaignID    uint       `json:"campaignId" gorm:"index"`
+	Number        string     `json:"number" gorm:"size:32"`
+	Name          string     `json:"name,omitempty" gorm:"size:128"`
+	Timezone      string     `json:"timezone,omitempty" gorm:"size:64"`
+	Variables     string     `json:"variables,omitempty" gorm:"type:text"` // 其他 CSV 列, JSON 对象
+	Status        string     `json:"status" gorm:"size:16;index"`
+	Outcome       string     `json:"outcome,omitempty" gorm:"size:16"` // 最后一次呼叫的结果
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty" gorm:"index"`
+	LastAttemptAt *time.Time `json:"lastAttemptAt,omitempty"`
+	SessionID     string     `json:"sessionId,omitempty" gorm:"size:64"` // 接通后的会话, 对应 ChatSessionLog
+	Error         string     `json:"error,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt     time.Time  `json:"updatedAt" gorm:"autoUpdateTime"`
+}
+
+// Validate check the calling rules of the campaign
+func (c *Campaign) Validate() error {
+	if c.Name == "" {
+		return &util.Error{Code: http.StatusBadRequest, Message: "name is required"}
+	}
+	if c.AssistantID == 0 || c.CredentialID == 0 {
+		return &util.Error{Code: http.StatusBadRequest, Message: "assistantId and credentialId are required"}
+	}
+	if c.MaxAttempts < 0 || c.MaxAttempts > CampaignMaxMaxAttempts || c.RetryDelaySeconds < 0 {
+		return ErrCampaignInvalidAttempts
+	}
+	if _, err := c.CallingWindow(""); err != nil {
+		return ErrCampaignInvalidHours
+	}
+	if _, err := template.New("greeting").Parse(c.Greeting); err != nil {
+		return ErrCampaignInvalidGreeting
+	}
+	c.CallerNumber = NormalizePhoneNumber(c.CallerNumber)
+	if c.Status == "" {
+		c.Status = CampaignStatusDraft
+	}
+	return nil
+}
+
+// CallingWindow returns the calling hours in the timezone of a contact,
+// the timezone of the campaign when empty
+func (c *Campaign) CallingWindow(timezone string) (*schedule.Hours, error) {
+	if timezone == "" {
+		timezone = c.Timezone
+	}
+	return schedule.Parse(c.CallingHours, timezone)
+}
+
+// Attempts returns the calls a contact gets at most
+func (c *Campaign) Attempts() int {
+	if c.MaxAttempts <= 0 {
+		return CampaignDefaultMaxAttempts
+	}
+	return c.MaxAttempts
+}
+
+// RetryDelay returns the delay after the failed attempt, doubling with every attempt
+func (c *Campaign) RetryDelay(attempt int) time.Duration {
+	delay := CampaignDefaultRetryDelay
+	if c.RetryDelaySeconds > 0 {
+		delay = time.Duration(c.RetryDelaySeconds) * time.Second
+	}
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+	}
+	return delay
+}
+
+// RenderGreeting renders the greeting with the columns of the contact
+func (c *Campaign) RenderGreeting(contact *CampaignContact) (string, error) {
+	if c.Greeting == "" {
+		return "", nil
+	}
+	t, err := template.New("greeting").Option("missingkey=zero").Parse(c.Greeting)
+	if err != nil {
+		return "", ErrCampaignInvalidGreeting
+	}
+	vars, err := contact.GetVariables()
+	if err != nil {
+		return "", err
+	}
+	vars["name"] = contact.Name
+	vars["number"] = contact.Number
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, vars); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// GetVariables decodes the other CSV columns of the contact
+func (ct *CampaignContact) GetVariables() (map[string]any, error) {
+	vars := map[string]any{}
+	if ct.Variables == "" {
+		return vars, nil
+	}
+	err := json.Unmarshal([]byte(ct.Variables), &vars)
+	return vars, err
+}
+
+// Record applies the outcome of a call to the contact: an answered call
+// completes it, no answer or busy is retried after the retry delay until the
+// attempts of the campaign are used up, other outcomes fail it
+func (ct *CampaignContact) Record(campaign *Campaign, outcome, sessionID, errMessage string, now time.Time) {
+	ct.Attempts++
+	ct.Outcome = outcome
+	ct.LastAttemptAt = &now
+	ct.NextAttemptAt = nil
+	ct.Error = errMessage
+	if sessionID != "" {
+		ct.SessionID = sessionID
+	}
+	switch outcome {
+	case CallOutcomeAnswered:
+		ct.Status = ContactStatusCompleted
+	case CallOutcomeNoAnswer, CallOutcomeBusy:
+		if ct.Attempts >= campaign.Attempts() {
+			ct.Status = ContactStatusFailed
+			return
+		}
+		next := now.Add(campaign.RetryDelay(ct.Attempts))
+		ct.Status, ct.NextAttemptAt = ContactStatusPending, &next
+	default:
+		ct.Status = ContactStatusFailed
+	}
+}
+
+// ParseCampaignContacts parses a CSV with a header line, the number (or phone)
+// column is required, the name and timezone columns are optional and the
+// other columns are kept as the variables of the greeting
+func ParseCampaignContacts(r io.Reader) ([]CampaignContact, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, &util.Error{Code: http.StatusBadRequest, Message: "invalid csv: " + err.Error()}
+	}
+	numberCol := -1
+	for i, name := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if header[i] == "number" || header[i] == "phone" {
+			numberCol = i
+		}
+	}
+	if numberCol < 0 {
+		return nil, &util.Error{Code: http.StatusBadRequest, Message: "csv requires a number column"}
+	}
+
+	var contacts []CampaignContact
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, &util.Error{Code: http.StatusBadRequest, Message: "invalid csv: " + err.Error()}
+		}
+		if len(contacts) >= CampaignMaxContacts {
+			return nil, &util.Error{Code: http.StatusBadRequest, Message: fmt.Sprintf("csv has more than %d contacts", CampaignMaxContacts)}
+		}
+		contact := CampaignContact{Status: ContactStatusPending}
+		vars := map[string]string{}
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			switch {
+			case i == numberCol:
+				contact.Number = NormalizePhoneNumber(value)
+			case header[i] == "name":
+				contact.Name = value
+			case header[i] == "timezone":
+				contact.Timezone = value
+			case header[i] != "" && value != "":
+				vars[header[i]] = value
+			}
+		}
+		if strings.TrimPrefix(contact.Number, "+") == "" {
+			return nil, &util.Error{Code: http.StatusBadRequest, Message: fmt.Sprintf("line %d: invalid number", line)}
+		}
+		if contact.Timezone != "" {
+			if _, err := time.LoadLocation(contact.Timezone); err != nil {
+				return nil, &util.Error{Code: http.StatusBadRequest, Message: fmt.Sprintf("line %d: unknown timezone %s", line, contact.Timezone)}
+			}
+		}
+		if len(vars) > 0 {
+			data, _ := json.Marshal(vars)
+			contact.Variables = string(data)
+		}
+		contacts = append(contacts, contact)
+	}
+	return contacts, nil
+}
+
+// AddCampaignContacts adds the contacts to the campaign
+func AddCampaignContacts(db *gorm.DB, campaignID uint, contacts []CampaignContact) error {
+	if len(contacts) == 0 {
+		return nil
+	}
+	for i := range contacts {
+		contacts[i].CampaignID = campaignID
+	}
+	return db.CreateInBatches(contacts, 500).Error
+}
+
+// GetCampaign returns the campaign of the user
+func GetCampaign(db *gorm.DB, userID, id uint) (*Campaign, error) {
+	var campaign Campaign
+	if err := db.Where("id = ? AND user_id = ?", id, userID).Take(&campaign).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCampaignNotFound
+		}
+		return nil, err
+	}
+	return &campaign, nil
+}
+
+// ListCampaigns returns the campaigns of the user, newest first
+func ListCampaigns(db *gorm.DB, userID uint) ([]Campaign, error) {
+	var campaigns []Campaign
+	err := db.Where("user_id = ?", userID).Order("created_at DESC").Find(&campaigns).Error
+	return campaigns, err
+}
+
+// CountCampaignContacts returns the number of contacts of the campaign by status
+func CountCampaignContacts(db *gorm.DB, campaignID uint) (map[string]int64, error) {
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	err := db.Model(&CampaignContact{}).Select("status, count(*) as count").
+		Where("campaign_id = ?", campaignID).Group("status").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int64{}
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
+
+// SetCampaignStatus starts, pauses or completes the campaign
+func SetCampaignStatus(db *gorm.DB, campaign *Campaign, status string) error {
+	vals := map[string]any{"status": status}
+	now := time.Now()
+	switch status {
+	case CampaignStatusRunning:
+		if campaign.StartedAt == nil {
+			vals["started_at"] = now
+		}
+	case CampaignStatusCompleted:
+		vals["completed_at"] = now
+	}
+	if err := db.Model(campaign).Updates(vals).Error; err != nil {
+		return err
+	}
+	return db.Take(campaign, campaign.ID).Error
+}
+
+// ResetCallingContacts returns the contacts left calling, by a stopped
+// scheduler, to the pending contacts
+func ResetCallingContacts(db *gorm.DB) error {
+	return db.Model(&CampaignContact{}).Where("status = ?", ContactStatusCalling).
+		Update("status", ContactStatusPending).Error
+}
+
+// ListCampaignContacts returns a page of the contacts of the campaign, of the status when not empty
+func ListCampaignContacts(db *gorm.DB, campaignID uint, status string, page, size int) ([]CampaignContact, int64, error) {
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 || size > 100 {
+		size = 10
+	}
+	query := db.Model(&CampaignContact{}).Where("campaign_id = ?", campaignID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var contacts []CampaignContact
+	err := query.Order("id").Offset((page - 1) * size).Limit(size).Find(&contacts).Error
+	return contacts, total, err
+}
@@ -0,0 +1,200 @@
+package models
+
+import (
+	"VoiceSculptor/pkg/llm"
+	"VoiceSculptor/pkg/util"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ChatSessionLogDefaultPageSize = 10
+	ChatSessionLogMaxPageSize     = 100
+)
+
+var ErrChatSessionLogNotFound = &util.Error{Code: http.StatusNotFound, Message: "chat session log not found"}
+
+// ChatSessionLog the header of a conversation transcript
+type ChatSessionLog struct {
+	ID           uint        `json:"id" gorm:"primaryKey"`
+	CreatedAt    time.Time   `json:"createdAt" gorm:"autoCreateTime;index"`
+	UpdatedAt    time.Time   `json:"updatedAt" gorm:"autoUpdateTime"`
+	EndedAt      *time.Time  `json:"endedAt,omitempty"`
+	SessionID    string      `json:"sessionId" gorm:"size:64;uniqueIndex"`
+	UserID       uint        `json:"userId" gorm:"index"`
+	AssistantID  uint        `json:"assistantId" gorm:"index"`
+	CredentialID uint        `json:"credentialId" gorm:"index"`
+	Content      string      `json:"content"` // 最后一条消息, 用于列表展示
+	TurnCount    int         `json:"turnCount"`
+	TotalTokens  int         `json:"totalTokens"`
+	RecordingURL string      `json:"recordingUrl,omitempty"`                      // 语音会话的双声道录音, 左声道来电方, 右声道助手
+	MediaStats   *MediaStats `json:"mediaStats,omitempty" gorm:"serializer:json"` // 语音会话的音频质量
+
+	Turns []ChatSessionTurn `json:"turns,omitempty" gorm:"foreignKey:SessionLogID;constraint:OnDelete:CASCADE"`
+}
+
+// MediaStats the quality of the audio of a WebRTC voice session
+type MediaStats struct {
+	Codec string `json:"codec"`
+	// the audio of the caller: the packets lost are concealed, those arriving
+	// after they were concealed are late and dropped
+	PacketsReceived uint64 `json:"packetsReceived"`
+	PacketsLost     uint64 `json:"packetsLost"`
+	PacketsLate     uint64 `json:"packetsLate"`
+	JitterMs        int64  `json:"jitterMs"`
+	JitterBufferMs  int64  `json:"jitterBufferMs"` // how long a missing packet is waited for
+	// the audio of the assistant as reported by the caller over RTCP
+	RemotePacketsLost  int64   `json:"remotePacketsLost"`
+	RemoteFractionLost float64 `json:"remoteFractionLost"` // 0 to 1, of the last report
+	RemoteJitterMs     int64   `json:"remoteJitterMs"`
+	RttMs              int64   `json:"rttMs"` // 0 until the caller reported
+}
+
+// ChatSessionTurn a message of the transcript
+type ChatSessionTurn struct {
+	ID               uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt        time.Time `json:"createdAt" gorm:"autoCreateTime"`
+	SessionLogID     uint      `json:"sessionLogId" gorm:"index"`
+	Seq              int       `json:"seq"`
+	Role             string    `json:"role" gorm:"size:20"`
+	Content          string    `json:"content"`
+	AudioURL         string    `json:"audioUrl,omitempty"` // 语音会话的录音地址
+	LatencyMs        int64     `json:"latencyMs"`
+	PromptTokens     int       `json:"promptTokens"`
+	CompletionTokens int       `json:"completionTokens"`
+	TotalTokens      int       `json:"totalTokens"`
+	Interrupted      bool      `json:"interrupted"`
+	AssistantID      uint      `json:"assistantId"`
+	CredentialID     uint      `json:"credentialId"`
+	AgentID          uint      `json:"agentId,omitempty"` // 接管会话的人工客服, 仅人工回复的消息
+
+	// 助手调用的函数, 仅 tool 消息, Content 为函数的结果
+	ToolCallID    string `json:"toolCallId,omitempty" gorm:"size:128"`
+	ToolName      string `json:"toolName,omitempty" gorm:"size:64"`
+	ToolArguments string `json:"toolArguments,omitempty" gorm:"type:text"`
+	ToolError     string `json:"toolError,omitempty"`
+}
+
+// ChatRecordingKey returns the storage key of the recording of a voice session,
+// the whole call for seq 0, otherwise the clip of the turn
+func ChatRecordingKey(sessionID string, seq int) string {
+	if seq == 0 {
+		return fmt.Sprintf("recordings/%s/call.wav", sessionID)
+	}
+	return fmt.Sprintf("recordings/%s/turn-%d.wav", sessionID, seq)
+}
+
+// ChatSessionLogFilter the query of the transcripts of a user
+type ChatSessionLogFilter struct {
+	UserID      uint
+	AssistantID uint
+	Page        int
+	Size        int
+	Start       time.Time
+	End         time.Time
+}
+
+// CreateChatSessionLog creates the header of the transcript
+func CreateChatSessionLog(db *gorm.DB, log *ChatSessionLog) error {
+	return db.Create(log).Error
+}
+
+// AppendChatSessionTurn saves the turn and updates the header of its transcript,
+// the results of the tools are not shown as the last message
+func AppendChatSessionTurn(db *gorm.DB, turn *ChatSessionTurn) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(turn).Error; err != nil {
+			return err
+		}
+		updates := map[string]any{
+			"turn_count":   gorm.Expr("turn_count + 1"),
+			"total_tokens": gorm.Expr("total_tokens + ?", turn.TotalTokens),
+			"updated_at":   time.Now(),
+		}
+		if turn.Role != llm.RoleTool {
+			updates["content"] = turn.Content
+		}
+		return tx.Model(&ChatSessionLog{}).Where("id = ?", turn.SessionLogID).Updates(updates).Error
+	})
+}
+
+// EndChatSessionLog marks the transcript as ended
+func EndChatSessionLog(db *gorm.DB, id uint) error {
+	return db.Model(&ChatSessionLog{}).Where("id = ?", id).Update("ended_at", time.Now()).Error
+}
+
+// SetChatSessionRecording links the recording of the call to the transcript
+func SetChatSessionRecording(db *gorm.DB, id uint, url string) error {
+	return db.Model(&ChatSessionLog{}).Where("id = ?", id).Update("recording_url", url).Error
+}
+
+// SetChatSessionMediaStats records the audio quality of a voice session once it ended
+func SetChatSessionMediaStats(db *gorm.DB, id uint, stats *MediaStats) error {
+	return db.Model(&ChatSessionLog{ID: id}).Select("media_stats").Updates(&ChatSessionLog{MediaStats: stats}).Error
+}
+
+// SetChatSessionAssistant records the assistant a call was handed off to
+func SetChatSessionAssistant(db *gorm.DB, id, assistantID uint) error {
+	return db.Model(&ChatSessionLog{}).Where("id = ?", id).Update("assistant_id", assistantID).Error
+}
+
+// SetChatSessionTurnAudio links the clip of a turn to the transcript
+func SetChatSessionTurnAudio(db *gorm.DB, sessionLogID uint, seq int, url string) error {
+	return db.Model(&ChatSessionTurn{}).Where("session_log_id = ? AND seq = ?", sessionLogID, seq).Update("audio_url", url).Error
+}
+
+// ListChatSessionLogs returns a page of the transcripts of the user, newest first
+func ListChatSessionLogs(db *gorm.DB, filter ChatSessionLogFilter) ([]ChatSessionLog, int64, error) {
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.Size < 1 {
+		filter.Size = ChatSessionLogDefaultPageSize
+	}
+	if filter.Size > ChatSessionLogMaxPageSize {
+		filter.Size = ChatSessionLogMaxPageSize
+	}
+
+	query := db.Model(&ChatSessionLog{}).Where("user_id = ?", filter.UserID)
+	if filter.AssistantID > 0 {
+		query = query.Where("assistant_id = ?", filter.AssistantID)
+	}
+	if !filter.Start.IsZero() {
+		query = query.Where("created_at >= ?", filter.Start)
+	}
+	if !filter.End.IsZero() {
+		query = query.Where("created_at <= ?", filter.End)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var logs []ChatSessionLog
+	err := query.Order("created_at DESC").
+		Offset((filter.Page - 1) * filter.Size).
+		Limit(filter.Size).
+		Find(&logs).Error
+	return logs, total, err
+}
+
+// GetChatSessionLog returns the transcript of the user with its turns in order
+func GetChatSessionLog(db *gorm.DB, userID, id uint) (*ChatSessionLog, error) {
+	var log ChatSessionLog
+	err := db.Where("id = ? AND user_id = ?", id, userID).
+		Preload("Turns", func(db *gorm.DB) *gorm.DB {
+			return db.Order("seq ASC")
+		}).
+		Take(&log).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrChatSessionLogNotFound
+		}
+		return nil, err
+	}
+	return &log, nil
+}
@@ -0,0 +1,126 @@
+package models
+
+import (
+	"VoiceSculptor/pkg/asr"
+	"VoiceSculptor/pkg/llm"
+	"VoiceSculptor/pkg/tts"
+	"VoiceSculptor/pkg/util"
+	"errors"
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+var ErrCredentialNotFound = &util.Error{Code: http.StatusNotFound, Message: "credential not found"}
+var ErrCredentialQuotaExceeded = &util.Error{Code: http.StatusPaymentRequired, Message: util.ErrQuotaExceeded.Error()}
+
+// LLMConfig returns the connection settings of the llm provider
+func (uc *UserCredential) LLMConfig() llm.Config {
+	return llm.Config{
+		APIKey:  uc.LLMApiKey,
+		BaseURL: uc.LLMApiURL,
+		Model:   uc.LLMModel,
+	}
+}
+
+// NewLLMProvider creates the llm provider selected by the credential
+func (uc *UserCredential) NewLLMProvider() (llm.Provider, error) {
+	return llm.New(uc.LLMProvider, uc.LLMConfig())
+}
+
+// ASRConfig returns the connection settings of the asr provider
+func (uc *UserCredential) ASRConfig() asr.Config {
+	return asr.Config{
+		AppID:     uc.AsrAppID,
+		SecretID:  uc.AsrSecretID,
+		SecretKey: uc.AsrSecretKey,
+		Language:  uc.AsrLanguage,
+	}
+}
+
+// NewASRProvider creates the asr provider selected by the credential
+func (uc *UserCredential) NewASRProvider() (asr.Provider, error) {
+	return asr.New(uc.AsrProvider, uc.ASRConfig())
+}
+
+// TTSConfig returns the connection settings of the tts provider
+func (uc *UserCredential) TTSConfig() tts.Config {
+	return tts.Config{
+		AppID:     uc.TTSAppID,
+		SecretID:  uc.TTSSecretID,
+		SecretKey: uc.TTSSecretKey,
+	}
+}
+
+// NewTTSProvider creates the tts provider selected by the credential
+func (uc *UserCredential) NewTTSProvider() (tts.Provider, error) {
+	return tts.New(uc.TtsProvider, uc.TTSConfig())
+}
+
+// CheckQuota returns an error if the token quota of the credential is used up
+func (uc *UserCredential) CheckQuota() error {
+	if uc.Quota > 0 && uc.Used >= uc.Quota {
+		return ErrCredentialQuotaExceeded
+	}
+	return nil
+}
+
+// CallLimit returns the outbound calls the credential places at the same time
+func (uc *UserCredential) CallLimit() int {
+	if uc.CallConcurrency <= 0 {
+		return 1
+	}
+	return uc.CallConcurrency
+}
+
+// AddCredentialUsage adds the consumed tokens to the credential
+func AddCredentialUsage(db *gorm.DB, credentialID uint, tokens int) error {
+	if tokens <= 0 {
+		return nil
+	}
+	return db.Model(&UserCredential{}).Where("id = ?", credentialID).
+		UpdateColumn("used", gorm.Expr("used + ?", tokens)).Error
+}
+
+// GetUserCredentials returns all credentials of the user
+func GetUserCredentials(db *gorm.DB, userID uint) ([]UserCredential, error) {
+	var credentials []UserCredential
+	err := db.Where("user_id = ?", userID).Order("updated_at DESC").Find(&credentials).Error
+	return credentials, err
+}
+
+// GetUserCredential returns the credential of the user by its id
+func GetUserCredential(db *gorm.DB, userID, id uint) (*UserCredential, error) {
+	var credential UserCredential
+	if err := db.Where("user_id = ? AND id = ?", userID, id).Take(&credential).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCredentialNotFound
+		}
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// GetUserCredentialByAPIKey returns the credential of the user by the api key
+func GetUserCredentialByAPIKey(db *gorm.DB, userID uint, apiKey string) (*UserCredential, error) {
+	var credential UserCredential
+	if err := db.Where("user_id = ? AND api_key = ?", userID, apiKey).Take(&credential).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCredentialNotFound
+		}
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// GetLatestUserCredential returns the most recently updated credential of the user
+func GetLatestUserCredential(db *gorm.DB, userID uint) (*UserCredential, error) {
+	var credential UserCredential
+	if err := db.Where("user_id = ?", userID).Order("updated_at DESC").Take(&credential).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCredentialNotFound
+		}
+		return nil, err
+	}
+	return &credential, nil
+}
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"VoiceSculptor/pkg/util"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	CustomerMaxExternalIDLength = 128
+	// CustomerMaxFacts the facts kept about a customer, the oldest are forgotten
+	CustomerMaxFacts = 50
+	// CustomerRecalledSessions the summaries of the previous sessions recalled by a new one
+	CustomerRecalledSessions = 3
+)
+
+var ErrCustomerNotFound = &util.Error{Code: http.StatusNotFound, Message: "customer not found"}
+var ErrCustomerInvalidExternalID = &util.Error{Code: http.StatusBadRequest, Message: "customerId must be at most 128 characters"}
+
+// Customer 来电方或访客的档案, 按外部 ID 跨会话记住客户, 归属于会话所属的用户
+type Customer struct {
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt    time.Time  `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt    time.Time  `json:"updatedAt" gorm:"autoUpdateTime"`
+	UserID       uint       `json:"userId" gorm:"uniqueIndex:idx_customer_external"`
+	ExternalID   string     `json:"externalId" gorm:"size:128;uniqueIndex:idx_customer_external"` // 电话号码、邮箱或宿主应用的用户 ID
+	Facts        []string   `json:"facts" gorm:"serializer:json"`                                 // 从对话中提取的客户信息
+	SessionCount int        `json:"sessionCount"`
+	LastSeenAt   *time.Time `json:"lastSeenAt,omitempty"`
+
+	Sessions []CustomerSession `json:"sessions,omitempty" gorm:"foreignKey:CustomerID;constraint:OnDelete:CASCADE"`
+}
+
+// CustomerSession 客户一次会话的摘要
+type CustomerSession struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt   time.Time `json:"createdAt" gorm:"autoCreateTime;index"`
+	CustomerID  uint      `json:"customerId" gorm:"index"`
+	SessionID   string    `json:"sessionId" gorm:"size:64"`
+	AssistantID uint      `json:"assistantId"`
+	Summary     string    `json:"summary" gorm:"type:text"`
+}
+
+// NormalizeExternalID trims the external ID, an email is not case sensitive
+func NormalizeExternalID(id string) string {
+	id = strings.TrimSpace(id)
+	if strings.Contains(id, "@") {
+		id = strings.ToLower(id)
+	}
+	return id
+}
+
+// ListCustomers returns the customers of the user, the last seen first
+func ListCustomers(db *gorm.DB, userID uint, externalID string) ([]Customer, error) {
+	tx := db.Where("user_id = ?", userID)
+	if externalID != "" {
+		tx = tx.Where("external_id = ?", NormalizeExternalID(externalID))
+	}
+	var customers []Customer
+	err := tx.Order("last_seen_at DESC").Find(&customers).Error
+	return customers, err
+}
+
+// GetCustomer returns the customer of the user with the summaries of its sessions, newest first
+func GetCustomer(db *gorm.DB, userID, id uint) (*Customer, error) {
+	var customer Customer
+	err := db.Where("id = ? AND user_id = ?", id, userID).
+		Preload("Sessions", func(tx *gorm.DB) *gorm.DB { return tx.Order("created_at DESC") }).
+		Take(&customer).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCustomerNotFound
+		}
+		return nil, err
+	}
+	return &customer, nil
+}
+
+// FindCustomer returns the customer of the user with the external ID and the
+// summaries of its latest sessions, nil if the customer is new
+func FindCustomer(db *gorm.DB, userID uint, externalID string) (*Customer, error) {
+	var customer Customer
+	err := db.Where("user_id = ? AND external_id = ?", userID, NormalizeExternalID(externalID)).
+		Preload("Sessions", func(tx *gorm.DB) *gorm.DB {
+			return tx.Order("created_at DESC").Limit(CustomerRecalledSessions)
+		}).
+		Take(&customer).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &customer, nil
+}
+
+// RememberCustomer records a session of the customer, creating the customer
+// on its first session. The facts replace those known when not nil.
+func RememberCustomer(db *gorm.DB, userID uint, externalID string, session *CustomerSession, facts []string) error {
+	externalID = NormalizeExternalID(externalID)
+	return db.Transaction(func(tx *gorm.DB) error {
+		customer := Customer{UserID: userID, ExternalID: externalID}
+		if err := tx.Where(&customer).FirstOrCreate(&customer).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		updates := map[string]any{"session_count": gorm.Expr("session_count + 1"), "last_seen_at": &now}
+		if facts != nil {
+			if len(facts) > CustomerMaxFacts {
+				facts = facts[len(facts)-CustomerMaxFacts:]
+			}
+			customer.Facts = facts
+			if err := tx.Model(&customer).Select("Facts").Updates(&customer).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&customer).Updates(updates).Error; err != nil {
+			return err
+		}
+		if session == nil || session.Summary == "" {
+			return nil
+		}
+		session.CustomerID = customer.ID
+		return tx.Create(session).Error
+	})
+}
+
+// EraseCustomer forgets the customer: its facts and the summaries of its sessions
+func EraseCustomer(db *gorm.DB, customer *Customer) error {
+	return db.Select("Sessions").Delete(customer).Error
+}
@@ -0,0 +1,302 @@
+package models
+
+import (
+	"VoiceSculptor/pkg/embedding"
+	"VoiceSculptor/pkg/util"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	KnowledgeDocumentProcessing = "processing"
+	KnowledgeDocumentReady      = "ready"
+	KnowledgeDocumentFailed     = "failed"
+
+	KnowledgeDefaultTopK = 3
+	KnowledgeMaxTopK     = 20
+
+	KnowledgeMinChunkSize = 100
+	KnowledgeMaxChunkSize = 8000
+
+	// knowledgeScanBatch the chunks scored at once by a search
+	knowledgeScanBatch = 500
+)
+
+var ErrKnowledgeBaseNotFound = &util.Error{Code: http.StatusNotFound, Message: "knowledge base not found"}
+var ErrKnowledgeBaseForbidden = &util.Error{Code: http.StatusForbidden, Message: "no permission to modify this knowledge base"}
+var ErrKnowledgeDocumentNotFound = &util.Error{Code: http.StatusNotFound, Message: "document not found"}
+var ErrKnowledgeInvalidChunkSize = &util.Error{Code: http.StatusBadRequest, Message: "chunkSize must be between 100 and 8000, chunkOverlap between 0 and half of chunkSize"}
+var ErrKnowledgeInvalidTopK = &util.Error{Code: http.StatusBadRequest, Message: "knowledgeTopK must be between 0 and 20"}
+
+// KnowledgeBase 知识库, 上传的文档切块向量化后供助手检索, 归属于创建者, 也可以共享给某个用户组
+type KnowledgeBase struct {
+	ID                uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt         time.Time `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt         time.Time `json:"updatedAt" gorm:"autoUpdateTime"`
+	UserID            uint      `json:"userId" gorm:"index"`            // 创建者
+	GroupID           uint      `json:"groupId,omitempty" gorm:"index"` // 所属用户组, 0 表示私有
+	Name              string    `json:"name" gorm:"size:128"`
+	Description       string    `json:"description,omitempty"`
+	EmbeddingProvider string    `json:"embeddingProvider" gorm:"size:32"` // 创建时的向量化服务, 文档和查询必须使用同一个
+	EmbeddingModel    string    `json:"embeddingModel,omitempty" gorm:"size:128"`
+	Dimensions        int       `json:"dimensions,omitempty"` // 向量维度, 0 表示服务的默认值
+	ChunkSize         int       `json:"chunkSize"`            // 每块的最大字符数
+	ChunkOverlap      int       `json:"chunkOverlap"`         // 相邻两块重叠的字符数
+}
+
+// KnowledgeDocument 知识库中上传的文档, 原文件保存在 storage 中
+type KnowledgeDocument struct {
+	ID              uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt       time.Time `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt       time.Time `json:"updatedAt" gorm:"autoUpdateTime"`
+	KnowledgeBaseID uint      `json:"knowledgeBaseId" gorm:"index"`
+	Name            string    `json:"name" gorm:"size:255"` // 文件名
+	Type            string    `json:"type" gorm:"size:16"`  // pdf, markdown 或 text
+	Size            int64     `json:"size"`
+	StorageKey      string    `json:"-" gorm:"size:512"`
+	Status          string    `json:"status" gorm:"size:16;index"` // processing, ready 或 failed
+	Error           string    `json:"error,omitempty"`
+	ChunkCount      int       `json:"chunkCount"`
+}
+
+// KnowledgeChunk 文档的一个切块及其向量
+type KnowledgeChunk struct {
+	ID              uint   `json:"id" gorm:"primaryKey"`
+	KnowledgeBaseID uint   `json:"knowledgeBaseId" gorm:"index"`
+	DocumentID      uint   `json:"documentId" gorm:"index"`
+	Seq             int    `json:"seq"` // 在文档中的顺序, 从 1 开始
+	Content         string `json:"content" gorm:"type:text"`
+	Embedding       []byte `json:"-"` // little endian float32, 已归一化
+}
+
+// KnowledgeMatch a chunk found by a search
+type KnowledgeMatch struct {
+	Chunk    KnowledgeChunk
+	Document string // the name of the document
+	Score    float64
+}
+
+// Validate checks the chunking of the knowledge base
+func (kb *KnowledgeBase) Validate() error {
+	if kb.Name == "" {
+		return &util.Error{Code: http.StatusBadRequest, Message: "name is required"}
+	}
+	if !embedding.IsRegistered(kb.EmbeddingProvider) {
+		return &util.Error{Code: http.StatusBadRequest, Message: "unknown embedding provider: " + kb.EmbeddingProvider}
+	}
+	if kb.ChunkSize < KnowledgeMinChunkSize || kb.ChunkSize > KnowledgeMaxChunkSize ||
+		kb.ChunkOverlap < 0 || kb.ChunkOverlap > kb.ChunkSize/2 {
+		return ErrKnowledgeInvalidChunkSize
+	}
+	return nil
+}
+
+// EncodeVector encodes the vector of a chunk
+func EncodeVector(v []float32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(x))
+	}
+	return b
+}
+
+// DecodeVector decodes the vector of a chunk
+func DecodeVector(b []byte) []float32 {
+	v := make([]float32, len(b)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return v
+}
+
+// ScopeKnowledgeBases limits the query to the knowledge bases visible to the
+// user: owned by the user or shared to one of the user's groups
+func ScopeKnowledgeBases(db *gorm.DB, user *User) (*gorm.DB, error) {
+	groupIDs, err := GetUserGroupIDs(db, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	tx := db.Model(&KnowledgeBase{})
+	if len(groupIDs) == 0 {
+		return tx.Where("user_id = ?", user.ID), nil
+	}
+	return tx.Where("user_id = ? OR (group_id <> 0 AND group_id IN ?)", user.ID, groupIDs), nil
+}
+
+// ListKnowledgeBases returns all knowledge bases visible to the user
+func ListKnowledgeBases(db *gorm.DB, user *User) ([]KnowledgeBase, error) {
+	tx, err := ScopeKnowledgeBases(db, user)
+	if err != nil {
+		return nil, err
+	}
+	var bases []KnowledgeBase
+	err = tx.Order("updated_at DESC").Find(&bases).Error
+	return bases, err
+}
+
+// GetKnowledgeBase returns the knowledge base if it is visible to the user
+func GetKnowledgeBase(db *gorm.DB, user *User, id uint) (*KnowledgeBase, error) {
+	tx, err := ScopeKnowledgeBases(db, user)
+	if err != nil {
+		return nil, err
+	}
+	var kb KnowledgeBase
+	if err := tx.Where("id = ?", id).Take(&kb).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrKnowledgeBaseNotFound
+		}
+		return nil, err
+	}
+	return &kb, nil
+}
+
+// CanModifyKnowledgeBase only the owner or the admin of the owning group can modify the knowledge base
+func CanModifyKnowledgeBase(db *gorm.DB, user *User, kb *KnowledgeBase) bool {
+	if kb.UserID == user.ID {
+		return true
+	}
+	if kb.GroupID == 0 {
+		return false
+	}
+	return GetGroupRole(db, user.ID, kb.GroupID) == GroupRoleAdmin
+}
+
+// DeleteKnowledgeBase deletes the knowledge base with its documents and chunks,
+// the assistants grounded on it are detached
+func DeleteKnowledgeBase(db *gorm.DB, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("knowledge_base_id = ?", id).Delete(&KnowledgeChunk{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("knowledge_base_id = ?", id).Delete(&KnowledgeDocument{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&Assistant{}).Where("knowledge_base_id = ?", id).Update("knowledge_base_id", 0).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&KnowledgeBase{}, id).Error
+	})
+}
+
+// ListKnowledgeDocuments returns the documents of the knowledge base, newest first
+func ListKnowledgeDocuments(db *gorm.DB, kbID uint) ([]KnowledgeDocument, error) {
+	var docs []KnowledgeDocument
+	err := db.Where("knowledge_base_id = ?", kbID).Order("created_at DESC").Find(&docs).Error
+	return docs, err
+}
+
+// GetKnowledgeDocument returns the document of the knowledge base
+func GetKnowledgeDocument(db *gorm.DB, kbID, id uint) (*KnowledgeDocument, error) {
+	var doc KnowledgeDocument
+	if err := db.Where("id = ? AND knowledge_base_id = ?", id, kbID).Take(&doc).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrKnowledgeDocumentNotFound
+		}
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// DeleteKnowledgeDocument deletes the document with its chunks
+func DeleteKnowledgeDocument(db *gorm.DB, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("document_id = ?", id).Delete(&KnowledgeChunk{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&KnowledgeDocument{}, id).Error
+	})
+}
+
+// SaveKnowledgeChunks replaces the chunks of the document and marks it ready
+func SaveKnowledgeChunks(db *gorm.DB, doc *KnowledgeDocument, chunks []KnowledgeChunk) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("document_id = ?", doc.ID).Delete(&KnowledgeChunk{}).Error; err != nil {
+			return err
+		}
+		if len(chunks) > 0 {
+			if err := tx.CreateInBatches(chunks, 100).Error; err != nil {
+				return err
+			}
+		}
+		doc.Status, doc.Error, doc.ChunkCount = KnowledgeDocumentReady, "", len(chunks)
+		return tx.Model(doc).Select("status", "error", "chunk_count").Updates(doc).Error
+	})
+}
+
+// FailKnowledgeDocument records why the document could not be ingested
+func FailKnowledgeDocument(db *gorm.DB, doc *KnowledgeDocument, reason error) error {
+	doc.Status, doc.Error = KnowledgeDocumentFailed, reason.Error()
+	return db.Model(doc).Select("status", "error").Updates(doc).Error
+}
+
+// SearchKnowledgeChunks returns the topK chunks of the knowledge base closest
+// to the vector, best first. The vectors are normalized, so their dot product
+// is their cosine similarity.
+func SearchKnowledgeChunks(db *gorm.DB, kbID uint, vector []float32, topK int) ([]KnowledgeMatch, error) {
+	type scored struct {
+		id    uint
+		score float64
+	}
+	var best []scored
+	var batch []KnowledgeChunk
+	err := db.Select("id", "embedding").Where("knowledge_base_id = ?", kbID).
+		FindInBatches(&batch, knowledgeScanBatch, func(tx *gorm.DB, _ int) error {
+			for _, c := range batch {
+				v := DecodeVector(c.Embedding)
+				if len(v) != len(vector) {
+					continue
+				}
+				var dot float64
+				for i := range v {
+					dot += float64(v[i]) * float64(vector[i])
+				}
+				if len(best) < topK || dot > best[len(best)-1].score {
+					best = append(best, scored{c.ID, dot})
+					sort.Slice(best, func(i, j int) bool { return best[i].score > best[j].score })
+					best = best[:min(len(best), topK)]
+				}
+			}
+			return nil
+		}).Error
+	if err != nil || len(best) == 0 {
+		return nil, err
+	}
+
+	ids := make([]uint, len(best))
+	for i, b := range best {
+		ids[i] = b.id
+	}
+	var chunks []KnowledgeChunk
+	if err := db.Omit("embedding").Where("id IN ?", ids).Find(&chunks).Error; err != nil {
+		return nil, err
+	}
+	var docs []KnowledgeDocument
+	docIDs := make([]uint, 0, len(chunks))
+	for _, c := range chunks {
+		docIDs = append(docIDs, c.DocumentID)
+	}
+	if err := db.Select("id", "name").Where("id IN ?", docIDs).Find(&docs).Error; err != nil {
+		return nil, err
+	}
+	names := make(map[uint]string, len(docs))
+	for _, d := range docs {
+		names[d.ID] = d.Name
+	}
+	byID := make(map[uint]KnowledgeChunk, len(chunks))
+	for _, c := range chunks {
+		byID[c.ID] = c
+	}
+	matches := make([]KnowledgeMatch, 0, len(best))
+	for _, b := range best {
+		if c, ok := byID[b.id]; ok {
+			matches = append(matches, KnowledgeMatch{Chunk: c, Document: names[c.DocumentID], Score: b.score})
+		}
+	}
+	return matches, nil
+}
@@ -0,0 +1,166 @@
+package models
+
+import (
+	"VoiceSculptor/pkg/schedule"
+	"VoiceSculptor/pkg/util"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrPhoneRouteNotFound = &util.Error{Code: http.StatusNotFound, Message: "no route for the number"}
+var ErrPhoneRouteInvalidNumber = &util.Error{Code: http.StatusBadRequest, Message: "number must be a phone number"}
+var ErrPhoneRouteInvalidAssistant = &util.Error{Code: http.StatusBadRequest, Message: "assistantId is required"}
+var ErrPhoneRouteInvalidHours = &util.Error{Code: http.StatusBadRequest, Message: "businessHours must look like mon-fri 09:00-18:00; sat 10:00-14:00 with a valid timezone"}
+var ErrPhoneRouteInvalidOverrides = &util.Error{Code: http.StatusBadRequest, Message: "callerOverrides must be a JSON array of {caller, assistantId, greeting}"}
+
+// PhoneRoute 呼入号码 (DID) 的路由, 来电时由语音网关查询, 决定接听的助手
+type PhoneRoute struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt     time.Time `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt     time.Time `json:"updatedAt" gorm:"autoUpdateTime"`
+	UserID        uint      `json:"userId" gorm:"index"`                     // 创建者
+	Number        string    `json:"number" gorm:"size:32;uniqueIndex"`       // 呼入号码, 只保留数字和开头的 +
+	Name          string    `json:"name,omitempty" gorm:"size:128"`          // 备注
+	Greeting      string    `json:"greeting,omitempty"`                      // 开场指令, 覆盖助手的 Instruction
+	Timezone      string    `json:"timezone,omitempty" gorm:"size:64"`       // 营业时间的时区, 默认 UTC
+	Disabled      bool      `json:"disabled,omitempty"`                      // 停用后来电转接到备用号码
+	Fallback      string    `json:"fallback,omitempty" gorm:"size:32"`       // 非营业时间或助手不可用时转接的号码
+	BusinessHours string    `json:"businessHours,omitempty" gorm:"size:256"` // 营业时间, 如 mon-fri 09:00-18:00; sat 10:00-14:00, 空表示全天
+
+	AssistantID uint `json:"assistantId" gorm:"index"`
+	// 按来电号码覆盖助手和开场指令, JSON 数组, 按顺序取第一个匹配
+	CallerOverrides string `json:"callerOverrides,omitempty" gorm:"type:text"`
+}
+
+// CallerOverride routes the callers matching Caller, an exact number or a
+// prefix ending with *, the zero fields keep the values of the route
+type CallerOverride struct {
+	Caller      string `json:"caller"`
+	AssistantID uint   `json:"assistantId,omitempty"`
+	Greeting    string `json:"greeting,omitempty"`
+}
+
+func (o CallerOverride) match(caller string) bool {
+	if prefix, ok := strings.CutSuffix(o.Caller, "*"); ok {
+		return strings.HasPrefix(caller, NormalizePhoneNumber(prefix))
+	}
+	return caller == NormalizePhoneNumber(o.Caller)
+}
+
+// PhoneRouteDecision how an incoming call is handled
+type PhoneRouteDecision struct {
+	RouteID     uint   `json:"routeId"`
+	AssistantID uint   `json:"assistantId"`
+	Greeting    string `json:"greeting,omitempty"`
+	Fallback    string `json:"fallback,omitempty"`
+	// Closed the route is disabled or out of its business hours, the call
+	// is transferred to the fallback number, rejected without one
+	Closed bool `json:"closed,omitempty"`
+}
+
+// NormalizePhoneNumber keeps the digits of the number and its leading +
+func NormalizePhoneNumber(number string) string {
+	number = strings.TrimSpace(number)
+	// sip:+8610@host
+	number = strings.TrimPrefix(number, "sip:")
+	if user, _, ok := strings.Cut(number, "@"); ok {
+		number = user
+	}
+	var b strings.Builder
+	for i, r := range number {
+		if (r >= '0' && r <= '9') || (r == '+' && i == 0) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// GetCallerOverrides decodes the caller overrides of the route
+func (r *PhoneRoute) GetCallerOverrides() ([]CallerOverride, error) {
+	var overrides []CallerOverride
+	if r.CallerOverrides == "" {
+		return overrides, nil
+	}
+	err := json.Unmarshal([]byte(r.CallerOverrides), &overrides)
+	return overrides, err
+}
+
+// OpeningHours returns the business hours of the route
+func (r *PhoneRoute) OpeningHours() (*schedule.Hours, error) {
+	return schedule.Parse(r.BusinessHours, r.Timezone)
+}
+
+// Validate normalizes the numbers of the route and checks its rules
+func (r *PhoneRoute) Validate() error {
+	r.Number = NormalizePhoneNumber(r.Number)
+	if strings.TrimPrefix(r.Number, "+") == "" {
+		return ErrPhoneRouteInvalidNumber
+	}
+	r.Fallback = NormalizePhoneNumber(r.Fallback)
+	if r.AssistantID == 0 {
+		return ErrPhoneRouteInvalidAssistant
+	}
+	if _, err := r.OpeningHours(); err != nil {
+		return ErrPhoneRouteInvalidHours
+	}
+	overrides, err := r.GetCallerOverrides()
+	if err != nil {
+		return ErrPhoneRouteInvalidOverrides
+	}
+	for _, o := range overrides {
+		if NormalizePhoneNumber(strings.TrimSuffix(o.Caller, "*")) == "" {
+			return ErrPhoneRouteInvalidOverrides
+		}
+	}
+	return nil
+}
+
+// Decide returns how a call of the caller is handled at the time
+func (r *PhoneRoute) Decide(caller string, now time.Time) (*PhoneRouteDecision, error) {
+	decision := &PhoneRouteDecision{
+		RouteID:     r.ID,
+		AssistantID: r.AssistantID,
+		Greeting:    r.Greeting,
+		Fallback:    r.Fallback,
+	}
+	overrides, err := r.GetCallerOverrides()
+	if err != nil {
+		return nil, ErrPhoneRouteInvalidOverrides
+	}
+	caller = NormalizePhoneNumber(caller)
+	for _, o := range overrides {
+		if !o.match(caller) {
+			continue
+		}
+		if o.AssistantID != 0 {
+			decision.AssistantID = o.AssistantID
+		}
+		if o.Greeting != "" {
+			decision.Greeting = o.Greeting
+		}
+		break
+	}
+	hours, err := r.OpeningHours()
+	if err != nil {
+		return nil, ErrPhoneRouteInvalidHours
+	}
+	decision.Closed = r.Disabled || !hours.Open(now)
+	return decision, nil
+}
+
+// ResolvePhoneRoute returns how a call from the caller to the number is handled
+func ResolvePhoneRoute(db *gorm.DB, number, caller string, now time.Time) (*PhoneRouteDecision, error) {
+	var route PhoneRoute
+	if err := db.Where("number = ?", NormalizePhoneNumber(number)).Take(&route).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPhoneRouteNotFound
+		}
+		return nil, err
+	}
+	return route.Decide(caller, now)
+}
@@ -0,0 +1,10 @@
+package models
+
+import "VoiceSculptor/pkg/prompt"
+
+// PromptModel the prompts are owned by pkg/prompt which renders them
+type PromptModel = prompt.PromptModel
+
+type PromptArgModel = prompt.PromptArgModel
+
+type PromptVersionModel = prompt.PromptVersionModel
@@ -256,6 +256,13 @@ func GetUserByUID(db *gorm.DB, userID uint) (*User, error) {
 	return &val, nil
 }
 
+// ListStaffUserIDs returns the enabled staff users, they take the escalated conversations over
+func ListStaffUserIDs(db *gorm.DB) ([]uint, error) {
+	var ids []uint
+	err := db.Model(&User{}).Where("is_staff", true).Where("enabled", true).Pluck("id", &ids).Error
+	return ids, err
+}
+
 func GetUserByEmail(db *gorm.DB, email string) (user *User, err error) {
 	var val User
 	result := db.Table("users").Where("email", strings.ToLower(email)).Take(&val)
@@ -0,0 +1,209 @@
+package task
+
+import (
+	"VoiceSculptor/internal/models"
+	"VoiceSculptor/pkg/logger"
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// DefaultCampaignInterval how often the scheduler looks for contacts to call
+const DefaultCampaignInterval = 5 * time.Second
+
+// CallResult the outcome of an outbound call, one of models.CallOutcome*
+type CallResult struct {
+	Outcome   string
+	SessionID string // the conversation of an answered call
+}
+
+// Dialer places the call to a contact of a campaign and blocks until it ends.
+// An error without an outcome counts as a failed call.
+type Dialer interface {
+	Dial(ctx context.Context, campaign *models.Campaign, contact *models.CampaignContact) (CallResult, error)
+}
+
+// CampaignScheduler calls the contacts of the running campaigns within their
+// calling windows, the calls of a credential are limited by its CallLimit
+type CampaignScheduler struct {
+	Interval time.Duration
+	Now      func() time.Time
+
+	db     *gorm.DB
+	dialer Dialer
+	mu     sync.Mutex
+	active map[uint]int // calls in progress by credential
+	wg     sync.WaitGroup
+}
+
+func NewCampaignScheduler(db *gorm.DB, dialer Dialer) *CampaignScheduler {
+	return &CampaignScheduler{
+		Interval: DefaultCampaignInterval,
+		Now:      time.Now,
+		db:       db,
+		dialer:   dialer,
+		active:   map[uint]int{},
+	}
+}
+
+// Run calls the contacts until ctx is done, then waits for the calls in progress
+func (s *CampaignScheduler) Run(ctx context.Context) error {
+	// calls of a scheduler which stopped are lost
+	if err := models.ResetCallingContacts(s.db); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		s.Tick(ctx)
+		select {
+		case <-ctx.Done():
+			s.wg.Wait()
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Wait blocks until the calls started by Tick end
+func (s *CampaignScheduler) Wait() {
+	s.wg.Wait()
+}
+
+// Tick starts the calls due now and completes the campaigns without contacts left
+func (s *CampaignScheduler) Tick(ctx context.Context) {
+	var campaigns []models.Campaign
+	if err := s.db.Where("status = ?", models.CampaignStatusRunning).Order("id").Find(&campaigns).Error; err != nil {
+		logger.Warn("load campaigns failed", zap.Error(err))
+		return
+	}
+	for i := range campaigns {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := s.schedule(ctx, &campaigns[i]); err != nil {
+			logger.Warn("schedule campaign failed", zap.Uint("campaignId", campaigns[i].ID), zap.Error(err))
+		}
+	}
+}
+
+func (s *CampaignScheduler) schedule(ctx context.Context, campaign *models.Campaign) error {
+	var left int64
+	err := s.db.Model(&models.CampaignContact{}).
+		Where("campaign_id = ? AND status IN ?", campaign.ID, []string{models.ContactStatusPending, models.ContactStatusCalling}).
+		Count(&left).Error
+	if err != nil {
+		return err
+	}
+	if left == 0 {
+		logger.Info("campaign completed", zap.Uint("campaignId", campaign.ID))
+		return models.SetCampaignStatus(s.db, campaign, models.CampaignStatusCompleted)
+	}
+
+	var credential models.UserCredential
+	if err := s.db.Take(&credential, campaign.CredentialID).Error; err != nil {
+		return err
+	}
+	free := credential.CallLimit() - s.calls(credential.ID)
+	if free <= 0 {
+		return nil
+	}
+
+	now := s.Now()
+	due := s.db.Model(&models.CampaignContact{}).
+		Where("campaign_id = ? AND status = ?", campaign.ID, models.ContactStatusPending).
+		Where("next_attempt_at IS NULL OR next_attempt_at <= ?", now)
+	// the calling window depends on the timezone of the contact
+	var timezones []string
+	if err := due.Session(&gorm.Session{}).Distinct("timezone").Pluck("timezone", &timezones).Error; err != nil {
+		return err
+	}
+	var open []string
+	for _, tz := range timezones {
+		hours, err := campaign.CallingWindow(tz)
+		if err != nil {
+			logger.Warn("invalid calling window", zap.Uint("campaignId", campaign.ID), zap.String("timezone", tz), zap.Error(err))
+			continue
+		}
+		if hours.Open(now) {
+			open = append(open, tz)
+		}
+	}
+	if len(open) == 0 {
+		return nil
+	}
+
+	var contacts []models.CampaignContact
+	err = due.Session(&gorm.Session{}).Where("timezone IN ?", open).Order("id").Limit(free).Find(&contacts).Error
+	if err != nil {
+		return err
+	}
+	for i := range contacts {
+		contact := &contacts[i]
+		// claim the contact, another scheduler may have taken it
+		res := s.db.Model(contact).Where("status = ?", models.ContactStatusPending).
+			Update("status", models.ContactStatusCalling)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			continue
+		}
+		s.acquire(credential.ID)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.release(credential.ID)
+			s.call(ctx, campaign, contact)
+		}()
+	}
+	return nil
+}
+
+// call dials the contact and records the outcome
+func (s *CampaignScheduler) call(ctx context.Context, campaign *models.Campaign, contact *models.CampaignContact) {
+	result, err := s.dialer.Dial(ctx, campaign, contact)
+	if err != nil && ctx.Err() != nil && result.Outcome == "" {
+		// stopped while ringing, the attempt does not count
+		s.db.Model(contact).Update("status", models.ContactStatusPending)
+		return
+	}
+	var message string
+	if err != nil {
+		message = err.Error()
+		if result.Outcome == "" {
+			result.Outcome = models.CallOutcomeFailed
+		}
+	}
+	contact.Record(campaign, result.Outcome, result.SessionID, message, s.Now())
+	logger.Info("campaign call ended", zap.Uint("campaignId", campaign.ID), zap.Uint("contactId", contact.ID),
+		zap.String("outcome", result.Outcome), zap.Int("attempts", contact.Attempts), zap.String("status", contact.Status))
+	err = s.db.Model(contact).Select("status", "outcome", "attempts", "next_attempt_at", "last_attempt_at", "session_id", "error").
+		Updates(contact).Error
+	if err != nil {
+		logger.Warn("save campaign contact failed", zap.Uint("contactId", contact.ID), zap.Error(err))
+	}
+}
+
+func (s *CampaignScheduler) calls(credentialID uint) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active[credentialID]
+}
+
+func (s *CampaignScheduler) acquire(credentialID uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active[credentialID]++
+}
+
+func (s *CampaignScheduler) release(credentialID uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active[credentialID]--; s.active[credentialID] <= 0 {
+		delete(s.active, credentialID)
+	}
+}
@@ -0,0 +1,232 @@
+package task
+
+import (
+	"VoiceSculptor/internal/models"
+	"VoiceSculptor/pkg/logger"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestMain(m *testing.M) {
+	dir, _ := os.MkdirTemp("", "task")
+	_ = logger.Init(&logger.LogConfig{Level: "error", Filename: filepath.Join(dir, "task.log")}, "test")
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// fakeDialer answers with the scripted outcomes of each number, answered once the script ends
+type fakeDialer struct {
+	mu       sync.Mutex
+	outcomes map[string][]string
+	dialed   []string
+	greeting []string
+	active   int
+	peak     int
+	release  chan struct{}
+}
+
+func (d *fakeDialer) Dial(ctx context.Context, campaign *models.Campaign, contact *models.CampaignContact) (CallResult, error) {
+	greeting, err := campaign.RenderGreeting(contact)
+	if err != nil {
+		return CallResult{}, err
+	}
+	d.mu.Lock()
+	d.dialed = append(d.dialed, contact.Number)
+	d.greeting = append(d.greeting, greeting)
+	outcome := models.CallOutcomeAnswered
+	if script := d.outcomes[contact.Number]; len(script) > 0 {
+		outcome, d.outcomes[contact.Number] = script[0], script[1:]
+	}
+	d.active++
+	d.peak = max(d.peak, d.active)
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		d.active--
+		d.mu.Unlock()
+	}()
+	if d.release != nil {
+		select {
+		case <-d.release:
+		case <-ctx.Done():
+			return CallResult{}, ctx.Err()
+		}
+	}
+	switch outcome {
+	case models.CallOutcomeAnswered:
+		return CallResult{Outcome: outcome, SessionID: "session-" + contact.Number}, nil
+	case models.CallOutcomeFailed:
+		return CallResult{}, errors.New("pbx unreachable")
+	}
+	return CallResult{Outcome: outcome}, errors.New(outcome)
+}
+
+func setupCampaign(t *testing.T, campaign *models.Campaign, csv string, concurrency int) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "task.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.UserCredential{}, &models.Campaign{}, &models.CampaignContact{}))
+	credential := models.UserCredential{UserID: 1, APIKey: "key", APISecret: "secret", CallConcurrency: concurrency}
+	require.NoError(t, db.Create(&credential).Error)
+
+	campaign.UserID, campaign.AssistantID, campaign.CredentialID = 1, 1, credential.ID
+	require.NoError(t, campaign.Validate())
+	campaign.Status = models.CampaignStatusRunning
+	require.NoError(t, db.Create(campaign).Error)
+	contacts, err := models.ParseCampaignContacts(strings.NewReader(csv))
+	require.NoError(t, err)
+	require.NoError(t, models.AddCampaignContacts(db, campaign.ID, contacts))
+	return db
+}
+
+func contactsOf(t *testing.T, db *gorm.DB, campaignID uint) map[string]models.CampaignContact {
+	var contacts []models.CampaignContact
+	require.NoError(t, db.Where("campaign_id = ?", campaignID).Find(&contacts).Error)
+	byNumber := map[string]models.CampaignContact{}
+	for _, c := range contacts {
+		byNumber[c.Number] = c
+	}
+	return byNumber
+}
+
+func TestCampaignScheduler_Retry(t *testing.T) {
+	campaign := &models.Campaign{Name: "reminder", Greeting: "Remind {{.name}} of {{.time}}", MaxAttempts: 2, RetryDelaySeconds: 60}
+	db := setupCampaign(t, campaign, "Name,Phone,Time\nAlice,+86 138-0000-0001,10:00\nBob,+8613800000002,11:00\nCarol,+8613800000003,12:00\n", 3)
+	dialer := &fakeDialer{outcomes: map[string][]string{
+		"+8613800000002": {models.CallOutcomeBusy},
+		"+8613800000003": {models.CallOutcomeNoAnswer, models.CallOutcomeNoAnswer},
+	}}
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	s := NewCampaignScheduler(db, dialer)
+	s.Now = func() time.Time { return now }
+
+	s.Tick(context.Background())
+	s.Wait()
+	contacts := contactsOf(t, db, campaign.ID)
+	assert.Equal(t, models.ContactStatusCompleted, contacts["+8613800000001"].Status)
+	assert.Equal(t, "session-+8613800000001", contacts["+8613800000001"].SessionID)
+	bob := contacts["+8613800000002"]
+	assert.Equal(t, models.ContactStatusPending, bob.Status)
+	assert.Equal(t, models.CallOutcomeBusy, bob.Outcome)
+	assert.Equal(t, 1, bob.Attempts)
+	assert.Equal(t, now.Add(time.Minute), bob.NextAttemptAt.UTC())
+	assert.Contains(t, dialer.greeting, "Remind Alice of 10:00")
+
+	// nothing is due before the retry delay
+	s.Tick(context.Background())
+	s.Wait()
+	assert.Len(t, dialer.dialed, 3)
+
+	now = now.Add(time.Minute)
+	s.Tick(context.Background())
+	s.Wait()
+	contacts = contactsOf(t, db, campaign.ID)
+	assert.Equal(t, models.ContactStatusCompleted, contacts["+8613800000002"].Status)
+	carol := contacts["+8613800000003"]
+	assert.Equal(t, models.ContactStatusFailed, carol.Status)
+	assert.Equal(t, 2, carol.Attempts)
+	assert.Nil(t, carol.NextAttemptAt)
+
+	s.Tick(context.Background())
+	require.NoError(t, db.Take(campaign, campaign.ID).Error)
+	assert.Equal(t, models.CampaignStatusCompleted, campaign.Status)
+	assert.NotNil(t, campaign.CompletedAt)
+}
+
+func TestCampaignScheduler_CallingWindow(t *testing.T) {
+	campaign := &models.Campaign{Name: "survey", CallingHours: "mon-fri 09:00-18:00", Timezone: "Asia/Shanghai"}
+	db := setupCampaign(t, campaign, "number,timezone\n+8613800000001,\n+12125550100,America/New_York\n", 2)
+	dialer := &fakeDialer{}
+	// 10:00 in Shanghai, 21:00 in New York
+	now := time.Date(2024, 1, 1, 2, 0, 0, 0, time.UTC)
+	s := NewCampaignScheduler(db, dialer)
+	s.Now = func() time.Time { return now }
+
+	s.Tick(context.Background())
+	s.Wait()
+	assert.Equal(t, []string{"+8613800000001"}, dialer.dialed)
+
+	// 22:00 in Shanghai, 09:00 in New York
+	now = time.Date(2024, 1, 1, 14, 0, 0, 0, time.UTC)
+	s.Tick(context.Background())
+	s.Wait()
+	assert.Equal(t, []string{"+8613800000001", "+12125550100"}, dialer.dialed)
+}
+
+func TestCampaignScheduler_Concurrency(t *testing.T) {
+	campaign := &models.Campaign{Name: "limited"}
+	db := setupCampaign(t, campaign, "number\n1001\n1002\n1003\n1004\n1005\n", 2)
+	dialer := &fakeDialer{release: make(chan struct{})}
+	s := NewCampaignScheduler(db, dialer)
+
+	s.Tick(context.Background())
+	s.Tick(context.Background())
+	require.Eventually(t, func() bool {
+		dialer.mu.Lock()
+		defer dialer.mu.Unlock()
+		return dialer.active == 2
+	}, time.Second, 10*time.Millisecond)
+	var calling int64
+	db.Model(&models.CampaignContact{}).Where("status = ?", models.ContactStatusCalling).Count(&calling)
+	assert.EqualValues(t, 2, calling)
+
+	close(dialer.release)
+	for range 3 {
+		s.Wait()
+		s.Tick(context.Background())
+	}
+	s.Wait()
+	assert.Equal(t, 2, dialer.peak)
+	assert.Len(t, dialer.dialed, 5)
+}
+
+func TestCampaignScheduler_Stop(t *testing.T) {
+	campaign := &models.Campaign{Name: "stopped"}
+	db := setupCampaign(t, campaign, "number\n1001\n", 1)
+	dialer := &fakeDialer{release: make(chan struct{})}
+	s := NewCampaignScheduler(db, dialer)
+	s.Interval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+	require.Eventually(t, func() bool {
+		dialer.mu.Lock()
+		defer dialer.mu.Unlock()
+		return dialer.active == 1
+	}, time.Second, 10*time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+
+	// the interrupted call does not count as an attempt
+	contact := contactsOf(t, db, campaign.ID)["1001"]
+	assert.Equal(t, models.ContactStatusPending, contact.Status)
+	assert.Zero(t, contact.Attempts)
+}
+
+func TestParseCampaignContacts(t *testing.T) {
+	_, err := models.ParseCampaignContacts(strings.NewReader("name\nAlice\n"))
+	assert.Error(t, err)
+	_, err = models.ParseCampaignContacts(strings.NewReader("number\nabc\n"))
+	assert.Error(t, err)
+	_, err = models.ParseCampaignContacts(strings.NewReader("number,timezone\n1001,Mars/Olympus\n"))
+	assert.Error(t, err)
+
+	contacts, err := models.ParseCampaignContacts(strings.NewReader("\ufeffNumber, Name, Plan\n+1 (212) 555-0100, Alice, gold\n"))
+	require.NoError(t, err)
+	require.Len(t, contacts, 1)
+	assert.Equal(t, "+12125550100", contacts[0].Number)
+	assert.Equal(t, "Alice", contacts[0].Name)
+	assert.JSONEq(t, `{"plan":"gold"}`, contacts[0].Variables)
+}
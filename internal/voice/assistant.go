@@ -0,0 +1,138 @@
+package voice
+
+import (
+	"VoiceSculptor/internal/chat"
+	"VoiceSculptor/internal/models"
+	"VoiceSculptor/pkg/asr"
+	"VoiceSculptor/pkg/llm"
+	"VoiceSculptor/pkg/logger"
+	"VoiceSculptor/pkg/tts"
+	"context"
+	"errors"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// LoadAssistant loads the assistant with its system prompt rendered and its tools
+func LoadAssistant(db *gorm.DB, id uint) (*models.Assistant, error) {
+	var assistant models.Assistant
+	if err := db.Take(&assistant, id).Error; err != nil {
+		return nil, err
+	}
+	var err error
+	if assistant.SystemPrompt, err = assistant.RenderSystemPrompt(db); err != nil {
+		return nil, err
+	}
+	if assistant.Tools, err = models.ListAssistantTools(db, id); err != nil {
+		return nil, err
+	}
+	return &assistant, nil
+}
+
+// AssistantOptions returns the defaults answered by the assistant, the
+// greeting instruction is appended to its system prompt
+func AssistantOptions(defaults CallOptions, assistant *models.Assistant, greeting string) CallOptions {
+	opts := defaults
+	opts.Options.UserID = assistant.UserID
+	opts.Options.AssistantID = assistant.ID
+	opts.Options.SystemPrompt = assistant.SystemPrompt
+	opts.Options.Temperature = assistant.Temperature
+	opts.Options.MaxTokens = assistant.MaxTokens
+	opts.Options.Tools = assistant.Toolset()
+	opts.Options.Memory = &chat.Memory{Turns: assistant.MemoryTurns, ContextTokens: assistant.ContextTokens}
+	opts.Options.Knowledge = nil
+	if opts.Knowledge != nil {
+		opts.Options.Knowledge = opts.Knowledge(assistant)
+	}
+	opts.Options.Escalation = nil
+	if opts.Escalation != nil {
+		opts.Options.Escalation = opts.Escalation(assistant)
+	}
+	if greeting != "" {
+		opts.Options.SystemPrompt += "\n\n" + greeting
+	}
+	if opts.VAD != nil {
+		vadConfig := assistant.VADConfig()
+		opts.VAD = &vadConfig
+	}
+	menu, err := assistant.Menu()
+	if err != nil {
+		logger.Warn("invalid ivr menu", zap.Uint("assistantId", assistant.ID), zap.Error(err))
+	}
+	opts.IVR = menu
+	return opts
+}
+
+// AssistantResolver returns a CallOptions.ResolveAssistant loading the
+// assistants the key menus hand off to from the database
+func AssistantResolver(db *gorm.DB, defaults CallOptions) func(assistantID uint) (chat.Options, error) {
+	return func(assistantID uint) (chat.Options, error) {
+		assistant, err := LoadAssistant(db, assistantID)
+		if err != nil {
+			return chat.Options{}, err
+		}
+		return AssistantOptions(defaults, assistant, "").Options, nil
+	}
+}
+
+// CredentialOptions returns opts answered with the providers of a credential
+// of the owner of the assistant, the credential of credentialID or the most
+// recently updated one when 0. The providers the credential leaves unset, or
+// all of them if the owner has no credential, keep those of the gateway.
+// The tokens of the call are charged to the credential, an error is returned
+// once its quota is used up.
+func CredentialOptions(db *gorm.DB, opts CallOptions, credentialID uint) (CallOptions, error) {
+	var credential *models.UserCredential
+	var err error
+	if credentialID == 0 {
+		credential, err = models.GetLatestUserCredential(db, opts.Options.UserID)
+		if errors.Is(err, models.ErrCredentialNotFound) {
+			return opts, nil
+		}
+	} else {
+		credential, err = models.GetUserCredential(db, opts.Options.UserID, credentialID)
+	}
+	if err != nil {
+		return opts, err
+	}
+	if err := credential.CheckQuota(); err != nil {
+		return opts, err
+	}
+	opts.Options.CredentialID = credential.ID
+	onUsage := opts.Options.OnUsage
+	opts.Options.OnUsage = func(usage llm.Usage) {
+		if err := models.AddCredentialUsage(db, credential.ID, usage.TotalTokens); err != nil {
+			logger.Warn("add credential usage failed", zap.Uint("credentialId", credential.ID), zap.Error(err))
+		}
+		if onUsage != nil {
+			onUsage(usage)
+		}
+	}
+	if credential.LLMProvider != "" {
+		if opts.Provider, err = credential.NewLLMProvider(); err != nil {
+			return opts, err
+		}
+	}
+	if credential.AsrProvider != "" {
+		recognizer, err := credential.NewASRProvider()
+		if err != nil {
+			return opts, err
+		}
+		opts.NewTranscriber = func() (Transcriber, error) {
+			return recognizer.NewStream(context.Background(), asr.Options{SampleRate: SampleRate})
+		}
+	}
+	if credential.TtsProvider != "" {
+		synthesizer, err := credential.NewTTSProvider()
+		if err != nil {
+			return opts, err
+		}
+		speech := opts.Speech
+		speech.SampleRate = SampleRate
+		opts.NewSynthesizer = func() (Synthesizer, error) {
+			return tts.WithOptions(synthesizer, speech), nil
+		}
+	}
+	return opts, nil
+}
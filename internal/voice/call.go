@@ -0,0 +1,959 @@
+package voice
+
+import (
+	"VoiceSculptor/internal/chat"
+	"VoiceSculptor/internal/models"
+	"VoiceSculptor/pkg/codec"
+	"VoiceSculptor/pkg/dtmf"
+	"VoiceSculptor/pkg/ivr"
+	"VoiceSculptor/pkg/jitter"
+	"VoiceSculptor/pkg/llm"
+	"VoiceSculptor/pkg/logger"
+	"VoiceSculptor/pkg/tts"
+	"VoiceSculptor/pkg/vad"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"go.uber.org/zap"
+)
+
+const (
+	inboundQueueSize  = 50 // 1s of audio
+	sentenceQueueSize = 16
+	// iceUser the user of the TURN credentials of the gateway
+	iceUser = "voiceSculptor"
+)
+
+type sentence struct {
+	ctx   context.Context
+	text  string
+	reply *reply
+	after func() // called once the sentence is spoken, skipped or interrupted
+}
+
+// output the local track sending the negotiated codec
+type output struct {
+	codec   codec.Codec
+	track   *webrtc.TrackLocalStaticSample
+	ssrc    uint32        // the SSRC of the track, the caller reports about it over RTCP
+	encoder codec.Encoder // only used by the speak goroutine
+}
+
+// Call a call answered by an assistant, each call runs its own pipeline:
+// remote track -> decode -> ASR -> chat -> TTS -> encode -> local track.
+// A call bridged from a Media leg has no WebSocket nor peer connection,
+// the PCM of the leg goes straight to the stages.
+type Call struct {
+	ID string
+
+	gateway *Gateway
+	ws      *websocket.Conn // nil for a bridged call
+	wsMu    sync.Mutex
+	pc      *webrtc.PeerConnection // nil for a bridged call
+	out     atomic.Pointer[output] // set once the offer is answered
+	leg     Media                  // nil for a WebRTC call
+	options CallOptions
+	conv    *chat.Conversation
+	asr     Transcriber
+	tts     Synthesizer
+	vad     *vad.Detector  // only used by the recognize goroutine
+	keys    *dtmf.Detector // in band keys, only used by the recognize goroutine
+
+	// outOfBand is set once the caller sends its keys as events, the in band
+	// detection then stops so that a key is not reported twice
+	outOfBand atomic.Bool
+	menuMu    sync.Mutex
+	menu      *ivr.Session // the key menu, nil without one
+	menuTimer *time.Timer  // waits for the next key of the menu
+	menuGen   int          // numbers the steps of the menu, a stale timer is ignored
+
+	jitter atomic.Pointer[jitter.Buffer] // the caller audio, nil for a bridged call
+	rtcpMu sync.Mutex
+	remote remoteReport // guarded by rtcpMu
+
+	sessionLog *models.ChatSessionLog // nil without Config.DB
+	rec        *recording             // nil without Config.Recordings
+	userMu     sync.Mutex             // serializes the user turns
+	userTurn   *Transcript            // the transcript being sent, guarded by userMu
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	inbound   chan []byte
+	sentences chan sentence
+
+	speakMu      sync.Mutex
+	speakCtx     context.Context
+	stopSpeaking context.CancelFunc
+	pending      []byte    // PCM not yet filling a frame
+	nextFrame    time.Time // pacing of the outbound frames
+
+	signalMu          sync.Mutex
+	pendingCandidates []webrtc.ICECandidateInit
+	greet             sync.Once
+}
+
+func (g *Gateway) newCall(ctx context.Context, ws *websocket.Conn, opts *CallOptions) (*Call, error) {
+	pc, err := g.api.NewPeerConnection(webrtc.Configuration{ICEServers: g.config.ICE.WebRTCServers(iceUser, time.Now())})
+	if err != nil {
+		return nil, err
+	}
+	c := g.call(ctx, opts)
+	c.ws, c.pc = ws, pc
+	if err := c.start(); err != nil {
+		pc.Close()
+		return nil, err
+	}
+
+	c.pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate == nil {
+			return
+		}
+		init := candidate.ToJSON()
+		c.send(Message{Type: MessageCandidate, Candidate: &init})
+	})
+	c.pc.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		if track.Kind() != webrtc.RTPCodecTypeAudio {
+			return
+		}
+		c.wg.Add(2)
+		go c.readTrack(track, receiver)
+		go c.readRTCP(receiver.ReadRTCP)
+	})
+	c.pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		logger.Info("voice call state changed", zap.String("sessionId", c.ID), zap.String("state", state.String()))
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			c.greet.Do(c.sayHello)
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			c.cancel()
+		}
+	})
+	return c, nil
+}
+
+func (g *Gateway) newBridgedCall(ctx context.Context, leg Media, opts *CallOptions) (*Call, error) {
+	c := g.call(ctx, opts)
+	c.leg = leg
+	if err := c.start(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// call creates a call answered with the options, those of the Config when nil
+func (g *Gateway) call(ctx context.Context, opts *CallOptions) *Call {
+	if opts == nil {
+		opts = &CallOptions{
+			Options:          g.config.Options,
+			VAD:              g.config.VAD,
+			IVR:              g.config.IVR,
+			ResolveAssistant: g.config.ResolveAssistant,
+		}
+	}
+	c := &Call{
+		gateway:   g,
+		options:   *opts,
+		inbound:   make(chan []byte, inboundQueueSize),
+		sentences: make(chan sentence, sentenceQueueSize),
+	}
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	c.speakCtx, c.stopSpeaking = context.WithCancel(c.ctx)
+	return c
+}
+
+// start creates the stages and the conversation of the call
+func (c *Call) start() (err error) {
+	g := c.gateway
+	defer func() {
+		if err != nil {
+			c.closeTranscriber()
+			c.cancel()
+		}
+	}()
+	if c.options.VAD != nil {
+		c.vad = vad.New(*c.options.VAD, SampleRate)
+	}
+	if g.config.DetectDTMF {
+		c.keys = dtmf.NewDetector(SampleRate)
+	}
+	if c.options.IVR != nil {
+		c.menu = ivr.NewSession(c.options.IVR)
+	}
+	newTranscriber := c.options.NewTranscriber
+	if newTranscriber == nil {
+		newTranscriber = g.config.NewTranscriber
+	}
+	if newTranscriber != nil {
+		if c.asr, err = newTranscriber(); err != nil {
+			return err
+		}
+	}
+	newSynthesizer := c.options.NewSynthesizer
+	if newSynthesizer == nil {
+		newSynthesizer = g.config.NewSynthesizer
+	}
+	if newSynthesizer != nil {
+		if c.tts, err = newSynthesizer(); err != nil {
+			return err
+		}
+	}
+	opts := c.options.Options
+	onTurn, onClose := opts.OnTurn, opts.OnClose
+	opts.OnTurn = func(turn chat.Turn) {
+		c.onTurn(turn)
+		if onTurn != nil {
+			onTurn(turn)
+		}
+	}
+	opts.OnClose = func() {
+		c.endSessionLog()
+		if onClose != nil {
+			onClose()
+		}
+	}
+	provider := c.options.Provider
+	if provider == nil {
+		provider = g.config.Provider
+	}
+	c.conv = g.engine.Start(provider, opts)
+	c.ID = c.conv.ID
+	if g.config.DB != nil {
+		c.sessionLog = &models.ChatSessionLog{
+			SessionID:    c.ID,
+			UserID:       opts.UserID,
+			AssistantID:  opts.AssistantID,
+			CredentialID: opts.CredentialID,
+		}
+		if err := models.CreateChatSessionLog(g.config.DB, c.sessionLog); err != nil {
+			c.sessionLog = nil
+			_ = g.engine.Stop(c.ID)
+			return err
+		}
+	}
+	if g.config.Recordings != nil {
+		// the call goes on without a recording
+		if c.rec, err = newRecording(); err != nil {
+			logger.Warn("create call recording failed", zap.String("sessionId", c.ID), zap.Error(err))
+			err = nil
+		}
+	}
+	if c.options.OnStart != nil {
+		c.options.OnStart(c.ID)
+	}
+	return nil
+}
+
+// sayHello plays the key menu, or lets the assistant open the conversation without one
+func (c *Call) sayHello() {
+	if c.menu != nil {
+		// a key may already have ended the menu
+		if step, gen, ok := c.navigate(0, (*ivr.Session).Start); ok {
+			c.runMenu(step, gen)
+		}
+		return
+	}
+	if err := c.conv.Greet(); err != nil {
+		c.sendError(err)
+	}
+}
+
+// run starts the pipeline and blocks until the call ends
+func (c *Call) run() error {
+	c.wg.Add(4)
+	if c.leg != nil {
+		go c.readMedia()
+		if keys, ok := c.leg.(KeySource); ok {
+			c.wg.Add(1)
+			go c.readKeys(keys)
+		}
+		c.greet.Do(c.sayHello)
+	} else {
+		c.wg.Add(1)
+		go c.readSignals()
+		go c.reportStats()
+	}
+	go c.recognize()
+	go c.respond()
+	go c.speak()
+
+	<-c.ctx.Done()
+	c.teardown()
+	logger.Info("voice call ended", zap.String("sessionId", c.ID))
+	return nil
+}
+
+// teardown releases the resources of the call, unblocking every goroutine
+func (c *Call) teardown() {
+	c.stopSpeaking()
+	c.menuMu.Lock()
+	if c.menuTimer != nil {
+		c.menuTimer.Stop()
+	}
+	c.menuMu.Unlock()
+	if c.pc != nil {
+		if err := c.pc.Close(); err != nil {
+			logger.Warn("close peer connection failed", zap.String("sessionId", c.ID), zap.Error(err))
+		}
+	}
+	if c.leg != nil {
+		if err := c.leg.Close(); err != nil {
+			logger.Warn("close media failed", zap.String("sessionId", c.ID), zap.Error(err))
+		}
+	}
+	_ = c.gateway.engine.Stop(c.conv.ID)
+	c.closeTranscriber()
+	if c.ws != nil {
+		c.ws.Close()
+	}
+	c.wg.Wait()
+	c.saveRecording()
+	c.saveStats()
+}
+
+func (c *Call) closeTranscriber() {
+	if c.asr != nil {
+		_ = c.asr.Close()
+	}
+}
+
+// readSignals handles the signalling messages of the caller
+func (c *Call) readSignals() {
+	defer c.wg.Done()
+	defer c.cancel()
+	for {
+		var msg Message
+		if err := c.ws.ReadJSON(&msg); err != nil {
+			if c.ctx.Err() == nil && !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logger.Warn("read signalling message failed", zap.String("sessionId", c.ID), zap.Error(err))
+			}
+			return
+		}
+		switch msg.Type {
+		case MessageOffer:
+			if err := c.answer(msg.SDP); err != nil {
+				c.sendError(err)
+			}
+		case MessageCandidate:
+			if msg.Candidate != nil {
+				if err := c.addCandidate(*msg.Candidate); err != nil {
+					c.sendError(err)
+				}
+			}
+		case MessageText:
+			c.userSaid(msg.Text, nil)
+		case MessageDTMF:
+			if !dtmf.IsKey(msg.Text) {
+				c.sendError(errors.New("unknown key: " + msg.Text))
+				continue
+			}
+			c.outOfBand.Store(true)
+			c.keyPressed(msg.Text)
+		case MessageHangup:
+			return
+		default:
+			c.sendError(errors.New("unknown message type: " + msg.Type))
+		}
+	}
+}
+
+func (c *Call) answer(sdp string) error {
+	offer := webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: sdp}
+	if c.out.Load() == nil {
+		if err := c.addOutput(offer); err != nil {
+			return err
+		}
+	}
+	err := c.pc.SetRemoteDescription(offer)
+	if err != nil {
+		return err
+	}
+	answer, err := c.pc.CreateAnswer(nil)
+	if err != nil {
+		return err
+	}
+	if err := c.pc.SetLocalDescription(answer); err != nil {
+		return err
+	}
+	c.send(Message{Type: MessageAnswer, SessionID: c.ID, SDP: answer.SDP, Codec: c.out.Load().codec.Name})
+
+	c.signalMu.Lock()
+	candidates := c.pendingCandidates
+	c.pendingCandidates = nil
+	c.signalMu.Unlock()
+	for _, candidate := range candidates {
+		if err := c.pc.AddICECandidate(candidate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addOutput negotiates the codec of the offer and adds the local track sending it,
+// the caller may still send any codec of the answer, see readTrack
+func (c *Call) addOutput(offer webrtc.SessionDescription) error {
+	offered, err := offeredCodecs(offer)
+	if err != nil {
+		return err
+	}
+	chosen, err := codec.Negotiate(offered, c.gateway.config.Codecs)
+	if err != nil {
+		return err
+	}
+	encoder, err := codec.NewEncoder(chosen.Name)
+	if err != nil {
+		return err
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(codecParameters(chosen).RTPCodecCapability, "audio", "voiceSculptor")
+	if err != nil {
+		return err
+	}
+	sender, err := c.pc.AddTrack(track)
+	if err != nil {
+		return err
+	}
+	out := &output{codec: chosen, track: track, encoder: encoder}
+	if encodings := sender.GetParameters().Encodings; len(encodings) > 0 {
+		out.ssrc = uint32(encodings[0].SSRC)
+	}
+	c.out.Store(out)
+	c.wg.Add(1)
+	go c.readRTCP(sender.ReadRTCP)
+	logger.Info("voice call codec negotiated", zap.String("sessionId", c.ID), zap.String("codec", chosen.Name))
+	return nil
+}
+
+// offeredCodecs returns the audio codecs of the offer, in the order of the offer
+func offeredCodecs(offer webrtc.SessionDescription) ([]string, error) {
+	parsed, err := offer.Unmarshal()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, m := range parsed.MediaDescriptions {
+		if m.MediaName.Media != "audio" {
+			continue
+		}
+		for _, format := range m.MediaName.Formats {
+			pt, err := strconv.ParseUint(format, 10, 8)
+			if err != nil {
+				continue
+			}
+			if sc, err := parsed.GetCodecForPayloadType(uint8(pt)); err == nil {
+				names = append(names, sc.Name)
+			}
+		}
+	}
+	return names, nil
+}
+
+// addCandidate adds a trickled candidate, candidates arriving before the offer are kept
+func (c *Call) addCandidate(candidate webrtc.ICECandidateInit) error {
+	c.signalMu.Lock()
+	if c.pc.RemoteDescription() == nil {
+		c.pendingCandidates = append(c.pendingCandidates, candidate)
+		c.signalMu.Unlock()
+		return nil
+	}
+	c.signalMu.Unlock()
+	return c.pc.AddICECandidate(candidate)
+}
+
+// readTrack decodes the caller audio into PCM frames at SampleRate. The
+// packets are reordered by a jitter buffer and the lost ones concealed, the
+// telephone events of the track are the keys pressed by the caller.
+func (c *Call) readTrack(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+	defer c.wg.Done()
+	// the codec of a packet follows its payload type
+	mimeTypes := map[uint8]string{}
+	for _, params := range receiver.GetParameters().Codecs {
+		mimeTypes[uint8(params.PayloadType)] = params.MimeType
+	}
+	buffer := jitter.New(jitter.Config{ClockRate: track.Codec().ClockRate})
+	c.jitter.Store(buffer)
+	var (
+		in      codec.Codec
+		decoder codec.Decoder
+		events  dtmf.Receiver
+		plc     codec.Concealer
+	)
+	deliver := func(pcm []byte) {
+		select {
+		case c.inbound <- pcm:
+		default:
+			// the ASR stage is lagging, drop the frame rather than the call
+		}
+	}
+	for {
+		// the read returns once the packet waited for is late
+		deadline, _ := buffer.Deadline()
+		if err := track.SetReadDeadline(deadline); err != nil {
+			return
+		}
+		packet, _, err := track.ReadRTP()
+		var netErr net.Error
+		if err != nil && !(errors.As(err, &netErr) && netErr.Timeout()) {
+			return
+		}
+		now := time.Now()
+		if err == nil {
+			buffer.Push(packet, now)
+		}
+		for {
+			packet, ok := buffer.Pop(now)
+			if !ok {
+				break
+			}
+			if packet == nil {
+				if pcm := plc.Conceal(); len(pcm) > 0 {
+					deliver(pcm)
+				}
+				continue
+			}
+			mimeType, ok := mimeTypes[packet.PayloadType]
+			if !ok {
+				mimeType = track.Codec().MimeType
+			}
+			if strings.EqualFold(mimeType, dtmf.MimeType) {
+				if digit, ok := events.Receive(packet.Timestamp, packet.Payload); ok {
+					c.outOfBand.Store(true)
+					c.keyPressed(digit)
+				}
+				continue
+			}
+			if decoder == nil || !strings.EqualFold(mimeType, in.MimeType()) {
+				if in, ok = codec.Lookup(mimeType); !ok {
+					logger.Warn("unsupported remote codec", zap.String("sessionId", c.ID), zap.String("codec", mimeType))
+					return
+				}
+				if decoder, err = codec.NewDecoder(in.Name); err != nil {
+					return
+				}
+			}
+			pcm, err := decoder.Decode(packet.Payload)
+			if err != nil {
+				continue
+			}
+			pcm = codec.Resample(pcm, in.SampleRate, SampleRate)
+			plc.Received(pcm)
+			deliver(pcm)
+		}
+	}
+}
+
+// readMedia reads the caller audio of a bridged call, the call ends with the leg
+func (c *Call) readMedia() {
+	defer c.wg.Done()
+	defer c.cancel()
+	for {
+		pcm, err := c.leg.ReadFrame()
+		if err != nil {
+			return
+		}
+		select {
+		case c.inbound <- pcm:
+		default:
+			// the ASR stage is lagging, drop the frame rather than the call
+		}
+	}
+}
+
+// readKeys reads the keys a bridged leg reports out of band
+func (c *Call) readKeys(keys KeySource) {
+	defer c.wg.Done()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case digit := <-keys.Keys():
+			if !dtmf.IsKey(digit) {
+				continue
+			}
+			c.outOfBand.Store(true)
+			c.keyPressed(digit)
+		}
+	}
+}
+
+// recognize feeds the caller audio to the ASR stage
+func (c *Call) recognize() {
+	defer c.wg.Done()
+	if c.asr != nil {
+		c.wg.Add(1)
+		go c.readTranscripts()
+	}
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case pcm := <-c.inbound:
+			if c.keys != nil && !c.outOfBand.Load() {
+				for _, digit := range c.keys.Process(pcm) {
+					c.keyPressed(digit)
+				}
+			}
+			c.detect(pcm)
+			if c.rec != nil {
+				c.rec.writeCaller(pcm)
+			}
+			if c.asr == nil {
+				continue
+			}
+			if err := c.asr.Write(pcm); err != nil {
+				logger.Warn("write asr failed", zap.String("sessionId", c.ID), zap.Error(err))
+			}
+		}
+	}
+}
+
+// detect runs the VAD, the caller talking over the assistant interrupts it
+func (c *Call) detect(pcm []byte) {
+	if c.vad == nil {
+		return
+	}
+	ev := c.vad.Process(pcm)
+	switch ev {
+	case vad.SpeechStart:
+		c.send(Message{Type: MessageSpeech, Text: ev.String()})
+		// the prompts of the menu are only interrupted by a key
+		if !c.inMenu() {
+			c.bargeIn()
+		}
+	case vad.SpeechEnd:
+		c.send(Message{Type: MessageSpeech, Text: ev.String()})
+		if c.asr != nil {
+			if err := c.asr.Finalize(); err != nil {
+				logger.Warn("finalize asr failed", zap.String("sessionId", c.ID), zap.Error(err))
+			}
+		}
+	}
+}
+
+// bargeIn stops the reply being generated and spoken
+func (c *Call) bargeIn() {
+	c.interrupt()
+	c.conv.Cancel()
+}
+
+func (c *Call) readTranscripts() {
+	defer c.wg.Done()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case t, ok := <-c.asr.Results():
+			if !ok {
+				return
+			}
+			c.send(Message{Type: MessageTranscript, Text: t.Text, Final: t.Final})
+			if t.Final {
+				c.userSaid(t.Text, &t)
+			}
+		}
+	}
+}
+
+// userSaid interrupts the assistant and sends the utterance to the conversation,
+// the transcript is nil for typed text. The caller only presses keys while in the menu.
+func (c *Call) userSaid(text string, transcript *Transcript) {
+	text = strings.TrimSpace(text)
+	if text == "" || c.inMenu() {
+		return
+	}
+	c.interrupt()
+	c.userMu.Lock()
+	defer c.userMu.Unlock()
+	c.userTurn = transcript
+	if err := c.conv.Send(text); err != nil {
+		c.sendError(err)
+	}
+	c.userTurn = nil
+}
+
+// onTurn persists the turn, the user turns are recorded by Send while userMu is held
+func (c *Call) onTurn(turn chat.Turn) {
+	if c.rec != nil && turn.Role == llm.RoleUser && c.userTurn != nil {
+		c.rec.addUserTurn(turn.Seq, c.userTurn.Start, c.userTurn.End)
+	}
+	if c.sessionLog == nil {
+		return
+	}
+	opts := c.options.Options
+	if turn.AssistantID != 0 {
+		opts.AssistantID = turn.AssistantID
+	}
+	record := &models.ChatSessionTurn{
+		SessionLogID:     c.sessionLog.ID,
+		Seq:              turn.Seq,
+		Role:             turn.Role,
+		Content:          turn.Content,
+		LatencyMs:        turn.LatencyMs,
+		PromptTokens:     turn.Usage.PromptTokens,
+		CompletionTokens: turn.Usage.CompletionTokens,
+		TotalTokens:      turn.Usage.TotalTokens,
+		Interrupted:      turn.Interrupted,
+		AssistantID:      opts.AssistantID,
+		CredentialID:     opts.CredentialID,
+		AgentID:          turn.AgentID,
+	}
+	if turn.Tool != nil {
+		record.ToolCallID, record.ToolName = turn.Tool.ID, turn.Tool.Name
+		record.ToolArguments, record.ToolError = turn.Tool.Arguments, turn.Tool.Error
+	}
+	if err := models.AppendChatSessionTurn(c.gateway.config.DB, record); err != nil {
+		logger.Warn("append chat session turn failed", zap.String("sessionId", c.ID), zap.Error(err))
+	}
+}
+
+func (c *Call) endSessionLog() {
+	if c.sessionLog == nil {
+		return
+	}
+	if err := models.EndChatSessionLog(c.gateway.config.DB, c.sessionLog.ID); err != nil {
+		logger.Warn("end chat session log failed", zap.String("sessionId", c.ID), zap.Error(err))
+	}
+}
+
+// saveRecording uploads the recording of the call and its clips, and links them from the transcript
+func (c *Call) saveRecording() {
+	if c.rec == nil {
+		return
+	}
+	defer c.rec.close()
+	store, db := c.gateway.config.Recordings, c.gateway.config.DB
+	upload := func(seq int, wav io.Reader) (string, error) {
+		key := models.ChatRecordingKey(c.ID, seq)
+		if err := store.Write(key, wav); err != nil {
+			return "", err
+		}
+		return store.PublicURL(key), nil
+	}
+
+	var url string
+	stereo, err := c.rec.stereo()
+	if err == nil {
+		url, err = upload(0, stereo)
+	}
+	if err != nil {
+		logger.Warn("save call recording failed", zap.String("sessionId", c.ID), zap.Error(err))
+		return
+	}
+	if c.sessionLog != nil {
+		if err := models.SetChatSessionRecording(db, c.sessionLog.ID, url); err != nil {
+			logger.Warn("link call recording failed", zap.String("sessionId", c.ID), zap.Error(err))
+		}
+	}
+	for seq, s := range c.rec.clips() {
+		clip, err := c.rec.clip(s)
+		if err == nil {
+			url, err = upload(seq, clip)
+		}
+		if err != nil {
+			logger.Warn("save turn recording failed", zap.String("sessionId", c.ID), zap.Int("seq", seq), zap.Error(err))
+			continue
+		}
+		if c.sessionLog != nil {
+			if err := models.SetChatSessionTurnAudio(db, c.sessionLog.ID, seq, url); err != nil {
+				logger.Warn("link turn recording failed", zap.String("sessionId", c.ID), zap.Int("seq", seq), zap.Error(err))
+			}
+		}
+	}
+}
+
+// interrupt drops the reply being spoken
+func (c *Call) interrupt() {
+	c.speakMu.Lock()
+	c.stopSpeaking()
+	c.speakCtx, c.stopSpeaking = context.WithCancel(c.ctx)
+	c.pending = nil
+	c.speakMu.Unlock()
+}
+
+// respond relays the reply of the assistant to the caller, the reply is
+// synthesized sentence by sentence
+func (c *Call) respond() {
+	defer c.wg.Done()
+	var buf strings.Builder
+	current := &reply{}
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-c.conv.Done():
+			return
+		case ev := <-c.conv.Events():
+			switch data := ev.Data.(type) {
+			case chat.TokenData:
+				c.send(Message{Type: MessageReply, Text: data.Content})
+				buf.WriteString(data.Content)
+				text := buf.String()
+				if i := strings.LastIndexAny(text, tts.SentenceEnds); i >= 0 {
+					c.enqueue(text[:i+1], current, nil)
+					buf.Reset()
+					buf.WriteString(text[i+1:])
+				}
+			case chat.DoneData:
+				if !data.Interrupted {
+					c.enqueue(buf.String(), current, nil)
+				}
+				buf.Reset()
+				current.setSeq(data.Seq)
+				if c.rec != nil {
+					c.rec.addReply(current)
+				}
+				current = &reply{}
+				c.send(Message{Type: MessageDone, Text: data.Content})
+			case chat.ErrorData:
+				buf.Reset()
+				current = &reply{}
+				c.send(Message{Type: MessageError, Message: data.Message})
+			}
+		}
+	}
+}
+
+// enqueue queues a sentence to speak, it reports false if the sentence is
+// not spoken, after is then never called
+func (c *Call) enqueue(text string, rep *reply, after func()) bool {
+	text = strings.TrimSpace(text)
+	if text == "" || c.tts == nil {
+		return false
+	}
+	c.speakMu.Lock()
+	ctx := c.speakCtx
+	c.speakMu.Unlock()
+	select {
+	case c.sentences <- sentence{ctx: ctx, text: text, reply: rep, after: after}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// speak synthesizes the queued sentences to the local track
+func (c *Call) speak() {
+	defer c.wg.Done()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case s := <-c.sentences:
+			if s.ctx.Err() == nil {
+				c.synthesize(s)
+			}
+			if s.after != nil {
+				// after may queue the next sentence
+				go s.after()
+			}
+		}
+	}
+}
+
+func (c *Call) synthesize(s sentence) {
+	err := c.tts.Synthesize(s.ctx, s.text, func(pcm []byte) error {
+		return c.play(s.ctx, pcm, s.reply)
+	})
+	if err == nil {
+		err = c.flush(s.ctx, s.reply)
+	}
+	if err != nil && s.ctx.Err() == nil {
+		logger.Warn("synthesize failed", zap.String("sessionId", c.ID), zap.Error(err))
+	}
+}
+
+// play encodes the PCM into frames written to the local track in real time,
+// the audio is dropped while no codec is negotiated
+func (c *Call) play(ctx context.Context, pcm []byte, rep *reply) error {
+	out := c.out.Load()
+	if out == nil && c.leg == nil {
+		return nil
+	}
+	c.speakMu.Lock()
+	data := append(c.pending, pcm...)
+	c.pending = nil
+	c.speakMu.Unlock()
+
+	for len(data) >= FrameSize {
+		if err := c.pace(ctx); err != nil {
+			return err
+		}
+		if err := c.writeFrame(out, data[:FrameSize]); err != nil {
+			return err
+		}
+		if c.rec != nil {
+			rep.extend(c.rec.writeAssistant(data[:FrameSize]))
+		}
+		data = data[FrameSize:]
+	}
+
+	c.speakMu.Lock()
+	if ctx.Err() == nil {
+		c.pending = append(c.pending, data...)
+	}
+	c.speakMu.Unlock()
+	return ctx.Err()
+}
+
+func (c *Call) writeFrame(out *output, frame []byte) error {
+	if c.leg != nil {
+		return c.leg.WriteFrame(frame)
+	}
+	payload, err := out.encoder.Encode(codec.Resample(frame, SampleRate, out.codec.SampleRate))
+	if err != nil {
+		return err
+	}
+	return out.track.WriteSample(media.Sample{Data: payload, Duration: FrameDuration})
+}
+
+// flush pads the remaining PCM with silence to a full frame and plays it
+func (c *Call) flush(ctx context.Context, rep *reply) error {
+	c.speakMu.Lock()
+	n := len(c.pending)
+	c.speakMu.Unlock()
+	if n == 0 {
+		return nil
+	}
+	return c.play(ctx, make([]byte, FrameSize-n), rep)
+}
+
+// pace waits until the next frame is due
+func (c *Call) pace(ctx context.Context) error {
+	now := time.Now()
+	if c.nextFrame.Before(now) {
+		c.nextFrame = now
+	}
+	timer := time.NewTimer(time.Until(c.nextFrame))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+	}
+	c.nextFrame = c.nextFrame.Add(FrameDuration)
+	return nil
+}
+
+// send a signalling message to the caller, bridged calls have no signalling
+func (c *Call) send(msg Message) {
+	if c.ws == nil {
+		return
+	}
+	c.wsMu.Lock()
+	defer c.wsMu.Unlock()
+	_ = c.ws.WriteJSON(msg)
+}
+
+func (c *Call) sendError(err error) {
+	c.send(Message{Type: MessageError, Message: err.Error()})
+}
@@ -0,0 +1,165 @@
+package voice
+
+import (
+	"VoiceSculptor/internal/chat"
+	"VoiceSculptor/internal/models"
+	"VoiceSculptor/pkg/codec"
+	"VoiceSculptor/pkg/dtmf"
+	"VoiceSculptor/pkg/ice"
+	"VoiceSculptor/pkg/ivr"
+	"VoiceSculptor/pkg/llm"
+	stores "VoiceSculptor/pkg/storage"
+	"VoiceSculptor/pkg/tts"
+	"VoiceSculptor/pkg/vad"
+	"context"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v3"
+	"gorm.io/gorm"
+)
+
+// Config the settings shared by the calls of a gateway
+type Config struct {
+	// ICE the STUN and TURN servers of the peer connections, the NAT 1:1 IPs
+	// and the UDP port range of the worker
+	ICE ice.Config
+	// codec names by preference, all supported codecs best quality first when empty
+	Codecs []string
+
+	// the assistant answering the calls
+	Provider llm.Provider
+	Options  chat.Options
+	// the key menu of the calls answered with Options, see CallOptions
+	IVR              *ivr.Menu
+	ResolveAssistant func(assistantID uint) (chat.Options, error)
+
+	// optional stages, without ASR only text messages reach the assistant,
+	// without TTS the replies are only sent as text
+	NewTranscriber func() (Transcriber, error)
+	NewSynthesizer func() (Synthesizer, error)
+
+	// voice activity detection of the caller, the assistant is interrupted
+	// when the caller starts talking (barge-in) and the end of speech ends
+	// the utterance of the ASR stage. Disabled when nil.
+	VAD *vad.Config
+	// DetectDTMF detects the keys pressed in the caller audio, for the callers
+	// which do not send them as telephone events
+	DetectDTMF bool
+
+	// DB persists the transcript of each call as a ChatSessionLog of the
+	// user and assistant of Options, optional
+	DB *gorm.DB
+	// Recordings stores a stereo WAV of each call, caller left and assistant
+	// right, and a clip per turn once the call ends, calls are not recorded when nil.
+	// The audio is written to a temporary file as the call runs.
+	Recordings stores.Store
+}
+
+// CallOptions the assistant answering a call, such as the assistant of the
+// route of the number called
+type CallOptions struct {
+	Options chat.Options
+	VAD     *vad.Config
+	// IVR the key menu played before the assistant takes the call, none when nil
+	IVR *ivr.Menu
+	// ResolveAssistant returns the settings of the assistant a key menu hands
+	// the call off to, the call stays with its assistant when nil
+	ResolveAssistant func(assistantID uint) (chat.Options, error)
+	// Knowledge returns the knowledge base grounding the replies of an
+	// assistant, the replies are not grounded when nil
+	Knowledge func(assistant *models.Assistant) chat.Knowledge
+	// Escalation returns the escalation of the calls of an assistant to a
+	// human agent, the calls are not escalated when nil
+	Escalation func(assistant *models.Assistant) *chat.Escalation
+	// Provider the llm answering the call, that of the Config when nil
+	Provider llm.Provider
+	// NewTranscriber and NewSynthesizer the ASR and TTS stages of the call,
+	// those of the Config when nil
+	NewTranscriber func() (Transcriber, error)
+	NewSynthesizer func() (Synthesizer, error)
+	// Speech the voice, speed and volume of the TTS providers of the credentials
+	Speech tts.Options
+	// OnStart is called with the session ID once the conversation of the call started
+	OnStart func(sessionID string)
+}
+
+// Gateway bridges WebRTC calls and Media legs to the chat engine
+type Gateway struct {
+	config Config
+	engine *chat.Engine
+	api    *webrtc.API
+}
+
+func NewGateway(engine *chat.Engine, config Config) (*Gateway, error) {
+	mediaEngine := &webrtc.MediaEngine{}
+	for _, name := range config.Codecs {
+		if _, ok := codec.Lookup(name); !ok {
+			return nil, fmt.Errorf("unsupported codec: %s", name)
+		}
+	}
+	for _, c := range codec.Supported() {
+		err := mediaEngine.RegisterCodec(codecParameters(c), webrtc.RTPCodecTypeAudio)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// the keys pressed by the caller, RFC 4733
+	err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: dtmf.MimeType, ClockRate: 8000, SDPFmtpLine: "0-15"},
+		PayloadType:        dtmf.DefaultPayloadType,
+	}, webrtc.RTPCodecTypeAudio)
+	if err != nil {
+		return nil, err
+	}
+	// sender and receiver reports, the reports of the caller measure the round trip
+	registry := &interceptor.Registry{}
+	if err := webrtc.ConfigureRTCPReports(registry); err != nil {
+		return nil, err
+	}
+	var settings webrtc.SettingEngine
+	if err := config.ICE.Configure(&settings); err != nil {
+		return nil, err
+	}
+	return &Gateway{
+		config: config,
+		engine: engine,
+		api: webrtc.NewAPI(
+			webrtc.WithMediaEngine(mediaEngine),
+			webrtc.WithInterceptorRegistry(registry),
+			webrtc.WithSettingEngine(settings),
+		),
+	}, nil
+}
+
+// Serve runs a call signalled over the WebSocket until the caller hangs up,
+// the WebSocket is closed when the call ends.
+// The call is answered with the assistant of the Config when opts is nil.
+func (g *Gateway) Serve(ctx context.Context, ws *websocket.Conn, opts *CallOptions) error {
+	call, err := g.newCall(ctx, ws, opts)
+	if err != nil {
+		ws.Close()
+		return err
+	}
+	return call.run()
+}
+
+// Bridge runs a call over a leg connected elsewhere, such as a call answered
+// on the PBX, until the leg ends, the leg is closed when the call ends.
+// The call is answered with the assistant of the Config when opts is nil.
+func (g *Gateway) Bridge(ctx context.Context, leg Media, opts *CallOptions) error {
+	call, err := g.newBridgedCall(ctx, leg, opts)
+	if err != nil {
+		leg.Close()
+		return err
+	}
+	return call.run()
+}
+
+func codecParameters(c codec.Codec) webrtc.RTPCodecParameters {
+	return webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: c.MimeType(), ClockRate: c.ClockRate, Channels: 1},
+		PayloadType:        webrtc.PayloadType(c.PayloadType),
+	}
+}
@@ -0,0 +1,780 @@
+package voice
+
+import (
+	"VoiceSculptor/internal/chat"
+	"VoiceSculptor/internal/models"
+	"VoiceSculptor/pkg/asr"
+	"VoiceSculptor/pkg/codec"
+	"VoiceSculptor/pkg/dtmf"
+	"VoiceSculptor/pkg/ivr"
+	"VoiceSculptor/pkg/llm"
+	"VoiceSculptor/pkg/logger"
+	stores "VoiceSculptor/pkg/storage"
+	"VoiceSculptor/pkg/tts"
+	"VoiceSculptor/pkg/vad"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestMain(m *testing.M) {
+	dir, _ := os.MkdirTemp("", "voice")
+	_ = logger.Init(&logger.LogConfig{Level: "error", Filename: filepath.Join(dir, "voice.log")}, "test")
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// stubTranscriber reports a final transcript once it has heard finalAt frames, never if 0
+type stubTranscriber struct {
+	finalAt   int32
+	frames    atomic.Int32
+	finalized atomic.Int32
+	results   chan Transcript
+	once      sync.Once
+}
+
+func newStubTranscriber(finalAt int32) *stubTranscriber {
+	return &stubTranscriber{finalAt: finalAt, results: make(chan Transcript, 1)}
+}
+
+func (s *stubTranscriber) Write(pcm []byte) error {
+	if s.frames.Add(1) == s.finalAt {
+		s.results <- Transcript{Text: "heard you", Final: true, End: time.Duration(s.finalAt) * FrameDuration}
+	}
+	return nil
+}
+
+func (s *stubTranscriber) Finalize() error {
+	s.finalized.Add(1)
+	return nil
+}
+
+func (s *stubTranscriber) Results() <-chan Transcript { return s.results }
+
+func (s *stubTranscriber) Close() error {
+	s.once.Do(func() { close(s.results) })
+	return nil
+}
+
+// stubSynthesizer renders 100ms of a square wave per sentence
+type stubSynthesizer struct{}
+
+func (stubSynthesizer) Synthesize(ctx context.Context, text string, out func(pcm []byte) error) error {
+	pcm := make([]byte, FrameSize*5)
+	for i := 0; i < len(pcm); i += 2 {
+		if (i/2/8)%2 == 0 {
+			pcm[i+1] = 0x20
+		}
+	}
+	return out(pcm)
+}
+
+type testCaller struct {
+	t        *testing.T
+	ws       *websocket.Conn
+	pc       *webrtc.PeerConnection
+	messages chan Message
+	received atomic.Int32
+	codec    atomic.Value // the codec of the answer
+	talking  atomic.Bool  // send a tone instead of silence
+}
+
+// newTestCaller offers the codecs in order of preference and sends silence with the first
+func newTestCaller(t *testing.T, url string, codecs ...codec.Codec) *testCaller {
+	return newTestCallerOver(t, url, nil, codecs...)
+}
+
+// newTestCallerOver is newTestCaller sending its packets through the network, if any
+func newTestCallerOver(t *testing.T, url string, network interceptor.Factory, codecs ...codec.Codec) *testCaller {
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+
+	mediaEngine := &webrtc.MediaEngine{}
+	for _, c := range codecs {
+		require.NoError(t, mediaEngine.RegisterCodec(codecParameters(c), webrtc.RTPCodecTypeAudio))
+	}
+	registry := &interceptor.Registry{}
+	if network != nil {
+		registry.Add(network)
+	}
+	pc, err := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine), webrtc.WithInterceptorRegistry(registry)).NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+
+	caller := &testCaller{t: t, ws: ws, pc: pc, messages: make(chan Message, 256)}
+	track, err := webrtc.NewTrackLocalStaticSample(codecParameters(codecs[0]).RTPCodecCapability, "audio", "caller")
+	require.NoError(t, err)
+	encoder, err := codec.NewEncoder(codecs[0].Name)
+	require.NoError(t, err)
+	silence := make([]byte, codecs[0].FrameSize(FrameDuration))
+	tone := make([]byte, len(silence))
+	for i := 0; i < len(tone); i += 2 {
+		// a 500 Hz square wave
+		if (i/2*1000/codecs[0].SampleRate)%2 == 0 {
+			tone[i+1] = 0x20
+		} else {
+			tone[i+1] = 0xe0
+		}
+	}
+	_, err = pc.AddTrack(track)
+	require.NoError(t, err)
+
+	pc.OnTrack(func(remote *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		for {
+			if _, _, err := remote.ReadRTP(); err != nil {
+				return
+			}
+			caller.received.Add(1)
+		}
+	})
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state != webrtc.PeerConnectionStateConnected {
+			return
+		}
+		go func() {
+			// 10s at most
+			for i := 0; i < 500; i++ {
+				pcm := silence
+				if caller.talking.Load() {
+					pcm = tone
+				}
+				payload, err := encoder.Encode(pcm)
+				if err != nil || track.WriteSample(media.Sample{Data: payload, Duration: FrameDuration}) != nil {
+					return
+				}
+				time.Sleep(FrameDuration)
+			}
+		}()
+	})
+
+	offer, err := pc.CreateOffer(nil)
+	require.NoError(t, err)
+	gathered := webrtc.GatheringCompletePromise(pc)
+	require.NoError(t, pc.SetLocalDescription(offer))
+	<-gathered
+	require.NoError(t, ws.WriteJSON(Message{Type: MessageOffer, SDP: pc.LocalDescription().SDP}))
+
+	go func() {
+		defer close(caller.messages)
+		for {
+			var msg Message
+			if err := ws.ReadJSON(&msg); err != nil {
+				return
+			}
+			switch msg.Type {
+			case MessageAnswer:
+				caller.codec.Store(msg.Codec)
+				_ = pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: msg.SDP})
+			case MessageCandidate:
+				_ = pc.AddICECandidate(*msg.Candidate)
+			default:
+				caller.messages <- msg
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		pc.Close()
+		ws.Close()
+	})
+	return caller
+}
+
+// waitSpeech returns the text of the next speech message
+func (c *testCaller) waitSpeech() string {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg, ok := <-c.messages:
+			if !ok {
+				c.t.Fatal("signalling closed")
+			}
+			if msg.Type == MessageSpeech {
+				return msg.Text
+			}
+		case <-timeout:
+			c.t.Fatal("no voice activity detected")
+		}
+	}
+}
+
+// waitDone returns the text of the next done message
+func (c *testCaller) waitDone() string {
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case msg, ok := <-c.messages:
+			if !ok {
+				c.t.Fatal("signalling closed")
+			}
+			require.NotEqual(c.t, MessageError, msg.Type, msg.Message)
+			if msg.Type == MessageDone {
+				return msg.Text
+			}
+		case <-timeout:
+			c.t.Fatal("no reply received")
+		}
+	}
+}
+
+func newTestServer(t *testing.T, gateway *Gateway) (url string, served chan error) {
+	served = make(chan error, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		served <- gateway.Serve(context.Background(), ws, nil)
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http"), served
+}
+
+func TestGateway_Call(t *testing.T) {
+	asr := newStubTranscriber(10)
+	gateway, err := NewGateway(chat.NewEngine(60), Config{
+		Provider:       &llm.FakeProvider{},
+		NewTranscriber: func() (Transcriber, error) { return asr, nil },
+		NewSynthesizer: func() (Synthesizer, error) { return stubSynthesizer{}, nil },
+	})
+	require.NoError(t, err)
+
+	url, served := newTestServer(t, gateway)
+	pcmu, _ := codec.Lookup(codec.PCMU)
+	g722, _ := codec.Lookup(codec.G722)
+	caller := newTestCaller(t, url, pcmu, g722)
+
+	// the assistant greets once connected, then answers the transcript of the caller audio
+	assert.Equal(t, llm.FakeGreeting, caller.waitDone())
+	assert.Equal(t, "Echo: heard you", caller.waitDone())
+	assert.GreaterOrEqual(t, asr.frames.Load(), int32(10))
+	// the best codec both sides support wins over the preference of the caller
+	assert.Equal(t, codec.G722, caller.codec.Load())
+
+	require.NoError(t, caller.ws.WriteJSON(Message{Type: MessageText, Text: "typed"}))
+	assert.Equal(t, "Echo: typed", caller.waitDone())
+	assert.Eventually(t, func() bool { return caller.received.Load() > 0 }, 5*time.Second, 20*time.Millisecond)
+
+	require.NoError(t, caller.ws.WriteJSON(Message{Type: MessageHangup}))
+	select {
+	case err := <-served:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("call not ended after hangup")
+	}
+}
+
+func TestGateway_Negotiate(t *testing.T) {
+	gateway, err := NewGateway(chat.NewEngine(60), Config{
+		Provider:       &llm.FakeProvider{},
+		Codecs:         []string{codec.PCMA, codec.PCMU},
+		NewSynthesizer: func() (Synthesizer, error) { return stubSynthesizer{}, nil },
+	})
+	require.NoError(t, err)
+	url, _ := newTestServer(t, gateway)
+
+	pcmu, _ := codec.Lookup(codec.PCMU)
+	pcma, _ := codec.Lookup(codec.PCMA)
+	caller := newTestCaller(t, url, pcmu, pcma)
+	assert.Equal(t, llm.FakeGreeting, caller.waitDone())
+	assert.Equal(t, codec.PCMA, caller.codec.Load())
+	assert.Eventually(t, func() bool { return caller.received.Load() > 0 }, 5*time.Second, 20*time.Millisecond)
+
+	_, err = NewGateway(chat.NewEngine(60), Config{Codecs: []string{"opus"}})
+	assert.Error(t, err)
+}
+
+func TestGateway_NoCommonCodec(t *testing.T) {
+	gateway, err := NewGateway(chat.NewEngine(60), Config{Provider: &llm.FakeProvider{}, Codecs: []string{codec.G722}})
+	require.NoError(t, err)
+	url, _ := newTestServer(t, gateway)
+
+	pcmu, _ := codec.Lookup(codec.PCMU)
+	caller := newTestCaller(t, url, pcmu)
+	select {
+	case msg := <-caller.messages:
+		assert.Equal(t, MessageError, msg.Type)
+		assert.Contains(t, msg.Message, codec.ErrNoCommonCodec.Error())
+	case <-time.After(5 * time.Second):
+		t.Fatal("no error received")
+	}
+}
+
+func TestGateway_BargeIn(t *testing.T) {
+	asr := newStubTranscriber(0)
+	gateway, err := NewGateway(chat.NewEngine(60), Config{
+		Provider:       &llm.FakeProvider{Delay: 200 * time.Millisecond},
+		NewTranscriber: func() (Transcriber, error) { return asr, nil },
+		NewSynthesizer: func() (Synthesizer, error) { return stubSynthesizer{}, nil },
+		VAD:            &vad.Config{MinSpeech: 40 * time.Millisecond, MinSilence: 100 * time.Millisecond},
+	})
+	require.NoError(t, err)
+	url, _ := newTestServer(t, gateway)
+
+	pcmu, _ := codec.Lookup(codec.PCMU)
+	caller := newTestCaller(t, url, pcmu)
+	assert.Equal(t, llm.FakeGreeting, caller.waitDone())
+
+	// talking over the reply interrupts it
+	require.NoError(t, caller.ws.WriteJSON(Message{Type: MessageText, Text: "tell me a long story"}))
+	caller.talking.Store(true)
+	assert.Equal(t, vad.SpeechStart.String(), caller.waitSpeech())
+	assert.NotEqual(t, "Echo: tell me a long story", caller.waitDone())
+
+	// the end of speech ends the utterance of the ASR stage
+	caller.talking.Store(false)
+	assert.Equal(t, vad.SpeechEnd.String(), caller.waitSpeech())
+	assert.Eventually(t, func() bool { return asr.finalized.Load() == 1 }, time.Second, 10*time.Millisecond)
+}
+
+func TestGateway_Recording(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "voice.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.ChatSessionLog{}, &models.ChatSessionTurn{}))
+	store := &stores.LocalStore{Root: t.TempDir()}
+
+	// the greeting is played before the caller is heard after 1s
+	gateway, err := NewGateway(chat.NewEngine(60), Config{
+		Provider:       &llm.FakeProvider{},
+		NewTranscriber: func() (Transcriber, error) { return newStubTranscriber(50), nil },
+		NewSynthesizer: func() (Synthesizer, error) { return stubSynthesizer{}, nil },
+		Options:        chat.Options{UserID: 1},
+		DB:             db,
+		Recordings:     store,
+	})
+	require.NoError(t, err)
+	url, served := newTestServer(t, gateway)
+	pcmu, _ := codec.Lookup(codec.PCMU)
+	caller := newTestCaller(t, url, pcmu)
+	assert.Equal(t, llm.FakeGreeting, caller.waitDone())
+	assert.Equal(t, "Echo: heard you", caller.waitDone())
+	// let the reply play
+	time.Sleep(300 * time.Millisecond)
+	require.NoError(t, caller.ws.WriteJSON(Message{Type: MessageHangup}))
+	select {
+	case err := <-served:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("call not ended after hangup")
+	}
+
+	read := func(seq int, sessionID string) *codec.WAV {
+		r, _, err := store.Read(models.ChatRecordingKey(sessionID, seq))
+		require.NoError(t, err)
+		defer r.Close()
+		wav, err := codec.ReadWAV(r)
+		require.NoError(t, err)
+		return wav
+	}
+	var log models.ChatSessionLog
+	require.NoError(t, db.Take(&log).Error)
+	log2, err := models.GetChatSessionLog(db, 1, log.ID)
+	require.NoError(t, err)
+	assert.Equal(t, store.PublicURL(models.ChatRecordingKey(log.SessionID, 0)), log2.RecordingURL)
+
+	// caller left, assistant right, at least the second the caller was heard
+	call := read(0, log.SessionID)
+	assert.Equal(t, 2, call.Channels)
+	assert.Equal(t, SampleRate, call.SampleRate)
+	assert.GreaterOrEqual(t, len(call.Data), FrameSize*50*2)
+
+	require.Len(t, log2.Turns, 3)
+	for _, turn := range log2.Turns {
+		assert.Equal(t, store.PublicURL(models.ChatRecordingKey(log.SessionID, turn.Seq)), turn.AudioURL, turn.Role)
+		clip := read(turn.Seq, log.SessionID)
+		assert.Equal(t, 1, clip.Channels)
+		if turn.Role == llm.RoleUser {
+			// the audio of the transcript
+			assert.Len(t, clip.Data, FrameSize*50)
+		} else {
+			// the frames played for the reply
+			assert.Equal(t, 0, len(clip.Data)%FrameSize)
+			assert.NotEmpty(t, clip.Data)
+		}
+	}
+}
+
+// lossyNetwork loses the drop-th packet of the caller and swaps the swap-th with the next
+type lossyNetwork struct {
+	interceptor.NoOp
+	drop, swap int32
+	sent       atomic.Int32
+	held       *rtp.Packet // only used by the sending goroutine
+}
+
+func (n *lossyNetwork) NewInterceptor(string) (interceptor.Interceptor, error) {
+	return n, nil
+}
+
+func (n *lossyNetwork) BindLocalStream(_ *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		switch n.sent.Add(1) {
+		case n.drop:
+			return len(payload), nil
+		case n.swap:
+			n.held = &rtp.Packet{Header: header.Clone(), Payload: append([]byte(nil), payload...)}
+			return len(payload), nil
+		}
+		written, err := writer.Write(header, payload, attributes)
+		if n.held != nil {
+			_, _ = writer.Write(&n.held.Header, n.held.Payload, attributes)
+			n.held = nil
+		}
+		return written, err
+	})
+}
+
+func TestGateway_MediaStats(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "voice.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.ChatSessionLog{}, &models.ChatSessionTurn{}))
+	asr := newStubTranscriber(0)
+	gateway, err := NewGateway(chat.NewEngine(60), Config{
+		Provider:       &llm.FakeProvider{},
+		NewTranscriber: func() (Transcriber, error) { return asr, nil },
+		Options:        chat.Options{UserID: 1},
+		DB:             db,
+	})
+	require.NoError(t, err)
+
+	url, served := newTestServer(t, gateway)
+	pcmu, _ := codec.Lookup(codec.PCMU)
+	network := &lossyNetwork{drop: 20, swap: 30}
+	caller := newTestCallerOver(t, url, network, pcmu)
+	assert.Equal(t, llm.FakeGreeting, caller.waitDone())
+	assert.Eventually(t, func() bool { return network.sent.Load() > 40 }, 5*time.Second, 20*time.Millisecond)
+	require.NoError(t, caller.ws.WriteJSON(Message{Type: MessageHangup}))
+	select {
+	case err := <-served:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("call not ended after hangup")
+	}
+
+	// the lost packet is concealed, the swapped one reordered
+	var log models.ChatSessionLog
+	require.NoError(t, db.Take(&log).Error)
+	require.NotNil(t, log.MediaStats)
+	assert.Equal(t, codec.PCMU, log.MediaStats.Codec)
+	assert.Equal(t, uint64(1), log.MediaStats.PacketsLost)
+	assert.Zero(t, log.MediaStats.PacketsLate)
+	assert.GreaterOrEqual(t, log.MediaStats.PacketsReceived, uint64(39))
+	assert.GreaterOrEqual(t, log.MediaStats.JitterBufferMs, int64(20))
+	assert.GreaterOrEqual(t, int64(asr.frames.Load()), int64(log.MediaStats.PacketsReceived))
+}
+
+// stubMedia a bridged leg sending silence and counting the frames played
+type stubMedia struct {
+	played atomic.Int32
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (m *stubMedia) ReadFrame() ([]byte, error) {
+	select {
+	case <-m.closed:
+		return nil, errors.New("media closed")
+	case <-time.After(FrameDuration):
+		return make([]byte, FrameSize), nil
+	}
+}
+
+func (m *stubMedia) WriteFrame(pcm []byte) error {
+	if len(pcm) != FrameSize {
+		return errors.New("not a frame")
+	}
+	m.played.Add(1)
+	return nil
+}
+
+func (m *stubMedia) Close() error {
+	m.once.Do(func() { close(m.closed) })
+	return nil
+}
+
+func TestGateway_Bridge(t *testing.T) {
+	asr := newStubTranscriber(10)
+	gateway, err := NewGateway(chat.NewEngine(60), Config{
+		Provider:       &llm.FakeProvider{},
+		NewTranscriber: func() (Transcriber, error) { return asr, nil },
+		NewSynthesizer: func() (Synthesizer, error) { return stubSynthesizer{}, nil },
+	})
+	require.NoError(t, err)
+
+	leg := &stubMedia{closed: make(chan struct{})}
+	served := make(chan error, 1)
+	go func() { served <- gateway.Bridge(context.Background(), leg, nil) }()
+
+	// the greeting and the answer to the caller are played on the leg
+	assert.Eventually(t, func() bool { return asr.frames.Load() >= 10 }, 5*time.Second, 20*time.Millisecond)
+	assert.Eventually(t, func() bool { return leg.played.Load() >= 10 }, 5*time.Second, 20*time.Millisecond)
+
+	// the call ends with the leg
+	leg.Close()
+	select {
+	case err := <-served:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("call not ended with the leg")
+	}
+}
+
+// keyedMedia a bridged leg reporting the keys out of band, playing the tones
+// queued in band, and which can be transferred
+type keyedMedia struct {
+	stubMedia
+	keys        chan string
+	tones       chan []byte
+	transferred chan string
+}
+
+func newKeyedMedia() *keyedMedia {
+	return &keyedMedia{
+		stubMedia:   stubMedia{closed: make(chan struct{})},
+		keys:        make(chan string, 1),
+		tones:       make(chan []byte, 64),
+		transferred: make(chan string, 1),
+	}
+}
+
+func (m *keyedMedia) ReadFrame() ([]byte, error) {
+	select {
+	case pcm := <-m.tones:
+		return pcm, nil
+	default:
+		return m.stubMedia.ReadFrame()
+	}
+}
+
+func (m *keyedMedia) Keys() <-chan string { return m.keys }
+
+func (m *keyedMedia) Transfer(target string) error {
+	m.transferred <- target
+	return m.Close()
+}
+
+// press plays the tones of the key followed by silence
+func (m *keyedMedia) press(t *testing.T, digit string) {
+	pcm, err := dtmf.Generate(digit, SampleRate, 100*time.Millisecond)
+	require.NoError(t, err)
+	pcm = append(pcm, make([]byte, FrameSize*3)...)
+	for len(pcm) >= FrameSize {
+		m.tones <- pcm[:FrameSize]
+		pcm = pcm[FrameSize:]
+	}
+}
+
+// turnRecorder collects the turns of a conversation
+type turnRecorder struct {
+	mu    sync.Mutex
+	turns []chat.Turn
+}
+
+func (r *turnRecorder) record(turn chat.Turn) {
+	r.mu.Lock()
+	r.turns = append(r.turns, turn)
+	r.mu.Unlock()
+}
+
+// wait returns the turns once there are n of them
+func (r *turnRecorder) wait(t *testing.T, n int) []chat.Turn {
+	require.Eventually(t, func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return len(r.turns) >= n
+	}, 5*time.Second, 10*time.Millisecond)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]chat.Turn(nil), r.turns...)
+}
+
+const testMenu = `{
+	"prompt": "Press 1 for sales, 0 for an operator.",
+	"options": [
+		{"digit": "1", "action": "assistant", "assistantId": 2, "say": "Connecting you to sales."},
+		{"digit": "0", "action": "transfer", "target": "+8610000"},
+		{"digit": "9", "action": "hangup"}
+	]
+}`
+
+func TestGateway_IVRHandoff(t *testing.T) {
+	menu, err := ivr.Parse(testMenu)
+	require.NoError(t, err)
+	gateway, err := NewGateway(chat.NewEngine(60), Config{
+		Provider:       &llm.FakeProvider{},
+		NewSynthesizer: func() (Synthesizer, error) { return stubSynthesizer{}, nil },
+	})
+	require.NoError(t, err)
+
+	var turns turnRecorder
+	leg := newKeyedMedia()
+	served := make(chan error, 1)
+	go func() {
+		served <- gateway.Bridge(context.Background(), leg, &CallOptions{
+			Options: chat.Options{AssistantID: 1, OnTurn: turns.record},
+			IVR:     menu,
+			ResolveAssistant: func(assistantID uint) (chat.Options, error) {
+				return chat.Options{AssistantID: assistantID, SystemPrompt: "You sell."}, nil
+			},
+		})
+	}()
+
+	// the menu is played instead of the greeting of the assistant
+	first := turns.wait(t, 1)[0]
+	assert.Equal(t, llm.RoleAssistant, first.Role)
+	assert.Equal(t, menu.Prompt, first.Content)
+	assert.Equal(t, uint(1), first.AssistantID)
+	assert.Eventually(t, func() bool { return leg.played.Load() >= 5 }, 5*time.Second, 20*time.Millisecond)
+
+	// the key hands the call off to the sales assistant which greets the caller
+	leg.keys <- "1"
+	got := turns.wait(t, 4)
+	assert.Equal(t, keyText("1"), got[1].Content)
+	assert.Equal(t, llm.RoleUser, got[1].Role)
+	assert.Equal(t, "Connecting you to sales.", got[2].Content)
+	assert.Equal(t, "Echo: "+keyText("1"), got[3].Content)
+	assert.Equal(t, uint(2), got[3].AssistantID)
+
+	leg.Close()
+	select {
+	case err := <-served:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("call not ended with the leg")
+	}
+}
+
+func TestGateway_IVRTransferInBand(t *testing.T) {
+	menu, err := ivr.Parse(testMenu)
+	require.NoError(t, err)
+	gateway, err := NewGateway(chat.NewEngine(60), Config{
+		Provider:   &llm.FakeProvider{},
+		DetectDTMF: true,
+	})
+	require.NoError(t, err)
+
+	var turns turnRecorder
+	leg := newKeyedMedia()
+	served := make(chan error, 1)
+	go func() {
+		served <- gateway.Bridge(context.Background(), leg, &CallOptions{
+			Options: chat.Options{OnTurn: turns.record},
+			IVR:     menu,
+		})
+	}()
+	turns.wait(t, 1)
+
+	// the key is detected in the audio of the caller
+	leg.press(t, "0")
+	select {
+	case target := <-leg.transferred:
+		assert.Equal(t, "+8610000", target)
+	case <-time.After(5 * time.Second):
+		t.Fatal("call not transferred")
+	}
+	select {
+	case err := <-served:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("call not ended after the transfer")
+	}
+	assert.Equal(t, keyText("0"), turns.wait(t, 2)[1].Content)
+}
+
+func TestGateway_IVRHangup(t *testing.T) {
+	menu, err := ivr.Parse(testMenu)
+	require.NoError(t, err)
+	gateway, err := NewGateway(chat.NewEngine(60), Config{
+		Provider: &llm.FakeProvider{},
+		IVR:      menu,
+	})
+	require.NoError(t, err)
+
+	url, served := newTestServer(t, gateway)
+	pcmu, _ := codec.Lookup(codec.PCMU)
+	caller := newTestCaller(t, url, pcmu)
+	assert.Equal(t, menu.Prompt, caller.waitDone())
+
+	// speech is ignored while in the menu, the key of the keypad hangs up
+	require.NoError(t, caller.ws.WriteJSON(Message{Type: MessageText, Text: "hello"}))
+	require.NoError(t, caller.ws.WriteJSON(Message{Type: MessageDTMF, Text: "9"}))
+	select {
+	case err := <-served:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("call not hung up by the menu")
+	}
+}
+
+func TestCredentialOptions(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "voice.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.UserCredential{}))
+	defaults := CallOptions{Options: chat.Options{UserID: 1}}
+
+	// without a credential the stages of the gateway answer
+	opts, err := CredentialOptions(db, defaults, 0)
+	require.NoError(t, err)
+	assert.Zero(t, opts.Options.CredentialID)
+	assert.Nil(t, opts.Options.OnUsage)
+	assert.Nil(t, opts.Provider)
+	assert.Nil(t, opts.NewTranscriber)
+	assert.Nil(t, opts.NewSynthesizer)
+
+	require.NoError(t, db.Create(&[]models.UserCredential{
+		{UserID: 1, APIKey: "k1", APISecret: "s"},
+		{UserID: 1, APIKey: "k2", APISecret: "s", LLMProvider: llm.ProviderFake, AsrProvider: asr.ProviderScripted, TtsProvider: tts.ProviderTone},
+		{UserID: 2, APIKey: "k3", APISecret: "s"},
+		{UserID: 1, APIKey: "k4", APISecret: "s", Quota: 10, Used: 10},
+	}).Error)
+	opts, err = CredentialOptions(db, defaults, 1)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, opts.Options.CredentialID)
+	assert.Nil(t, opts.Provider)
+	assert.Nil(t, opts.NewTranscriber)
+	assert.Nil(t, opts.NewSynthesizer)
+	// the tokens of the call are charged to the credential
+	opts.Options.OnUsage(llm.Usage{TotalTokens: 7})
+	var credential models.UserCredential
+	require.NoError(t, db.Take(&credential, 1).Error)
+	assert.EqualValues(t, 7, credential.Used)
+
+	opts, err = CredentialOptions(db, defaults, 2)
+	require.NoError(t, err)
+	assert.IsType(t, &llm.FakeProvider{}, opts.Provider)
+	require.NotNil(t, opts.NewTranscriber)
+	stream, err := opts.NewTranscriber()
+	require.NoError(t, err)
+	require.NoError(t, stream.Close())
+	require.NotNil(t, opts.NewSynthesizer)
+	synthesizer, err := opts.NewSynthesizer()
+	require.NoError(t, err)
+	var audio int
+	require.NoError(t, synthesizer.Synthesize(context.Background(), "hi", func(pcm []byte) error {
+		audio += len(pcm)
+		return nil
+	}))
+	assert.Positive(t, audio)
+
+	// the credentials of another user are not found
+	_, err = CredentialOptions(db, defaults, 3)
+	assert.ErrorIs(t, err, models.ErrCredentialNotFound)
+	_, err = CredentialOptions(db, defaults, 4)
+	assert.ErrorIs(t, err, models.ErrCredentialQuotaExceeded)
+}
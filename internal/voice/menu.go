@@ -0,0 +1,184 @@
+package voice
+
+import (
+	"VoiceSculptor/internal/chat"
+	"VoiceSculptor/internal/models"
+	"VoiceSculptor/pkg/ivr"
+	"VoiceSculptor/pkg/llm"
+	"VoiceSculptor/pkg/logger"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	errNoTransfer = errors.New("the call cannot be transferred")
+	errNoResolver = errors.New("the call cannot be handed off to another assistant")
+)
+
+// keyText the user turn of a key pressed by the caller
+func keyText(digit string) string {
+	return "DTMF " + digit
+}
+
+// keyPressed handles a key of the caller: it navigates the menu, or is sent
+// to the assistant once the menu ended
+func (c *Call) keyPressed(digit string) {
+	c.send(Message{Type: MessageDTMF, Text: digit})
+	step, gen, ok := c.navigate(0, func(s *ivr.Session) ivr.Step { return s.Press(digit) })
+	if !ok {
+		c.userSaid(keyText(digit), nil)
+		return
+	}
+	c.interrupt()
+	c.userMu.Lock()
+	c.conv.Append(llm.RoleUser, keyText(digit))
+	c.userMu.Unlock()
+	c.runMenu(step, gen)
+}
+
+// inMenu reports whether the caller is navigating the key menu
+func (c *Call) inMenu() bool {
+	c.menuMu.Lock()
+	defer c.menuMu.Unlock()
+	return c.menu != nil && !c.menu.Done()
+}
+
+// navigate moves the menu to its next step, the step waiting for a key is
+// abandoned. gen is the step the event applies to, any step when 0.
+// ok is false once the menu ended or without one.
+func (c *Call) navigate(gen int, event func(*ivr.Session) ivr.Step) (step ivr.Step, next int, ok bool) {
+	c.menuMu.Lock()
+	defer c.menuMu.Unlock()
+	if c.menu == nil || c.menu.Done() || (gen != 0 && gen != c.menuGen) {
+		return ivr.Step{}, 0, false
+	}
+	if c.menuTimer != nil {
+		c.menuTimer.Stop()
+		c.menuTimer = nil
+	}
+	c.menuGen++
+	return event(c.menu), c.menuGen, true
+}
+
+// runMenu speaks the step, then waits for a key or runs the action ending the menu
+func (c *Call) runMenu(step ivr.Step, gen int) {
+	if step.Action == nil {
+		c.say(step.Say, func() { c.waitKey(gen, step.Timeout) })
+		return
+	}
+	action := step.Action
+	logger.Info("ivr menu ended", zap.String("sessionId", c.ID), zap.String("action", action.Action))
+	c.say(step.Say, func() { c.runMenuAction(action) })
+}
+
+// waitKey times out the step unless a key is pressed in time
+func (c *Call) waitKey(gen int, timeout time.Duration) {
+	c.menuMu.Lock()
+	defer c.menuMu.Unlock()
+	if gen != c.menuGen || c.ctx.Err() != nil {
+		return
+	}
+	c.menuTimer = time.AfterFunc(timeout, func() {
+		if step, next, ok := c.navigate(gen, (*ivr.Session).Timeout); ok {
+			c.runMenu(step, next)
+		}
+	})
+}
+
+// say speaks a text of the menu as a turn of the assistant, then is called
+// once it is spoken, skipped or interrupted
+func (c *Call) say(text string, then func()) {
+	if text == "" || c.ctx.Err() != nil {
+		go then()
+		return
+	}
+	rep := &reply{}
+	rep.setSeq(c.conv.Append(llm.RoleAssistant, text))
+	if c.rec != nil {
+		c.rec.addReply(rep)
+	}
+	c.send(Message{Type: MessageReply, Text: text})
+	c.send(Message{Type: MessageDone, Text: text})
+	if !c.enqueue(text, rep, then) {
+		go then()
+	}
+}
+
+func (c *Call) runMenuAction(action *ivr.Action) {
+	if c.ctx.Err() != nil {
+		return
+	}
+	switch action.Action {
+	case ivr.ActionAssistant:
+		c.handoff(action)
+	case ivr.ActionTransfer:
+		c.transfer(action.Target)
+	case ivr.ActionHangup:
+		c.cancel()
+	}
+}
+
+// handoff lets the assistant of the action take the call, the assistant of
+// the call stays when it cannot be resolved
+func (c *Call) handoff(action *ivr.Action) {
+	opts := c.options.Options
+	if action.AssistantID != 0 && action.AssistantID != opts.AssistantID {
+		resolved, err := c.resolveAssistant(action.AssistantID)
+		if err != nil {
+			logger.Warn("resolve ivr assistant failed", zap.String("sessionId", c.ID),
+				zap.Uint("assistantId", action.AssistantID), zap.Error(err))
+		} else {
+			opts = resolved
+		}
+	}
+	if action.Greeting != "" {
+		opts.SystemPrompt = joinPrompt(opts.SystemPrompt, action.Greeting)
+	}
+	c.conv.Handoff(opts)
+	if c.sessionLog != nil && opts.AssistantID != c.sessionLog.AssistantID {
+		err := models.SetChatSessionAssistant(c.gateway.config.DB, c.sessionLog.ID, opts.AssistantID)
+		if err != nil {
+			logger.Warn("set chat session assistant failed", zap.String("sessionId", c.ID), zap.Error(err))
+		}
+	}
+	if err := c.conv.Greet(); err != nil {
+		c.sendError(err)
+	}
+}
+
+func (c *Call) resolveAssistant(assistantID uint) (chat.Options, error) {
+	if c.options.ResolveAssistant == nil {
+		return chat.Options{}, errNoResolver
+	}
+	return c.options.ResolveAssistant(assistantID)
+}
+
+// transfer hands the call over to the target, a WebRTC caller is asked to
+// call the target itself. The call ends either way.
+func (c *Call) transfer(target string) {
+	if c.ws != nil {
+		c.send(Message{Type: MessageTransfer, Text: target})
+		c.cancel()
+		return
+	}
+	t, ok := c.leg.(Transferer)
+	if !ok {
+		logger.Warn("transfer ivr call failed", zap.String("sessionId", c.ID), zap.Error(errNoTransfer))
+		c.cancel()
+		return
+	}
+	// the leg ends once transferred
+	if err := t.Transfer(target); err != nil {
+		logger.Warn("transfer ivr call failed", zap.String("sessionId", c.ID), zap.String("target", target), zap.Error(err))
+		c.cancel()
+	}
+}
+
+func joinPrompt(prompt, extra string) string {
+	if prompt == "" {
+		return extra
+	}
+	return prompt + "\n\n" + extra
+}
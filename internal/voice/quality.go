@@ -0,0 +1,157 @@
+package voice
+
+import (
+	"VoiceSculptor/internal/models"
+	"VoiceSculptor/pkg/logger"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"go.uber.org/zap"
+)
+
+// statsInterval how often the media stats of a call are sent to the caller
+const statsInterval = 5 * time.Second
+
+// MediaStats the quality of the audio of a WebRTC call
+type MediaStats = models.MediaStats
+
+// remoteReport the last RTCP report of the caller about the assistant audio
+type remoteReport struct {
+	lost         int64
+	fractionLost float64
+	jitter       time.Duration
+	rtt          time.Duration
+}
+
+// Stats returns the media stats of the call, nil for a bridged call or
+// before the caller audio arrived
+func (c *Call) Stats() *MediaStats {
+	buffer := c.jitter.Load()
+	if buffer == nil {
+		return nil
+	}
+	in := buffer.Stats()
+	stats := &MediaStats{
+		PacketsReceived: in.Received,
+		PacketsLost:     in.Lost,
+		PacketsLate:     in.Late,
+		JitterMs:        in.Jitter.Milliseconds(),
+		JitterBufferMs:  in.Delay.Milliseconds(),
+	}
+	if out := c.out.Load(); out != nil {
+		stats.Codec = out.codec.Name
+	}
+	c.rtcpMu.Lock()
+	stats.RemotePacketsLost = c.remote.lost
+	stats.RemoteFractionLost = c.remote.fractionLost
+	stats.RemoteJitterMs = c.remote.jitter.Milliseconds()
+	stats.RttMs = c.remote.rtt.Milliseconds()
+	c.rtcpMu.Unlock()
+	return stats
+}
+
+// readRTCP reads the RTCP packets of the peer connection, the reception
+// reports about the assistant audio measure its loss and the round trip
+func (c *Call) readRTCP(read func() ([]rtcp.Packet, interceptor.Attributes, error)) {
+	defer c.wg.Done()
+	for {
+		packets, _, err := read()
+		if err != nil {
+			return
+		}
+		now := time.Now()
+		for _, packet := range packets {
+			var reports []rtcp.ReceptionReport
+			switch p := packet.(type) {
+			case *rtcp.ReceiverReport:
+				reports = p.Reports
+			case *rtcp.SenderReport:
+				reports = p.Reports
+			}
+			for _, report := range reports {
+				c.received(report, now)
+			}
+		}
+	}
+}
+
+func (c *Call) received(report rtcp.ReceptionReport, now time.Time) {
+	out := c.out.Load()
+	if out == nil || report.SSRC != out.ssrc {
+		return
+	}
+	c.rtcpMu.Lock()
+	defer c.rtcpMu.Unlock()
+	c.remote.lost = int64(report.TotalLost)
+	c.remote.fractionLost = float64(report.FractionLost) / 256
+	c.remote.jitter = time.Duration(report.Jitter) * time.Second / time.Duration(out.codec.ClockRate)
+	if rtt, ok := roundTrip(report, now); ok {
+		c.remote.rtt = rtt
+	}
+}
+
+// roundTrip computes the round trip from the last sender report the report
+// refers to, section 6.4.1 of RFC 3550
+func roundTrip(report rtcp.ReceptionReport, now time.Time) (time.Duration, bool) {
+	if report.LastSenderReport == 0 {
+		return 0, false
+	}
+	// middle 32 bits of the NTP timestamp, in 1/65536 seconds
+	arrival := uint32(ntpTime(now) >> 16)
+	rtt := int32(arrival - report.LastSenderReport - report.Delay)
+	if rtt < 0 {
+		return 0, false
+	}
+	return time.Duration(rtt) * time.Second / 65536, true
+}
+
+// ntpTime converts the time to the 64 bit NTP format, seconds since 1900
+// in the 32 high bits and the fraction of the second in the low bits
+func ntpTime(t time.Time) uint64 {
+	const ntpEpochOffset = 2208988800 // seconds from 1900 to 1970
+	seconds := uint64(t.Unix()) + ntpEpochOffset
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return seconds<<32 | fraction
+}
+
+// reportStats sends the media stats to the caller while the call lasts
+func (c *Call) reportStats() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			if stats := c.Stats(); stats != nil {
+				c.send(Message{Type: MessageStats, Stats: stats})
+			}
+		}
+	}
+}
+
+// saveStats logs the media stats once the call ended and records them with the transcript
+func (c *Call) saveStats() {
+	stats := c.Stats()
+	if stats == nil {
+		return
+	}
+	logger.Info("voice call media stats",
+		zap.String("sessionId", c.ID),
+		zap.String("codec", stats.Codec),
+		zap.Uint64("packetsReceived", stats.PacketsReceived),
+		zap.Uint64("packetsLost", stats.PacketsLost),
+		zap.Uint64("packetsLate", stats.PacketsLate),
+		zap.Int64("jitterMs", stats.JitterMs),
+		zap.Int64("remotePacketsLost", stats.RemotePacketsLost),
+		zap.Float64("remoteFractionLost", stats.RemoteFractionLost),
+		zap.Int64("rttMs", stats.RttMs))
+	if c.sessionLog == nil {
+		return
+	}
+	if err := models.SetChatSessionMediaStats(c.gateway.config.DB, c.sessionLog.ID, stats); err != nil {
+		logger.Warn("save media stats failed", zap.String("sessionId", c.ID), zap.Error(err))
+	}
+}
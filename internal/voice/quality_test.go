@@ -0,0 +1,26 @@
+package voice
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoundTrip(t *testing.T) {
+	sent := time.Now()
+	lsr := uint32(ntpTime(sent) >> 16)
+	// the caller held the sender report 100ms before replying, the reply arrives 250ms after the report
+	report := rtcp.ReceptionReport{LastSenderReport: lsr, Delay: 65536 / 10}
+	rtt, ok := roundTrip(report, sent.Add(250*time.Millisecond))
+	assert.True(t, ok)
+	assert.InDelta(t, 150*time.Millisecond, rtt, float64(time.Millisecond))
+
+	// no sender report received yet
+	_, ok = roundTrip(rtcp.ReceptionReport{}, sent)
+	assert.False(t, ok)
+	// the clocks disagree
+	_, ok = roundTrip(report, sent)
+	assert.False(t, ok)
+}
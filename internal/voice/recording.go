@@ -0,0 +1,194 @@
+package voice
+
+import (
+	"VoiceSculptor/pkg/codec"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	channelCaller    = 0 // left
+	channelAssistant = 1 // right
+)
+
+// segment a part of a channel of the recording, offsets in bytes
+type segment struct {
+	channel  int
+	from, to int
+}
+
+// reply the audio played for a reply of the assistant, the assistant turn
+// is known once the reply is done
+type reply struct {
+	mu       sync.Mutex
+	seq      int
+	from, to int
+	played   bool
+}
+
+func (r *reply) setSeq(seq int) {
+	r.mu.Lock()
+	r.seq = seq
+	r.mu.Unlock()
+}
+
+func (r *reply) extend(from, to int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.played {
+		r.from, r.played = from, true
+	}
+	r.to = to
+}
+
+// segment returns the audio of the reply and its turn, ok is false if
+// the reply was never played or has no turn
+func (r *reply) segment() (seq int, s segment, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.seq, segment{channel: channelAssistant, from: r.from, to: r.to}, r.played && r.seq > 0
+}
+
+// recording the audio of a call at SampleRate, streamed to a temporary
+// stereo WAV as the call runs. The caller channel is the audio received in
+// order, the assistant audio is placed at the position of the caller channel
+// when it is played so both stay in sync.
+type recording struct {
+	mu      sync.Mutex
+	file    *os.File
+	sizes   [2]int          // the bytes of PCM of each channel
+	turns   map[int]segment // clips of the user turns by seq
+	replies []*reply
+	err     error // the first error writing the file, the recording is then dropped
+}
+
+func newRecording() (*recording, error) {
+	f, err := os.CreateTemp("", "voicesculptor-call-*.wav")
+	if err != nil {
+		return nil, err
+	}
+	// the header is written once the call ends and the size is known
+	if _, err := f.Write(make([]byte, codec.WAVHeaderSize)); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &recording{file: f, turns: make(map[int]segment)}, nil
+}
+
+func (r *recording) writeCaller(pcm []byte) {
+	r.mu.Lock()
+	r.write(channelCaller, r.sizes[channelCaller], pcm)
+	r.mu.Unlock()
+}
+
+// writeAssistant appends the played audio and returns where it was placed
+func (r *recording) writeAssistant(pcm []byte) (from, to int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	from = max(r.sizes[channelCaller], r.sizes[channelAssistant])
+	r.write(channelAssistant, from, pcm)
+	return from, from + len(pcm)
+}
+
+// write places the PCM in the channel at the offset, the samples of the
+// other channel are kept and the gaps are silence. r.mu held.
+func (r *recording) write(channel, offset int, pcm []byte) {
+	if r.err != nil {
+		return
+	}
+	pcm = pcm[:len(pcm)&^1]
+	at := int64(codec.WAVHeaderSize + offset*2)
+	frames := make([]byte, len(pcm)*2)
+	if _, err := r.file.ReadAt(frames, at); err != nil && !errors.Is(err, io.EOF) {
+		r.err = err
+		return
+	}
+	for i := 0; i < len(pcm); i += 2 {
+		copy(frames[i*2+channel*2:], pcm[i:i+2])
+	}
+	if _, err := r.file.WriteAt(frames, at); err != nil {
+		r.err = err
+		return
+	}
+	r.sizes[channel] = max(r.sizes[channel], offset+len(pcm))
+}
+
+// addUserTurn records the caller audio of a transcript, offsets from the start of the call
+func (r *recording) addUserTurn(seq int, start, end time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	from := codec.FrameSize(SampleRate, start)
+	to := min(codec.FrameSize(SampleRate, end), r.sizes[channelCaller])
+	if from < to {
+		r.turns[seq] = segment{channel: channelCaller, from: from, to: to}
+	}
+}
+
+func (r *recording) addReply(rep *reply) {
+	r.mu.Lock()
+	r.replies = append(r.replies, rep)
+	r.mu.Unlock()
+}
+
+// stereo writes the header of the WAV of the call and returns it, caller
+// left and assistant right
+func (r *recording) stereo() (io.Reader, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return nil, r.err
+	}
+	size := max(r.sizes[channelCaller], r.sizes[channelAssistant]) * 2
+	if _, err := r.file.WriteAt(codec.WAVHeader(SampleRate, 2, size), 0); err != nil {
+		return nil, err
+	}
+	return io.NewSectionReader(r.file, 0, int64(codec.WAVHeaderSize+size)), nil
+}
+
+// clips returns the segment of each turn by seq
+func (r *recording) clips() map[int]segment {
+	segments := make(map[int]segment)
+	r.mu.Lock()
+	for seq, s := range r.turns {
+		segments[seq] = s
+	}
+	replies := r.replies
+	r.mu.Unlock()
+	for _, rep := range replies {
+		if seq, s, ok := rep.segment(); ok {
+			segments[seq] = s
+		}
+	}
+	return segments
+}
+
+// clip returns the segment as a mono WAV
+func (r *recording) clip(s segment) (io.Reader, error) {
+	frames := make([]byte, (s.to-s.from)*2)
+	r.mu.Lock()
+	_, err := r.file.ReadAt(frames, int64(codec.WAVHeaderSize+s.from*2))
+	r.mu.Unlock()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	data := make([]byte, s.to-s.from)
+	for i := 0; i+1 < len(data); i += 2 {
+		copy(data[i:], frames[i*2+s.channel*2:i*2+s.channel*2+2])
+	}
+	var wav bytes.Buffer
+	if err := codec.WriteWAV(&wav, &codec.WAV{SampleRate: SampleRate, Channels: 1, Data: data}); err != nil {
+		return nil, err
+	}
+	return &wav, nil
+}
+
+// close removes the temporary WAV
+func (r *recording) close() {
+	r.file.Close()
+	os.Remove(r.file.Name())
+}
@@ -0,0 +1,47 @@
+package voice
+
+import (
+	"VoiceSculptor/pkg/codec"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecording(t *testing.T) {
+	rec, err := newRecording()
+	require.NoError(t, err)
+	name := rec.file.Name()
+
+	rec.writeCaller([]byte{1, 0, 2, 0})
+	// the assistant is placed at the end of the caller channel
+	from, to := rec.writeAssistant([]byte{9, 0})
+	assert.Equal(t, 4, from)
+	assert.Equal(t, 6, to)
+	// and keeps its place when the caller catches up
+	rec.writeCaller([]byte{3, 0, 4, 0})
+	rec.addUserTurn(1, 0, time.Second)
+	rec.addReply(&reply{seq: 2, from: from, to: to, played: true})
+
+	stereo, err := rec.stereo()
+	require.NoError(t, err)
+	wav, err := codec.ReadWAV(stereo)
+	require.NoError(t, err)
+	assert.Equal(t, &codec.WAV{SampleRate: SampleRate, Channels: 2, Data: []byte{1, 0, 0, 0, 2, 0, 0, 0, 3, 0, 9, 0, 4, 0, 0, 0}}, wav)
+
+	segments := rec.clips()
+	require.Len(t, segments, 2)
+	for seq, data := range map[int][]byte{1: {1, 0, 2, 0, 3, 0, 4, 0}, 2: {9, 0}} {
+		clip, err := rec.clip(segments[seq])
+		require.NoError(t, err)
+		wav, err := codec.ReadWAV(clip)
+		require.NoError(t, err)
+		assert.Equal(t, data, wav.Data, seq)
+	}
+
+	rec.close()
+	_, err = os.Stat(name)
+	assert.True(t, os.IsNotExist(err))
+}
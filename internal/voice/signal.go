@@ -0,0 +1,38 @@
+package voice
+
+import "github.com/pion/webrtc/v3"
+
+// signalling messages exchanged over the WebSocket
+const (
+	// client -> server
+	MessageOffer     = "offer"
+	MessageCandidate = "candidate"
+	MessageText      = "text" // a user message typed instead of spoken
+	MessageHangup    = "hangup"
+	// a key pressed, both ways: a key of the client keypad, or a key the
+	// server received or detected in the audio, the text is the key
+	MessageDTMF = "dtmf"
+
+	// server -> client
+	MessageAnswer     = "answer"
+	MessageTranscript = "transcript"
+	MessageSpeech     = "speech" // voice activity of the caller, the text is speech_start or speech_end
+	MessageReply      = "reply"
+	MessageDone       = "done"
+	MessageError      = "error"
+	MessageTransfer   = "transfer" // a key menu transfers the caller to the number of the text, the call ends
+	MessageStats      = "stats"    // the media stats of the call, sent every few seconds
+)
+
+// Message a signalling message, the fields used depend on the type
+type Message struct {
+	Type      string                   `json:"type"`
+	SessionID string                   `json:"sessionId,omitempty"`
+	SDP       string                   `json:"sdp,omitempty"`
+	Codec     string                   `json:"codec,omitempty"` // the negotiated codec, sent with the answer
+	Candidate *webrtc.ICECandidateInit `json:"candidate,omitempty"`
+	Text      string                   `json:"text,omitempty"`
+	Final     bool                     `json:"final,omitempty"`
+	Message   string                   `json:"message,omitempty"`
+	Stats     *MediaStats              `json:"stats,omitempty"`
+}
@@ -0,0 +1,49 @@
+package voice
+
+import (
+	"VoiceSculptor/pkg/asr"
+	"VoiceSculptor/pkg/tts"
+	"time"
+)
+
+const (
+	// SampleRate the rate of the PCM frames inside the pipeline, the audio
+	// of the peer is resampled from and to the rate of the negotiated codec
+	SampleRate = 16000
+	// FrameDuration the duration of a frame sent to or received from the peer
+	FrameDuration = 20 * time.Millisecond
+	// FrameSize the bytes of a 16 bit mono PCM frame
+	FrameSize = SampleRate / 1000 * int(FrameDuration/time.Millisecond) * 2
+)
+
+// Transcript a recognized utterance of the caller
+type Transcript = asr.Result
+
+// Transcriber the ASR stage, consumes the caller audio as 16 bit
+// little endian mono PCM at SampleRate and produces transcripts
+type Transcriber = asr.Stream
+
+// Media a call leg connected outside of WebRTC, such as a call of the PBX,
+// carrying 16 bit little endian mono PCM at SampleRate both ways
+type Media interface {
+	// ReadFrame returns the next audio of the caller, an error once the leg ended
+	ReadFrame() ([]byte, error)
+	WriteFrame(pcm []byte) error
+	Close() error
+}
+
+// KeySource a Media leg reporting the keys pressed by the caller out of band,
+// such as the dtmf events of a PBX call
+type KeySource interface {
+	Keys() <-chan string
+}
+
+// Transferer a Media leg which can be transferred to another number, the leg
+// ends once the call is transferred
+type Transferer interface {
+	Transfer(target string) error
+}
+
+// Synthesizer the TTS stage, renders the text as 16 bit little endian
+// mono PCM at SampleRate and hands the audio to out as it is produced
+type Synthesizer = tts.Synthesizer
@@ -0,0 +1,104 @@
+package asr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrProviderNotConfigured = errors.New("asr provider not configured")
+var ErrStreamClosed = errors.New("asr stream closed")
+
+// Result a transcript of the caller, partial results are replaced by the
+// next result until the final one ends the utterance
+type Result struct {
+	Text  string
+	Final bool
+	// offsets of the utterance from the start of the stream
+	Start time.Duration
+	End   time.Duration
+}
+
+// Stream a streaming recognition of 16 bit little endian mono PCM
+type Stream interface {
+	// Write pushes a frame of PCM at the sample rate of the stream
+	Write(pcm []byte) error
+	// Finalize ends the current utterance, e.g. once the VAD detects the end
+	// of speech, the final result of the audio written so far is sent
+	Finalize() error
+	// Results is closed when the stream is closed
+	Results() <-chan Result
+	Close() error
+}
+
+// Options the settings of a recognition stream
+type Options struct {
+	SampleRate int
+	Language   string // overrides the language of the provider config
+}
+
+// Provider a speech recognition backend
+type Provider interface {
+	// NewStream starts a recognition, the stream is closed once ctx is done
+	NewStream(ctx context.Context, opts Options) (Stream, error)
+}
+
+// Config the connection settings of a provider
+type Config struct {
+	AppID     string
+	SecretID  string
+	SecretKey string
+	Language  string
+}
+
+// Factory creates a provider from the config
+type Factory func(cfg Config) (Provider, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a provider available by the name
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[name] = factory
+}
+
+// Providers returns the names of the registered providers
+func Providers() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsRegistered reports whether a provider is registered by the name
+func IsRegistered(name string) bool {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	_, ok := factories[name]
+	return ok
+}
+
+// New creates the provider registered by the name
+func New(name string, cfg Config) (Provider, error) {
+	if name == "" {
+		return nil, ErrProviderNotConfigured
+	}
+	factoriesMu.RLock()
+	factory, ok := factories[name]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown asr provider: %s", name)
+	}
+	return factory(cfg)
+}
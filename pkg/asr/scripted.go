@@ -0,0 +1,206 @@
+package asr
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const ProviderScripted = "scripted"
+
+// DefaultUtteranceDuration the audio consumed by an utterance without a duration
+const DefaultUtteranceDuration = time.Second
+
+const resultQueueSize = 64
+
+func init() {
+	Register(ProviderScripted, func(cfg Config) (Provider, error) {
+		if cfg.AppID == "" {
+			return &ScriptedProvider{Script: []Utterance{{Text: "hello", Duration: DefaultUtteranceDuration}}}, nil
+		}
+		script, err := LoadScript(cfg.AppID)
+		if err != nil {
+			return nil, err
+		}
+		return &ScriptedProvider{Script: script}, nil
+	})
+}
+
+// Utterance a line of the script, recognized after Duration of audio
+type Utterance struct {
+	Text     string
+	Duration time.Duration
+}
+
+// ParseScript reads one utterance per line, a line may start with the
+// duration of the utterance in brackets, e.g. "[1.5s] I want to book a table".
+// Empty lines and lines starting with # are skipped.
+func ParseScript(r io.Reader) ([]Utterance, error) {
+	var script []Utterance
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		u := Utterance{Text: line, Duration: DefaultUtteranceDuration}
+		if strings.HasPrefix(line, "[") {
+			end := strings.Index(line, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("script line %d: missing ]", n)
+			}
+			d, err := time.ParseDuration(line[1:end])
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("script line %d: invalid duration %q", n, line[1:end])
+			}
+			u.Text, u.Duration = strings.TrimSpace(line[end+1:]), d
+		}
+		if u.Text == "" {
+			return nil, fmt.Errorf("script line %d: empty utterance", n)
+		}
+		script = append(script, u)
+	}
+	return script, scanner.Err()
+}
+
+// LoadScript parses the script file, see ParseScript
+func LoadScript(path string) ([]Utterance, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseScript(f)
+}
+
+// ScriptedProvider a fake provider for development and tests, every stream
+// replays the script against the audio written to it: the words of an
+// utterance are revealed as partial results while its duration of audio is
+// consumed, then the final result is sent and the next utterance starts.
+// Finalize ends the current utterance early.
+// The audio content is ignored, audio after the script is discarded.
+// Registered as "scripted", the script is read from the file named by the AppID.
+type ScriptedProvider struct {
+	Script []Utterance
+}
+
+// NewStream implements Provider.
+func (p *ScriptedProvider) NewStream(ctx context.Context, opts Options) (Stream, error) {
+	if opts.SampleRate <= 0 {
+		return nil, fmt.Errorf("invalid sample rate: %d", opts.SampleRate)
+	}
+	s := &scriptedStream{
+		script:     p.Script,
+		sampleRate: opts.SampleRate,
+		results:    make(chan Result, resultQueueSize),
+		done:       make(chan struct{}),
+	}
+	s.stop = context.AfterFunc(ctx, s.close)
+	return s, nil
+}
+
+type scriptedStream struct {
+	script     []Utterance
+	sampleRate int
+
+	mu       sync.Mutex
+	heard    time.Duration // audio written so far
+	start    time.Duration // start of the current utterance
+	current  int           // index of the current utterance
+	revealed int           // words of the current utterance sent as partial
+
+	results chan Result
+	done    chan struct{}
+	once    sync.Once
+	stop    func() bool
+}
+
+func (s *scriptedStream) Write(pcm []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.done:
+		return ErrStreamClosed
+	default:
+	}
+
+	s.heard += time.Duration(len(pcm)/2) * time.Second / time.Duration(s.sampleRate)
+	for s.current < len(s.script) {
+		u := s.script[s.current]
+		words := strings.Fields(u.Text)
+		elapsed := s.heard - s.start
+		if elapsed >= u.Duration {
+			s.emit(Result{Text: u.Text, Final: true, Start: s.start, End: s.start + u.Duration})
+			s.start += u.Duration
+			s.current++
+			s.revealed = 0
+			continue
+		}
+		// the words revealed so far, in proportion to the audio heard
+		if n := int(int64(len(words)) * int64(elapsed) / int64(u.Duration)); n > s.revealed {
+			s.revealed = n
+			s.emit(Result{Text: strings.Join(words[:n], " "), Start: s.start, End: s.heard})
+		}
+		break
+	}
+	return nil
+}
+
+// Finalize sends the current utterance as final if any of its audio was heard
+func (s *scriptedStream) Finalize() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.done:
+		return ErrStreamClosed
+	default:
+	}
+	if s.current >= len(s.script) || s.heard <= s.start {
+		return nil
+	}
+	s.emit(Result{Text: s.script[s.current].Text, Final: true, Start: s.start, End: s.heard})
+	s.start = s.heard
+	s.current++
+	s.revealed = 0
+	return nil
+}
+
+// emit sends the result, partial results are dropped when nobody reads them
+func (s *scriptedStream) emit(r Result) {
+	if !r.Final {
+		select {
+		case s.results <- r:
+		default:
+		}
+		return
+	}
+	select {
+	case s.results <- r:
+	case <-s.done:
+	}
+}
+
+func (s *scriptedStream) Results() <-chan Result {
+	return s.results
+}
+
+func (s *scriptedStream) Close() error {
+	s.stop()
+	s.close()
+	return nil
+}
+
+func (s *scriptedStream) close() {
+	s.once.Do(func() {
+		close(s.done)
+		// wait for a pending Write before closing the results
+		s.mu.Lock()
+		close(s.results)
+		s.mu.Unlock()
+	})
+}
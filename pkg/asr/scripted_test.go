@@ -0,0 +1,114 @@
+package asr
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sampleRate = 8000
+
+// frame returns d of silence at sampleRate
+func frame(d time.Duration) []byte {
+	return make([]byte, int(d*sampleRate/time.Second)*2)
+}
+
+func TestParseScript(t *testing.T) {
+	script, err := ParseScript(strings.NewReader("# greeting\n[500ms] hi there\n\nbook a table\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []Utterance{
+		{Text: "hi there", Duration: 500 * time.Millisecond},
+		{Text: "book a table", Duration: DefaultUtteranceDuration},
+	}, script)
+
+	for _, bad := range []string{"[1s hi", "[soon] hi", "[-1s] hi", "[1s]"} {
+		_, err := ParseScript(strings.NewReader(bad))
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestScriptedProvider_Replay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.txt")
+	require.NoError(t, os.WriteFile(path, []byte("[400ms] one two three four\n[200ms] bye\n"), 0644))
+
+	provider, err := New(ProviderScripted, Config{AppID: path})
+	require.NoError(t, err)
+	stream, err := provider.NewStream(context.Background(), Options{SampleRate: sampleRate})
+	require.NoError(t, err)
+
+	for i := 0; i < 40; i++ {
+		require.NoError(t, stream.Write(frame(20*time.Millisecond)))
+	}
+	require.NoError(t, stream.Close())
+	assert.ErrorIs(t, stream.Write(frame(20*time.Millisecond)), ErrStreamClosed)
+
+	var results []Result
+	for r := range stream.Results() {
+		results = append(results, r)
+	}
+	// a single word is only revealed by the final result
+	require.Len(t, results, 5)
+	assert.Equal(t, Result{Text: "one", Start: 0, End: 100 * time.Millisecond}, results[0])
+	assert.Equal(t, "one two three", results[2].Text)
+	assert.Equal(t, Result{Text: "one two three four", Final: true, Start: 0, End: 400 * time.Millisecond}, results[3])
+	assert.Equal(t, Result{Text: "bye", Final: true, Start: 400 * time.Millisecond, End: 600 * time.Millisecond}, results[4])
+}
+
+func TestScriptedProvider_Finalize(t *testing.T) {
+	stream, err := (&ScriptedProvider{Script: []Utterance{{Text: "one", Duration: time.Second}, {Text: "two", Duration: time.Second}}}).
+		NewStream(context.Background(), Options{SampleRate: sampleRate})
+	require.NoError(t, err)
+
+	// nothing heard yet
+	require.NoError(t, stream.Finalize())
+	require.NoError(t, stream.Write(frame(300*time.Millisecond)))
+	require.NoError(t, stream.Finalize())
+	require.NoError(t, stream.Write(frame(time.Second)))
+	require.NoError(t, stream.Close())
+	assert.ErrorIs(t, stream.Finalize(), ErrStreamClosed)
+
+	var results []Result
+	for r := range stream.Results() {
+		results = append(results, r)
+	}
+	assert.Equal(t, []Result{
+		{Text: "one", Final: true, Start: 0, End: 300 * time.Millisecond},
+		{Text: "two", Final: true, Start: 300 * time.Millisecond, End: 1300 * time.Millisecond},
+	}, results)
+}
+
+func TestScriptedProvider_ContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := (&ScriptedProvider{}).NewStream(ctx, Options{SampleRate: sampleRate})
+	require.NoError(t, err)
+	cancel()
+
+	select {
+	case _, ok := <-stream.Results():
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("stream not closed after cancel")
+	}
+	assert.NoError(t, stream.Close())
+
+	_, err = (&ScriptedProvider{}).NewStream(context.Background(), Options{})
+	assert.Error(t, err)
+}
+
+func TestRegistry(t *testing.T) {
+	assert.Contains(t, Providers(), ProviderScripted)
+	assert.True(t, IsRegistered(ProviderScripted))
+
+	_, err := New("", Config{})
+	assert.ErrorIs(t, err, ErrProviderNotConfigured)
+	_, err = New("nope", Config{})
+	assert.Error(t, err)
+	_, err = New(ProviderScripted, Config{AppID: "/does/not/exist"})
+	assert.Error(t, err)
+}
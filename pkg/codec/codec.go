@@ -0,0 +1,158 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shenjinti/go711"
+	"github.com/shenjinti/go722"
+)
+
+const (
+	PCMU = "PCMU"
+	PCMA = "PCMA"
+	G722 = "G722"
+)
+
+var ErrNoCommonCodec = errors.New("no common codec")
+
+// Encoder encodes 16 bit little endian mono PCM at the sample rate of the codec
+type Encoder interface {
+	Encode(pcm []byte) ([]byte, error)
+}
+
+// Decoder decodes a payload into 16 bit little endian mono PCM at the sample rate of the codec
+type Decoder interface {
+	Decode(payload []byte) ([]byte, error)
+}
+
+// Codec an audio codec of the RTP audio/video profile
+type Codec struct {
+	Name        string
+	PayloadType uint8
+	ClockRate   uint32 // RTP clock rate, 8000 for G.722 although it samples at 16 kHz (RFC 3551)
+	SampleRate  int    // PCM sample rate
+}
+
+// MimeType returns the mime type used by pion, e.g. audio/PCMU
+func (c Codec) MimeType() string {
+	return "audio/" + c.Name
+}
+
+// FrameSize returns the bytes of PCM at the sample rate of the codec for the duration
+func (c Codec) FrameSize(d time.Duration) int {
+	return FrameSize(c.SampleRate, d)
+}
+
+// supported codecs, best quality first
+var supported = []Codec{
+	{Name: G722, PayloadType: 9, ClockRate: 8000, SampleRate: 16000},
+	{Name: PCMU, PayloadType: 0, ClockRate: 8000, SampleRate: 8000},
+	{Name: PCMA, PayloadType: 8, ClockRate: 8000, SampleRate: 8000},
+}
+
+// Supported returns the supported codecs, best quality first
+func Supported() []Codec {
+	return append([]Codec(nil), supported...)
+}
+
+// Lookup finds a codec by name or mime type, case-insensitive
+func Lookup(name string) (Codec, bool) {
+	name = strings.TrimPrefix(strings.ToUpper(name), "AUDIO/")
+	for _, c := range supported {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Codec{}, false
+}
+
+// Negotiate picks the first of the preferred codecs which is also offered,
+// the supported codecs are used if no preference is given
+func Negotiate(offered []string, preferred []string) (Codec, error) {
+	if len(preferred) == 0 {
+		for _, c := range supported {
+			preferred = append(preferred, c.Name)
+		}
+	}
+	for _, name := range preferred {
+		c, ok := Lookup(name)
+		if !ok {
+			continue
+		}
+		for _, o := range offered {
+			if o, ok := Lookup(o); ok && o.Name == c.Name {
+				return c, nil
+			}
+		}
+	}
+	return Codec{}, fmt.Errorf("%w: offered %s", ErrNoCommonCodec, strings.Join(offered, ", "))
+}
+
+// NewEncoder creates an encoder of the codec, encoders may keep state between frames
+func NewEncoder(name string) (Encoder, error) {
+	c, ok := Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unsupported codec: %s", name)
+	}
+	switch c.Name {
+	case PCMU:
+		return g711Codec{encode: go711.EncodePCMU, decode: go711.DecodePCMU}, nil
+	case PCMA:
+		return g711Codec{encode: go711.EncodePCMA, decode: go711.DecodePCMA}, nil
+	default:
+		return &g722Encoder{enc: go722.NewG722Encoder(go722.Rate64000, go722.G722_DEFAULT)}, nil
+	}
+}
+
+// NewDecoder creates a decoder of the codec, decoders may keep state between frames
+func NewDecoder(name string) (Decoder, error) {
+	c, ok := Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unsupported codec: %s", name)
+	}
+	switch c.Name {
+	case PCMU:
+		return g711Codec{encode: go711.EncodePCMU, decode: go711.DecodePCMU}, nil
+	case PCMA:
+		return g711Codec{encode: go711.EncodePCMA, decode: go711.DecodePCMA}, nil
+	default:
+		return &g722Decoder{dec: go722.NewG722Decoder(go722.Rate64000, go722.G722_DEFAULT)}, nil
+	}
+}
+
+// g711Codec G.711 is stateless, the same value encodes and decodes
+type g711Codec struct {
+	encode func([]byte) ([]byte, error)
+	decode func([]byte) ([]byte, error)
+}
+
+func (c g711Codec) Encode(pcm []byte) ([]byte, error) {
+	return c.encode(pcm)
+}
+
+func (c g711Codec) Decode(payload []byte) ([]byte, error) {
+	return c.decode(payload)
+}
+
+type g722Encoder struct {
+	enc *go722.G722Encoder
+}
+
+func (e *g722Encoder) Encode(pcm []byte) ([]byte, error) {
+	payload := e.enc.Encode(pcm)
+	if payload == nil {
+		return nil, errors.New("g722 encode failed")
+	}
+	return payload, nil
+}
+
+type g722Decoder struct {
+	dec *go722.G722Decoder
+}
+
+func (d *g722Decoder) Decode(payload []byte) ([]byte, error) {
+	return d.dec.Decode(payload), nil
+}
@@ -0,0 +1,130 @@
+package codec
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sine returns 16 bit PCM of a 440 Hz tone
+func sine(sampleRate int, d time.Duration) []byte {
+	pcm := make([]byte, FrameSize(sampleRate, d))
+	for i := 0; i < len(pcm)/2; i++ {
+		v := int16(8000 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate)))
+		binary.LittleEndian.PutUint16(pcm[i*2:], uint16(v))
+	}
+	return pcm
+}
+
+func rms(pcm []byte) float64 {
+	var sum float64
+	for i := 0; i+1 < len(pcm); i += 2 {
+		v := float64(int16(binary.LittleEndian.Uint16(pcm[i:])))
+		sum += v * v
+	}
+	return math.Sqrt(sum / float64(len(pcm)/2))
+}
+
+func TestLookup(t *testing.T) {
+	c, ok := Lookup("audio/pcmu")
+	require.True(t, ok)
+	assert.Equal(t, uint8(0), c.PayloadType)
+	assert.Equal(t, "audio/PCMU", c.MimeType())
+
+	c, ok = Lookup(G722)
+	require.True(t, ok)
+	assert.Equal(t, uint32(8000), c.ClockRate)
+	assert.Equal(t, 16000, c.SampleRate)
+	assert.Equal(t, 640, c.FrameSize(20*time.Millisecond))
+
+	_, ok = Lookup("opus")
+	assert.False(t, ok)
+}
+
+func TestNegotiate(t *testing.T) {
+	c, err := Negotiate([]string{"opus", "PCMU", "G722"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, G722, c.Name)
+
+	c, err = Negotiate([]string{"PCMU", "PCMA"}, []string{PCMA, PCMU})
+	require.NoError(t, err)
+	assert.Equal(t, PCMA, c.Name)
+
+	_, err = Negotiate([]string{"opus"}, nil)
+	assert.ErrorIs(t, err, ErrNoCommonCodec)
+	_, err = Negotiate([]string{"PCMU"}, []string{G722})
+	assert.ErrorIs(t, err, ErrNoCommonCodec)
+}
+
+func TestCodec_RoundTrip(t *testing.T) {
+	for _, c := range Supported() {
+		t.Run(c.Name, func(t *testing.T) {
+			encoder, err := NewEncoder(c.Name)
+			require.NoError(t, err)
+			decoder, err := NewDecoder(c.Name)
+			require.NoError(t, err)
+
+			pcm := sine(c.SampleRate, 200*time.Millisecond)
+			frame := c.FrameSize(20 * time.Millisecond)
+			var decoded []byte
+			for i := 0; i < len(pcm); i += frame {
+				payload, err := encoder.Encode(pcm[i : i+frame])
+				require.NoError(t, err)
+				// 8 bits per sample for G.711, 64 kbit/s for G.722
+				assert.Len(t, payload, 160)
+				out, err := decoder.Decode(payload)
+				require.NoError(t, err)
+				assert.Len(t, out, frame)
+				decoded = append(decoded, out...)
+			}
+			assert.InDelta(t, rms(pcm), rms(decoded), rms(pcm)*0.2)
+		})
+	}
+
+	_, err := NewEncoder("opus")
+	assert.Error(t, err)
+	_, err = NewDecoder("opus")
+	assert.Error(t, err)
+}
+
+func TestResample(t *testing.T) {
+	pcm := sine(8000, 100*time.Millisecond)
+	up := Resample(pcm, 8000, 16000)
+	assert.Len(t, up, len(pcm)*2)
+	assert.InDelta(t, rms(pcm), rms(up), rms(pcm)*0.05)
+
+	down := Resample(up, 16000, 8000)
+	assert.Len(t, down, len(pcm))
+	assert.InDelta(t, rms(pcm), rms(down), rms(pcm)*0.05)
+
+	assert.Len(t, Resample(pcm, 8000, 24000), len(pcm)*3)
+	assert.Equal(t, pcm, Resample(pcm, 8000, 8000))
+
+	// the interpolated sample lies between its neighbours
+	two := make([]byte, 4)
+	binary.LittleEndian.PutUint16(two[2:], 1000)
+	up = Resample(two, 8000, 16000)
+	assert.Equal(t, int16(500), int16(binary.LittleEndian.Uint16(up[2:])))
+}
+
+func TestConcealer(t *testing.T) {
+	var c Concealer
+	assert.Empty(t, c.Conceal())
+
+	frame := sine(16000, 20*time.Millisecond)
+	c.Received(frame)
+	first := c.Conceal()
+	assert.Len(t, first, len(frame))
+	assert.InDelta(t, rms(frame)/2, rms(first), 1)
+	// the loss fades out
+	second := c.Conceal()
+	assert.Less(t, rms(second), rms(first))
+
+	// a received frame restores the gain
+	c.Received(frame)
+	assert.InDelta(t, rms(first), rms(c.Conceal()), 1)
+}
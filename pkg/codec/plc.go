@@ -0,0 +1,32 @@
+package codec
+
+import "encoding/binary"
+
+// concealFade the gain applied to each consecutive concealed frame, the
+// concealment fades to silence after a few frames
+const concealFade = 0.5
+
+// Concealer conceals the lost frames of a stream of 16 bit mono PCM frames:
+// a lost frame repeats the last frame received, fading out while the loss
+// lasts. It is not safe for concurrent use.
+type Concealer struct {
+	last []byte
+	gain float64
+}
+
+// Received records a frame of the stream
+func (c *Concealer) Received(pcm []byte) {
+	c.last = append(c.last[:0], pcm...)
+	c.gain = 1
+}
+
+// Conceal returns a frame in place of a lost one, empty until a frame was received
+func (c *Concealer) Conceal() []byte {
+	frame := make([]byte, len(c.last))
+	c.gain *= concealFade
+	for i := 0; i+1 < len(c.last); i += 2 {
+		v := float64(int16(binary.LittleEndian.Uint16(c.last[i:])))
+		binary.LittleEndian.PutUint16(frame[i:], uint16(int16(v*c.gain)))
+	}
+	return frame
+}
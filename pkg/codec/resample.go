@@ -0,0 +1,47 @@
+package codec
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// FrameSize returns the bytes of 16 bit mono PCM at the sample rate for the duration
+func FrameSize(sampleRate int, d time.Duration) int {
+	return int(int64(sampleRate)*int64(d)/int64(time.Second)) * 2
+}
+
+// Resample converts 16 bit little endian mono PCM between sample rates,
+// halving averages each pair of samples, other ratios interpolate linearly
+func Resample(pcm []byte, from, to int) []byte {
+	if from == to || from <= 0 || to <= 0 || len(pcm) < 2 {
+		return pcm
+	}
+	in := len(pcm) / 2
+	sample := func(i int) int32 {
+		return int32(int16(binary.LittleEndian.Uint16(pcm[i*2:])))
+	}
+
+	if from == to*2 {
+		out := make([]byte, in/2*2)
+		for i := 0; i < in/2; i++ {
+			v := (sample(i*2) + sample(i*2+1)) / 2
+			binary.LittleEndian.PutUint16(out[i*2:], uint16(int16(v)))
+		}
+		return out
+	}
+
+	n := int(int64(in) * int64(to) / int64(from))
+	out := make([]byte, n*2)
+	for i := 0; i < n; i++ {
+		// position of the output sample in the input, in 1/to units
+		pos := int64(i) * int64(from)
+		j := int(pos / int64(to))
+		frac := int32(pos % int64(to))
+		v := sample(j)
+		if j+1 < in {
+			v += (sample(j+1) - v) * frac / int32(to)
+		}
+		binary.LittleEndian.PutUint16(out[i*2:], uint16(int16(v)))
+	}
+	return out
+}
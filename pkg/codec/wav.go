@@ -0,0 +1,135 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var ErrInvalidWAV = errors.New("invalid wav")
+
+// WAVHeaderSize the bytes before the PCM of the WAVs written by WriteWAV
+const WAVHeaderSize = 44
+
+// WAV 16 bit little endian PCM audio, stereo samples are interleaved
+type WAV struct {
+	SampleRate int
+	Channels   int
+	Data       []byte
+}
+
+// Mono returns the PCM mixed down to a single channel
+func (w *WAV) Mono() []byte {
+	if w.Channels <= 1 {
+		return w.Data
+	}
+	frame := w.Channels * 2
+	out := make([]byte, len(w.Data)/frame*2)
+	for i := 0; i+frame <= len(w.Data); i += frame {
+		var sum int32
+		for ch := 0; ch < w.Channels; ch++ {
+			sum += int32(int16(binary.LittleEndian.Uint16(w.Data[i+ch*2:])))
+		}
+		binary.LittleEndian.PutUint16(out[i/w.Channels:], uint16(int16(sum/int32(w.Channels))))
+	}
+	return out
+}
+
+// ReadWAV reads a RIFF WAVE of 16 bit PCM, other chunks are skipped
+func ReadWAV(r io.Reader) (*WAV, error) {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWAV, err)
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("%w: not a RIFF WAVE", ErrInvalidWAV)
+	}
+
+	w := &WAV{}
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return nil, fmt.Errorf("%w: missing data chunk", ErrInvalidWAV)
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+		switch string(chunk[0:4]) {
+		case "fmt ":
+			if size < 16 {
+				return nil, fmt.Errorf("%w: short fmt chunk", ErrInvalidWAV)
+			}
+			var format [16]byte
+			if _, err := io.ReadFull(r, format[:]); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidWAV, err)
+			}
+			if tag := binary.LittleEndian.Uint16(format[0:]); tag != 1 {
+				return nil, fmt.Errorf("%w: unsupported format %d, only PCM", ErrInvalidWAV, tag)
+			}
+			if bits := binary.LittleEndian.Uint16(format[14:]); bits != 16 {
+				return nil, fmt.Errorf("%w: unsupported %d bits per sample, only 16", ErrInvalidWAV, bits)
+			}
+			w.Channels = int(binary.LittleEndian.Uint16(format[2:]))
+			w.SampleRate = int(binary.LittleEndian.Uint32(format[4:]))
+			if _, err := io.CopyN(io.Discard, r, size-16+size%2); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidWAV, err)
+			}
+		case "data":
+			if w.SampleRate == 0 || w.Channels == 0 {
+				return nil, fmt.Errorf("%w: data before fmt chunk", ErrInvalidWAV)
+			}
+			w.Data = make([]byte, size)
+			// tolerate a truncated data chunk, recorders often leave the size unset
+			n, err := io.ReadFull(r, w.Data)
+			if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidWAV, err)
+			}
+			w.Data = w.Data[:n-n%(2*w.Channels)]
+			return w, nil
+		default:
+			if _, err := io.CopyN(io.Discard, r, size+size%2); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidWAV, err)
+			}
+		}
+	}
+}
+
+// LoadWAV reads the WAV file, see ReadWAV
+func LoadWAV(path string) (*WAV, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadWAV(f)
+}
+
+// WriteWAV writes the PCM as a RIFF WAVE of 16 bit PCM
+func WriteWAV(w io.Writer, wav *WAV) error {
+	if _, err := w.Write(WAVHeader(wav.SampleRate, wav.Channels, len(wav.Data))); err != nil {
+		return err
+	}
+	_, err := w.Write(wav.Data)
+	return err
+}
+
+// WAVHeader returns the WAVHeaderSize bytes header of a canonical WAV with
+// dataSize bytes of PCM, the header of a WAV streamed to a file is written
+// once its size is known
+func WAVHeader(sampleRate, channels, dataSize int) []byte {
+	h := make([]byte, WAVHeaderSize)
+	copy(h[0:], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:], uint32(36+dataSize))
+	copy(h[8:], "WAVE")
+	copy(h[12:], "fmt ")
+	binary.LittleEndian.PutUint32(h[16:], 16)
+	binary.LittleEndian.PutUint16(h[20:], 1)
+	binary.LittleEndian.PutUint16(h[22:], uint16(channels))
+	binary.LittleEndian.PutUint32(h[24:], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(h[28:], uint32(sampleRate*channels*2))
+	binary.LittleEndian.PutUint16(h[32:], uint16(channels*2))
+	binary.LittleEndian.PutUint16(h[34:], 16)
+	copy(h[36:], "data")
+	binary.LittleEndian.PutUint32(h[40:], uint32(dataSize))
+	return h
+}
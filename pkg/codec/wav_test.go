@@ -0,0 +1,57 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWAV_RoundTrip(t *testing.T) {
+	pcm := sine(16000, 100*time.Millisecond)
+	var buf bytes.Buffer
+	require.NoError(t, WriteWAV(&buf, &WAV{SampleRate: 16000, Channels: 1, Data: pcm}))
+	assert.Equal(t, 44+len(pcm), buf.Len())
+
+	wav, err := ReadWAV(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, 16000, wav.SampleRate)
+	assert.Equal(t, 1, wav.Channels)
+	assert.Equal(t, pcm, wav.Data)
+	assert.Equal(t, pcm, wav.Mono())
+}
+
+func TestReadWAV_SkipsChunksAndMixesDown(t *testing.T) {
+	var buf bytes.Buffer
+	header := WAVHeader(8000, 2, 8)
+	buf.Write(header[:36])
+	// a LIST chunk of odd size, padded to an even size
+	buf.WriteString("LIST")
+	binary.Write(&buf, binary.LittleEndian, uint32(3))
+	buf.Write([]byte{1, 2, 3, 0})
+	buf.Write(header[36:])
+	for _, v := range []int16{100, 300, -100, -300} {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+
+	wav, err := ReadWAV(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, 2, wav.Channels)
+	mono := wav.Mono()
+	require.Len(t, mono, 4)
+	assert.Equal(t, int16(200), int16(binary.LittleEndian.Uint16(mono)))
+	assert.Equal(t, int16(-200), int16(binary.LittleEndian.Uint16(mono[2:])))
+}
+
+func TestReadWAV_Invalid(t *testing.T) {
+	_, err := ReadWAV(bytes.NewReader([]byte("RIFF....WAVX")))
+	assert.ErrorIs(t, err, ErrInvalidWAV)
+
+	header := WAVHeader(8000, 1, 0)
+	binary.LittleEndian.PutUint16(header[34:], 8)
+	_, err = ReadWAV(bytes.NewReader(header))
+	assert.ErrorIs(t, err, ErrInvalidWAV)
+}
@@ -1,11 +1,16 @@
 package config
 
 import (
+	"VoiceSculptor/pkg/embedding"
+	"VoiceSculptor/pkg/ice"
 	"VoiceSculptor/pkg/logger"
 	"VoiceSculptor/pkg/notification"
 	"VoiceSculptor/pkg/util"
+	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"time"
 )
 
 // config/config.go
@@ -15,6 +20,9 @@ type Config struct {
 	DSN                 string `env:"DSN"`
 	Log                 logger.LogConfig
 	Mail                notification.MailConfig
+	ICE                 ice.Config
+	EmbeddingProvider   string `env:"EMBEDDING_PROVIDER"`
+	Embedding           embedding.Config
 	Addr                string `env:"ADDR"`
 	Mode                string `env:"MODE"`
 	DocsPrefix          string `env:"DOCS_PREFIX"`
@@ -41,6 +49,14 @@ func Load() error {
 	}
 
 	// 2. 加载全局配置
+	udpPortMin, err := portEnv("ICE_UDP_PORT_MIN")
+	if err != nil {
+		return err
+	}
+	udpPortMax, err := portEnv("ICE_UDP_PORT_MAX")
+	if err != nil {
+		return err
+	}
 	GlobalConfig = &Config{
 		MachineID:           util.GetIntEnv("MACHINE_ID"),
 		DBDriver:            util.GetEnv("DB_DRIVER"),
@@ -69,6 +85,46 @@ func Load() error {
 			Port:     util.GetIntEnv("MAIL_PORT"),
 			From:     util.GetEnv("MAIL_FROM"),
 		},
+		ICE: ice.Config{
+			STUNURLs:     util.GetListEnv("ICE_STUN_URLS"),
+			TURNURLs:     util.GetListEnv("ICE_TURN_URLS"),
+			TURNUsername: util.GetEnv("ICE_TURN_USERNAME"),
+			TURNPassword: util.GetEnv("ICE_TURN_PASSWORD"),
+			TURNSecret:   util.GetEnv("ICE_TURN_SECRET"),
+			TURNTTL:      time.Duration(util.GetIntEnv("ICE_TURN_TTL")) * time.Second,
+			NAT1To1IPs:   util.GetListEnv("ICE_NAT_1TO1_IPS"),
+			UDPPortMin:   udpPortMin,
+			UDPPortMax:   udpPortMax,
+		},
+		// 知识库的向量化服务, 未配置时使用本地 hash, 只适合开发测试
+		EmbeddingProvider: util.GetEnv("EMBEDDING_PROVIDER"),
+		Embedding: embedding.Config{
+			APIKey:     util.GetEnv("EMBEDDING_API_KEY"),
+			BaseURL:    util.GetEnv("EMBEDDING_BASE_URL"),
+			Model:      util.GetEnv("EMBEDDING_MODEL"),
+			Dimensions: int(util.GetIntEnv("EMBEDDING_DIMENSIONS")),
+		},
+	}
+	// 未配置时使用公共 STUN, 内网部署可设置 ICE_STUN_URLS= 为空
+	if _, ok := util.LookupEnv("ICE_STUN_URLS"); !ok {
+		GlobalConfig.ICE.STUNURLs = []string{ice.DefaultSTUNURL}
+	}
+	return GlobalConfig.ICE.Validate()
+}
+
+// portEnv returns the port of the variable, 0 when unset
+func portEnv(key string) (uint16, error) {
+	return parsePort(key, util.GetEnv(key))
+}
+
+// parsePort parses the value of the variable as a port, 0 when empty
+func parsePort(key, value string) (uint16, error) {
+	if value == "" {
+		return 0, nil
+	}
+	port, err := strconv.ParseUint(value, 10, 16)
+	if err != nil || port == 0 {
+		return 0, fmt.Errorf("%w: %s=%s is not a port", ice.ErrInvalidPortRange, key, value)
 	}
-	return nil
+	return uint16(port), nil
 }
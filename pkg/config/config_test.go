@@ -0,0 +1,36 @@
+package config
+
+import (
+	"VoiceSculptor/pkg/ice"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func udpPortRange(min, max string) (ice.Config, error) {
+	var cfg ice.Config
+	var err error
+	if cfg.UDPPortMin, err = parsePort("ICE_UDP_PORT_MIN", min); err != nil {
+		return cfg, err
+	}
+	if cfg.UDPPortMax, err = parsePort("ICE_UDP_PORT_MAX", max); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.Validate()
+}
+
+func TestParsePort_UDPPortRange(t *testing.T) {
+	cfg, err := udpPortRange("10000", "20000")
+	require.NoError(t, err)
+	assert.Equal(t, uint16(10000), cfg.UDPPortMin)
+	assert.Equal(t, uint16(20000), cfg.UDPPortMax)
+
+	_, err = udpPortRange("", "")
+	assert.NoError(t, err)
+
+	for _, ports := range [][2]string{{"-1", "20000"}, {"0", "20000"}, {"10000", "65536"}, {"10000", "port"}, {"20000", "10000"}, {"10000", ""}, {"", "20000"}} {
+		_, err := udpPortRange(ports[0], ports[1])
+		assert.ErrorIs(t, err, ice.ErrInvalidPortRange, ports)
+	}
+}
@@ -0,0 +1,106 @@
+package document
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	DefaultChunkSize    = 800
+	DefaultChunkOverlap = 100
+)
+
+// Chunk splits the text into chunks of at most size characters, the
+// paragraphs are kept whole when they fit. Every chunk but the first
+// repeats about overlap characters of the end of the previous one, so a
+// sentence cut between two chunks is found in either.
+func Chunk(text string, size, overlap int) []string {
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+	if overlap < 0 || overlap >= size/2 {
+		overlap = min(DefaultChunkOverlap, size/4)
+	}
+
+	var chunks []string
+	var current []rune
+	pending := false // current has text which is in no chunk yet
+	flush := func() {
+		chunks = append(chunks, string(current))
+		current, pending = tail(current, overlap), false
+	}
+	for _, paragraph := range paragraphs(text) {
+		// room for the overlap and the paragraph separator
+		for _, piece := range split([]rune(paragraph), size-overlap-2) {
+			if pending && len(current)+2+len(piece) > size {
+				flush()
+			}
+			if len(current) > 0 {
+				current = append(current, '\n', '\n')
+			}
+			current, pending = append(current, piece...), true
+		}
+	}
+	if pending {
+		flush()
+	}
+	return chunks
+}
+
+// paragraphs returns the paragraphs of the text separated by blank lines,
+// with their whitespace collapsed
+func paragraphs(text string) []string {
+	var result []string
+	for _, p := range strings.Split(text, "\n\n") {
+		if p = strings.Join(strings.Fields(p), " "); p != "" {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// split cuts a paragraph longer than size at the ends of its sentences, or
+// its spaces, or anywhere as the last resort
+func split(p []rune, size int) [][]rune {
+	size = max(size, 1)
+	var pieces [][]rune
+	for len(p) > size {
+		cut := lastIndex(p[:size], func(r rune) bool { return strings.ContainsRune(".!?。！？；;", r) })
+		if cut < size/2 {
+			cut = lastIndex(p[:size], unicode.IsSpace)
+		}
+		if cut < size/2 {
+			cut = size - 1
+		}
+		pieces = append(pieces, p[:cut+1])
+		p = []rune(strings.TrimLeftFunc(string(p[cut+1:]), unicode.IsSpace))
+	}
+	return append(pieces, p)
+}
+
+func lastIndex(p []rune, f func(rune) bool) int {
+	for i := len(p) - 1; i >= 0; i-- {
+		if f(p[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// tail returns the last n characters of the chunk, starting at a word
+func tail(chunk []rune, n int) []rune {
+	if n <= 0 || len(chunk) == 0 {
+		return nil
+	}
+	if len(chunk) <= n {
+		return append([]rune(nil), chunk...)
+	}
+	start := len(chunk) - n
+	for i := start; i < len(chunk) && i < start+n/2; i++ {
+		if unicode.IsSpace(chunk[i]) {
+			start = i + 1
+			break
+		}
+	}
+	return append([]rune(nil), chunk[start:]...)
+}
@@ -0,0 +1,61 @@
+// Package document extracts the text of the uploaded documents and splits
+// it into chunks small enough to be embedded and quoted in a prompt.
+package document
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	TypeText     = "text"
+	TypeMarkdown = "markdown"
+	TypePDF      = "pdf"
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported document type, expected .pdf, .md or .txt")
+	ErrNoText          = errors.New("no text found in the document")
+)
+
+// TypeOf returns the type of the document by its file name
+func TypeOf(name string) (string, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".pdf":
+		return TypePDF, nil
+	case ".md", ".markdown":
+		return TypeMarkdown, nil
+	case ".txt", ".text":
+		return TypeText, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnsupportedType, name)
+}
+
+// Extract returns the text of the document of the type
+func Extract(typ string, data []byte) (string, error) {
+	var text string
+	switch typ {
+	case TypePDF:
+		var err error
+		if text, err = ExtractPDF(data); err != nil {
+			return "", err
+		}
+	case TypeMarkdown, TypeText:
+		data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+		if !utf8.Valid(data) {
+			return "", errors.New("the document is not UTF-8 text")
+		}
+		text = string(data)
+	default:
+		return "", ErrUnsupportedType
+	}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if strings.TrimSpace(text) == "" {
+		return "", ErrNoText
+	}
+	return text, nil
+}
@@ -0,0 +1,91 @@
+package document
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// buildPDF returns a PDF document with a page per content stream, the odd
+// pages are compressed
+func buildPDF(t *testing.T, pages ...string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	for i, content := range pages {
+		data := []byte(content)
+		filter := ""
+		if i%2 == 0 {
+			var z bytes.Buffer
+			w := zlib.NewWriter(&z)
+			_, err := w.Write(data)
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+			data, filter = z.Bytes(), " /Filter /FlateDecode"
+		}
+		fmt.Fprintf(&b, "%d 0 obj\n<< /Length %d%s >>\nstream\n", i+1, len(data), filter)
+		b.Write(data)
+		b.WriteString("\nendstream\nendobj\n")
+	}
+	// a font program is not text
+	b.WriteString("9 0 obj\n<< /Length 20 /Length1 20 >>\nstream\nBT (glyphs) Tj ET\nendstream\nendobj\n")
+	b.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return b.Bytes()
+}
+
+func TestExtractPDF(t *testing.T) {
+	data := buildPDF(t,
+		"BT /F1 12 Tf 72 720 Td (Router manual) Tj 0 -14 Td (Hold the \\(reset\\) button.) Tj ET",
+		"BT /F1 12 Tf 72 720 Td [(War) 20 (ranty) -300 (covers)] TJ T* <FEFF00E9007400E9> Tj ET",
+	)
+	text, err := Extract(TypePDF, data)
+	require.NoError(t, err)
+	assert.Equal(t, "Router manual\nHold the (reset) button.\n\nWarranty covers\nété\n\n", text)
+
+	_, err = Extract(TypePDF, []byte("%PDF-1.4\n<< /Encrypt 5 0 R >>"))
+	assert.ErrorIs(t, err, ErrEncryptedPDF)
+	_, err = Extract(TypePDF, buildPDF(t, "0 0 m 10 10 l S"))
+	assert.ErrorIs(t, err, ErrNoText)
+}
+
+func TestExtractText(t *testing.T) {
+	text, err := Extract(TypeMarkdown, []byte("\xef\xbb\xbf# Title\r\n\r\nBody"))
+	require.NoError(t, err)
+	assert.Equal(t, "# Title\n\nBody", text)
+
+	_, err = Extract(TypeText, []byte{0xff, 0xfe})
+	assert.Error(t, err)
+
+	typ, err := TypeOf("Manual.PDF")
+	require.NoError(t, err)
+	assert.Equal(t, TypePDF, typ)
+	_, err = TypeOf("slides.pptx")
+	assert.ErrorIs(t, err, ErrUnsupportedType)
+}
+
+func TestChunk(t *testing.T) {
+	assert.Equal(t, []string{"short text"}, Chunk("short   text", 100, 10))
+	assert.Nil(t, Chunk(" \n\n ", 100, 10))
+
+	// the paragraphs are packed in chunks, the next chunk repeats the end of the previous one
+	text := "First paragraph here.\n\nSecond paragraph here.\n\nThird paragraph here."
+	chunks := Chunk(text, 50, 10)
+	assert.Equal(t, []string{
+		"First paragraph here.\n\nSecond paragraph here.",
+		"here.\n\nThird paragraph here.",
+	}, chunks)
+
+	// a long paragraph is cut at the ends of its sentences
+	long := strings.Repeat("This is a sentence. ", 20)
+	for _, chunk := range Chunk(long, 100, 20) {
+		assert.LessOrEqual(t, len([]rune(chunk)), 100)
+		assert.True(t, strings.HasSuffix(chunk, "."), chunk)
+	}
+	for _, chunk := range Chunk(strings.Repeat("字", 250), 100, 0) {
+		assert.LessOrEqual(t, len([]rune(chunk)), 100)
+	}
+}
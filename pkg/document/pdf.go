@@ -0,0 +1,259 @@
+package document
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// maxStreamSize the largest decompressed stream of a PDF read
+const maxStreamSize = 32 << 20
+
+var ErrEncryptedPDF = errors.New("encrypted PDF documents are not supported")
+
+var (
+	pdfStream = regexp.MustCompile(`(?s)<<(.*?)>>\s*stream\r?\n`)
+	pdfSkip   = regexp.MustCompile(`/(XRef|ObjStm|Image|FontFile\d?|Metadata|XObject)\b|/Length1\b`)
+)
+
+// ExtractPDF returns the text shown by the pages of a PDF document. The text
+// operators of its content streams are read, uncompressed or compressed with
+// FlateDecode: the documents exported by the office suites are supported,
+// not the scanned or encrypted ones, and the fonts with custom encodings may
+// come out garbled.
+func ExtractPDF(data []byte) (string, error) {
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		return "", errors.New("not a PDF document")
+	}
+	if bytes.Contains(data, []byte("/Encrypt")) {
+		return "", ErrEncryptedPDF
+	}
+	var text strings.Builder
+	for _, loc := range pdfStream.FindAllSubmatchIndex(data, -1) {
+		dict := data[loc[2]:loc[3]]
+		start := loc[1]
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		if pdfSkip.Match(dict) {
+			continue
+		}
+		content := data[start : start+end]
+		if bytes.Contains(dict, []byte("/FlateDecode")) {
+			r, err := zlib.NewReader(bytes.NewReader(content))
+			if err != nil {
+				continue
+			}
+			// a stream truncated by its trailing whitespace still decodes
+			content, _ = io.ReadAll(io.LimitReader(r, maxStreamSize))
+		} else if bytes.Contains(dict, []byte("/Filter")) {
+			continue
+		}
+		if page := strings.TrimSpace(pdfText(content)); page != "" {
+			text.WriteString(page)
+			text.WriteString("\n\n")
+		}
+	}
+	return text.String(), nil
+}
+
+// pdfText interprets the text operators of a content stream
+func pdfText(content []byte) string {
+	var text strings.Builder
+	var operands []string // the strings shown by the next operator
+	var numbers []float64
+	newline := func() {
+		if text.Len() > 0 && !strings.HasSuffix(text.String(), "\n") {
+			text.WriteByte('\n')
+		}
+	}
+	inText := false
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '(':
+			s, n := pdfLiteral(content[i:])
+			operands = append(operands, s)
+			i += n
+		case c == '<' && i+1 < len(content) && content[i+1] != '<':
+			end := bytes.IndexByte(content[i:], '>')
+			if end < 0 {
+				return text.String()
+			}
+			operands = append(operands, pdfHex(content[i+1:i+end]))
+			i += end + 1
+		case c == '[':
+			operands = operands[:0]
+			i++
+		case c == ']':
+			i++
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case isPDFSpace(c) || c == '<' || c == '>' || c == '{' || c == '}' || c == '/':
+			i++
+			if c == '/' {
+				for i < len(content) && !isPDFSpace(content[i]) && !strings.ContainsRune("/[]()<>{}%", rune(content[i])) {
+					i++
+				}
+			}
+		default:
+			start := i
+			for i < len(content) && !isPDFSpace(content[i]) && !strings.ContainsRune("/[]()<>{}%", rune(content[i])) {
+				i++
+			}
+			token := string(content[start:i])
+			if f, err := strconv.ParseFloat(token, 64); err == nil {
+				numbers = append(numbers, f)
+				continue
+			}
+			switch token {
+			case "BT":
+				inText = true
+			case "ET":
+				inText = false
+				newline()
+			case "Tj", "TJ":
+				if inText {
+					for _, s := range operands {
+						text.WriteString(s)
+					}
+				}
+			case "'", "\"":
+				newline()
+				if inText {
+					for _, s := range operands {
+						text.WriteString(s)
+					}
+				}
+			case "T*":
+				newline()
+			case "Td", "TD":
+				if len(numbers) >= 2 && numbers[len(numbers)-1] != 0 {
+					newline()
+				} else if len(numbers) >= 2 && numbers[len(numbers)-2] > 0 && !strings.HasSuffix(text.String(), " ") {
+					text.WriteByte(' ')
+				}
+			case "Tm":
+				newline()
+			}
+			operands, numbers = operands[:0], numbers[:0]
+		}
+		// a large negative kerning in a TJ array separates two words
+		if len(numbers) > 0 && numbers[len(numbers)-1] < -200 && len(operands) > 0 {
+			operands[len(operands)-1] += " "
+			numbers = numbers[:0]
+		}
+	}
+	return text.String()
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+// pdfLiteral decodes the literal string at the start of b, returns it with
+// the bytes read
+func pdfLiteral(b []byte) (string, int) {
+	var out []byte
+	depth := 0
+	i := 0
+	for ; i < len(b); i++ {
+		c := b[i]
+		switch c {
+		case '(':
+			if depth > 0 {
+				out = append(out, c)
+			}
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return pdfDecode(out), i + 1
+			}
+			out = append(out, c)
+		case '\\':
+			i++
+			if i >= len(b) {
+				break
+			}
+			switch e := b[i]; e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b', 'f':
+			case '\r', '\n':
+				// a line continuation
+				if e == '\r' && i+1 < len(b) && b[i+1] == '\n' {
+					i++
+				}
+			default:
+				if e >= '0' && e <= '7' {
+					v := 0
+					for j := 0; j < 3 && i < len(b) && b[i] >= '0' && b[i] <= '7'; j++ {
+						v = v*8 + int(b[i]-'0')
+						i++
+					}
+					i--
+					out = append(out, byte(v))
+				} else {
+					out = append(out, e)
+				}
+			}
+		default:
+			out = append(out, c)
+		}
+	}
+	return pdfDecode(out), i
+}
+
+// pdfHex decodes a hexadecimal string
+func pdfHex(b []byte) string {
+	var digits []byte
+	for _, c := range b {
+		if !isPDFSpace(c) {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, 0, len(digits)/2)
+	for i := 0; i+1 < len(digits); i += 2 {
+		v, err := strconv.ParseUint(string(digits[i:i+2]), 16, 8)
+		if err != nil {
+			return ""
+		}
+		out = append(out, byte(v))
+	}
+	return pdfDecode(out)
+}
+
+// pdfDecode decodes the bytes of a string, UTF-16 with its byte order mark
+// or a single byte encoding taken as Latin-1
+func pdfDecode(b []byte) string {
+	if len(b) >= 2 && b[0] == 0xfe && b[1] == 0xff {
+		units := make([]uint16, 0, len(b)/2)
+		for i := 2; i+1 < len(b); i += 2 {
+			units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+	runes := make([]rune, 0, len(b))
+	for _, c := range b {
+		if c >= 0x20 || c == '\n' || c == '\t' {
+			runes = append(runes, rune(c))
+		}
+	}
+	return string(runes)
+}
@@ -0,0 +1,77 @@
+// Package dtmf detects the keys pressed by a caller, out of band as RFC 4733
+// telephone events or in band as dual tones in the PCM of the call.
+package dtmf
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+)
+
+// the keys of a telephone keypad, by the code of their RFC 4733 event
+const keys = "0123456789*#ABCD"
+
+// MimeType the RTP payload of the telephone events
+const MimeType = "audio/telephone-event"
+
+// DefaultPayloadType the payload type usually offered for the telephone events
+const DefaultPayloadType = 101
+
+var ErrInvalidEvent = errors.New("invalid telephone event")
+
+var rowFrequencies = [4]float64{697, 770, 852, 941}
+var columnFrequencies = [4]float64{1209, 1336, 1477, 1633}
+
+// IsKey reports whether the digit is a key of the keypad, 0-9, *, # or A-D
+func IsKey(digit string) bool {
+	return len(digit) == 1 && indexOf(digit[0]) >= 0
+}
+
+func indexOf(key byte) int {
+	for i := 0; i < len(keys); i++ {
+		if keys[i] == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// Generate returns the dual tone of the key as 16 bit little endian mono PCM
+func Generate(digit string, sampleRate int, duration time.Duration) ([]byte, error) {
+	if !IsKey(digit) {
+		return nil, errors.New("dtmf: unknown key " + digit)
+	}
+	code := indexOf(digit[0])
+	row, col := keyTones(code)
+	n := int(duration.Seconds() * float64(sampleRate))
+	pcm := make([]byte, n*2)
+	for i := 0; i < n; i++ {
+		t := float64(i) / float64(sampleRate)
+		v := 8000*math.Sin(2*math.Pi*row*t) + 8000*math.Sin(2*math.Pi*col*t)
+		binary.LittleEndian.PutUint16(pcm[i*2:], uint16(int16(v)))
+	}
+	return pcm, nil
+}
+
+// keyTones returns the row and column frequencies of the event code
+func keyTones(code int) (row, col float64) {
+	switch {
+	case code >= 1 && code <= 9:
+		return rowFrequencies[(code-1)/3], columnFrequencies[(code-1)%3]
+	case code == 0:
+		return rowFrequencies[3], columnFrequencies[1]
+	case code == 10: // *
+		return rowFrequencies[3], columnFrequencies[0]
+	case code == 11: // #
+		return rowFrequencies[3], columnFrequencies[2]
+	default: // A-D
+		return rowFrequencies[code-12], columnFrequencies[3]
+	}
+}
+
+// keyAt returns the key of a row and a column of the keypad
+func keyAt(row, col int) string {
+	layout := [4]string{"123A", "456B", "789C", "*0#D"}
+	return layout[row][col : col+1]
+}
@@ -0,0 +1,113 @@
+package dtmf
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func silence(sampleRate int, d time.Duration) []byte {
+	return make([]byte, int(d.Seconds()*float64(sampleRate))*2)
+}
+
+func tone(freq float64, sampleRate int, d time.Duration) []byte {
+	n := int(d.Seconds() * float64(sampleRate))
+	pcm := make([]byte, n*2)
+	for i := 0; i < n; i++ {
+		v := 12000 * math.Sin(2*math.Pi*freq*float64(i)/float64(sampleRate))
+		binary.LittleEndian.PutUint16(pcm[i*2:], uint16(int16(v)))
+	}
+	return pcm
+}
+
+func TestDetector_Keys(t *testing.T) {
+	for _, sampleRate := range []int{8000, 16000} {
+		for _, key := range keys {
+			d := NewDetector(sampleRate)
+			pcm, err := Generate(string(key), sampleRate, 100*time.Millisecond)
+			require.NoError(t, err)
+			pcm = append(pcm, silence(sampleRate, 40*time.Millisecond)...)
+			assert.Equal(t, []string{string(key)}, d.Process(pcm), "%c at %d Hz", key, sampleRate)
+		}
+	}
+}
+
+func TestDetector_Sequence(t *testing.T) {
+	d := NewDetector(16000)
+	var got []string
+	for _, key := range "12#0" {
+		pcm, err := Generate(string(key), 16000, 70*time.Millisecond)
+		require.NoError(t, err)
+		// fed in frames of 20ms not aligned with the tones
+		pcm = append(pcm, silence(16000, 50*time.Millisecond)...)
+		for len(pcm) > 0 {
+			n := min(len(pcm), 640)
+			got = append(got, d.Process(pcm[:n])...)
+			pcm = pcm[n:]
+		}
+	}
+	assert.Equal(t, []string{"1", "2", "#", "0"}, got)
+
+	// a held key is reported once
+	pcm, _ := Generate("5", 16000, time.Second)
+	assert.Equal(t, []string{"5"}, d.Process(pcm))
+}
+
+func TestDetector_Rejects(t *testing.T) {
+	d := NewDetector(16000)
+	assert.Empty(t, d.Process(silence(16000, time.Second)))
+	assert.Empty(t, d.Process(tone(440, 16000, time.Second)))
+	assert.Empty(t, d.Process(tone(697, 16000, time.Second)))
+
+	// a glitch shorter than a key
+	pcm, _ := Generate("1", 16000, 20*time.Millisecond)
+	assert.Empty(t, d.Process(append(silence(16000, 10*time.Millisecond), pcm...)))
+
+	// a key drowned in another tone
+	key, _ := Generate("1", 16000, 200*time.Millisecond)
+	loud := tone(1000, 16000, 200*time.Millisecond)
+	for i := 0; i+1 < len(key); i += 2 {
+		v := int(int16(binary.LittleEndian.Uint16(key[i:])))/4 + int(int16(binary.LittleEndian.Uint16(loud[i:])))
+		binary.LittleEndian.PutUint16(key[i:], uint16(int16(v)))
+	}
+	assert.Empty(t, NewDetector(16000).Process(key))
+}
+
+func TestReceiver(t *testing.T) {
+	ev, ok := KeyEvent("#")
+	require.True(t, ok)
+	parsed, err := ParseEvent(ev.Marshal())
+	require.NoError(t, err)
+	assert.Equal(t, ev, parsed)
+	assert.Equal(t, "#", parsed.Digit())
+	_, err = ParseEvent([]byte{1, 2})
+	assert.ErrorIs(t, err, ErrInvalidEvent)
+
+	var r Receiver
+	send := func(ts uint32, digit string, end bool, duration uint16) (string, bool) {
+		ev, _ := KeyEvent(digit)
+		ev.End, ev.Duration = end, duration
+		return r.Receive(ts, ev.Marshal())
+	}
+	digit, ok := send(1000, "7", false, 160)
+	assert.True(t, ok)
+	assert.Equal(t, "7", digit)
+	_, ok = send(1000, "7", false, 320)
+	assert.False(t, ok)
+	for range 3 {
+		_, ok = send(1000, "7", true, 800)
+		assert.False(t, ok)
+	}
+	// the same key pressed again is a new event
+	digit, ok = send(3000, "7", false, 160)
+	assert.True(t, ok)
+	assert.Equal(t, "7", digit)
+
+	// flash is not a key
+	_, ok = r.Receive(5000, Event{Code: 16}.Marshal())
+	assert.False(t, ok)
+}
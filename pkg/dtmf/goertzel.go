@@ -0,0 +1,140 @@
+package dtmf
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+const (
+	// blockDuration the audio analysed at once, about 50 Hz of resolution
+	blockDuration = 20 * time.Millisecond
+	// a key is reported once its tones last minBlocks blocks, it can be pressed
+	// again after a block without it
+	minBlocks = 2
+	// minLevel the mean square of a block below which it is silence, about -45 dBFS
+	minLevel = 32768 * 32768 * 3e-5
+	// the tones of a key carry most of the energy of the block
+	minToneRatio = 0.6
+	// the strongest tone of a group is 6 dB above the others
+	minPeakRatio = 4.0
+	// the row and column tones are within 8 dB of each other
+	maxTwist = 6.3
+)
+
+// Detector detects the keys pressed in band with the Goertzel algorithm,
+// it is not safe for concurrent use
+type Detector struct {
+	sampleRate int
+	block      int // samples per block
+	rows, cols [4]float64
+	samples    []float64
+	current    string // the key of the previous blocks
+	count      int    // consecutive blocks of the current key
+	reported   bool
+}
+
+// NewDetector creates a detector of the PCM at the sample rate
+func NewDetector(sampleRate int) *Detector {
+	d := &Detector{
+		sampleRate: sampleRate,
+		block:      int(blockDuration.Seconds() * float64(sampleRate)),
+	}
+	for i := range rowFrequencies {
+		d.rows[i] = 2 * math.Cos(2*math.Pi*rowFrequencies[i]/float64(sampleRate))
+		d.cols[i] = 2 * math.Cos(2*math.Pi*columnFrequencies[i]/float64(sampleRate))
+	}
+	d.samples = make([]float64, 0, d.block)
+	return d
+}
+
+// Process analyses 16 bit little endian mono PCM, it returns the keys pressed
+// since the previous call, each key once however long it is held
+func (d *Detector) Process(pcm []byte) []string {
+	var pressed []string
+	for i := 0; i+1 < len(pcm); i += 2 {
+		d.samples = append(d.samples, float64(int16(binary.LittleEndian.Uint16(pcm[i:]))))
+		if len(d.samples) < d.block {
+			continue
+		}
+		if key := d.analyse(d.samples); d.track(key) {
+			pressed = append(pressed, key)
+		}
+		d.samples = d.samples[:0]
+	}
+	return pressed
+}
+
+// track debounces the key of a block, it reports whether the key is pressed
+func (d *Detector) track(key string) bool {
+	if key != d.current {
+		d.current, d.count, d.reported = key, 0, false
+	}
+	if key == "" {
+		return false
+	}
+	d.count++
+	if d.count >= minBlocks && !d.reported {
+		d.reported = true
+		return true
+	}
+	return false
+}
+
+// analyse returns the key of the block, empty if none
+func (d *Detector) analyse(samples []float64) string {
+	var energy float64
+	for _, s := range samples {
+		energy += s * s
+	}
+	n := float64(len(samples))
+	if energy/n < minLevel {
+		return ""
+	}
+	var rows, cols [4]float64
+	for i := range rows {
+		rows[i] = goertzel(samples, d.rows[i])
+		cols[i] = goertzel(samples, d.cols[i])
+	}
+	row, rowPower, ok := peak(rows)
+	if !ok {
+		return ""
+	}
+	col, colPower, ok := peak(cols)
+	if !ok {
+		return ""
+	}
+	if rowPower > colPower*maxTwist || colPower > rowPower*maxTwist {
+		return ""
+	}
+	// a tone of amplitude a carries a^2 n/2 of the energy and has a power of (a n/2)^2
+	if (rowPower+colPower)/(energy*n/2) < minToneRatio {
+		return ""
+	}
+	return keyAt(row, col)
+}
+
+// peak returns the strongest tone of a group if it stands out of the others
+func peak(powers [4]float64) (int, float64, bool) {
+	best := 0
+	for i := range powers {
+		if powers[i] > powers[best] {
+			best = i
+		}
+	}
+	for i := range powers {
+		if i != best && powers[i]*minPeakRatio > powers[best] {
+			return 0, 0, false
+		}
+	}
+	return best, powers[best], true
+}
+
+// goertzel returns the power of the samples at the frequency of the coefficient
+func goertzel(samples []float64, coeff float64) float64 {
+	var s1, s2 float64
+	for _, x := range samples {
+		s1, s2 = x+coeff*s1-s2, s1
+	}
+	return s1*s1 + s2*s2 - coeff*s1*s2
+}
@@ -0,0 +1,75 @@
+package dtmf
+
+import "encoding/binary"
+
+// Event a telephone event of RFC 4733, the packets of an event share the RTP
+// timestamp of its start, its end is sent three times
+type Event struct {
+	Code     uint8
+	End      bool
+	Volume   uint8  // in -dBm0
+	Duration uint16 // in units of the RTP clock
+}
+
+// ParseEvent parses the payload of a telephone event packet
+func ParseEvent(payload []byte) (Event, error) {
+	if len(payload) < 4 {
+		return Event{}, ErrInvalidEvent
+	}
+	return Event{
+		Code:     payload[0],
+		End:      payload[1]&0x80 != 0,
+		Volume:   payload[1] & 0x3f,
+		Duration: binary.BigEndian.Uint16(payload[2:4]),
+	}, nil
+}
+
+// Marshal returns the payload of the event
+func (e Event) Marshal() []byte {
+	payload := make([]byte, 4)
+	payload[0] = e.Code
+	payload[1] = e.Volume & 0x3f
+	if e.End {
+		payload[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(payload[2:], e.Duration)
+	return payload
+}
+
+// Digit returns the key of the event, empty for the events which are not keys
+func (e Event) Digit() string {
+	if int(e.Code) >= len(keys) {
+		return ""
+	}
+	return keys[e.Code : e.Code+1]
+}
+
+// KeyEvent returns the event of a key
+func KeyEvent(digit string) (Event, bool) {
+	if !IsKey(digit) {
+		return Event{}, false
+	}
+	return Event{Code: uint8(indexOf(digit[0])), Volume: 10}, true
+}
+
+// Receiver reports each key of the telephone events of a stream once,
+// it is not safe for concurrent use
+type Receiver struct {
+	timestamp uint32
+	started   bool
+}
+
+// Receive handles a telephone event packet with its RTP timestamp, it returns
+// the key when the packet starts a new event
+func (r *Receiver) Receive(timestamp uint32, payload []byte) (string, bool) {
+	ev, err := ParseEvent(payload)
+	if err != nil {
+		return "", false
+	}
+	if r.started && timestamp == r.timestamp {
+		return "", false
+	}
+	r.timestamp, r.started = timestamp, true
+	digit := ev.Digit()
+	return digit, digit != ""
+}
@@ -0,0 +1,110 @@
+// Package embedding turns texts into vectors whose cosine similarity
+// measures how close their meanings are.
+package embedding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+var ErrProviderNotConfigured = errors.New("embedding provider not configured")
+
+// Provider an embedding backend
+type Provider interface {
+	// Embed returns the vectors of the texts in order, normalized to unit length
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// Config the connection settings of a provider
+type Config struct {
+	APIKey     string
+	BaseURL    string
+	Model      string
+	Dimensions int // 0 uses the default of the provider
+}
+
+// Factory creates a provider from the config
+type Factory func(cfg Config) (Provider, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a provider available by the name
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[name] = factory
+}
+
+// Providers returns the names of the registered providers
+func Providers() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsRegistered reports whether a provider is registered by the name
+func IsRegistered(name string) bool {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	_, ok := factories[name]
+	return ok
+}
+
+// New creates the provider registered by the name
+func New(name string, cfg Config) (Provider, error) {
+	if name == "" {
+		return nil, ErrProviderNotConfigured
+	}
+	factoriesMu.RLock()
+	factory, ok := factories[name]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown embedding provider: %s", name)
+	}
+	return factory(cfg)
+}
+
+// Normalize scales the vector to unit length in place, a zero vector is kept
+func Normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return v
+	}
+	norm := float32(math.Sqrt(sum))
+	for i := range v {
+		v[i] /= norm
+	}
+	return v
+}
+
+// Cosine returns the cosine similarity of two vectors, 0 if their dimensions differ
+func Cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
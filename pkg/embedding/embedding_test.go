@@ -0,0 +1,74 @@
+package embedding
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashProvider(t *testing.T) {
+	p, err := New(ProviderHash, Config{})
+	require.NoError(t, err)
+	vectors, err := p.Embed(context.Background(), []string{
+		"How do I reset the router to factory settings?",
+		"Hold the reset button of the router for 10 seconds to restore the factory settings.",
+		"The warranty covers two years of repairs.",
+	})
+	require.NoError(t, err)
+	require.Len(t, vectors, 3)
+	assert.Len(t, vectors[0], DefaultHashDimensions)
+	assert.InDelta(t, 1, Cosine(vectors[0], vectors[0]), 1e-6)
+	assert.Greater(t, Cosine(vectors[0], vectors[1]), Cosine(vectors[0], vectors[2]))
+
+	again, err := p.Embed(context.Background(), []string{"How do I reset the router to factory settings?"})
+	require.NoError(t, err)
+	assert.Equal(t, vectors[0], again[0])
+}
+
+func TestWords(t *testing.T) {
+	assert.Equal(t, []string{"reset", "the", "wi", "fi", "路", "由", "器"}, Words("Reset the Wi-Fi 路由器!"))
+}
+
+func TestNew(t *testing.T) {
+	_, err := New("", Config{})
+	assert.ErrorIs(t, err, ErrProviderNotConfigured)
+	_, err = New("missing", Config{})
+	assert.Error(t, err)
+	assert.Contains(t, Providers(), ProviderHash)
+	assert.True(t, IsRegistered(ProviderOpenAI))
+}
+
+func TestOpenAIProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/embeddings", r.URL.Path)
+		assert.Equal(t, "Bearer key", r.Header.Get("Authorization"))
+		var req openAIRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "embed-model", req.Model)
+		assert.Equal(t, []string{"a", "b"}, req.Input)
+		// out of order, as allowed by the API
+		_, _ = w.Write([]byte(`{"data":[{"index":1,"embedding":[0,2]},{"index":0,"embedding":[3,4]}]}`))
+	}))
+	defer server.Close()
+
+	p := NewOpenAIProvider(Config{APIKey: "key", BaseURL: server.URL + "/v1/", Model: "embed-model"})
+	vectors, err := p.Embed(context.Background(), []string{"a", "b"})
+	require.NoError(t, err)
+	assert.Equal(t, [][]float32{{0.6, 0.8}, {0, 1}}, vectors)
+}
+
+func TestOpenAIProvider_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"error":{"message":"invalid api key"}}`))
+	}))
+	defer server.Close()
+
+	_, err := NewOpenAIProvider(Config{BaseURL: server.URL}).Embed(context.Background(), []string{"a"})
+	assert.EqualError(t, err, "embedding request failed: 401 invalid api key")
+}
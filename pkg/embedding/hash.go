@@ -0,0 +1,90 @@
+package embedding
+
+import (
+	"context"
+	"hash/fnv"
+	"strings"
+	"unicode"
+)
+
+const (
+	ProviderHash = "hash"
+
+	DefaultHashDimensions = 256
+)
+
+func init() {
+	Register(ProviderHash, func(cfg Config) (Provider, error) {
+		return &HashProvider{Dimensions: cfg.Dimensions}, nil
+	})
+}
+
+// HashProvider a local provider for development and tests: the words of the
+// text and the pairs of consecutive words are hashed into the dimensions of
+// the vector, so texts sharing words are close. It knows nothing of synonyms.
+type HashProvider struct {
+	Dimensions int // DefaultHashDimensions when zero
+}
+
+// Embed implements Provider.
+func (p *HashProvider) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for _, text := range texts {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, p.embed(text))
+	}
+	return vectors, nil
+}
+
+func (p *HashProvider) embed(text string) []float32 {
+	dims := p.Dimensions
+	if dims <= 0 {
+		dims = DefaultHashDimensions
+	}
+	v := make([]float32, dims)
+	add := func(feature string, weight float32) {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+		// the sign bit spreads the collisions around zero
+		if sum>>63 == 1 {
+			weight = -weight
+		}
+		v[sum%uint64(dims)] += weight
+	}
+	words := Words(text)
+	for i, w := range words {
+		add(w, 1)
+		if i > 0 {
+			add(words[i-1]+" "+w, 0.5)
+		}
+	}
+	return Normalize(v)
+}
+
+// Words splits the text into lower case words, every CJK character is a word
+func Words(text string) []string {
+	var words []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			words = append(words, word.String())
+			word.Reset()
+		}
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r):
+			flush()
+			words = append(words, string(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word.WriteRune(r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return words
+}
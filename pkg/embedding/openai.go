@@ -0,0 +1,120 @@
+package embedding
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	ProviderOpenAI = "openai"
+
+	DefaultOpenAIBaseURL = "https://api.openai.com/v1"
+	DefaultOpenAIModel   = "text-embedding-3-small"
+)
+
+func init() {
+	Register(ProviderOpenAI, func(cfg Config) (Provider, error) {
+		return NewOpenAIProvider(cfg), nil
+	})
+}
+
+// OpenAIProvider talks to any OpenAI compatible /embeddings endpoint
+type OpenAIProvider struct {
+	APIKey     string
+	BaseURL    string
+	Model      string
+	Dimensions int
+	Client     *http.Client
+}
+
+func NewOpenAIProvider(cfg Config) *OpenAIProvider {
+	p := &OpenAIProvider{
+		APIKey:     cfg.APIKey,
+		BaseURL:    strings.TrimSuffix(cfg.BaseURL, "/"),
+		Model:      cfg.Model,
+		Dimensions: cfg.Dimensions,
+		Client:     &http.Client{Timeout: time.Minute},
+	}
+	if p.BaseURL == "" {
+		p.BaseURL = DefaultOpenAIBaseURL
+	}
+	if p.Model == "" {
+		p.Model = DefaultOpenAIModel
+	}
+	return p
+}
+
+type openAIRequest struct {
+	Model      string   `json:"model"`
+	Input      []string `json:"input"`
+	Dimensions int      `json:"dimensions,omitempty"`
+}
+
+type openAIResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Embed implements Provider.
+func (p *OpenAIProvider) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	body, err := json.Marshal(openAIRequest{Model: p.Model, Input: texts, Dimensions: p.Dimensions})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.BaseURL+"/embeddings", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var result openAIResponse
+	if err := json.Unmarshal(data, &result); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if result.Error != nil && result.Error.Message != "" {
+			return nil, fmt.Errorf("embedding request failed: %d %s", resp.StatusCode, result.Error.Message)
+		}
+		return nil, fmt.Errorf("embedding request failed: %d %s", resp.StatusCode, strings.TrimSpace(string(data[:min(len(data), 4096)])))
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, d := range result.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding response index out of range: %d", d.Index)
+		}
+		vectors[d.Index] = Normalize(d.Embedding)
+	}
+	for i, v := range vectors {
+		if v == nil {
+			return nil, fmt.Errorf("embedding response missing input %d", i)
+		}
+	}
+	return vectors, nil
+}
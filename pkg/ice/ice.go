@@ -0,0 +1,150 @@
+// Package ice configures how the WebRTC calls traverse NATs: the STUN and
+// TURN servers handed to the peers and the candidates of the worker.
+package ice
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// DefaultSTUNURL the STUN server used when none is configured
+	DefaultSTUNURL = "stun:stun.l.google.com:19302"
+	// DefaultTURNTTL the lifetime of the credentials of the TURN REST API
+	DefaultTURNTTL = 24 * time.Hour
+)
+
+var (
+	ErrInvalidURL       = errors.New("invalid ice server url")
+	ErrInvalidIP        = errors.New("invalid nat 1:1 ip")
+	ErrInvalidPortRange = errors.New("invalid udp port range")
+)
+
+// Config the ICE servers of the calls and the candidates of the worker
+type Config struct {
+	// STUNURLs such as stun:stun.example.com:3478
+	STUNURLs []string
+	// TURNURLs such as turn:turn.example.com:3478?transport=udp or turns:turn.example.com:5349
+	TURNURLs []string
+	// the static credentials of the TURN servers
+	TURNUsername string
+	TURNPassword string
+	// TURNSecret the shared secret of the TURN REST API (coturn static-auth-secret),
+	// time-limited credentials are issued instead of the static ones when set
+	TURNSecret string
+	TURNTTL    time.Duration // DefaultTURNTTL when zero
+
+	// NAT1To1IPs the public IPs of the worker behind a 1:1 NAT, advertised
+	// as its host candidates
+	NAT1To1IPs []string
+	// UDPPortMin and UDPPortMax the range of the UDP ports of the calls, any port when zero
+	UDPPortMin uint16
+	UDPPortMax uint16
+}
+
+// Server an ICE server, as the RTCIceServer of the browsers
+type Server struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// Validate checks the urls, the ips and the port range
+func (c Config) Validate() error {
+	for _, url := range c.STUNURLs {
+		if !strings.HasPrefix(url, "stun:") && !strings.HasPrefix(url, "stuns:") {
+			return fmt.Errorf("%w: %s", ErrInvalidURL, url)
+		}
+	}
+	for _, url := range c.TURNURLs {
+		if !strings.HasPrefix(url, "turn:") && !strings.HasPrefix(url, "turns:") {
+			return fmt.Errorf("%w: %s", ErrInvalidURL, url)
+		}
+	}
+	for _, ip := range c.NAT1To1IPs {
+		if net.ParseIP(ip) == nil {
+			return fmt.Errorf("%w: %s", ErrInvalidIP, ip)
+		}
+	}
+	if (c.UDPPortMin == 0) != (c.UDPPortMax == 0) || c.UDPPortMin > c.UDPPortMax {
+		return fmt.Errorf("%w: %d-%d", ErrInvalidPortRange, c.UDPPortMin, c.UDPPortMax)
+	}
+	return nil
+}
+
+// TTL returns how long the TURN credentials issued now are valid, 0 for static credentials
+func (c Config) TTL() time.Duration {
+	if c.TURNSecret == "" {
+		return 0
+	}
+	if c.TURNTTL <= 0 {
+		return DefaultTURNTTL
+	}
+	return c.TURNTTL
+}
+
+// Servers returns the ICE servers for the user, the TURN credentials of the
+// REST API are issued to the user at now
+func (c Config) Servers(user string, now time.Time) []Server {
+	var servers []Server
+	if len(c.STUNURLs) > 0 {
+		servers = append(servers, Server{URLs: c.STUNURLs})
+	}
+	if len(c.TURNURLs) > 0 {
+		turn := Server{URLs: c.TURNURLs, Username: c.TURNUsername, Credential: c.TURNPassword}
+		if c.TURNSecret != "" {
+			turn.Username, turn.Credential = Credentials(c.TURNSecret, user, now.Add(c.TTL()))
+		}
+		servers = append(servers, turn)
+	}
+	return servers
+}
+
+// WebRTCServers returns the Servers for a peer connection of pion
+func (c Config) WebRTCServers(user string, now time.Time) []webrtc.ICEServer {
+	var servers []webrtc.ICEServer
+	for _, s := range c.Servers(user, now) {
+		server := webrtc.ICEServer{URLs: s.URLs}
+		if s.Username != "" {
+			server.Username, server.Credential = s.Username, s.Credential
+		}
+		servers = append(servers, server)
+	}
+	return servers
+}
+
+// Configure applies the NAT 1:1 IPs and the port range to the setting engine
+func (c Config) Configure(engine *webrtc.SettingEngine) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if len(c.NAT1To1IPs) > 0 {
+		engine.SetNAT1To1IPs(c.NAT1To1IPs, webrtc.ICECandidateTypeHost)
+	}
+	if c.UDPPortMin > 0 {
+		return engine.SetEphemeralUDPPortRange(c.UDPPortMin, c.UDPPortMax)
+	}
+	return nil
+}
+
+// Credentials returns the time-limited credentials of the TURN REST API
+// valid until expires: the username is the expiry in unix seconds and the
+// user, the password the base64 HMAC-SHA1 of the username keyed by the secret
+func Credentials(secret, user string, expires time.Time) (username, password string) {
+	username = strconv.FormatInt(expires.Unix(), 10)
+	if user != "" {
+		username += ":" + user
+	}
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	return username, base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,63 @@
+package ice
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentials(t *testing.T) {
+	username, password := Credentials("north", "alice", time.Unix(1700000000, 0))
+	assert.Equal(t, "1700000000:alice", username)
+	assert.Equal(t, "Cd/49soE35ICqcJF/bCTn8Z4OyE=", password)
+
+	username, _ = Credentials("north", "", time.Unix(1700000000, 0))
+	assert.Equal(t, "1700000000", username)
+}
+
+func TestServers(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	config := Config{
+		STUNURLs:     []string{"stun:stun.internal:3478"},
+		TURNURLs:     []string{"turn:turn.internal:3478?transport=udp", "turns:turn.internal:5349"},
+		TURNUsername: "static",
+		TURNPassword: "pass",
+	}
+	assert.Equal(t, []Server{
+		{URLs: []string{"stun:stun.internal:3478"}},
+		{URLs: config.TURNURLs, Username: "static", Credential: "pass"},
+	}, config.Servers("alice", now))
+	assert.Zero(t, config.TTL())
+
+	// the credentials of the REST API replace the static ones
+	config.TURNSecret, config.TURNTTL = "north", time.Hour
+	servers := config.Servers("alice", now)
+	require.Len(t, servers, 2)
+	username, password := Credentials("north", "alice", now.Add(time.Hour))
+	assert.Equal(t, username, servers[1].Username)
+	assert.Equal(t, password, servers[1].Credential)
+
+	webrtcServers := config.WebRTCServers("alice", now)
+	require.Len(t, webrtcServers, 2)
+	assert.Empty(t, webrtcServers[0].Username)
+	assert.Equal(t, password, webrtcServers[1].Credential)
+
+	// no server at all, such as an air-gapped LAN
+	assert.Empty(t, Config{}.Servers("alice", now))
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Config{STUNURLs: []string{"stun:a:3478"}, NAT1To1IPs: []string{"203.0.113.7"}, UDPPortMin: 40000, UDPPortMax: 40100}.Validate())
+	assert.ErrorIs(t, Config{STUNURLs: []string{"turn:a:3478"}}.Validate(), ErrInvalidURL)
+	assert.ErrorIs(t, Config{TURNURLs: []string{"http://a"}}.Validate(), ErrInvalidURL)
+	assert.ErrorIs(t, Config{NAT1To1IPs: []string{"public"}}.Validate(), ErrInvalidIP)
+	assert.ErrorIs(t, Config{UDPPortMin: 40000}.Validate(), ErrInvalidPortRange)
+	assert.ErrorIs(t, Config{UDPPortMin: 40100, UDPPortMax: 40000}.Validate(), ErrInvalidPortRange)
+
+	var engine webrtc.SettingEngine
+	assert.NoError(t, Config{NAT1To1IPs: []string{"203.0.113.7"}, UDPPortMin: 40000, UDPPortMax: 40100}.Configure(&engine))
+	assert.ErrorIs(t, Config{UDPPortMax: 40000}.Configure(&engine), ErrInvalidPortRange)
+}
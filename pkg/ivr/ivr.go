@@ -0,0 +1,284 @@
+// Package ivr navigates the declarative key menus of a call, such as
+// "press 1 for sales, 2 for support", before the call is handed off.
+package ivr
+
+import (
+	"VoiceSculptor/pkg/dtmf"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// actions of a key
+const (
+	ActionAssistant = "assistant" // hand the call off to an LLM assistant
+	ActionTransfer  = "transfer"  // transfer the call to the target number
+	ActionHangup    = "hangup"
+	ActionMenu      = "menu"   // enter the sub menu
+	ActionBack      = "back"   // return to the parent menu
+	ActionRepeat    = "repeat" // repeat the prompt of the menu
+)
+
+const (
+	DefaultTimeout = 8 * time.Second
+	DefaultRetries = 2
+	maxDepth       = 5
+)
+
+var ErrInvalidMenu = errors.New("invalid ivr menu")
+
+// Menu a key menu, the prompt is spoken and the caller presses a key of the options
+type Menu struct {
+	Prompt  string   `json:"prompt"`
+	Options []Option `json:"options"`
+	// Timeout seconds waiting for a key, DefaultTimeout when 0
+	Timeout int `json:"timeout,omitempty"`
+	// Retries the prompt is repeated after a timeout or an unknown key, DefaultRetries when 0, none when negative
+	Retries int `json:"retries,omitempty"`
+	// Invalid spoken before the prompt is repeated after an unknown key
+	Invalid string `json:"invalid,omitempty"`
+	// Default the action once the retries are used up, the call is handed off to the assistant when nil
+	Default *Action `json:"default,omitempty"`
+}
+
+// Option the action of a key of the menu
+type Option struct {
+	Digit string `json:"digit"`
+	Action
+}
+
+// Action what a key does, the fields used depend on the action
+type Action struct {
+	Action string `json:"action"`
+	// Say spoken before the action, such as "transferring you to sales"
+	Say string `json:"say,omitempty"`
+	// AssistantID the assistant taking over, the assistant of the menu when 0
+	AssistantID uint `json:"assistantId,omitempty"`
+	// Greeting appended to the system prompt of the assistant taking over
+	Greeting string `json:"greeting,omitempty"`
+	Target   string `json:"target,omitempty"` // transfer
+	Menu     *Menu  `json:"menu,omitempty"`   // menu
+}
+
+// Parse decodes and validates a menu in JSON, nil when empty
+func Parse(data string) (*Menu, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var menu Menu
+	if err := json.Unmarshal([]byte(data), &menu); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMenu, err)
+	}
+	if err := menu.Validate(); err != nil {
+		return nil, err
+	}
+	return &menu, nil
+}
+
+// Validate checks the menu and its sub menus
+func (m *Menu) Validate() error {
+	return m.validate(1)
+}
+
+func (m *Menu) validate(depth int) error {
+	if depth > maxDepth {
+		return fmt.Errorf("%w: more than %d levels of menus", ErrInvalidMenu, maxDepth)
+	}
+	if m.Prompt == "" {
+		return fmt.Errorf("%w: prompt is required", ErrInvalidMenu)
+	}
+	if len(m.Options) == 0 {
+		return fmt.Errorf("%w: options are required", ErrInvalidMenu)
+	}
+	if m.Timeout < 0 {
+		return fmt.Errorf("%w: timeout must not be negative", ErrInvalidMenu)
+	}
+	seen := map[string]bool{}
+	for _, o := range m.Options {
+		if !dtmf.IsKey(o.Digit) {
+			return fmt.Errorf("%w: %q is not a key", ErrInvalidMenu, o.Digit)
+		}
+		if seen[o.Digit] {
+			return fmt.Errorf("%w: key %s is used twice", ErrInvalidMenu, o.Digit)
+		}
+		seen[o.Digit] = true
+		if err := o.Action.validate(depth, depth > 1); err != nil {
+			return fmt.Errorf("key %s: %w", o.Digit, err)
+		}
+	}
+	if m.Default != nil {
+		if err := m.Default.validate(depth, false); err != nil {
+			return fmt.Errorf("default: %w", err)
+		}
+		if m.Default.Action == ActionRepeat || m.Default.Action == ActionBack {
+			return fmt.Errorf("%w: default must end the menu", ErrInvalidMenu)
+		}
+	}
+	return nil
+}
+
+func (a *Action) validate(depth int, canGoBack bool) error {
+	switch a.Action {
+	case ActionAssistant, ActionHangup, ActionRepeat:
+	case ActionTransfer:
+		if a.Target == "" {
+			return fmt.Errorf("%w: transfer requires a target", ErrInvalidMenu)
+		}
+	case ActionMenu:
+		if a.Menu == nil {
+			return fmt.Errorf("%w: menu requires a menu", ErrInvalidMenu)
+		}
+		return a.Menu.validate(depth + 1)
+	case ActionBack:
+		if !canGoBack {
+			return fmt.Errorf("%w: back requires a parent menu", ErrInvalidMenu)
+		}
+	default:
+		return fmt.Errorf("%w: unknown action %q", ErrInvalidMenu, a.Action)
+	}
+	return nil
+}
+
+// AssistantIDs returns the assistants the menu and its sub menus hand off to
+func (m *Menu) AssistantIDs() []uint {
+	var ids []uint
+	add := func(a *Action) {
+		switch {
+		case a.Action == ActionAssistant && a.AssistantID != 0:
+			ids = append(ids, a.AssistantID)
+		case a.Action == ActionMenu && a.Menu != nil:
+			ids = append(ids, a.Menu.AssistantIDs()...)
+		}
+	}
+	for i := range m.Options {
+		add(&m.Options[i].Action)
+	}
+	if m.Default != nil {
+		add(m.Default)
+	}
+	return ids
+}
+
+func (m *Menu) timeout() time.Duration {
+	if m.Timeout == 0 {
+		return DefaultTimeout
+	}
+	return time.Duration(m.Timeout) * time.Second
+}
+
+func (m *Menu) retries() int {
+	if m.Retries == 0 {
+		return DefaultRetries
+	}
+	return max(m.Retries, 0)
+}
+
+// Step what the call does next: speak Say, then either run the Action which
+// ends the menu, or wait Timeout for the next key
+type Step struct {
+	Say     string
+	Action  *Action // assistant, transfer or hangup, nil while in the menu
+	Timeout time.Duration
+}
+
+// Session the navigation of a caller through a menu, it is not safe for concurrent use
+type Session struct {
+	stack  []*Menu // the current menu is the last
+	misses int
+	done   bool
+}
+
+// NewSession starts navigating the menu
+func NewSession(menu *Menu) *Session {
+	return &Session{stack: []*Menu{menu}}
+}
+
+// Done reports whether an action ended the menu
+func (s *Session) Done() bool {
+	return s.done
+}
+
+func (s *Session) current() *Menu {
+	return s.stack[len(s.stack)-1]
+}
+
+// Start returns the prompt of the current menu
+func (s *Session) Start() Step {
+	m := s.current()
+	return Step{Say: m.Prompt, Timeout: m.timeout()}
+}
+
+// Press handles a key of the caller
+func (s *Session) Press(digit string) Step {
+	if s.done {
+		return Step{}
+	}
+	m := s.current()
+	for i := range m.Options {
+		if m.Options[i].Digit == digit {
+			return s.run(&m.Options[i].Action)
+		}
+	}
+	return s.miss(m.Invalid)
+}
+
+// Timeout handles the caller not pressing a key in time
+func (s *Session) Timeout() Step {
+	if s.done {
+		return Step{}
+	}
+	return s.miss("")
+}
+
+// miss repeats the prompt until the retries are used up, then runs the default
+func (s *Session) miss(say string) Step {
+	m := s.current()
+	s.misses++
+	if s.misses <= m.retries() {
+		step := s.Start()
+		step.Say = joinSay(say, step.Say)
+		return step
+	}
+	def := m.Default
+	if def == nil {
+		def = &Action{Action: ActionAssistant}
+	}
+	step := s.run(def)
+	step.Say = joinSay(say, step.Say)
+	return step
+}
+
+func (s *Session) run(a *Action) Step {
+	s.misses = 0
+	switch a.Action {
+	case ActionMenu:
+		s.stack = append(s.stack, a.Menu)
+		step := s.Start()
+		step.Say = joinSay(a.Say, step.Say)
+		return step
+	case ActionBack:
+		if len(s.stack) > 1 {
+			s.stack = s.stack[:len(s.stack)-1]
+		}
+		step := s.Start()
+		step.Say = joinSay(a.Say, step.Say)
+		return step
+	case ActionRepeat:
+		step := s.Start()
+		step.Say = joinSay(a.Say, step.Say)
+		return step
+	}
+	s.done = true
+	return Step{Say: a.Say, Action: a}
+}
+
+func joinSay(first, second string) string {
+	switch {
+	case first == "":
+		return second
+	case second == "":
+		return first
+	}
+	return first + " " + second
+}
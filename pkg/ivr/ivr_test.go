@@ -0,0 +1,108 @@
+package ivr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const menuJSON = `{
+	"prompt": "Press 1 for sales, 2 for support.",
+	"invalid": "Sorry.",
+	"retries": 1,
+	"options": [
+		{"digit": "1", "action": "assistant", "assistantId": 7, "say": "Connecting you to sales.", "greeting": "The caller wants to buy."},
+		{"digit": "2", "action": "menu", "menu": {
+			"prompt": "Press 1 to talk to us, 0 for an operator, * to go back.",
+			"timeout": 3,
+			"options": [
+				{"digit": "1", "action": "assistant"},
+				{"digit": "0", "action": "transfer", "target": "+8610000"},
+				{"digit": "*", "action": "back"}
+			]
+		}},
+		{"digit": "9", "action": "hangup", "say": "Goodbye."}
+	],
+	"default": {"action": "transfer", "target": "+8620000"}
+}`
+
+func TestParse(t *testing.T) {
+	menu, err := Parse("")
+	require.NoError(t, err)
+	assert.Nil(t, menu)
+
+	menu, err = Parse(menuJSON)
+	require.NoError(t, err)
+	assert.Len(t, menu.Options, 3)
+	assert.Equal(t, []uint{7}, menu.AssistantIDs())
+
+	for _, invalid := range []string{
+		`{"prompt": "p"}`,
+		`{"options": [{"digit": "1", "action": "hangup"}]}`,
+		`{"prompt": "p", "options": [{"digit": "11", "action": "hangup"}]}`,
+		`{"prompt": "p", "options": [{"digit": "1", "action": "hangup"}, {"digit": "1", "action": "repeat"}]}`,
+		`{"prompt": "p", "options": [{"digit": "1", "action": "transfer"}]}`,
+		`{"prompt": "p", "options": [{"digit": "1", "action": "dance"}]}`,
+		`{"prompt": "p", "options": [{"digit": "*", "action": "back"}]}`,
+		`{"prompt": "p", "options": [{"digit": "1", "action": "menu"}]}`,
+		`{"prompt": "p", "options": [{"digit": "1", "action": "hangup"}], "default": {"action": "repeat"}}`,
+		`not json`,
+	} {
+		_, err := Parse(invalid)
+		assert.ErrorIs(t, err, ErrInvalidMenu, invalid)
+	}
+}
+
+func TestSession(t *testing.T) {
+	menu, err := Parse(menuJSON)
+	require.NoError(t, err)
+
+	s := NewSession(menu)
+	step := s.Start()
+	assert.Equal(t, "Press 1 for sales, 2 for support.", step.Say)
+	assert.Equal(t, DefaultTimeout, step.Timeout)
+
+	step = s.Press("2")
+	assert.Nil(t, step.Action)
+	assert.Equal(t, 3*time.Second, step.Timeout)
+	assert.Contains(t, step.Say, "operator")
+
+	step = s.Press("*")
+	assert.Equal(t, "Press 1 for sales, 2 for support.", step.Say)
+
+	step = s.Press("1")
+	require.NotNil(t, step.Action)
+	assert.Equal(t, ActionAssistant, step.Action.Action)
+	assert.EqualValues(t, 7, step.Action.AssistantID)
+	assert.Equal(t, "Connecting you to sales.", step.Say)
+	assert.True(t, s.Done())
+	assert.Equal(t, Step{}, s.Press("9"))
+}
+
+func TestSession_Retries(t *testing.T) {
+	menu, err := Parse(menuJSON)
+	require.NoError(t, err)
+	s := NewSession(menu)
+	s.Start()
+
+	step := s.Press("5")
+	assert.Nil(t, step.Action)
+	assert.Equal(t, "Sorry. Press 1 for sales, 2 for support.", step.Say)
+	step = s.Timeout()
+	require.NotNil(t, step.Action)
+	assert.Equal(t, ActionTransfer, step.Action.Action)
+	assert.Equal(t, "+8620000", step.Action.Target)
+
+	// the sub menu hands off to the assistant of the menu without a default
+	s = NewSession(menu)
+	s.Press("2")
+	for range DefaultRetries {
+		assert.Nil(t, s.Timeout().Action)
+	}
+	step = s.Timeout()
+	require.NotNil(t, step.Action)
+	assert.Equal(t, ActionAssistant, step.Action.Action)
+	assert.Zero(t, step.Action.AssistantID)
+}
@@ -0,0 +1,186 @@
+// Package jitter reorders the RTP packets of an audio stream.
+//
+// The audio of a call feeds the ASR rather than a speaker, so a packet
+// arriving in order is released at once: the delay of the buffer is how long
+// a missing packet is waited for before it is reported lost. The delay adapts
+// to the interarrival jitter of the stream (RFC 3550), within the bounds of
+// the Config.
+package jitter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+const (
+	DefaultClockRate = 8000
+	DefaultMinDelay  = 20 * time.Millisecond
+	DefaultMaxDelay  = 200 * time.Millisecond
+
+	// jitterFactor the delay in multiples of the jitter, covers most of the late packets
+	jitterFactor = 3
+	// maxJump a sequence number further away from the expected one restarts
+	// the stream, such as a sender restarting its sequence
+	maxJump = 500
+)
+
+// Config the stream of a Buffer, the zero value uses the defaults
+type Config struct {
+	ClockRate uint32 // RTP clock rate of the stream
+	MinDelay  time.Duration
+	MaxDelay  time.Duration
+}
+
+// Stats the counters of a Buffer
+type Stats struct {
+	Received  uint64 // packets received, duplicates excluded
+	Lost      uint64 // packets reported lost
+	Late      uint64 // packets arriving after they were reported lost, dropped
+	Duplicate uint64
+	// Jitter the interarrival jitter of RFC 3550
+	Jitter time.Duration
+	// Delay how long a missing packet is currently waited for
+	Delay time.Duration
+}
+
+type entry struct {
+	packet  *rtp.Packet
+	arrival time.Time
+}
+
+// Buffer a jitter buffer of an RTP stream, safe for concurrent use
+type Buffer struct {
+	mu      sync.Mutex
+	config  Config
+	packets map[uint16]entry
+	next    uint16 // the sequence number released next
+	started bool
+
+	epoch      time.Time // the arrival of the first packet
+	transit    float64   // relative transit time of the last packet, in clock units
+	hasTransit bool
+	jitter     float64 // in clock units
+	delay      time.Duration
+	stats      Stats
+}
+
+func New(config Config) *Buffer {
+	if config.ClockRate == 0 {
+		config.ClockRate = DefaultClockRate
+	}
+	if config.MinDelay <= 0 {
+		config.MinDelay = DefaultMinDelay
+	}
+	if config.MaxDelay < config.MinDelay {
+		config.MaxDelay = max(DefaultMaxDelay, config.MinDelay)
+	}
+	return &Buffer{
+		config:  config,
+		packets: map[uint16]entry{},
+		delay:   config.MinDelay,
+	}
+}
+
+// Push adds a packet received at arrival
+func (b *Buffer) Push(packet *rtp.Packet, arrival time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.updateJitter(packet.Timestamp, arrival)
+
+	seq := packet.SequenceNumber
+	if !b.started {
+		b.started, b.next = true, seq
+	}
+	if d := int16(seq - b.next); d < -maxJump || d > maxJump {
+		// a new sequence, the packets of the previous one are not waited for
+		clear(b.packets)
+		b.next = seq
+	} else if d < 0 {
+		b.stats.Late++
+		return
+	}
+	if _, ok := b.packets[seq]; ok {
+		b.stats.Duplicate++
+		return
+	}
+	b.packets[seq] = entry{packet: packet, arrival: arrival}
+	b.stats.Received++
+}
+
+// updateJitter estimates the interarrival jitter, section 6.4.1 of RFC 3550
+func (b *Buffer) updateJitter(timestamp uint32, arrival time.Time) {
+	if b.epoch.IsZero() {
+		b.epoch = arrival
+	}
+	// arrival and timestamp in clock units, the wrap of the timestamp is
+	// harmless since only the difference of two transits is used
+	transit := arrival.Sub(b.epoch).Seconds()*float64(b.config.ClockRate) - float64(timestamp)
+	if b.hasTransit {
+		d := transit - b.transit
+		// the timestamp wrapped between the two packets
+		if d > 1<<31 {
+			d -= 1 << 32
+		} else if d < -(1 << 31) {
+			d += 1 << 32
+		}
+		if d < 0 {
+			d = -d
+		}
+		b.jitter += (d - b.jitter) / 16
+	}
+	b.transit, b.hasTransit = transit, true
+
+	delay := time.Duration(jitterFactor * b.jitter / float64(b.config.ClockRate) * float64(time.Second))
+	b.delay = min(max(delay, b.config.MinDelay), b.config.MaxDelay)
+}
+
+// Pop returns the next packet once it is due at now, ok is false when no
+// packet is due. A nil packet with ok is a packet which is lost: the packets
+// after it waited the delay of the buffer.
+func (b *Buffer) Pop(now time.Time) (packet *rtp.Packet, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e, ok := b.packets[b.next]; ok {
+		delete(b.packets, b.next)
+		b.next++
+		return e.packet, true
+	}
+	if deadline, ok := b.deadline(); !ok || now.Before(deadline) {
+		return nil, false
+	}
+	b.next++
+	b.stats.Lost++
+	return nil, true
+}
+
+// Deadline returns when the missing packet being waited for is reported lost,
+// ok is false when no packet is missing
+func (b *Buffer) Deadline() (deadline time.Time, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.deadline()
+}
+
+func (b *Buffer) deadline() (time.Time, bool) {
+	if _, ok := b.packets[b.next]; ok || len(b.packets) == 0 {
+		return time.Time{}, false
+	}
+	var oldest time.Time
+	for _, e := range b.packets {
+		if oldest.IsZero() || e.arrival.Before(oldest) {
+			oldest = e.arrival
+		}
+	}
+	return oldest.Add(b.delay), true
+}
+
+func (b *Buffer) Stats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := b.stats
+	stats.Jitter = time.Duration(b.jitter / float64(b.config.ClockRate) * float64(time.Second))
+	stats.Delay = b.delay
+	return stats
+}
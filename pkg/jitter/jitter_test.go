@@ -0,0 +1,122 @@
+package jitter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/assert"
+)
+
+const ptime = 20 * time.Millisecond
+
+func packet(seq uint16) *rtp.Packet {
+	return &rtp.Packet{Header: rtp.Header{SequenceNumber: seq, Timestamp: uint32(seq) * 160}}
+}
+
+// drain pops the due packets, a lost packet is reported as -1
+func drain(b *Buffer, now time.Time) []int {
+	var seqs []int
+	for {
+		p, ok := b.Pop(now)
+		if !ok {
+			return seqs
+		}
+		if p == nil {
+			seqs = append(seqs, -1)
+		} else {
+			seqs = append(seqs, int(p.SequenceNumber))
+		}
+	}
+}
+
+func TestBuffer_InOrder(t *testing.T) {
+	b := New(Config{})
+	start := time.Now()
+	for i := uint16(0); i < 5; i++ {
+		at := start.Add(time.Duration(i) * ptime)
+		b.Push(packet(i), at)
+		assert.Equal(t, []int{int(i)}, drain(b, at))
+	}
+	stats := b.Stats()
+	assert.Equal(t, uint64(5), stats.Received)
+	assert.Zero(t, stats.Lost)
+	assert.Zero(t, stats.Jitter)
+	assert.Equal(t, DefaultMinDelay, stats.Delay)
+}
+
+func TestBuffer_Reorder(t *testing.T) {
+	b := New(Config{})
+	start := time.Now()
+	b.Push(packet(10), start)
+	assert.Equal(t, []int{10}, drain(b, start))
+
+	// 12 arrives before 11, which shows up within the delay
+	b.Push(packet(12), start.Add(2*ptime))
+	assert.Empty(t, drain(b, start.Add(2*ptime)))
+	deadline, ok := b.Deadline()
+	assert.True(t, ok)
+	assert.True(t, deadline.After(start.Add(2*ptime)))
+	b.Push(packet(11), start.Add(2*ptime+5*time.Millisecond))
+	assert.Equal(t, []int{11, 12}, drain(b, start.Add(2*ptime+5*time.Millisecond)))
+	_, ok = b.Deadline()
+	assert.False(t, ok)
+
+	// duplicates and packets already released are dropped
+	b.Push(packet(12), start.Add(3*ptime))
+	assert.Empty(t, drain(b, start.Add(3*ptime)))
+	stats := b.Stats()
+	assert.Equal(t, uint64(3), stats.Received)
+	assert.Equal(t, uint64(1), stats.Late)
+}
+
+func TestBuffer_Loss(t *testing.T) {
+	b := New(Config{MinDelay: 40 * time.Millisecond})
+	start := time.Now()
+	b.Push(packet(0), start)
+	drain(b, start)
+	b.Push(packet(3), start.Add(3*ptime))
+	assert.Empty(t, drain(b, start.Add(3*ptime)))
+
+	// the packets after the gap waited the delay, the gap is lost
+	at, _ := b.Deadline()
+	assert.Equal(t, []int{-1, -1, 3}, drain(b, at))
+	b.Push(packet(1), at)
+	stats := b.Stats()
+	assert.Equal(t, uint64(2), stats.Lost)
+	assert.Equal(t, uint64(1), stats.Late)
+}
+
+func TestBuffer_Restart(t *testing.T) {
+	b := New(Config{})
+	start := time.Now()
+	b.Push(packet(100), start)
+	drain(b, start)
+	b.Push(packet(40000), start.Add(ptime))
+	assert.Equal(t, []int{40000}, drain(b, start.Add(ptime)))
+	assert.Zero(t, b.Stats().Lost)
+}
+
+func TestBuffer_AdaptiveDelay(t *testing.T) {
+	b := New(Config{MinDelay: 20 * time.Millisecond, MaxDelay: 100 * time.Millisecond})
+	start := time.Now()
+	for i := uint16(0); i < 200; i++ {
+		// every other packet is 30ms late
+		at := start.Add(time.Duration(i) * ptime)
+		if i%2 == 1 {
+			at = at.Add(30 * time.Millisecond)
+		}
+		b.Push(packet(i), at)
+		drain(b, at)
+	}
+	stats := b.Stats()
+	assert.InDelta(t, 30*time.Millisecond, stats.Jitter, float64(5*time.Millisecond))
+	assert.Equal(t, 90*time.Millisecond, stats.Delay.Round(10*time.Millisecond))
+
+	// the delay stays within its bounds
+	b = New(Config{MaxDelay: 50 * time.Millisecond})
+	for i := uint16(0); i < 100; i++ {
+		b.Push(packet(i), start.Add(time.Duration(i%2)*time.Second))
+	}
+	assert.Equal(t, 50*time.Millisecond, b.Stats().Delay)
+}
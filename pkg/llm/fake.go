@@ -0,0 +1,117 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const ProviderFake = "fake"
+
+const FakeGreeting = "Hello, how can I help you?"
+
+func init() {
+	Register(ProviderFake, func(cfg Config) (Provider, error) {
+		return &FakeProvider{}, nil
+	})
+}
+
+// FakeProvider a deterministic local provider for development and tests:
+//   - replies "Echo: <text>" to the last user message, or FakeGreeting without one
+//   - calls a tool with {"query": <text>} when the user message mentions the tool name
+//   - replies "<tool name>: <result>" after a tool result
+type FakeProvider struct {
+	// Delay between two chunks
+	Delay time.Duration
+}
+
+// ChatStream implements Provider.
+func (p *FakeProvider) ChatStream(ctx context.Context, req *ChatRequest, onChunk StreamHandler) (*ChatResponse, error) {
+	usage := Usage{}
+	for _, msg := range req.Messages {
+		usage.PromptTokens += len(strings.Fields(msg.Content))
+	}
+
+	if call := p.toolCall(req); call != nil {
+		usage.CompletionTokens = 1
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+		return &ChatResponse{ToolCalls: []ToolCall{*call}, FinishReason: FinishReasonToolCalls, Usage: usage}, nil
+	}
+
+	reply := p.reply(req)
+	result := &ChatResponse{FinishReason: FinishReasonStop}
+	var content strings.Builder
+	for _, piece := range strings.SplitAfter(reply, " ") {
+		if req.MaxTokens > 0 && usage.CompletionTokens >= req.MaxTokens {
+			result.FinishReason = FinishReasonLength
+			break
+		}
+		if p.Delay > 0 {
+			select {
+			case <-time.After(p.Delay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := onChunk(Chunk{Content: piece}); err != nil {
+			return nil, err
+		}
+		content.WriteString(piece)
+		usage.CompletionTokens++
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	result.Content = content.String()
+	result.Usage = usage
+	return result, nil
+}
+
+func (p *FakeProvider) toolCall(req *ChatRequest) *ToolCall {
+	if len(req.Tools) == 0 || len(req.Messages) == 0 {
+		return nil
+	}
+	last := req.Messages[len(req.Messages)-1]
+	if last.Role != RoleUser {
+		return nil
+	}
+	for _, tool := range req.Tools {
+		if !strings.Contains(last.Content, tool.Name) {
+			continue
+		}
+		args, _ := json.Marshal(map[string]string{"query": last.Content})
+		return &ToolCall{
+			ID:        fmt.Sprintf("call_%d", len(req.Messages)),
+			Name:      tool.Name,
+			Arguments: string(args),
+		}
+	}
+	return nil
+}
+
+func (p *FakeProvider) reply(req *ChatRequest) string {
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		msg := req.Messages[i]
+		switch msg.Role {
+		case RoleTool:
+			return fmt.Sprintf("%s: %s", p.toolName(req.Messages[:i], msg.ToolCallID), msg.Content)
+		case RoleUser:
+			return "Echo: " + msg.Content
+		}
+	}
+	return FakeGreeting
+}
+
+func (p *FakeProvider) toolName(messages []Message, callID string) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		for _, call := range messages[i].ToolCalls {
+			if call.ID == callID {
+				return call.Name
+			}
+		}
+	}
+	return "tool"
+}
@@ -0,0 +1,62 @@
+package llm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeProvider_Echo(t *testing.T) {
+	provider, err := New(ProviderFake, Config{})
+	require.NoError(t, err)
+
+	var chunks []string
+	resp, err := provider.ChatStream(context.Background(), &ChatRequest{
+		Messages: []Message{{Role: RoleUser, Content: "hello there"}},
+	}, func(chunk Chunk) error {
+		chunks = append(chunks, chunk.Content)
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"Echo: ", "hello ", "there"}, chunks)
+	assert.Equal(t, "Echo: hello there", resp.Content)
+	assert.Equal(t, Usage{PromptTokens: 2, CompletionTokens: 3, TotalTokens: 5}, resp.Usage)
+
+	resp, err = provider.ChatStream(context.Background(), &ChatRequest{}, func(chunk Chunk) error { return nil })
+	require.NoError(t, err)
+	assert.Equal(t, FakeGreeting, resp.Content)
+}
+
+func TestFakeProvider_ToolCall(t *testing.T) {
+	provider := &FakeProvider{}
+	req := &ChatRequest{
+		Messages: []Message{{Role: RoleUser, Content: "please lookup_order 42"}},
+		Tools:    []Tool{{Name: "lookup_order"}},
+	}
+	resp, err := provider.ChatStream(context.Background(), req, func(chunk Chunk) error { return nil })
+	require.NoError(t, err)
+	require.Len(t, resp.ToolCalls, 1)
+	assert.Equal(t, "lookup_order", resp.ToolCalls[0].Name)
+	assert.JSONEq(t, `{"query":"please lookup_order 42"}`, resp.ToolCalls[0].Arguments)
+
+	req.Messages = append(req.Messages,
+		Message{Role: RoleAssistant, ToolCalls: resp.ToolCalls},
+		Message{Role: RoleTool, ToolCallID: resp.ToolCalls[0].ID, Content: "shipped"},
+	)
+	resp, err = provider.ChatStream(context.Background(), req, func(chunk Chunk) error { return nil })
+	require.NoError(t, err)
+	assert.Equal(t, "lookup_order: shipped", resp.Content)
+}
+
+func TestFakeProvider_Cancel(t *testing.T) {
+	provider := &FakeProvider{Delay: time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := provider.ChatStream(ctx, &ChatRequest{}, func(chunk Chunk) error { return nil })
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,143 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleTool      = "tool"
+)
+
+const (
+	FinishReasonStop      = "stop"
+	FinishReasonLength    = "length"
+	FinishReasonToolCalls = "tool_calls"
+)
+
+var ErrProviderNotConfigured = errors.New("llm provider not configured")
+
+// Message a message of the conversation
+type Message struct {
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"toolCalls,omitempty"`  // tools requested by the assistant
+	ToolCallID string     `json:"toolCallId,omitempty"` // the call answered by a tool message
+}
+
+// Tool a function the model can call
+type Tool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"` // JSON schema of the arguments
+}
+
+// ToolCall a function call requested by the model
+type ToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"` // JSON encoded arguments
+}
+
+// Usage the tokens consumed by a completion
+type Usage struct {
+	PromptTokens     int `json:"promptTokens"`
+	CompletionTokens int `json:"completionTokens"`
+	TotalTokens      int `json:"totalTokens"`
+}
+
+// ChatRequest a chat completion request
+type ChatRequest struct {
+	Model       string    `json:"model,omitempty"`
+	Messages    []Message `json:"messages"`
+	Tools       []Tool    `json:"tools,omitempty"`
+	Temperature float32   `json:"temperature"`
+	MaxTokens   int       `json:"maxTokens,omitempty"`
+}
+
+// Chunk a piece of the streaming completion
+type Chunk struct {
+	Content string `json:"content"`
+}
+
+// ChatResponse the final result of a completion
+type ChatResponse struct {
+	Content      string     `json:"content"`
+	ToolCalls    []ToolCall `json:"toolCalls,omitempty"`
+	FinishReason string     `json:"finishReason,omitempty"`
+	Usage        Usage      `json:"usage"`
+}
+
+// StreamHandler receives the chunks of a streaming completion,
+// returning an error stops the generation
+type StreamHandler func(chunk Chunk) error
+
+// Provider a large language model backend
+type Provider interface {
+	// ChatStream generates a completion, calling onChunk for every generated chunk.
+	// The generation must stop as soon as ctx is done.
+	ChatStream(ctx context.Context, req *ChatRequest, onChunk StreamHandler) (*ChatResponse, error)
+}
+
+// Config the connection settings of a provider
+type Config struct {
+	APIKey  string
+	BaseURL string
+	Model   string
+}
+
+// Factory creates a provider from the config
+type Factory func(cfg Config) (Provider, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a provider available by the name
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[name] = factory
+}
+
+// Providers returns the names of the registered providers
+func Providers() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsRegistered reports whether a provider is registered by the name
+func IsRegistered(name string) bool {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	_, ok := factories[name]
+	return ok
+}
+
+// New creates the provider registered by the name
+func New(name string, cfg Config) (Provider, error) {
+	if name == "" {
+		return nil, ErrProviderNotConfigured
+	}
+	factoriesMu.RLock()
+	factory, ok := factories[name]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown llm provider: %s", name)
+	}
+	return factory(cfg)
+}
@@ -0,0 +1,248 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	ProviderOpenAI = "openai"
+
+	DefaultOpenAIBaseURL = "https://api.openai.com/v1"
+	DefaultOpenAIModel   = "gpt-4o-mini"
+
+	// maxToolCalls the tool calls accepted in one reply
+	maxToolCalls = 64
+)
+
+func init() {
+	Register(ProviderOpenAI, func(cfg Config) (Provider, error) {
+		return NewOpenAIProvider(cfg), nil
+	})
+}
+
+// OpenAIProvider talks to any OpenAI compatible /chat/completions endpoint
+type OpenAIProvider struct {
+	APIKey  string
+	BaseURL string
+	Model   string
+	Client  *http.Client
+}
+
+func NewOpenAIProvider(cfg Config) *OpenAIProvider {
+	p := &OpenAIProvider{
+		APIKey:  cfg.APIKey,
+		BaseURL: strings.TrimSuffix(cfg.BaseURL, "/"),
+		Model:   cfg.Model,
+		Client:  &http.Client{Timeout: 5 * time.Minute},
+	}
+	if p.BaseURL == "" {
+		p.BaseURL = DefaultOpenAIBaseURL
+	}
+	if p.Model == "" {
+		p.Model = DefaultOpenAIModel
+	}
+	return p
+}
+
+type openAIFunction struct {
+	Name        string          `json:"name,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+	Arguments   string          `json:"arguments,omitempty"`
+}
+
+type openAIToolCall struct {
+	Index    int            `json:"index"`
+	ID       string         `json:"id,omitempty"`
+	Type     string         `json:"type,omitempty"`
+	Function openAIFunction `json:"function"`
+}
+
+type openAITool struct {
+	Type     string         `json:"type"`
+	Function openAIFunction `json:"function"`
+}
+
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIRequest struct {
+	Model         string          `json:"model"`
+	Messages      []openAIMessage `json:"messages"`
+	Tools         []openAITool    `json:"tools,omitempty"`
+	Temperature   float32         `json:"temperature"`
+	MaxTokens     int             `json:"max_tokens,omitempty"`
+	Stream        bool            `json:"stream"`
+	StreamOptions map[string]bool `json:"stream_options,omitempty"`
+}
+
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type openAIStreamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content   string           `json:"content"`
+			ToolCalls []openAIToolCall `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *openAIUsage `json:"usage"`
+}
+
+type openAIError struct {
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// ChatStream implements Provider.
+func (p *OpenAIProvider) ChatStream(ctx context.Context, req *ChatRequest, onChunk StreamHandler) (*ChatResponse, error) {
+	body, err := json.Marshal(p.buildRequest(req))
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.BaseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+	if p.APIKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+p.APIKey)
+	}
+
+	resp, err := p.Client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		var apiErr openAIError
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Message != "" {
+			return nil, fmt.Errorf("llm request failed: %d %s", resp.StatusCode, apiErr.Error.Message)
+		}
+		return nil, fmt.Errorf("llm request failed: %d %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	result := &ChatResponse{}
+	var content strings.Builder
+	var toolCalls []ToolCall
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk openAIStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, fmt.Errorf("invalid llm stream chunk: %w", err)
+		}
+		if chunk.Usage != nil {
+			result.Usage = Usage{
+				PromptTokens:     chunk.Usage.PromptTokens,
+				CompletionTokens: chunk.Usage.CompletionTokens,
+				TotalTokens:      chunk.Usage.TotalTokens,
+			}
+		}
+		for _, choice := range chunk.Choices {
+			if choice.FinishReason != nil {
+				result.FinishReason = *choice.FinishReason
+			}
+			for _, delta := range choice.Delta.ToolCalls {
+				if delta.Index < 0 || delta.Index >= maxToolCalls {
+					return nil, fmt.Errorf("invalid llm tool call index: %d", delta.Index)
+				}
+				for len(toolCalls) <= delta.Index {
+					toolCalls = append(toolCalls, ToolCall{})
+				}
+				call := &toolCalls[delta.Index]
+				if delta.ID != "" {
+					call.ID = delta.ID
+				}
+				if delta.Function.Name != "" {
+					call.Name = delta.Function.Name
+				}
+				call.Arguments += delta.Function.Arguments
+			}
+			if choice.Delta.Content == "" {
+				continue
+			}
+			content.WriteString(choice.Delta.Content)
+			if err := onChunk(Chunk{Content: choice.Delta.Content}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	result.Content = content.String()
+	result.ToolCalls = toolCalls
+	return result, nil
+}
+
+func (p *OpenAIProvider) buildRequest(req *ChatRequest) *openAIRequest {
+	model := req.Model
+	if model == "" {
+		model = p.Model
+	}
+	r := &openAIRequest{
+		Model:         model,
+		Temperature:   req.Temperature,
+		MaxTokens:     req.MaxTokens,
+		Stream:        true,
+		StreamOptions: map[string]bool{"include_usage": true},
+	}
+	for _, msg := range req.Messages {
+		m := openAIMessage{Role: msg.Role, Content: msg.Content, ToolCallID: msg.ToolCallID}
+		for idx, call := range msg.ToolCalls {
+			m.ToolCalls = append(m.ToolCalls, openAIToolCall{
+				Index:    idx,
+				ID:       call.ID,
+				Type:     "function",
+				Function: openAIFunction{Name: call.Name, Arguments: call.Arguments},
+			})
+		}
+		r.Messages = append(r.Messages, m)
+	}
+	for _, tool := range req.Tools {
+		r.Tools = append(r.Tools, openAITool{
+			Type: "function",
+			Function: openAIFunction{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+	return r
+}
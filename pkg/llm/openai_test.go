@@ -0,0 +1,118 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newOpenAIStandIn(t *testing.T, chunks []string) (*httptest.Server, *openAIRequest) {
+	var received openAIRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/chat/completions", r.URL.Path)
+		assert.Equal(t, "Bearer sk-test", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(server.Close)
+	return server, &received
+}
+
+func TestOpenAIProvider_ChatStream(t *testing.T) {
+	server, received := newOpenAIStandIn(t, []string{
+		`{"choices":[{"delta":{"role":"assistant","content":"Hel"},"finish_reason":null}]}`,
+		`{"choices":[{"delta":{"content":"lo"},"finish_reason":null}]}`,
+		`{"choices":[{"delta":{},"finish_reason":"stop"}]}`,
+		`{"choices":[],"usage":{"prompt_tokens":7,"completion_tokens":2,"total_tokens":9}}`,
+	})
+
+	provider, err := New(ProviderOpenAI, Config{APIKey: "sk-test", BaseURL: server.URL + "/"})
+	require.NoError(t, err)
+
+	var chunks []string
+	resp, err := provider.ChatStream(context.Background(), &ChatRequest{
+		Messages:    []Message{{Role: RoleSystem, Content: "be nice"}, {Role: RoleUser, Content: "hi"}},
+		Temperature: 0.5,
+		MaxTokens:   64,
+	}, func(chunk Chunk) error {
+		chunks = append(chunks, chunk.Content)
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"Hel", "lo"}, chunks)
+	assert.Equal(t, "Hello", resp.Content)
+	assert.Equal(t, FinishReasonStop, resp.FinishReason)
+	assert.Equal(t, Usage{PromptTokens: 7, CompletionTokens: 2, TotalTokens: 9}, resp.Usage)
+
+	assert.Equal(t, DefaultOpenAIModel, received.Model)
+	assert.True(t, received.Stream)
+	assert.Equal(t, 64, received.MaxTokens)
+	assert.Len(t, received.Messages, 2)
+}
+
+func TestOpenAIProvider_ToolCalls(t *testing.T) {
+	server, received := newOpenAIStandIn(t, []string{
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"lookup_order","arguments":"{\"id\":"}}]}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"42\"}"}}]}}]}`,
+		`{"choices":[{"delta":{},"finish_reason":"tool_calls"}]}`,
+	})
+
+	provider := NewOpenAIProvider(Config{APIKey: "sk-test", BaseURL: server.URL, Model: "test-model"})
+	resp, err := provider.ChatStream(context.Background(), &ChatRequest{
+		Messages: []Message{{Role: RoleUser, Content: "where is my order 42"}},
+		Tools:    []Tool{{Name: "lookup_order", Parameters: json.RawMessage(`{"type":"object"}`)}},
+	}, func(chunk Chunk) error { return nil })
+	require.NoError(t, err)
+
+	assert.Equal(t, FinishReasonToolCalls, resp.FinishReason)
+	assert.Equal(t, []ToolCall{{ID: "call_1", Name: "lookup_order", Arguments: `{"id":"42"}`}}, resp.ToolCalls)
+	assert.Equal(t, "test-model", received.Model)
+	require.Len(t, received.Tools, 1)
+	assert.Equal(t, "function", received.Tools[0].Type)
+	assert.Equal(t, "lookup_order", received.Tools[0].Function.Name)
+}
+
+func TestOpenAIProvider_InvalidToolCallIndex(t *testing.T) {
+	for _, index := range []int{-1, maxToolCalls, 1 << 30} {
+		server, _ := newOpenAIStandIn(t, []string{
+			fmt.Sprintf(`{"choices":[{"delta":{"tool_calls":[{"index":%d,"id":"call_1","function":{"name":"lookup_order"}}]}}]}`, index),
+		})
+		provider := NewOpenAIProvider(Config{APIKey: "sk-test", BaseURL: server.URL})
+		_, err := provider.ChatStream(context.Background(), &ChatRequest{}, func(chunk Chunk) error { return nil })
+		assert.EqualError(t, err, fmt.Sprintf("invalid llm tool call index: %d", index))
+	}
+}
+
+func TestOpenAIProvider_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error":{"message":"invalid api key"}}`)
+	}))
+	defer server.Close()
+
+	provider := NewOpenAIProvider(Config{BaseURL: server.URL})
+	_, err := provider.ChatStream(context.Background(), &ChatRequest{}, func(chunk Chunk) error { return nil })
+	assert.EqualError(t, err, "llm request failed: 401 invalid api key")
+}
+
+func TestNew_UnknownProvider(t *testing.T) {
+	_, err := New("", Config{})
+	assert.ErrorIs(t, err, ErrProviderNotConfigured)
+
+	_, err = New("not-exists", Config{})
+	assert.Error(t, err)
+	assert.Contains(t, Providers(), ProviderOpenAI)
+	assert.Contains(t, Providers(), ProviderFake)
+}
@@ -0,0 +1,33 @@
+package llm
+
+import "unicode/utf8"
+
+// messageOverheadTokens the tokens framing every message of a prompt
+const messageOverheadTokens = 4
+
+// EstimateTokens estimates the tokens of the text without the tokenizer of
+// the model: about one token per CJK character and per four bytes of other
+// text, which rather overestimates
+func EstimateTokens(text string) int {
+	wide, other := 0, 0
+	for _, r := range text {
+		if r >= 0x2E80 {
+			wide++
+		} else {
+			other += utf8.RuneLen(r)
+		}
+	}
+	return wide + (other+3)/4
+}
+
+// EstimateMessageTokens estimates the prompt tokens of the messages
+func EstimateMessageTokens(messages ...Message) int {
+	tokens := 0
+	for _, msg := range messages {
+		tokens += messageOverheadTokens + EstimateTokens(msg.Content)
+		for _, call := range msg.ToolCalls {
+			tokens += EstimateTokens(call.Name) + EstimateTokens(call.Arguments)
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,23 @@
+package llm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	assert.Equal(t, 0, EstimateTokens(""))
+	assert.Equal(t, 1, EstimateTokens("abc"))
+	assert.Equal(t, 3, EstimateTokens("hello world"))
+	assert.Equal(t, 4, EstimateTokens("你好世界"))
+	assert.Equal(t, 4, EstimateTokens("你好 world"))
+}
+
+func TestEstimateMessageTokens(t *testing.T) {
+	assert.Equal(t, 0, EstimateMessageTokens())
+	assert.Equal(t, 2*messageOverheadTokens+3+1, EstimateMessageTokens(
+		Message{Role: RoleUser, Content: "hello world"},
+		Message{Role: RoleAssistant, ToolCalls: []ToolCall{{Name: "wea", Arguments: ""}}},
+	))
+}
@@ -0,0 +1,423 @@
+package pbx
+
+import (
+	"VoiceSculptor/pkg/util"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+const callEventQueueSize = 16
+
+// Handler handles an incoming call until it returns, the call is rejected if
+// it was not answered and hung up otherwise
+type Handler func(ctx context.Context, call *Call)
+
+// Client a connection to the call control of the PBX
+type Client struct {
+	config Config
+	ws     *websocket.Conn
+	wsMu   sync.Mutex
+
+	mu     sync.Mutex
+	calls  map[string]*Call
+	closed bool
+}
+
+// Dial connects to the events WebSocket of the PBX
+func Dial(ctx context.Context, config Config) (*Client, error) {
+	if config.WebSocketURL == "" {
+		return nil, ErrNotConfigured
+	}
+	config = config.withDefaults()
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, config.WebSocketURL+EventsPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("dial pbx: %w", err)
+	}
+	return &Client{config: config, ws: ws, calls: make(map[string]*Call)}, nil
+}
+
+// Run dispatches the events of the PBX, each incoming call is handled by its
+// own goroutine. It returns once the connection is lost or ctx is done, after
+// the handlers returned.
+func (cl *Client) Run(ctx context.Context, handle Handler) error {
+	stop := context.AfterFunc(ctx, func() { cl.Close() })
+	defer stop()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		var ev Event
+		if err := cl.ws.ReadJSON(&ev); err != nil {
+			cl.endCalls()
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if ev.Event == EventIncoming {
+			call := cl.newCall(ev)
+			if call == nil {
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer call.Close()
+				handle(ctx, call)
+			}()
+			continue
+		}
+		cl.mu.Lock()
+		call := cl.calls[ev.CallID]
+		cl.mu.Unlock()
+		if call != nil {
+			call.dispatch(ev)
+		}
+	}
+}
+
+// Close closes the connection, the calls in progress end
+func (cl *Client) Close() error {
+	cl.mu.Lock()
+	cl.closed = true
+	cl.mu.Unlock()
+	return cl.ws.Close()
+}
+
+// Calls returns the active calls of the PBX through its HTTP API
+func (cl *Client) Calls(ctx context.Context) ([]CallInfo, error) {
+	if cl.config.URL == "" {
+		return nil, ErrNotConfigured
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cl.config.URL+CallsPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("list pbx calls: %s", resp.Status)
+	}
+	var body struct {
+		Calls []CallInfo `json:"calls"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	return body.Calls, nil
+}
+
+// Invite places an outbound call from the caller number to the callee and
+// returns once the callee answered, with its media connected. A call which was
+// not answered returns ErrBusy, ErrNoAnswer or ErrRejected, a call still ringing
+// when ctx is done is cancelled. The events are only received while Run is
+// running. The call must be closed.
+func (cl *Client) Invite(ctx context.Context, caller, callee string) (*Call, error) {
+	id, err := util.GenerateSecureToken(12)
+	if err != nil {
+		return nil, err
+	}
+	call := cl.newCall(Event{CallID: id, Caller: caller, Callee: callee})
+	call.outbound = true
+	err = cl.send(Command{
+		Command: CommandInvite,
+		CallID:  id,
+		Caller:  caller,
+		Callee:  callee,
+		Option:  cl.config.mediaOption(),
+	})
+	if err != nil {
+		call.Close()
+		return nil, err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			call.Close()
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, ErrNoAnswer
+			}
+			return nil, ctx.Err()
+		case ev := <-call.events:
+			switch ev.Event {
+			case EventAnswer:
+				if err := call.connect(ctx); err != nil {
+					call.Close()
+					return nil, err
+				}
+				return call, nil
+			case EventHangup, EventError:
+				call.Close()
+				return nil, inviteError(ev)
+			}
+		case <-call.Done():
+			// the hangup is queued before the call ends
+			for {
+				select {
+				case ev := <-call.events:
+					if ev.Event == EventHangup {
+						call.Close()
+						return nil, inviteError(ev)
+					}
+					continue
+				default:
+				}
+				break
+			}
+			call.Close()
+			return nil, ErrCallEnded
+		}
+	}
+}
+
+func inviteError(ev Event) error {
+	switch ev.Reason {
+	case ReasonBusy:
+		return ErrBusy
+	case ReasonNoAnswer:
+		return ErrNoAnswer
+	}
+	return fmt.Errorf("%w: %s%s", ErrRejected, ev.Reason, ev.Error)
+}
+
+func (cl *Client) send(cmd Command) error {
+	cl.mu.Lock()
+	closed := cl.closed
+	cl.mu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	cl.wsMu.Lock()
+	defer cl.wsMu.Unlock()
+	return cl.ws.WriteJSON(cmd)
+}
+
+// newCall registers an incoming call, nil for a call already known
+func (cl *Client) newCall(ev Event) *Call {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if _, ok := cl.calls[ev.CallID]; ok {
+		return nil
+	}
+	call := &Call{
+		ID:     ev.CallID,
+		Caller: ev.Caller,
+		Callee: ev.Callee,
+		client: cl,
+		events: make(chan Event, callEventQueueSize),
+		keys:   make(chan string, callEventQueueSize),
+		ended:  make(chan struct{}),
+	}
+	cl.calls[call.ID] = call
+	return call
+}
+
+func (cl *Client) removeCall(id string) {
+	cl.mu.Lock()
+	delete(cl.calls, id)
+	cl.mu.Unlock()
+}
+
+func (cl *Client) endCalls() {
+	cl.mu.Lock()
+	calls := make([]*Call, 0, len(cl.calls))
+	for _, call := range cl.calls {
+		calls = append(calls, call)
+	}
+	cl.mu.Unlock()
+	for _, call := range calls {
+		call.end()
+	}
+}
+
+// Call a call of the PBX. Its audio can be read and written once answered,
+// as 16 bit little endian mono PCM at the SampleRate of the Config.
+type Call struct {
+	ID     string
+	Caller string
+	Callee string
+
+	client *Client
+	events chan Event
+	keys   chan string
+	ended  chan struct{}
+
+	mu       sync.Mutex
+	outbound bool // placed by Invite
+	answered bool
+	media    *websocket.Conn
+	mediaMu  sync.Mutex // serializes the writes of the media
+	endOnce  sync.Once
+	closed   bool
+}
+
+// Events returns the events of the call other than incoming, such as dtmf,
+// events are dropped while the queue is full
+func (c *Call) Events() <-chan Event {
+	return c.events
+}
+
+// Keys returns the keys pressed by the caller, the digits of the dtmf events,
+// keys are dropped while the queue is full
+func (c *Call) Keys() <-chan string {
+	return c.keys
+}
+
+// Done is closed once the call ended
+func (c *Call) Done() <-chan struct{} {
+	return c.ended
+}
+
+// Answer accepts the call and connects its media
+func (c *Call) Answer(ctx context.Context) error {
+	err := c.client.send(Command{
+		Command: CommandAccept,
+		CallID:  c.ID,
+		Option:  c.client.config.mediaOption(),
+	})
+	if err != nil {
+		return err
+	}
+	return c.connect(ctx)
+}
+
+// connect connects the media of the answered call
+func (c *Call) connect(ctx context.Context) error {
+	media, _, err := websocket.DefaultDialer.DialContext(ctx, c.client.config.mediaURL(c.ID), nil)
+	if err != nil {
+		return fmt.Errorf("dial pbx media: %w", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.ended:
+		media.Close()
+		return ErrCallEnded
+	default:
+	}
+	c.answered, c.media = true, media
+	return nil
+}
+
+// Reject declines a call not answered yet
+func (c *Call) Reject(reason string) error {
+	defer c.end()
+	return c.client.send(Command{Command: CommandReject, CallID: c.ID, Reason: reason})
+}
+
+// Hangup ends the call
+func (c *Call) Hangup(reason string) error {
+	defer c.end()
+	return c.client.send(Command{Command: CommandHangup, CallID: c.ID, Reason: reason})
+}
+
+// Transfer hands the call over to the target, a number or a SIP URI,
+// the call ends once the PBX reports its hangup
+func (c *Call) Transfer(target string) error {
+	return c.client.send(Command{Command: CommandRefer, CallID: c.ID, Target: target})
+}
+
+// ReadFrame returns the next audio of the caller
+func (c *Call) ReadFrame() ([]byte, error) {
+	c.mu.Lock()
+	media := c.media
+	c.mu.Unlock()
+	if media == nil {
+		return nil, ErrNotAnswered
+	}
+	for {
+		kind, data, err := media.ReadMessage()
+		if err != nil {
+			select {
+			case <-c.ended:
+				return nil, ErrCallEnded
+			default:
+				return nil, err
+			}
+		}
+		if kind == websocket.BinaryMessage {
+			return data, nil
+		}
+	}
+}
+
+// WriteFrame sends audio to the caller
+func (c *Call) WriteFrame(pcm []byte) error {
+	c.mu.Lock()
+	media := c.media
+	c.mu.Unlock()
+	if media == nil {
+		return ErrNotAnswered
+	}
+	select {
+	case <-c.ended:
+		return ErrCallEnded
+	default:
+	}
+	c.mediaMu.Lock()
+	defer c.mediaMu.Unlock()
+	return media.WriteMessage(websocket.BinaryMessage, pcm)
+}
+
+// Close ends the call, an incoming call not answered is rejected, other calls are hung up
+func (c *Call) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	hangup := c.answered || c.outbound
+	c.mu.Unlock()
+
+	var err error
+	select {
+	case <-c.ended:
+	default:
+		if hangup {
+			err = c.Hangup("")
+		} else {
+			err = c.Reject("")
+		}
+	}
+	c.end()
+	c.client.removeCall(c.ID)
+	return err
+}
+
+func (c *Call) dispatch(ev Event) {
+	select {
+	case c.events <- ev:
+	default:
+	}
+	if ev.Event == EventDTMF && ev.Digit != "" {
+		select {
+		case c.keys <- ev.Digit:
+		default:
+		}
+	}
+	if ev.Event == EventHangup {
+		c.end()
+	}
+}
+
+// end marks the call ended and releases its media, unblocking ReadFrame
+func (c *Call) end() {
+	c.endOnce.Do(func() {
+		c.mu.Lock()
+		close(c.ended)
+		media := c.media
+		c.mu.Unlock()
+		if media != nil {
+			media.Close()
+		}
+	})
+}
@@ -0,0 +1,124 @@
+// Package pbx is a client of the call control of RustPBX.
+//
+// The PBX pushes the events of its calls as JSON text messages over the
+// events WebSocket, the client answers with commands on the same connection.
+// Once a call is accepted its audio is exchanged over a WebSocket of the call
+// as binary messages of 16 bit little endian mono PCM, both ways.
+package pbx
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// EventsPath the events WebSocket, relative to the WebSocket URL
+	EventsPath = "/call/events"
+	// CallsPath lists the active calls, relative to the HTTP URL
+	CallsPath = "/call/lists"
+
+	DefaultSampleRate = 16000
+	// CodecPCM the media of an accepted call is raw PCM
+	CodecPCM = "pcm"
+)
+
+var ErrNotConfigured = errors.New("pbx not configured")
+var ErrClosed = errors.New("pbx connection closed")
+var ErrCallEnded = errors.New("pbx call ended")
+var ErrNotAnswered = errors.New("pbx call not answered")
+
+// outcomes of an outbound call which was not answered
+var ErrBusy = errors.New("pbx callee busy")
+var ErrNoAnswer = errors.New("pbx callee did not answer")
+var ErrRejected = errors.New("pbx call rejected")
+
+// events sent by the PBX
+const (
+	EventIncoming = "incoming"
+	EventRinging  = "ringing"
+	EventAnswer   = "answer"
+	EventHangup   = "hangup"
+	EventDTMF     = "dtmf"
+	EventError    = "error"
+)
+
+// commands sent to the PBX
+const (
+	CommandInvite = "invite" // an outbound call
+	CommandAccept = "accept"
+	CommandReject = "reject"
+	CommandHangup = "hangup"
+	CommandRefer  = "refer" // blind transfer
+)
+
+// hangup reasons of an outbound call which was not answered
+const (
+	ReasonBusy     = "busy"
+	ReasonNoAnswer = "no_answer"
+)
+
+// Config the endpoints of the PBX, RustPbxUrl and RustPbxWebSocketURL of config.Config
+type Config struct {
+	URL          string // HTTP API, e.g. http://localhost:8080
+	WebSocketURL string // e.g. ws://localhost:8080
+	// SampleRate of the media of the calls, DefaultSampleRate when 0
+	SampleRate int
+}
+
+func (c Config) withDefaults() Config {
+	if c.SampleRate <= 0 {
+		c.SampleRate = DefaultSampleRate
+	}
+	c.URL = strings.TrimRight(c.URL, "/")
+	c.WebSocketURL = strings.TrimRight(c.WebSocketURL, "/")
+	return c
+}
+
+// mediaURL the WebSocket carrying the audio of a call
+func (c Config) mediaURL(callID string) string {
+	return c.WebSocketURL + "/call/" + url.PathEscape(callID) + "/media?sampleRate=" + strconv.Itoa(c.SampleRate)
+}
+
+func (c Config) mediaOption() *MediaOption {
+	return &MediaOption{Codec: CodecPCM, SampleRate: c.SampleRate}
+}
+
+// Event an event of a call, the fields used depend on the event
+type Event struct {
+	Event     string `json:"event"`
+	CallID    string `json:"callId"`
+	Caller    string `json:"caller,omitempty"`
+	Callee    string `json:"callee,omitempty"`
+	Digit     string `json:"digit,omitempty"` // dtmf
+	Reason    string `json:"reason,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Timestamp int64  `json:"timestamp,omitempty"` // unix milliseconds
+}
+
+// Command a command on a call, the fields used depend on the command
+type Command struct {
+	Command string       `json:"command"`
+	CallID  string       `json:"callId"`
+	Caller  string       `json:"caller,omitempty"` // invite
+	Callee  string       `json:"callee,omitempty"` // invite
+	Target  string       `json:"target,omitempty"` // refer
+	Reason  string       `json:"reason,omitempty"`
+	Option  *MediaOption `json:"option,omitempty"` // invite, accept
+}
+
+// MediaOption the audio format of an accepted call
+type MediaOption struct {
+	Codec      string `json:"codec"`
+	SampleRate int    `json:"sampleRate"`
+}
+
+// CallInfo an active call of the PBX
+type CallInfo struct {
+	ID        string    `json:"id"`
+	Caller    string    `json:"caller"`
+	Callee    string    `json:"callee"`
+	CreatedAt time.Time `json:"createdAt"`
+}
@@ -0,0 +1,251 @@
+package pbx
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePBX pushes events over the events WebSocket and echoes the media of the calls
+type fakePBX struct {
+	t         *testing.T
+	server    *httptest.Server
+	commands  chan Command
+	media     chan string // the sample rate of each media connection
+	connected chan struct{}
+
+	mu sync.Mutex
+	ws *websocket.Conn
+}
+
+func newFakePBX(t *testing.T) *fakePBX {
+	p := &fakePBX{t: t, commands: make(chan Command, 16), media: make(chan string, 4), connected: make(chan struct{})}
+	upgrader := websocket.Upgrader{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+EventsPath, func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		p.mu.Lock()
+		p.ws = ws
+		p.mu.Unlock()
+		close(p.connected)
+		for {
+			var cmd Command
+			if err := ws.ReadJSON(&cmd); err != nil {
+				return
+			}
+			p.commands <- cmd
+		}
+	})
+	mux.HandleFunc("GET /call/{id}/media", func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		defer ws.Close()
+		p.media <- r.URL.Query().Get("sampleRate")
+		for {
+			kind, data, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := ws.WriteMessage(kind, data); err != nil {
+				return
+			}
+		}
+	})
+	mux.HandleFunc("GET "+CallsPath, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"calls": []CallInfo{{ID: "c1", Caller: "1001", Callee: "2000"}}})
+	})
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *fakePBX) config() Config {
+	return Config{URL: p.server.URL, WebSocketURL: "ws" + strings.TrimPrefix(p.server.URL, "http")}
+}
+
+func (p *fakePBX) push(ev Event) {
+	<-p.connected
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	require.NoError(p.t, p.ws.WriteJSON(ev))
+}
+
+func (p *fakePBX) command() Command {
+	select {
+	case cmd := <-p.commands:
+		return cmd
+	case <-time.After(5 * time.Second):
+		p.t.Fatal("no command received")
+		return Command{}
+	}
+}
+
+func dial(t *testing.T, p *fakePBX, handle Handler) chan error {
+	client, err := Dial(context.Background(), p.config())
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- client.Run(ctx, handle) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return done
+}
+
+func TestClient_AnswerAndHangup(t *testing.T) {
+	p := newFakePBX(t)
+	result := make(chan error, 1)
+	dial(t, p, func(ctx context.Context, call *Call) {
+		assert.Equal(t, "1001", call.Caller)
+		assert.Equal(t, "2000", call.Callee)
+		if err := call.Answer(ctx); err != nil {
+			result <- err
+			return
+		}
+		if err := call.WriteFrame([]byte{1, 2, 3, 4}); err != nil {
+			result <- err
+			return
+		}
+		frame, err := call.ReadFrame()
+		if err == nil {
+			assert.Equal(t, []byte{1, 2, 3, 4}, frame)
+			assert.Equal(t, EventDTMF, (<-call.Events()).Event)
+			assert.Equal(t, "5", <-call.Keys())
+			// blocks until the PBX hangs up
+			_, err = call.ReadFrame()
+		}
+		result <- err
+	})
+
+	p.push(Event{Event: EventIncoming, CallID: "c1", Caller: "1001", Callee: "2000"})
+	accept := p.command()
+	assert.Equal(t, Command{Command: CommandAccept, CallID: "c1", Option: &MediaOption{Codec: CodecPCM, SampleRate: DefaultSampleRate}}, accept)
+	assert.Equal(t, "16000", <-p.media)
+
+	p.push(Event{Event: EventDTMF, CallID: "c1", Digit: "5"})
+	p.push(Event{Event: EventHangup, CallID: "c1"})
+	select {
+	case err := <-result:
+		assert.ErrorIs(t, err, ErrCallEnded)
+	case <-time.After(5 * time.Second):
+		t.Fatal("call not ended after hangup")
+	}
+	// the call hung up by the PBX is not hung up again
+	select {
+	case cmd := <-p.commands:
+		t.Fatalf("unexpected command %s", cmd.Command)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestClient_RejectAndTransfer(t *testing.T) {
+	p := newFakePBX(t)
+	dial(t, p, func(ctx context.Context, call *Call) {
+		if call.ID == "c2" {
+			require.NoError(t, call.Answer(ctx))
+			require.NoError(t, call.Transfer("sip:1002@pbx"))
+			<-call.Done()
+		}
+	})
+
+	// a call the handler does not answer is rejected
+	p.push(Event{Event: EventIncoming, CallID: "c1"})
+	assert.Equal(t, Command{Command: CommandReject, CallID: "c1"}, p.command())
+
+	p.push(Event{Event: EventIncoming, CallID: "c2"})
+	assert.Equal(t, CommandAccept, p.command().Command)
+	assert.Equal(t, Command{Command: CommandRefer, CallID: "c2", Target: "sip:1002@pbx"}, p.command())
+	p.push(Event{Event: EventHangup, CallID: "c2", Reason: "transferred"})
+}
+
+func TestClient_Calls(t *testing.T) {
+	p := newFakePBX(t)
+	client, err := Dial(context.Background(), p.config())
+	require.NoError(t, err)
+	defer client.Close()
+	calls, err := client.Calls(context.Background())
+	require.NoError(t, err)
+	require.Len(t, calls, 1)
+	assert.Equal(t, "c1", calls[0].ID)
+
+	_, err = Dial(context.Background(), Config{})
+	assert.ErrorIs(t, err, ErrNotConfigured)
+}
+
+func TestClient_ConnectionLost(t *testing.T) {
+	p := newFakePBX(t)
+	ended := make(chan struct{})
+	done := dial(t, p, func(ctx context.Context, call *Call) {
+		<-call.Done()
+		close(ended)
+	})
+	p.push(Event{Event: EventIncoming, CallID: "c1"})
+	p.mu.Lock()
+	p.ws.Close()
+	p.mu.Unlock()
+	select {
+	case <-ended:
+	case <-time.After(5 * time.Second):
+		t.Fatal("call not ended with the connection")
+	}
+	assert.Error(t, <-done)
+	done <- nil // for the cleanup
+}
+
+func TestClient_Invite(t *testing.T) {
+	p := newFakePBX(t)
+	client, err := Dial(context.Background(), p.config())
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- client.Run(ctx, func(ctx context.Context, call *Call) {}) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// the PBX answers the first call, the second callee is busy
+	go func() {
+		invite := p.command()
+		assert.Equal(t, CommandInvite, invite.Command)
+		assert.Equal(t, "1001", invite.Caller)
+		assert.Equal(t, "2000", invite.Callee)
+		p.push(Event{Event: EventRinging, CallID: invite.CallID})
+		p.push(Event{Event: EventAnswer, CallID: invite.CallID})
+	}()
+	call, err := client.Invite(ctx, "1001", "2000")
+	require.NoError(t, err)
+	assert.Equal(t, "16000", <-p.media)
+	require.NoError(t, call.WriteFrame([]byte{1, 2}))
+	frame, err := call.ReadFrame()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, 2}, frame)
+	require.NoError(t, call.Close())
+	assert.Equal(t, CommandHangup, p.command().Command)
+
+	go func() {
+		invite := p.command()
+		p.push(Event{Event: EventHangup, CallID: invite.CallID, Reason: ReasonBusy})
+	}()
+	_, err = client.Invite(ctx, "1001", "2001")
+	assert.ErrorIs(t, err, ErrBusy)
+
+	// a callee still ringing at the deadline did not answer, the call is cancelled
+	ringing, stop := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer stop()
+	_, err = client.Invite(ringing, "1001", "2002")
+	assert.ErrorIs(t, err, ErrNoAnswer)
+	assert.Equal(t, CommandInvite, p.command().Command)
+	assert.Equal(t, CommandHangup, p.command().Command)
+}
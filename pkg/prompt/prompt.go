@@ -0,0 +1,142 @@
+package prompt
+
+import (
+	"VoiceSculptor/pkg/util"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrPromptNotFound = &util.Error{Code: http.StatusNotFound, Message: "prompt not found"}
+
+// PromptModel a named prompt, the template is rendered with Go text/template
+type PromptModel struct {
+	ID          uint             `json:"id" gorm:"primaryKey"`
+	CreatedAt   time.Time        `json:"createdAt"`
+	UpdatedAt   time.Time        `json:"updatedAt"`
+	Name        string           `json:"name" gorm:"size:128;uniqueIndex"`
+	Description string           `json:"description"`
+	Template    string           `json:"template" gorm:"type:text"` // 例如: 请总结以下文章: {{.content}}
+	Version     int              `json:"version"`                   // 最新版本号, 每次修改模板或参数自动递增
+	Args        []PromptArgModel `json:"args,omitempty" gorm:"foreignKey:PromptID"`
+}
+
+// PromptArgModel an argument of a prompt, referenced as {{.name}} in the template
+type PromptArgModel struct {
+	ID          uint   `json:"id" gorm:"primaryKey"`
+	Name        string `json:"name" gorm:"size:64"`
+	Description string `json:"description"`
+	Required    bool   `json:"required"`
+	Default     string `json:"default,omitempty"` // 可选参数未传入时使用
+	PromptID    uint   `json:"promptId" gorm:"index"`
+}
+
+// AfterSave records a version if the template or the arguments changed and drops
+// the compiled prompts, they are reloaded on next use
+func (m *PromptModel) AfterSave(tx *gorm.DB) error {
+	if err := recordVersion(tx.Session(&gorm.Session{NewDB: true}), m); err != nil {
+		return err
+	}
+	invalidateDefault()
+	return nil
+}
+
+func (m *PromptModel) AfterDelete(tx *gorm.DB) error {
+	invalidateDefault()
+	return nil
+}
+
+// AfterSave records a version of the prompt, the arguments are part of it
+func (m *PromptArgModel) AfterSave(tx *gorm.DB) error {
+	if err := recordArgsVersion(tx, m.PromptID); err != nil {
+		return err
+	}
+	invalidateDefault()
+	return nil
+}
+
+func (m *PromptArgModel) AfterDelete(tx *gorm.DB) error {
+	if err := recordArgsVersion(tx, m.PromptID); err != nil {
+		return err
+	}
+	invalidateDefault()
+	return nil
+}
+
+// Prompt a compiled prompt ready to render
+type Prompt struct {
+	Name        string           `json:"name"`
+	Version     int              `json:"version"`
+	Description string           `json:"description"`
+	Args        []PromptArgModel `json:"args"`
+	tmpl        *template.Template
+}
+
+// ParseTemplate checks the syntax of a prompt template
+func ParseTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template of prompt %s: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// Compile parses the template of the prompt
+func Compile(m *PromptModel) (*Prompt, error) {
+	tmpl, err := ParseTemplate(m.Name, m.Template)
+	if err != nil {
+		return nil, err
+	}
+	return &Prompt{
+		Name:        m.Name,
+		Version:     m.Version,
+		Description: m.Description,
+		Args:        m.Args,
+		tmpl:        tmpl,
+	}, nil
+}
+
+// Validate returns an error listing the required arguments not supplied
+func (p *Prompt) Validate(args map[string]any) error {
+	var missing []string
+	for _, arg := range p.Args {
+		if !arg.Required {
+			continue
+		}
+		if v, ok := args[arg.Name]; !ok || v == nil || v == "" {
+			missing = append(missing, arg.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return &util.Error{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("prompt %s missing required arguments: %s", p.Name, strings.Join(missing, ", ")),
+		}
+	}
+	return nil
+}
+
+// Render validates the arguments and executes the template,
+// optional arguments not supplied take their default value
+func (p *Prompt) Render(args map[string]any) (string, error) {
+	if err := p.Validate(args); err != nil {
+		return "", err
+	}
+	data := make(map[string]any, len(args)+len(p.Args))
+	for _, arg := range p.Args {
+		data[arg.Name] = arg.Default
+	}
+	for k, v := range args {
+		data[k] = v
+	}
+
+	var sb strings.Builder
+	if err := p.tmpl.Execute(&sb, data); err != nil {
+		return "", &util.Error{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	return sb.String(), nil
+}
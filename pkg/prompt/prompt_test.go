@@ -0,0 +1,84 @@
+package prompt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&PromptModel{}, &PromptArgModel{}, &PromptVersionModel{}))
+	return db
+}
+
+func TestRegistry_Render(t *testing.T) {
+	db := setupTestDB(t)
+	require.NoError(t, db.Create(&PromptModel{
+		Name:     "email_reply_generator",
+		Template: "Reply in a {{.tone}} tone: {{.email_body}}",
+		Args: []PromptArgModel{
+			{Name: "email_body", Required: true},
+			{Name: "tone", Default: "formal"},
+		},
+	}).Error)
+	require.NoError(t, InitPromptSystem(db))
+
+	text, err := Render("email_reply_generator", map[string]any{"email_body": "hello"})
+	require.NoError(t, err)
+	assert.Equal(t, "Reply in a formal tone: hello", text)
+
+	text, err = Render("email_reply_generator", map[string]any{"email_body": "hello", "tone": "casual"})
+	require.NoError(t, err)
+	assert.Equal(t, "Reply in a casual tone: hello", text)
+
+	_, err = Render("email_reply_generator", map[string]any{"tone": "casual"})
+	assert.ErrorContains(t, err, "prompt email_reply_generator missing required arguments: email_body")
+
+	_, err = Render("not_exists", nil)
+	assert.ErrorIs(t, err, ErrPromptNotFound)
+}
+
+func TestRegistry_ReloadAfterSave(t *testing.T) {
+	db := setupTestDB(t)
+	m := &PromptModel{Name: "greet", Template: "Hi {{.name}}", Args: []PromptArgModel{{Name: "name", Required: true}}}
+	require.NoError(t, db.Create(m).Error)
+	require.NoError(t, InitPromptSystem(db))
+	assert.Len(t, Default().List(), 1)
+
+	text, err := Render("greet", map[string]any{"name": "Bob"})
+	require.NoError(t, err)
+	assert.Equal(t, "Hi Bob", text)
+
+	m.Template = "Hello {{.name}}"
+	require.NoError(t, db.Save(m).Error)
+	text, err = Render("greet", map[string]any{"name": "Bob"})
+	require.NoError(t, err)
+	assert.Equal(t, "Hello Bob", text)
+}
+
+func TestRegistry_LoadInvalidTemplate(t *testing.T) {
+	db := setupTestDB(t)
+	require.NoError(t, db.Create(&PromptModel{Name: "ok", Template: "fine"}).Error)
+	require.NoError(t, db.Create(&PromptModel{Name: "broken", Template: "{{.name"}).Error)
+
+	r := NewRegistry(db)
+	assert.Error(t, r.Load())
+	text, err := r.Render("ok", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "fine", text)
+
+	_, err = ParseTemplate("broken", "{{.name")
+	assert.Error(t, err)
+}
+
+func TestPrompt_UndeclaredArg(t *testing.T) {
+	p, err := Compile(&PromptModel{Name: "p", Template: "{{.unknown}}"})
+	require.NoError(t, err)
+	_, err = p.Render(map[string]any{})
+	assert.Error(t, err)
+}
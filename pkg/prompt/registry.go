@@ -0,0 +1,190 @@
+package prompt
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+var ErrPromptSystemNotInitialized = errors.New("prompt system is not initialized")
+
+// Registry caches the compiled prompts stored in the database
+type Registry struct {
+	db       *gorm.DB
+	mu       sync.RWMutex
+	prompts  map[string]*Prompt
+	versions map[string]*Prompt // name@version
+}
+
+func NewRegistry(db *gorm.DB) *Registry {
+	return &Registry{
+		db:       db,
+		prompts:  make(map[string]*Prompt),
+		versions: make(map[string]*Prompt),
+	}
+}
+
+// Load compiles all prompts of the database, the prompts with an
+// invalid template are skipped and reported in the returned error
+func (r *Registry) Load() error {
+	var models []PromptModel
+	if err := r.db.Preload("Args").Find(&models).Error; err != nil {
+		return err
+	}
+	prompts := make(map[string]*Prompt, len(models))
+	var errs []error
+	for i := range models {
+		p, err := Compile(&models[i])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		prompts[p.Name] = p
+	}
+
+	r.mu.Lock()
+	r.prompts = prompts
+	r.mu.Unlock()
+	return errors.Join(errs...)
+}
+
+// Get returns the compiled prompt, loading it from the database if not cached
+func (r *Registry) Get(name string) (*Prompt, error) {
+	r.mu.RLock()
+	p, ok := r.prompts[name]
+	r.mu.RUnlock()
+	if ok {
+		return p, nil
+	}
+
+	m, err := GetPromptByName(r.db, name)
+	if err != nil {
+		return nil, err
+	}
+	p, err = Compile(m)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.prompts[name] = p
+	r.mu.Unlock()
+	return p, nil
+}
+
+// GetVersion returns the compiled version of the prompt, the latest if version is 0
+func (r *Registry) GetVersion(name string, version int) (*Prompt, error) {
+	if version <= 0 {
+		return r.Get(name)
+	}
+	key := fmt.Sprintf("%s@%d", name, version)
+	r.mu.RLock()
+	p, ok := r.versions[key]
+	r.mu.RUnlock()
+	if ok {
+		return p, nil
+	}
+
+	m, err := GetPromptByName(r.db, name)
+	if err != nil {
+		return nil, err
+	}
+	v, err := GetVersion(r.db, m.ID, version)
+	if err != nil {
+		return nil, err
+	}
+	p, err = v.Compile(name)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.versions[key] = p
+	r.mu.Unlock()
+	return p, nil
+}
+
+// List returns the cached prompts ordered by name
+func (r *Registry) List() []*Prompt {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	prompts := make([]*Prompt, 0, len(r.prompts))
+	for _, p := range r.prompts {
+		prompts = append(prompts, p)
+	}
+	sort.Slice(prompts, func(i, j int) bool { return prompts[i].Name < prompts[j].Name })
+	return prompts
+}
+
+// Render renders the named prompt with the arguments
+func (r *Registry) Render(name string, args map[string]any) (string, error) {
+	p, err := r.Get(name)
+	if err != nil {
+		return "", err
+	}
+	return p.Render(args)
+}
+
+// RenderVersion renders a version of the prompt, the latest if version is 0
+func (r *Registry) RenderVersion(name string, version int, args map[string]any) (string, error) {
+	p, err := r.GetVersion(name, version)
+	if err != nil {
+		return "", err
+	}
+	return p.Render(args)
+}
+
+// Invalidate drops the cached prompts
+func (r *Registry) Invalidate() {
+	r.mu.Lock()
+	r.prompts = make(map[string]*Prompt)
+	r.versions = make(map[string]*Prompt)
+	r.mu.Unlock()
+}
+
+var (
+	defaultMu       sync.RWMutex
+	defaultRegistry *Registry
+)
+
+// InitPromptSystem creates the default registry and loads the prompts of the database
+func InitPromptSystem(db *gorm.DB) error {
+	r := NewRegistry(db)
+	err := r.Load()
+	defaultMu.Lock()
+	defaultRegistry = r
+	defaultMu.Unlock()
+	return err
+}
+
+// Default returns the registry created by InitPromptSystem, nil if not initialized
+func Default() *Registry {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultRegistry
+}
+
+// Render renders the named prompt with the default registry
+func Render(name string, args map[string]any) (string, error) {
+	r := Default()
+	if r == nil {
+		return "", ErrPromptSystemNotInitialized
+	}
+	return r.Render(name, args)
+}
+
+// RenderVersion renders a version of the named prompt with the default registry
+func RenderVersion(name string, version int, args map[string]any) (string, error) {
+	r := Default()
+	if r == nil {
+		return "", ErrPromptSystemNotInitialized
+	}
+	return r.RenderVersion(name, version, args)
+}
+
+func invalidateDefault() {
+	if r := Default(); r != nil {
+		r.Invalidate()
+	}
+}
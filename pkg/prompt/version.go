@@ -0,0 +1,194 @@
+package prompt
+
+import (
+	"VoiceSculptor/pkg/util"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrPromptVersionNotFound = &util.Error{Code: http.StatusNotFound, Message: "prompt version not found"}
+var ErrPromptVersionImmutable = &util.Error{Code: http.StatusForbidden, Message: "prompt version is immutable"}
+
+// skipVersionKey the gorm setting of the argument changes which are recorded
+// with the template, see Rollback
+const skipVersionKey = "prompt:skip_version"
+
+// PromptVersionModel an immutable snapshot of the template and the arguments of a prompt
+type PromptVersionModel struct {
+	ID          uint             `json:"id" gorm:"primaryKey"`
+	CreatedAt   time.Time        `json:"createdAt"`
+	PromptID    uint             `json:"promptId" gorm:"uniqueIndex:idx_prompt_version"`
+	Version     int              `json:"version" gorm:"uniqueIndex:idx_prompt_version"`
+	Description string           `json:"description"`
+	Template    string           `json:"template" gorm:"type:text"`
+	Args        []PromptArgModel `json:"args" gorm:"serializer:json"` // 创建版本时的参数定义
+}
+
+// BeforeUpdate versions can not be changed once recorded
+func (v *PromptVersionModel) BeforeUpdate(tx *gorm.DB) error {
+	return ErrPromptVersionImmutable
+}
+
+// Compile parses the template of the version
+func (v *PromptVersionModel) Compile(name string) (*Prompt, error) {
+	return Compile(&PromptModel{
+		Name:        name,
+		Description: v.Description,
+		Template:    v.Template,
+		Version:     v.Version,
+		Args:        v.Args,
+	})
+}
+
+// ArgsText renders the arguments of the version one per line, to diff them
+func (v *PromptVersionModel) ArgsText() string {
+	lines := make([]string, 0, len(v.Args))
+	for _, arg := range v.Args {
+		var text strings.Builder
+		text.WriteString(arg.Name)
+		if arg.Required {
+			text.WriteString(" (required)")
+		}
+		if arg.Default != "" {
+			fmt.Fprintf(&text, " default=%q", arg.Default)
+		}
+		if arg.Description != "" {
+			text.WriteString(": " + arg.Description)
+		}
+		lines = append(lines, text.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sameArgs reports whether the two argument sets render the same way
+func sameArgs(a, b []PromptArgModel) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].Description != b[i].Description || a[i].Required != b[i].Required || a[i].Default != b[i].Default {
+			return false
+		}
+	}
+	return true
+}
+
+// recordArgsVersion records a version of the prompt once its arguments
+// changed. The arguments created with the prompt are recorded by its first
+// version, so are those restored by Rollback.
+func recordArgsVersion(tx *gorm.DB, promptID uint) error {
+	if promptID == 0 {
+		return nil
+	}
+	if skip, ok := tx.Get(skipVersionKey); ok && skip.(bool) {
+		return nil
+	}
+	db := tx.Session(&gorm.Session{NewDB: true})
+	var versions int64
+	if err := db.Model(&PromptVersionModel{}).Where("prompt_id = ?", promptID).Count(&versions).Error; err != nil || versions == 0 {
+		return err
+	}
+	return recordVersion(db, &PromptModel{ID: promptID})
+}
+
+// recordVersion creates a new version if the template or the arguments
+// stored for the prompt differ from its latest version
+func recordVersion(db *gorm.DB, m *PromptModel) error {
+	if m.ID == 0 {
+		return nil
+	}
+	// the caller may have saved only some of the columns, read back the row
+	var current PromptModel
+	if err := db.Preload("Args", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).Take(&current, m.ID).Error; err != nil {
+		return err
+	}
+
+	var latest PromptVersionModel
+	err := db.Where("prompt_id = ?", m.ID).Order("version DESC").Take(&latest).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if err == nil && latest.Template == current.Template && sameArgs(latest.Args, current.Args) {
+		m.Version = latest.Version
+		return nil
+	}
+
+	version := PromptVersionModel{
+		PromptID:    m.ID,
+		Version:     latest.Version + 1,
+		Description: current.Description,
+		Template:    current.Template,
+		Args:        current.Args,
+	}
+	if err := db.Create(&version).Error; err != nil {
+		return err
+	}
+	m.Version = version.Version
+	return db.Model(&PromptModel{}).Where("id = ?", m.ID).UpdateColumn("version", version.Version).Error
+}
+
+// GetPromptByName returns the prompt with its arguments
+func GetPromptByName(db *gorm.DB, name string) (*PromptModel, error) {
+	var m PromptModel
+	if err := db.Preload("Args").Where("name = ?", name).Take(&m).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPromptNotFound
+		}
+		return nil, err
+	}
+	return &m, nil
+}
+
+// ListVersions returns the versions of the prompt, newest first
+func ListVersions(db *gorm.DB, promptID uint) ([]PromptVersionModel, error) {
+	var versions []PromptVersionModel
+	err := db.Where("prompt_id = ?", promptID).Order("version DESC").Find(&versions).Error
+	return versions, err
+}
+
+// GetVersion returns a version of the prompt
+func GetVersion(db *gorm.DB, promptID uint, version int) (*PromptVersionModel, error) {
+	var v PromptVersionModel
+	if err := db.Where("prompt_id = ? AND version = ?", promptID, version).Take(&v).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPromptVersionNotFound
+		}
+		return nil, err
+	}
+	return &v, nil
+}
+
+// Rollback restores the template and the arguments of a previous version,
+// the history is kept, they are recorded as a new version
+func Rollback(db *gorm.DB, m *PromptModel, version int) error {
+	v, err := GetVersion(db, m.ID, version)
+	if err != nil {
+		return err
+	}
+	if v.Template == m.Template && sameArgs(v.Args, m.Args) {
+		return nil
+	}
+	args := make([]PromptArgModel, len(v.Args))
+	for i, arg := range v.Args {
+		arg.ID, arg.PromptID = 0, m.ID
+		args[i] = arg
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Set(skipVersionKey, true).Where("prompt_id = ?", m.ID).Delete(&PromptArgModel{}).Error; err != nil {
+			return err
+		}
+		if len(args) > 0 {
+			if err := tx.Set(skipVersionKey, true).Create(&args).Error; err != nil {
+				return err
+			}
+		}
+		// records the version
+		m.Template, m.Args = v.Template, args
+		return tx.Model(m).Select("template", "updated_at").Updates(m).Error
+	})
+}
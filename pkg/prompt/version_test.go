@@ -0,0 +1,95 @@
+package prompt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersion_RecordAndRollback(t *testing.T) {
+	db := setupTestDB(t)
+	m := &PromptModel{Name: "greet", Template: "Hi {{.name}}", Args: []PromptArgModel{{Name: "name", Required: true}}}
+	require.NoError(t, db.Create(m).Error)
+	require.NoError(t, InitPromptSystem(db))
+	assert.Equal(t, 1, m.Version)
+
+	m.Description = "only the description changed"
+	require.NoError(t, db.Save(m).Error)
+	assert.Equal(t, 1, m.Version)
+
+	m.Template = "Hello {{.name}}"
+	require.NoError(t, db.Save(m).Error)
+	assert.Equal(t, 2, m.Version)
+
+	versions, err := ListVersions(db, m.ID)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, 2, versions[0].Version)
+	assert.Equal(t, "Hi {{.name}}", versions[1].Template)
+	assert.Equal(t, "name", versions[1].Args[0].Name)
+
+	text, err := RenderVersion("greet", 1, map[string]any{"name": "Bob"})
+	require.NoError(t, err)
+	assert.Equal(t, "Hi Bob", text)
+	text, err = RenderVersion("greet", 0, map[string]any{"name": "Bob"})
+	require.NoError(t, err)
+	assert.Equal(t, "Hello Bob", text)
+
+	require.NoError(t, Rollback(db, m, 1))
+	assert.Equal(t, 3, m.Version)
+	text, err = Render("greet", map[string]any{"name": "Bob"})
+	require.NoError(t, err)
+	assert.Equal(t, "Hi Bob", text)
+
+	assert.ErrorIs(t, Rollback(db, m, 9), ErrPromptVersionNotFound)
+	assert.Error(t, db.Model(&versions[0]).Update("template", "changed").Error)
+}
+
+func TestVersion_RecordsArgs(t *testing.T) {
+	db := setupTestDB(t)
+	m := &PromptModel{Name: "greet", Template: "Hi {{.name}}", Args: []PromptArgModel{{Name: "name", Default: "there"}}}
+	require.NoError(t, db.Create(m).Error)
+	require.NoError(t, InitPromptSystem(db))
+	assert.Equal(t, 1, m.Version)
+
+	// a new default changes what the prompt renders
+	arg := m.Args[0]
+	arg.Default = "friend"
+	require.NoError(t, db.Save(&arg).Error)
+	require.NoError(t, db.Create(&PromptArgModel{Name: "mood", PromptID: m.ID}).Error)
+	versions, err := ListVersions(db, m.ID)
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	assert.Equal(t, "name default=\"friend\"\nmood", versions[0].ArgsText())
+	assert.Equal(t, "name default=\"there\"", versions[2].ArgsText())
+	text, err := Render("greet", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Hi friend", text)
+
+	m, err = GetPromptByName(db, "greet")
+	require.NoError(t, err)
+	require.NoError(t, Rollback(db, m, 1))
+	assert.Equal(t, 4, m.Version)
+	text, err = Render("greet", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Hi there", text)
+	m, err = GetPromptByName(db, "greet")
+	require.NoError(t, err)
+	require.Len(t, m.Args, 1)
+	assert.Equal(t, "there", m.Args[0].Default)
+	v, err := GetVersion(db, m.ID, 4)
+	require.NoError(t, err)
+	assert.Equal(t, versions[2].ArgsText(), v.ArgsText())
+}
+
+func TestDiff(t *testing.T) {
+	lines := Diff("a\nb\nc", "a\nc\nd")
+	assert.Equal(t, []DiffLine{
+		{Op: DiffEqual, Text: "a"},
+		{Op: DiffDelete, Text: "b"},
+		{Op: DiffEqual, Text: "c"},
+		{Op: DiffInsert, Text: "d"},
+	}, lines)
+	assert.Equal(t, " a\n-b\n c\n+d\n", FormatDiff(lines))
+}
@@ -0,0 +1,175 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrInvalidHours = errors.New("invalid hours")
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+const minutesPerDay = 24 * 60
+
+// span an opening of a day in minutes, a span ending before it starts runs past midnight
+type span struct {
+	day      time.Weekday
+	from, to int
+}
+
+// Hours weekly opening hours in a timezone, such as business hours or calling windows
+type Hours struct {
+	Location *time.Location
+	spans    []span // always open when empty
+}
+
+// Parse parses opening hours like "mon-fri 09:00-18:00; sat 10:00-14:00" in the
+// IANA timezone, UTC when empty. The items are separated by ";" or ",", the days
+// are a day, a range of days or "daily", a range ending before it starts runs
+// past midnight and "24:00" is the end of the day. An empty spec is always open.
+func Parse(spec, timezone string) (*Hours, error) {
+	loc := time.UTC
+	if timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(timezone); err != nil {
+			return nil, fmt.Errorf("%w: unknown timezone %s", ErrInvalidHours, timezone)
+		}
+	}
+	h := &Hours{Location: loc}
+	for _, item := range strings.FieldsFunc(spec, func(r rune) bool { return r == ';' || r == ',' }) {
+		fields := strings.Fields(strings.ToLower(item))
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%w: %q is not <days> <hh:mm-hh:mm>", ErrInvalidHours, strings.TrimSpace(item))
+		}
+		days, err := parseDays(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		from, to, err := parseTimes(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		for _, day := range days {
+			h.spans = append(h.spans, span{day: day, from: from, to: to})
+		}
+	}
+	return h, nil
+}
+
+func parseDays(s string) ([]time.Weekday, error) {
+	if s == "daily" {
+		return []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday}, nil
+	}
+	first, last, isRange := strings.Cut(s, "-")
+	from, ok := weekdays[first]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown day %s", ErrInvalidHours, first)
+	}
+	if !isRange {
+		return []time.Weekday{from}, nil
+	}
+	to, ok := weekdays[last]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown day %s", ErrInvalidHours, last)
+	}
+	var days []time.Weekday
+	for day := from; ; day = (day + 1) % 7 {
+		days = append(days, day)
+		if day == to {
+			return days, nil
+		}
+	}
+}
+
+func parseTimes(s string) (from, to int, err error) {
+	start, end, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("%w: %s is not hh:mm-hh:mm", ErrInvalidHours, s)
+	}
+	if from, err = parseClock(start); err != nil {
+		return 0, 0, err
+	}
+	if to, err = parseClock(end); err != nil {
+		return 0, 0, err
+	}
+	if from == to || from == minutesPerDay {
+		return 0, 0, fmt.Errorf("%w: empty range %s", ErrInvalidHours, s)
+	}
+	return from, to, nil
+}
+
+func parseClock(s string) (int, error) {
+	var hour, minute int
+	if n, err := fmt.Sscanf(s, "%d:%d", &hour, &minute); err != nil || n != 2 || len(s) != 5 {
+		return 0, fmt.Errorf("%w: %s is not hh:mm", ErrInvalidHours, s)
+	}
+	if hour == 24 && minute == 0 {
+		return minutesPerDay, nil
+	}
+	if hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return 0, fmt.Errorf("%w: %s is not hh:mm", ErrInvalidHours, s)
+	}
+	return hour*60 + minute, nil
+}
+
+// Always reports whether the hours have no restriction
+func (h *Hours) Always() bool {
+	return len(h.spans) == 0
+}
+
+// Open reports whether t is within the hours
+func (h *Hours) Open(t time.Time) bool {
+	if h.Always() {
+		return true
+	}
+	t = t.In(h.Location)
+	day, minute := t.Weekday(), t.Hour()*60+t.Minute()
+	for _, s := range h.spans {
+		if s.from < s.to {
+			if day == s.day && minute >= s.from && minute < s.to {
+				return true
+			}
+			continue
+		}
+		if (day == s.day && minute >= s.from) || (day == (s.day+1)%7 && minute < s.to) {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns t if the hours are open at t, otherwise the next opening,
+// the zero time if they never open
+func (h *Hours) Next(t time.Time) time.Time {
+	if h.Open(t) {
+		return t
+	}
+	t = t.In(h.Location)
+	var next time.Time
+	for _, s := range h.spans {
+		for d := 0; d <= 7; d++ {
+			day := t.AddDate(0, 0, d)
+			if day.Weekday() != s.day {
+				continue
+			}
+			start := time.Date(day.Year(), day.Month(), day.Day(), s.from/60, s.from%60, 0, 0, h.Location)
+			if start.After(t) {
+				if next.IsZero() || start.Before(next) {
+					next = start
+				}
+				break
+			}
+		}
+	}
+	return next
+}
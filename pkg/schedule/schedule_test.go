@@ -0,0 +1,56 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	h, err := Parse("", "")
+	require.NoError(t, err)
+	assert.True(t, h.Always())
+	assert.True(t, h.Open(time.Now()))
+
+	for _, spec := range []string{"mon 9:00-18:00", "mon 09:00", "monday 09:00-18:00", "mon-xyz 09:00-18:00", "mon 09:00-09:00", "mon 25:00-26:00", "09:00-18:00"} {
+		_, err := Parse(spec, "")
+		assert.ErrorIs(t, err, ErrInvalidHours, spec)
+	}
+	_, err = Parse("daily 09:00-18:00", "Mars/Olympus")
+	assert.ErrorIs(t, err, ErrInvalidHours)
+}
+
+func TestHours_Open(t *testing.T) {
+	h, err := Parse("mon-fri 09:00-18:00; sat 22:00-02:00, sun 10:00-24:00", "Asia/Shanghai")
+	require.NoError(t, err)
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, h.Location)
+		require.NoError(t, err)
+		return v
+	}
+
+	// 2024-01-01 is a Monday
+	assert.False(t, h.Open(at("2024-01-01 08:59")))
+	assert.True(t, h.Open(at("2024-01-01 09:00")))
+	assert.False(t, h.Open(at("2024-01-05 18:00")))
+	// past midnight into Sunday
+	assert.True(t, h.Open(at("2024-01-06 23:30")))
+	assert.True(t, h.Open(at("2024-01-07 01:59")))
+	assert.False(t, h.Open(at("2024-01-07 02:00")))
+	assert.True(t, h.Open(at("2024-01-07 23:59")))
+	// the timezone of the hours applies, 09:30 in Shanghai is 01:30 UTC
+	assert.True(t, h.Open(time.Date(2024, 1, 1, 1, 30, 0, 0, time.UTC)))
+}
+
+func TestHours_Next(t *testing.T) {
+	h, err := Parse("mon-fri 09:00-18:00", "UTC")
+	require.NoError(t, err)
+	friday := time.Date(2024, 1, 5, 19, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, 1, 8, 9, 0, 0, 0, time.UTC), h.Next(friday))
+	monday := time.Date(2024, 1, 8, 8, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, 1, 8, 9, 0, 0, 0, time.UTC), h.Next(monday))
+	open := time.Date(2024, 1, 8, 10, 0, 0, 0, time.UTC)
+	assert.Equal(t, open, h.Next(open))
+}
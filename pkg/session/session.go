@@ -81,6 +81,9 @@ type SessionManager struct {
 	// Expiry time in seconds
 	expirySeconds int
 
+	// Called after an expired session is removed
+	onExpire func(*Session)
+
 	// Mutex for concurrent access
 	mu sync.RWMutex
 }
@@ -108,6 +111,14 @@ func (m *SessionManager) CreateSession() *Session {
 	return session
 }
 
+// SetExpireHandler sets the handler called when a session expires,
+// so resources attached to the session data can be released
+func (m *SessionManager) SetExpireHandler(handler func(*Session)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onExpire = handler
+}
+
 // GetSession retrieves a session
 func (m *SessionManager) GetSession(id string) (*Session, bool) {
 	m.mu.RLock()
@@ -151,16 +162,29 @@ func (m *SessionManager) cleanupExpiredSessions() {
 	defer ticker.Stop()
 
 	for range ticker.C {
-		m.mu.Lock()
-		now := time.Now()
-		for id, session := range m.sessions {
-			session.mu.RLock()
-			if now.Sub(session.LastActivity) > time.Duration(m.expirySeconds)*time.Second {
-				delete(m.sessions, id)
-			}
-			session.mu.RUnlock()
+		m.removeExpiredSessions(time.Now())
+	}
+}
+
+// removeExpiredSessions removes the sessions inactive before now
+func (m *SessionManager) removeExpiredSessions(now time.Time) {
+	var expired []*Session
+	m.mu.Lock()
+	for id, session := range m.sessions {
+		session.mu.RLock()
+		if now.Sub(session.LastActivity) > time.Duration(m.expirySeconds)*time.Second {
+			delete(m.sessions, id)
+			expired = append(expired, session)
+		}
+		session.mu.RUnlock()
+	}
+	onExpire := m.onExpire
+	m.mu.Unlock()
+
+	if onExpire != nil {
+		for _, session := range expired {
+			onExpire(session)
 		}
-		m.mu.Unlock()
 	}
 }
 
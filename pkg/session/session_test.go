@@ -205,3 +205,28 @@ func TestGetActiveSessions(t *testing.T) {
 	assert.NotContains(t, sessions, session2.ID)
 	assert.Contains(t, sessions, session3.ID)
 }
+
+func TestSessionManager_ExpireHandler(t *testing.T) {
+	// Create session manager
+	manager := NewSessionManager(60)
+
+	// Record expired sessions
+	var expired []string
+	manager.SetExpireHandler(func(s *Session) {
+		expired = append(expired, s.ID)
+	})
+
+	session1 := manager.CreateSession()
+	session2 := manager.CreateSession()
+
+	// Only session1 is inactive for longer than the expiry time
+	session1.LastActivity = time.Now().Add(-2 * time.Minute)
+	manager.removeExpiredSessions(time.Now())
+
+	// Verify the handler is called for the expired session only
+	assert.Equal(t, []string{session1.ID}, expired)
+	_, exists := manager.GetSession(session1.ID)
+	assert.False(t, exists)
+	_, exists = manager.GetSession(session2.ID)
+	assert.True(t, exists)
+}
@@ -0,0 +1,196 @@
+// Package tool calls the functions of an assistant: each tool is an HTTP
+// webhook receiving the arguments the model chose and answering the result
+// handed back to the model.
+package tool
+
+import (
+	"VoiceSculptor/pkg/llm"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultTimeout = 10 * time.Second
+	// MaxResultSize the largest response of a webhook, the result goes into the prompt
+	MaxResultSize = 16 * 1024
+)
+
+var (
+	ErrUnknownTool      = errors.New("unknown tool")
+	ErrInvalidArguments = errors.New("tool arguments must be a JSON object")
+	ErrResultTooLarge   = fmt.Errorf("tool result larger than %d bytes", MaxResultSize)
+	ErrForbiddenAddress = errors.New("tool webhook address is not public")
+)
+
+// nonPublicPrefixes the ranges which are not reachable from the internet
+// beside those of the netip.Addr predicates, see IsPublicAddr
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // shared address space, also cloud metadata services
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"), // NAT64, may map to any IPv4 address
+}
+
+var defaultClient = NewClient()
+
+// Webhook a tool executed by POSTing its arguments to URL
+type Webhook struct {
+	Name        string
+	Description string
+	Parameters  json.RawMessage // JSON schema of the arguments
+	URL         string
+	Timeout     time.Duration // DefaultTimeout when zero
+	// AuthHeader and AuthValue a header sent with every call, such as
+	// Authorization: Bearer <token>
+	AuthHeader string
+	AuthValue  string
+}
+
+// Request the body POSTed to the webhook
+type Request struct {
+	Tool      string          `json:"tool"`
+	CallID    string          `json:"callId"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// Registry the tools of an assistant, safe for concurrent use
+type Registry struct {
+	Client   *http.Client
+	webhooks []Webhook
+}
+
+func NewRegistry(webhooks ...Webhook) *Registry {
+	return &Registry{Client: defaultClient, webhooks: webhooks}
+}
+
+// NewClient returns a client for the webhooks configured by the users: it
+// only dials public addresses and does not follow redirects, so that a
+// webhook cannot reach the services of the internal network (SSRF). The
+// address is checked once resolved, when it is dialed.
+func NewClient() *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: dialPublic}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would dial the internal addresses on our behalf
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// IsPublicAddr reports whether the address is a public unicast address,
+// not a loopback, private, link-local, multicast or reserved one
+func IsPublicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialPublic is the Control of the dialer of the webhooks, it refuses the
+// addresses which are not public
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !IsPublicAddr(ip) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+	}
+	return nil
+}
+
+// Tools returns the tools offered to the model
+func (r *Registry) Tools() []llm.Tool {
+	tools := make([]llm.Tool, 0, len(r.webhooks))
+	for _, w := range r.webhooks {
+		tools = append(tools, llm.Tool{Name: w.Name, Description: w.Description, Parameters: w.Parameters})
+	}
+	return tools
+}
+
+// Call executes the tool call requested by the model and returns the
+// response of the webhook, a status other than 2xx is an error
+func (r *Registry) Call(ctx context.Context, call llm.ToolCall) (string, error) {
+	webhook, ok := r.lookup(call.Name)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownTool, call.Name)
+	}
+	args := strings.TrimSpace(call.Arguments)
+	if args == "" {
+		args = "{}"
+	}
+	if !json.Valid([]byte(args)) || !strings.HasPrefix(args, "{") {
+		return "", ErrInvalidArguments
+	}
+	body, err := json.Marshal(Request{Tool: call.Name, CallID: call.ID, Arguments: json.RawMessage(args)})
+	if err != nil {
+		return "", err
+	}
+
+	timeout := webhook.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if webhook.AuthHeader != "" {
+		req.Header.Set(webhook.AuthHeader, webhook.AuthValue)
+	}
+
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxResultSize+1))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("tool %s failed: %s %s", call.Name, resp.Status, strings.TrimSpace(string(data[:min(len(data), 512)])))
+	}
+	if len(data) > MaxResultSize {
+		return "", ErrResultTooLarge
+	}
+	return string(data), nil
+}
+
+func (r *Registry) lookup(name string) (Webhook, bool) {
+	for _, w := range r.webhooks {
+		if w.Name == name {
+			return w, true
+		}
+	}
+	return Webhook{}, false
+}
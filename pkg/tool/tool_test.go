@@ -0,0 +1,139 @@
+package tool
+
+import (
+	"VoiceSculptor/pkg/llm"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_Tools(t *testing.T) {
+	r := NewRegistry(Webhook{Name: "weather", Description: "the weather of a city", Parameters: json.RawMessage(`{"type":"object"}`)})
+	assert.Equal(t, []llm.Tool{{Name: "weather", Description: "the weather of a city", Parameters: json.RawMessage(`{"type":"object"}`)}}, r.Tools())
+}
+
+func TestRegistry_Call(t *testing.T) {
+	var got Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		_, _ = w.Write([]byte(`{"temperature":21}`))
+	}))
+	defer server.Close()
+
+	r := NewRegistry(Webhook{Name: "weather", URL: server.URL, AuthHeader: "Authorization", AuthValue: "Bearer secret"})
+	r.Client = server.Client()
+	result, err := r.Call(context.Background(), llm.ToolCall{ID: "call_1", Name: "weather", Arguments: `{"city":"Paris"}`})
+	require.NoError(t, err)
+	assert.Equal(t, `{"temperature":21}`, result)
+	assert.Equal(t, "weather", got.Tool)
+	assert.Equal(t, "call_1", got.CallID)
+	assert.JSONEq(t, `{"city":"Paris"}`, string(got.Arguments))
+}
+
+func TestRegistry_CallErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fail":
+			http.Error(w, "no such city", http.StatusNotFound)
+		case "/large":
+			_, _ = w.Write([]byte(strings.Repeat("x", MaxResultSize+1)))
+		case "/slow":
+			select {
+			case <-time.After(300 * time.Millisecond):
+			case <-r.Context().Done():
+			}
+		}
+	}))
+	defer server.Close()
+
+	r := NewRegistry(
+		Webhook{Name: "fail", URL: server.URL + "/fail"},
+		Webhook{Name: "large", URL: server.URL + "/large"},
+		Webhook{Name: "slow", URL: server.URL + "/slow", Timeout: 50 * time.Millisecond},
+	)
+	r.Client = server.Client()
+	ctx := context.Background()
+
+	_, err := r.Call(ctx, llm.ToolCall{Name: "missing"})
+	assert.ErrorIs(t, err, ErrUnknownTool)
+
+	_, err = r.Call(ctx, llm.ToolCall{Name: "fail", Arguments: `[1]`})
+	assert.ErrorIs(t, err, ErrInvalidArguments)
+
+	_, err = r.Call(ctx, llm.ToolCall{Name: "fail"})
+	assert.ErrorContains(t, err, "404")
+	assert.ErrorContains(t, err, "no such city")
+
+	_, err = r.Call(ctx, llm.ToolCall{Name: "large"})
+	assert.ErrorIs(t, err, ErrResultTooLarge)
+
+	start := time.Now()
+	_, err = r.Call(ctx, llm.ToolCall{Name: "slow"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 250*time.Millisecond)
+}
+
+func TestRegistry_CallForbiddenAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+			return
+		}
+		t.Error("the webhook must not be called")
+	}))
+	defer server.Close()
+
+	r := NewRegistry(
+		Webhook{Name: "loopback", URL: server.URL},
+		Webhook{Name: "metadata", URL: "http://169.254.169.254/latest/meta-data/", Timeout: time.Second},
+		Webhook{Name: "private", URL: "http://10.0.0.1/", Timeout: time.Second},
+		Webhook{Name: "mapped", URL: "http://[::ffff:127.0.0.1]:1/", Timeout: time.Second},
+	)
+	for _, name := range []string{"loopback", "metadata", "private", "mapped"} {
+		_, err := r.Call(context.Background(), llm.ToolCall{Name: name})
+		assert.ErrorIs(t, err, ErrForbiddenAddress, name)
+	}
+
+	// the redirects are not followed, the webhook is dialed by a client which
+	// trusts the loopback address
+	client := NewClient()
+	client.Transport = server.Client().Transport
+	r = NewRegistry(Webhook{Name: "redirect", URL: server.URL + "/redirect"})
+	r.Client = client
+	_, err := r.Call(context.Background(), llm.ToolCall{Name: "redirect"})
+	assert.ErrorContains(t, err, "302")
+}
+
+func TestIsPublicAddr(t *testing.T) {
+	for addr, public := range map[string]bool{
+		"93.184.216.34":      true,
+		"2606:4700::1111":    true,
+		"127.0.0.1":          false,
+		"10.1.2.3":           false,
+		"172.16.0.1":         false,
+		"192.168.1.1":        false,
+		"169.254.169.254":    false,
+		"100.100.100.200":    false,
+		"0.0.0.0":            false,
+		"255.255.255.255":    false,
+		"224.0.0.1":          false,
+		"::1":                false,
+		"::":                 false,
+		"fe80::1":            false,
+		"fd00::1":            false,
+		"::ffff:10.0.0.1":    false,
+		"64:ff9b::a9fe:a9fe": false,
+	} {
+		assert.Equal(t, public, IsPublicAddr(netip.MustParseAddr(addr)), addr)
+	}
+}
@@ -0,0 +1,127 @@
+package tts
+
+import (
+	"VoiceSculptor/pkg/codec"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	ProviderTone = "tone"
+	ProviderWAV  = "wav"
+)
+
+const (
+	// ToneCharDuration the audio rendered per character of text at normal speed
+	ToneCharDuration = 60 * time.Millisecond
+	// ToneMinDuration the shortest audio rendered for a text
+	ToneMinDuration = 200 * time.Millisecond
+	// ToneAmplitude the peak of the tone at DefaultVolume
+	ToneAmplitude = 8000
+
+	chunkDuration = 100 * time.Millisecond
+)
+
+func init() {
+	Register(ProviderTone, func(cfg Config) (Provider, error) {
+		return &ToneProvider{}, nil
+	})
+	Register(ProviderWAV, func(cfg Config) (Provider, error) {
+		return NewWAVProvider(cfg.AppID)
+	})
+}
+
+// ToneProvider a fake provider for development and tests, renders a sine
+// tone lasting ToneCharDuration per character, the pitch depends on the voice
+type ToneProvider struct{}
+
+// Frequency returns the pitch of the voice, 440 Hz for the default voice
+func (p *ToneProvider) Frequency(voice string) float64 {
+	if voice == "" || voice == "default" {
+		return 440
+	}
+	h := fnv.New32a()
+	h.Write([]byte(voice))
+	return float64(220 + h.Sum32()%440)
+}
+
+// Duration returns the length of the audio rendered for the text
+func (p *ToneProvider) Duration(text string, opts Options) time.Duration {
+	d := time.Duration(float64(utf8.RuneCountInString(text)) * float64(ToneCharDuration) / opts.speed())
+	return max(d, ToneMinDuration)
+}
+
+// Synthesize implements Provider.
+func (p *ToneProvider) Synthesize(ctx context.Context, text string, opts Options, out func(pcm []byte) error) error {
+	if opts.SampleRate <= 0 {
+		return fmt.Errorf("invalid sample rate: %d", opts.SampleRate)
+	}
+	freq := p.Frequency(opts.Voice)
+	amplitude := ToneAmplitude * opts.gain()
+	total := codec.FrameSize(opts.SampleRate, p.Duration(text, opts)) / 2
+	chunk := codec.FrameSize(opts.SampleRate, chunkDuration) / 2
+
+	for start := 0; start < total; start += chunk {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n := min(chunk, total-start)
+		pcm := make([]byte, n*2)
+		for i := 0; i < n; i++ {
+			v := amplitude * math.Sin(2*math.Pi*freq*float64(start+i)/float64(opts.SampleRate))
+			v = math.Max(math.MinInt16, math.Min(math.MaxInt16, v))
+			binary.LittleEndian.PutUint16(pcm[i*2:], uint16(int16(v)))
+		}
+		if err := out(pcm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WAVProvider a fake provider playing the same recording for every text,
+// the speed changes the playback rate like a tape
+type WAVProvider struct {
+	wav *codec.WAV
+}
+
+// NewWAVProvider loads the WAV file played by the provider
+func NewWAVProvider(path string) (*WAVProvider, error) {
+	if path == "" {
+		return nil, fmt.Errorf("wav tts provider requires the wav file as app id")
+	}
+	wav, err := codec.LoadWAV(path)
+	if err != nil {
+		return nil, err
+	}
+	return &WAVProvider{wav: wav}, nil
+}
+
+// Synthesize implements Provider.
+func (p *WAVProvider) Synthesize(ctx context.Context, text string, opts Options, out func(pcm []byte) error) error {
+	if opts.SampleRate <= 0 {
+		return fmt.Errorf("invalid sample rate: %d", opts.SampleRate)
+	}
+	from := int(float64(p.wav.SampleRate) * opts.speed())
+	pcm := codec.Resample(p.wav.Mono(), from, opts.SampleRate)
+	pcm = append([]byte(nil), pcm...)
+	Gain(pcm, opts.gain())
+
+	chunk := codec.FrameSize(opts.SampleRate, chunkDuration)
+	for len(pcm) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n := min(chunk, len(pcm))
+		if err := out(pcm[:n]); err != nil {
+			return err
+		}
+		pcm = pcm[n:]
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package tts
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+const (
+	DefaultSpeed  = 1.0
+	DefaultVolume = 5.0 // on a scale of 0 to 10, the level the provider renders
+	MaxVolume     = 10.0
+)
+
+// SentenceEnds the terminators at which streamed text is synthesized, e.g.
+// the tokens of a reply are spoken sentence by sentence
+const SentenceEnds = ".!?;。！？；\n"
+
+var ErrProviderNotConfigured = errors.New("tts provider not configured")
+
+// Options the settings of a synthesis
+type Options struct {
+	SampleRate int
+	Voice      string  // the speaker, providers fall back to their default voice
+	Speed      float32 // 1 is normal, 0 means DefaultSpeed
+	Volume     float32 // 0 to 10, 0 means DefaultVolume
+}
+
+// speed returns the speed, falling back to the default
+func (o Options) speed() float64 {
+	if o.Speed <= 0 {
+		return DefaultSpeed
+	}
+	return float64(o.Speed)
+}
+
+// gain returns the amplitude factor of the volume
+func (o Options) gain() float64 {
+	if o.Volume <= 0 {
+		return 1
+	}
+	return float64(min(o.Volume, MaxVolume)) / DefaultVolume
+}
+
+// Provider a speech synthesis backend
+type Provider interface {
+	// Synthesize renders the text as 16 bit little endian mono PCM at
+	// opts.SampleRate, handing the audio to out as it is produced.
+	// The synthesis must stop as soon as ctx is done or out fails.
+	Synthesize(ctx context.Context, text string, opts Options, out func(pcm []byte) error) error
+}
+
+// Synthesizer a provider bound to the options of a call
+type Synthesizer interface {
+	Synthesize(ctx context.Context, text string, out func(pcm []byte) error) error
+}
+
+// WithOptions binds the provider to the options
+func WithOptions(p Provider, opts Options) Synthesizer {
+	return &boundSynthesizer{provider: p, opts: opts}
+}
+
+type boundSynthesizer struct {
+	provider Provider
+	opts     Options
+}
+
+func (s *boundSynthesizer) Synthesize(ctx context.Context, text string, out func(pcm []byte) error) error {
+	return s.provider.Synthesize(ctx, text, s.opts, out)
+}
+
+// Config the connection settings of a provider
+type Config struct {
+	AppID     string
+	SecretID  string
+	SecretKey string
+}
+
+// Factory creates a provider from the config
+type Factory func(cfg Config) (Provider, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a provider available by the name
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[name] = factory
+}
+
+// Providers returns the names of the registered providers
+func Providers() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsRegistered reports whether a provider is registered by the name
+func IsRegistered(name string) bool {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	_, ok := factories[name]
+	return ok
+}
+
+// New creates the provider registered by the name
+func New(name string, cfg Config) (Provider, error) {
+	if name == "" {
+		return nil, ErrProviderNotConfigured
+	}
+	factoriesMu.RLock()
+	factory, ok := factories[name]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown tts provider: %s", name)
+	}
+	return factory(cfg)
+}
+
+// Gain scales 16 bit PCM in place, clipping at the sample range
+func Gain(pcm []byte, factor float64) {
+	if factor == 1 {
+		return
+	}
+	for i := 0; i+1 < len(pcm); i += 2 {
+		v := float64(int16(binary.LittleEndian.Uint16(pcm[i:]))) * factor
+		v = math.Max(math.MinInt16, math.Min(math.MaxInt16, v))
+		binary.LittleEndian.PutUint16(pcm[i:], uint16(int16(v)))
+	}
+}
@@ -0,0 +1,118 @@
+package tts
+
+import (
+	"VoiceSculptor/pkg/codec"
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sampleRate = 8000
+
+func peak(pcm []byte) int {
+	p := 0
+	for i := 0; i+1 < len(pcm); i += 2 {
+		v := int(int16(binary.LittleEndian.Uint16(pcm[i:])))
+		if v < 0 {
+			v = -v
+		}
+		p = max(p, v)
+	}
+	return p
+}
+
+func synthesize(t *testing.T, synth Synthesizer, text string) []byte {
+	var pcm []byte
+	require.NoError(t, synth.Synthesize(context.Background(), text, func(chunk []byte) error {
+		pcm = append(pcm, chunk...)
+		return nil
+	}))
+	return pcm
+}
+
+func TestToneProvider(t *testing.T) {
+	provider, err := New(ProviderTone, Config{})
+	require.NoError(t, err)
+
+	// 10 characters at 60ms
+	pcm := synthesize(t, WithOptions(provider, Options{SampleRate: sampleRate}), "0123456789")
+	assert.Len(t, pcm, codec.FrameSize(sampleRate, 600*time.Millisecond))
+	assert.InDelta(t, ToneAmplitude, peak(pcm), 50)
+
+	fast := synthesize(t, WithOptions(provider, Options{SampleRate: sampleRate, Speed: 2}), "0123456789")
+	assert.Len(t, fast, codec.FrameSize(sampleRate, 300*time.Millisecond))
+	short := synthesize(t, WithOptions(provider, Options{SampleRate: sampleRate}), "hi")
+	assert.Len(t, short, codec.FrameSize(sampleRate, ToneMinDuration))
+
+	loud := synthesize(t, WithOptions(provider, Options{SampleRate: sampleRate, Volume: 10}), "0123456789")
+	assert.InDelta(t, ToneAmplitude*2, peak(loud), 100)
+	quiet := synthesize(t, WithOptions(provider, Options{SampleRate: sampleRate, Volume: 1}), "0123456789")
+	assert.InDelta(t, ToneAmplitude/5, peak(quiet), 50)
+
+	tone := &ToneProvider{}
+	assert.Equal(t, 440.0, tone.Frequency("default"))
+	assert.Equal(t, tone.Frequency("alice"), tone.Frequency("alice"))
+	assert.NotEqual(t, tone.Frequency("alice"), tone.Frequency("bob"))
+
+	stop := errors.New("stop")
+	calls := 0
+	err = tone.Synthesize(context.Background(), "a long sentence to render", Options{SampleRate: sampleRate}, func(pcm []byte) error {
+		calls++
+		return stop
+	})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 1, calls)
+}
+
+func TestWAVProvider(t *testing.T) {
+	// 200ms stereo at 16 kHz, the left channel at 1000, the right at 3000
+	data := make([]byte, codec.FrameSize(16000, 200*time.Millisecond)*2)
+	for i := 0; i < len(data); i += 4 {
+		binary.LittleEndian.PutUint16(data[i:], 1000)
+		binary.LittleEndian.PutUint16(data[i+2:], 3000)
+	}
+	path := filepath.Join(t.TempDir(), "voice.wav")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	require.NoError(t, codec.WriteWAV(f, &codec.WAV{SampleRate: 16000, Channels: 2, Data: data}))
+	require.NoError(t, f.Close())
+
+	provider, err := New(ProviderWAV, Config{AppID: path})
+	require.NoError(t, err)
+	pcm := synthesize(t, WithOptions(provider, Options{SampleRate: sampleRate}), "anything")
+	assert.Len(t, pcm, codec.FrameSize(sampleRate, 200*time.Millisecond))
+	assert.Equal(t, 2000, peak(pcm))
+
+	pcm = synthesize(t, WithOptions(provider, Options{SampleRate: sampleRate, Speed: 2, Volume: 2.5}), "anything")
+	assert.Len(t, pcm, codec.FrameSize(sampleRate, 100*time.Millisecond))
+	assert.Equal(t, 1000, peak(pcm))
+
+	_, err = New(ProviderWAV, Config{})
+	assert.Error(t, err)
+}
+
+func TestGain(t *testing.T) {
+	pcm := make([]byte, 4)
+	binary.LittleEndian.PutUint16(pcm, uint16(20000))
+	binary.LittleEndian.PutUint16(pcm[2:], uint16(0xffff&-20000))
+	Gain(pcm, 2)
+	assert.Equal(t, int16(math.MaxInt16), int16(binary.LittleEndian.Uint16(pcm)))
+	assert.Equal(t, int16(math.MinInt16), int16(binary.LittleEndian.Uint16(pcm[2:])))
+}
+
+func TestRegistry(t *testing.T) {
+	assert.Subset(t, Providers(), []string{ProviderTone, ProviderWAV})
+	assert.True(t, IsRegistered(ProviderTone))
+	_, err := New("", Config{})
+	assert.ErrorIs(t, err, ErrProviderNotConfigured)
+	_, err = New("nope", Config{})
+	assert.Error(t, err)
+}
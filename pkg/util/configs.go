@@ -59,6 +59,17 @@ func GetIntEnv(key string) int64 {
 	return v
 }
 
+// GetListEnv returns the comma separated items of the variable, empty items are skipped
+func GetListEnv(key string) []string {
+	var items []string
+	for _, item := range strings.Split(GetEnv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func LookupEnv(key string) (value string, found bool) {
 	key = strings.ToUpper(key)
 	if envCache != nil {
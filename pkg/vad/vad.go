@@ -0,0 +1,182 @@
+package vad
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+const (
+	// DefaultThreshold the level of speech in dBFS
+	DefaultThreshold = -45.0
+	// DefaultMinSpeech the speech needed before an utterance starts, shorter noises are ignored
+	DefaultMinSpeech = 120 * time.Millisecond
+	// DefaultMinSilence the silence ending an utterance
+	DefaultMinSilence = 600 * time.Millisecond
+
+	// NoiseMargin the level above the noise floor a frame needs to count as speech
+	NoiseMargin = 10.0
+	// MaxZeroCrossingRate frames crossing zero more often are hiss rather than voice,
+	// white noise crosses about every other sample
+	MaxZeroCrossingRate = 0.4
+
+	// noise floor adaption per non-speech frame
+	noiseAdaption = 0.05
+	silenceLevel  = -96.0
+)
+
+// Event a change of the voice activity
+type Event int
+
+const (
+	None Event = iota
+	SpeechStart
+	SpeechEnd
+)
+
+func (e Event) String() string {
+	switch e {
+	case SpeechStart:
+		return "speech_start"
+	case SpeechEnd:
+		return "speech_end"
+	default:
+		return "none"
+	}
+}
+
+// Config the thresholds of a detector, zero values use the defaults
+type Config struct {
+	Threshold  float64       `json:"threshold"` // dBFS, a negative value
+	MinSpeech  time.Duration `json:"minSpeech"`
+	MinSilence time.Duration `json:"minSilence"`
+}
+
+func (c Config) withDefaults() Config {
+	if c.Threshold == 0 {
+		c.Threshold = DefaultThreshold
+	}
+	if c.MinSpeech <= 0 {
+		c.MinSpeech = DefaultMinSpeech
+	}
+	if c.MinSilence <= 0 {
+		c.MinSilence = DefaultMinSilence
+	}
+	return c
+}
+
+// Detector an energy based voice activity detector over 16 bit little endian
+// mono PCM frames. A frame is speech when it is louder than the threshold and
+// the noise floor, and its zero crossing rate is below MaxZeroCrossingRate.
+// Not safe for concurrent use.
+type Detector struct {
+	config     Config
+	sampleRate int
+
+	noiseFloor float64
+	speaking   bool
+	speech     time.Duration // consecutive speech while silent
+	silence    time.Duration // consecutive silence while speaking
+}
+
+// New creates a detector for PCM at the sample rate
+func New(config Config, sampleRate int) *Detector {
+	return &Detector{
+		config:     config.withDefaults(),
+		sampleRate: sampleRate,
+		noiseFloor: silenceLevel,
+	}
+}
+
+// Config returns the thresholds in use, defaults applied
+func (d *Detector) Config() Config {
+	return d.config
+}
+
+// Speaking reports whether an utterance is in progress
+func (d *Detector) Speaking() bool {
+	return d.speaking
+}
+
+// Reset forgets the utterance in progress, the noise floor is kept
+func (d *Detector) Reset() {
+	d.speaking = false
+	d.speech, d.silence = 0, 0
+}
+
+// Process analyzes the next frame and reports whether an utterance started or ended
+func (d *Detector) Process(frame []byte) Event {
+	samples := len(frame) / 2
+	if samples == 0 {
+		return None
+	}
+	duration := time.Duration(samples) * time.Second / time.Duration(d.sampleRate)
+	level := Level(frame)
+	speech := level >= d.config.Threshold &&
+		level >= d.noiseFloor+NoiseMargin &&
+		ZeroCrossingRate(frame) <= MaxZeroCrossingRate
+	if !speech {
+		d.noiseFloor += (level - d.noiseFloor) * noiseAdaption
+	}
+
+	if !d.speaking {
+		if !speech {
+			d.speech = 0
+			return None
+		}
+		d.speech += duration
+		if d.speech < d.config.MinSpeech {
+			return None
+		}
+		d.speaking, d.speech = true, 0
+		return SpeechStart
+	}
+
+	if speech {
+		d.silence = 0
+		return None
+	}
+	d.silence += duration
+	if d.silence < d.config.MinSilence {
+		return None
+	}
+	d.speaking, d.silence = false, 0
+	return SpeechEnd
+}
+
+// Level returns the RMS level of the frame in dBFS
+func Level(frame []byte) float64 {
+	samples := len(frame) / 2
+	if samples == 0 {
+		return silenceLevel
+	}
+	var sum float64
+	for i := 0; i < samples; i++ {
+		v := float64(int16(binary.LittleEndian.Uint16(frame[i*2:])))
+		sum += v * v
+	}
+	rms := math.Sqrt(sum / float64(samples))
+	if rms < 1 {
+		return silenceLevel
+	}
+	return 20 * math.Log10(rms/math.MaxInt16)
+}
+
+// ZeroCrossingRate returns the share of adjacent samples changing sign,
+// a rough measure of the dominant frequency
+func ZeroCrossingRate(frame []byte) float64 {
+	samples := len(frame) / 2
+	if samples < 2 {
+		return 0
+	}
+	crossings := 0
+	prev := int16(binary.LittleEndian.Uint16(frame))
+	for i := 1; i < samples; i++ {
+		v := int16(binary.LittleEndian.Uint16(frame[i*2:]))
+		if (prev < 0) != (v < 0) {
+			crossings++
+		}
+		prev = v
+	}
+	return float64(crossings) / float64(samples-1)
+}
@@ -0,0 +1,114 @@
+package vad
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const sampleRate = 16000
+
+// tone returns a 20ms frame of a 300 Hz tone at the amplitude
+func tone(amplitude float64, offset int) []byte {
+	frame := make([]byte, sampleRate/50*2)
+	for i := 0; i < len(frame)/2; i++ {
+		v := amplitude * math.Sin(2*math.Pi*300*float64(offset+i)/sampleRate)
+		binary.LittleEndian.PutUint16(frame[i*2:], uint16(int16(v)))
+	}
+	return frame
+}
+
+// noise returns a 20ms frame of white noise at the amplitude
+func noise(r *rand.Rand, amplitude float64) []byte {
+	frame := make([]byte, sampleRate/50*2)
+	for i := 0; i < len(frame)/2; i++ {
+		v := amplitude * (r.Float64()*2 - 1)
+		binary.LittleEndian.PutUint16(frame[i*2:], uint16(int16(v)))
+	}
+	return frame
+}
+
+// run processes the frames and returns the events with the index of their frame
+func run(d *Detector, frames [][]byte) map[int]Event {
+	events := map[int]Event{}
+	for i, frame := range frames {
+		if ev := d.Process(frame); ev != None {
+			events[i] = ev
+		}
+	}
+	return events
+}
+
+func TestLevel(t *testing.T) {
+	assert.Equal(t, -96.0, Level(make([]byte, 640)))
+	// the RMS of a full scale sine is -3 dBFS
+	assert.InDelta(t, -3.0, Level(tone(math.MaxInt16, 0)), 0.1)
+	assert.InDelta(t, -23.0, Level(tone(math.MaxInt16/10, 0)), 0.1)
+}
+
+func TestZeroCrossingRate(t *testing.T) {
+	// 300 Hz crosses zero 600 times a second
+	assert.InDelta(t, 600.0/sampleRate, ZeroCrossingRate(tone(10000, 0)), 0.005)
+	assert.Greater(t, ZeroCrossingRate(noise(rand.New(rand.NewSource(1)), 10000)), MaxZeroCrossingRate)
+}
+
+func TestDetector_Utterance(t *testing.T) {
+	d := New(Config{}, sampleRate)
+	var frames [][]byte
+	for i := 0; i < 10; i++ {
+		frames = append(frames, make([]byte, 640))
+	}
+	for i := 0; i < 25; i++ {
+		frames = append(frames, tone(3000, i*320))
+	}
+	for i := 0; i < 40; i++ {
+		frames = append(frames, make([]byte, 640))
+	}
+
+	// speech starts after 120ms (6 frames) and ends after 600ms (30 frames) of silence
+	assert.Equal(t, map[int]Event{15: SpeechStart, 64: SpeechEnd}, run(d, frames))
+	assert.False(t, d.Speaking())
+}
+
+func TestDetector_IgnoresShortNoiseAndHiss(t *testing.T) {
+	d := New(Config{Threshold: -40, MinSpeech: 100 * time.Millisecond, MinSilence: 200 * time.Millisecond}, sampleRate)
+	r := rand.New(rand.NewSource(1))
+	var frames [][]byte
+	// a click of 40ms
+	frames = append(frames, tone(3000, 0), tone(3000, 320))
+	frames = append(frames, make([]byte, 640), make([]byte, 640))
+	// loud hiss
+	for i := 0; i < 20; i++ {
+		frames = append(frames, noise(r, 10000))
+	}
+	// too quiet for the threshold
+	for i := 0; i < 20; i++ {
+		frames = append(frames, tone(200, i*320))
+	}
+	assert.Empty(t, run(d, frames))
+}
+
+func TestDetector_NoiseFloor(t *testing.T) {
+	d := New(Config{Threshold: -60}, sampleRate)
+	var frames [][]byte
+	// a steady hum stays below the threshold while the floor adapts to it
+	for i := 0; i < 100; i++ {
+		frames = append(frames, tone(20, i*320))
+	}
+	assert.Empty(t, run(d, frames))
+
+	// a voice well above the floor is speech
+	frames = nil
+	for i := 0; i < 10; i++ {
+		frames = append(frames, tone(3000, i*320))
+	}
+	assert.Equal(t, map[int]Event{5: SpeechStart}, run(d, frames))
+	assert.True(t, d.Speaking())
+	d.Reset()
+	assert.False(t, d.Speaking())
+	assert.Equal(t, DefaultMinSilence, d.Config().MinSilence)
+}